@@ -0,0 +1,114 @@
+// Package activation implements systemd socket activation: it retrieves the
+// sockets the service manager passed to the process, as described by
+// sd_listen_fds(3).
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// The names of the sockets Mesos-DNS uses, as set by FileDescriptorName= in
+// the socket units.
+const (
+	DNSUDP = "dns-udp"
+	DNSTCP = "dns-tcp"
+	HTTP   = "http"
+)
+
+// Sockets holds the sockets passed by systemd, keyed by name. Stream sockets
+// are listeners while datagram sockets are packet connections.
+type Sockets struct {
+	Listeners   map[string]net.Listener
+	PacketConns map[string]net.PacketConn
+}
+
+// Listen returns the sockets passed by systemd, which are none if the process
+// wasn't socket activated. The environment variables passing them are unset
+// so that child processes don't inherit them.
+func Listen() (Sockets, error) {
+	s := Sockets{
+		Listeners:   map[string]net.Listener{},
+		PacketConns: map[string]net.PacketConn{},
+	}
+	names, err := parse(os.Getpid(), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	for _, v := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(v)
+	}
+	if err != nil {
+		return s, err
+	}
+
+	for i, name := range names {
+		fd := listenFdsStart + i
+		closeOnExec(fd)
+		f := os.NewFile(uintptr(fd), name)
+		// the net package works on duplicates of the descriptors
+		if l, err := net.FileListener(f); err == nil {
+			s.Listeners[name] = l
+		} else if pc, err := net.FilePacketConn(f); err == nil {
+			s.PacketConns[name] = pc
+		} else {
+			err = fmt.Errorf("socket %q (fd %d) is neither a stream nor a datagram socket: %v", name, fd, err)
+			_ = f.Close()
+			s.Close()
+			return Sockets{}, err
+		}
+		_ = f.Close()
+	}
+	return s, nil
+}
+
+// parse returns the names of the sockets passed to the process of the given
+// pid as per the given LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES environment
+// variables, or none if they aren't meant for it. Sockets lacking a name are
+// named "unknown", as with sd_listen_fds_with_names(3).
+func parse(pid int, listenPid, listenFds, listenFdNames string) ([]string, error) {
+	if listenPid == "" || listenFds == "" {
+		return nil, nil
+	}
+	if p, err := strconv.Atoi(listenPid); err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_PID %q: %v", listenPid, err)
+	} else if p != pid {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(listenFds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", listenFds)
+	}
+
+	names := make([]string, n)
+	var given []string
+	if listenFdNames != "" {
+		given = strings.Split(listenFdNames, ":")
+	}
+	for i := range names {
+		if i < len(given) && given[i] != "" {
+			names[i] = given[i]
+		} else {
+			names[i] = "unknown"
+		}
+	}
+	return names, nil
+}
+
+// Close closes all the sockets.
+func (s Sockets) Close() {
+	for _, l := range s.Listeners {
+		_ = l.Close()
+	}
+	for _, pc := range s.PacketConns {
+		_ = pc.Close()
+	}
+}
+
+// Empty reports whether there are no sockets.
+func (s Sockets) Empty() bool {
+	return len(s.Listeners) == 0 && len(s.PacketConns) == 0
+}
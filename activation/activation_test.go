@@ -0,0 +1,42 @@
+package activation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for i, tt := range []struct {
+		pid, fds, names string
+		want            []string
+		err             bool
+	}{
+		{"", "", "", nil, false},
+		{"42", "", "", nil, false},
+		{"43", "2", "dns-udp:dns-tcp", nil, false},
+		{"42", "2", "dns-udp:dns-tcp", []string{"dns-udp", "dns-tcp"}, false},
+		{"42", "3", "dns-udp::http", []string{"dns-udp", "unknown", "http"}, false},
+		{"42", "2", "", []string{"unknown", "unknown"}, false},
+		{"42", "1", "http:extra", []string{"http"}, false},
+		{"x", "1", "", nil, true},
+		{"42", "-1", "", nil, true},
+	} {
+		got, err := parse(42, tt.pid, tt.fds, tt.names)
+		if (err != nil) != tt.err {
+			t.Errorf("test #%d: got error %v, want error %t", i, err, tt.err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestListen_NotActivated(t *testing.T) {
+	s, err := Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Empty() {
+		t.Errorf("got sockets %+v without socket activation", s)
+	}
+}
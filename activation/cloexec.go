@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package activation
+
+import "syscall"
+
+// closeOnExec keeps the given file descriptor from leaking to child
+// processes.
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
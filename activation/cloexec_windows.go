@@ -0,0 +1,4 @@
+package activation
+
+// closeOnExec is a no-op: there's no socket activation on Windows.
+func closeOnExec(fd int) {}
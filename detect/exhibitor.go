@@ -0,0 +1,156 @@
+package detect
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesos/mesos-go/detector"
+
+	"github.com/mesosphere/mesos-dns/errorutil"
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// exhibitorClusterList is the path of the Exhibitor REST endpoint listing the
+// servers of the ZooKeeper ensemble.
+const exhibitorClusterList = "/exhibitor/v1/cluster/list"
+
+// Exhibitor detects the Mesos masters through the ZooKeeper ensemble
+// reported by a Netflix Exhibitor, polling it for changes of the ensemble's
+// servers, upon which leader detection is restarted against the new ones.
+// Should Exhibitor fail, the last known ensemble keeps being used or, if none
+// is known yet, the static ZooKeeper URL, or else the static masters.
+type Exhibitor struct {
+	// URL is the base URL of the Exhibitor REST API.
+	URL string
+	// Path is the znode path Mesos masters register under, e.g. /mesos.
+	Path string
+	// Zk is the static zk:// URL of the ensemble, if any.
+	Zk string
+	// Masters are the static masters.
+	Masters []string
+	// Interval is the interval at which Exhibitor is polled.
+	Interval time.Duration
+	// Client is the HTTP client Exhibitor is polled with.
+	Client *http.Client
+
+	// newDetector returns a leader detector for the given zk:// URL; it's
+	// overridden in tests.
+	newDetector func(spec string) (detector.Master, error)
+	md          detector.Master
+	current     string // zk:// URL of md, if any
+	static      bool   // whether the static masters were sent
+}
+
+// exhibitorClusterListing is the response of the cluster list endpoint.
+type exhibitorClusterListing struct {
+	Servers []string `json:"servers"`
+	Port    int      `json:"port"`
+}
+
+// Detect polls Exhibitor every interval, until done is closed, sending the
+// masters detected through the ensemble it reports to the given channel, as
+// NewMasters does.
+func (e *Exhibitor) Detect(changed chan<- []string, done <-chan struct{}) {
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+	for {
+		e.poll(changed)
+		select {
+		case <-ticker.C:
+		case <-done:
+			if e.md != nil {
+				e.md.Cancel()
+			}
+			return
+		}
+	}
+}
+
+// poll restarts leader detection if the ensemble reported by Exhibitor
+// changed, falling back as described by Exhibitor if it fails.
+func (e *Exhibitor) poll(changed chan<- []string) {
+	spec, err := e.ensemble()
+	switch {
+	case err != nil && e.current != "":
+		logging.CurLog.ExhibitorFailures.Inc()
+		logging.Error.Printf("Exhibitor: %v; keeping the ZooKeeper ensemble %s", err, e.current)
+		return
+	case err != nil && e.Zk != "":
+		logging.CurLog.ExhibitorFailures.Inc()
+		logging.Error.Printf("Exhibitor: %v; falling back to the ZooKeeper ensemble %s", err, e.Zk)
+		spec = e.Zk
+	case err != nil:
+		logging.CurLog.ExhibitorFailures.Inc()
+		logging.Error.Printf("Exhibitor: %v; falling back to the masters %v", err, e.Masters)
+		if !e.static {
+			e.static = true
+			emit(changed, e.Masters)
+		}
+		return
+	}
+	if spec == e.current {
+		return
+	}
+
+	if e.md != nil {
+		logging.Error.Printf("ZooKeeper ensemble changed from %s to %s", e.current, spec)
+		logging.CurLog.ZkEnsembleChanges.Inc()
+		e.md.Cancel()
+		e.md, e.current = nil, ""
+	}
+	newDetector := e.newDetector
+	if newDetector == nil {
+		newDetector = detector.New
+	}
+	md, err := newDetector(spec)
+	if err == nil {
+		if err = md.Detect(NewMasters(e.Masters, changed)); err != nil {
+			md.Cancel()
+		}
+	}
+	if err != nil {
+		// retried upon the next poll
+		logging.CurLog.ZkDetectorFailures.Inc()
+		logging.Error.Printf("ZooKeeper: failed to detect the masters through %s: %v", spec, err)
+		return
+	}
+	logging.Verbose.Println("Starting master detector for ZK ", spec)
+	e.md, e.current = md, spec
+}
+
+// ensemble returns the zk:// URL of the masters' znode on the ensemble
+// reported by Exhibitor, listing its servers in order.
+func (e *Exhibitor) ensemble() (string, error) {
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(strings.TrimSuffix(e.URL, "/") + exhibitorClusterList)
+	if err != nil {
+		return "", err
+	}
+	defer errorutil.Ignore(resp.Body.Close)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s from %s", resp.Status, resp.Request.URL)
+	}
+
+	var listing exhibitorClusterListing
+	if err = json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return "", fmt.Errorf("malformed cluster list: %v", err)
+	}
+	if len(listing.Servers) == 0 || listing.Port <= 0 {
+		return "", fmt.Errorf("no ZooKeeper servers listed")
+	}
+	servers := make([]string, len(listing.Servers))
+	for i, server := range listing.Servers {
+		servers[i] = net.JoinHostPort(server, strconv.Itoa(listing.Port))
+	}
+	sort.Strings(servers)
+	return "zk://" + strings.Join(servers, ",") + e.Path, nil
+}
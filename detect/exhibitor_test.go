@@ -0,0 +1,203 @@
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mesos/mesos-go/detector"
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// fakeExhibitor serves the cluster list endpoint of Exhibitor, failing while
+// no servers are set.
+type fakeExhibitor struct {
+	mu      sync.Mutex
+	servers []string
+}
+
+func (f *fakeExhibitor) set(servers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.servers = servers
+}
+
+func (f *fakeExhibitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path != exhibitorClusterList || len(f.servers) == 0 {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, `{"servers":["%s"],"port":2181}`, strings.Join(f.servers, `","`))
+}
+
+// fakeDetector reports the leader of the ensemble it was created for.
+type fakeDetector struct {
+	leader    string
+	done      chan struct{}
+	cancelled sync.Once
+}
+
+func (d *fakeDetector) Detect(obs detector.MasterChanged) error {
+	obs.OnMasterChanged(masterInfo(addr(d.leader, 5050)))
+	return nil
+}
+
+func (d *fakeDetector) Done() <-chan struct{} { return d.done }
+
+func (d *fakeDetector) Cancel() { d.cancelled.Do(func() { close(d.done) }) }
+
+// counter returns the value of the given counter.
+func counter(c logging.Counter) int {
+	n, _ := strconv.Atoi(fmt.Sprint(c))
+	return n
+}
+
+func TestExhibitor_Detect(t *testing.T) {
+	fake := &fakeExhibitor{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	leaders := map[string]string{
+		"zk://static:2181/mesos":       "10.0.0.1",
+		"zk://zk1:2181,zk2:2181/mesos": "10.0.0.2",
+		"zk://zk3:2181/mesos":          "10.0.0.3",
+	}
+	var (
+		mu        sync.Mutex
+		detectors []*fakeDetector
+	)
+	e := &Exhibitor{
+		URL:      srv.URL + "/",
+		Path:     "/mesos",
+		Zk:       "zk://static:2181/mesos",
+		Masters:  []string{"10.0.0.9:5050"},
+		Interval: 10 * time.Millisecond,
+		newDetector: func(spec string) (detector.Master, error) {
+			leader, ok := leaders[spec]
+			if !ok {
+				return nil, fmt.Errorf("unexpected ensemble %s", spec)
+			}
+			d := &fakeDetector{leader: leader, done: make(chan struct{})}
+			mu.Lock()
+			detectors = append(detectors, d)
+			mu.Unlock()
+			return d, nil
+		},
+	}
+
+	changed := make(chan []string, 10)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		e.Detect(changed, done)
+	}()
+	next := func(want ...string) {
+		select {
+		case got := <-changed:
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got masters %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for masters %q", want)
+		}
+	}
+	changes, failures := counter(logging.CurLog.ZkEnsembleChanges), counter(logging.CurLog.ExhibitorFailures)
+
+	// Exhibitor failing from the start falls back to the static ensemble
+	next("10.0.0.1:5050", "10.0.0.9:5050")
+	// then detection follows the ensemble reported by Exhibitor
+	fake.set("zk2", "zk1")
+	next("10.0.0.2:5050", "10.0.0.9:5050")
+	fake.set("zk3")
+	next("10.0.0.3:5050", "10.0.0.9:5050")
+
+	// Exhibitor failing keeps the last known ensemble
+	fake.set()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if counter(logging.CurLog.ExhibitorFailures) > failures+1 {
+			break
+		}
+	}
+	close(done)
+	<-stopped
+
+	select {
+	case got := <-changed:
+		t.Errorf("got masters %q after Exhibitor failed, want none", got)
+	default:
+	}
+	if got := counter(logging.CurLog.ExhibitorFailures); got <= failures+1 {
+		t.Errorf("got %d Exhibitor failures, want more than %d", got, failures+1)
+	}
+	if got := counter(logging.CurLog.ZkEnsembleChanges) - changes; got != 2 {
+		t.Errorf("got %d ensemble changes, want 2", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(detectors) != 3 {
+		t.Fatalf("got %d detectors, want 3", len(detectors))
+	}
+	for i, d := range detectors {
+		select {
+		case <-d.Done():
+		default:
+			t.Errorf("detector #%d not cancelled", i)
+		}
+	}
+}
+
+func TestExhibitor_Poll(t *testing.T) {
+	fake := &fakeExhibitor{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	fail := true
+	e := &Exhibitor{
+		URL:     srv.URL,
+		Path:    "/mesos/v2",
+		Masters: []string{"10.0.0.9:5050"},
+		newDetector: func(spec string) (detector.Master, error) {
+			if fail {
+				return nil, errors.New("boom")
+			}
+			return &fakeDetector{leader: "10.0.0.4", done: make(chan struct{})}, nil
+		},
+	}
+	changed := make(chan []string, 10)
+
+	// without a static ensemble, the static masters are sent once
+	e.poll(changed)
+	e.poll(changed)
+	if got, want := <-changed, []string{"10.0.0.9:5050"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got masters %q, want %q", got, want)
+	}
+
+	// failures to detect through the ensemble are retried
+	fake.set("zk3", "zk1")
+	failures := counter(logging.CurLog.ZkDetectorFailures)
+	e.poll(changed)
+	if got := counter(logging.CurLog.ZkDetectorFailures) - failures; got != 1 {
+		t.Errorf("got %d ZooKeeper failures, want 1", got)
+	}
+	fail = false
+	e.poll(changed)
+	if got, want := <-changed, []string{"10.0.0.4:5050", "10.0.0.9:5050"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got masters %q, want %q", got, want)
+	}
+	if got, want := e.current, "zk://zk1:2181,zk3:2181/mesos/v2"; got != want {
+		t.Errorf("got ensemble %s, want %s", got, want)
+	}
+	if len(changed) > 0 {
+		t.Errorf("got unexpected masters %q", <-changed)
+	}
+}
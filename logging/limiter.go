@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter rate limits log messages identified by a key: a message with a given
+// key may be logged at most once per interval. It's safe for concurrent use.
+type Limiter struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// NewLimiter returns a Limiter allowing one message per key per interval.
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{
+		interval: interval,
+		now:      time.Now,
+		last:     map[string]time.Time{},
+	}
+}
+
+// Allow reports whether a message with the given key may be logged now.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.interval {
+		return false
+	}
+	// forget expired keys so that the map doesn't grow without bound
+	for k, t := range l.last {
+		if now.Sub(t) >= l.interval {
+			delete(l.last, k)
+		}
+	}
+	l.last[key] = now
+	return true
+}
@@ -0,0 +1,32 @@
+package logging
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i, tt := range []struct {
+		advance time.Duration
+		key     string
+		want    bool
+	}{
+		{0, "a", true},
+		{0, "a", false},
+		{0, "b", true},
+		{30 * time.Second, "a", false},
+		{0, "b", false},
+		{30 * time.Second, "a", true},
+		{0, "a", false},
+		{time.Minute, "b", true},
+	} {
+		now = now.Add(tt.advance)
+		if got := l.Allow(tt.key); got != tt.want {
+			t.Errorf("test #%d: Allow(%q) = %v, want %v", i+1, tt.key, got, tt.want)
+		}
+	}
+}
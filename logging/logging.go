@@ -1,10 +1,14 @@
 package logging
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 
 	"github.com/golang/glog"
@@ -44,6 +48,145 @@ func (lc *LogCounter) String() string {
 	return strconv.FormatUint(atomic.LoadUint64(&lc.value), 10)
 }
 
+// Gauge defines an interface for a value that can arbitrarily go up and down.
+type Gauge interface {
+	Set(int64)
+}
+
+// LogGauge implements the Gauge interface with an int64 register.
+// It's safe for concurrent use.
+type LogGauge struct {
+	value int64
+}
+
+// Set sets the gauge to the given value.
+func (lg *LogGauge) Set(v int64) {
+	atomic.StoreInt64(&lg.value, v)
+}
+
+// String returns a string represention of the gauge.
+func (lg *LogGauge) String() string {
+	return strconv.FormatInt(atomic.LoadInt64(&lg.value), 10)
+}
+
+// GaugeFunc defines an interface for a value computed whenever it's read.
+type GaugeFunc interface {
+	SetFunc(func() int64)
+}
+
+// LogGaugeFunc implements the GaugeFunc interface, calling the function set
+// whenever it's printed.
+// It's safe for concurrent use.
+type LogGaugeFunc struct {
+	f atomic.Value
+}
+
+// SetFunc sets the function computing the gauge's value.
+func (lg *LogGaugeFunc) SetFunc(f func() int64) {
+	lg.f.Store(f)
+}
+
+// Value returns the gauge's current value, or 0 if no function was set.
+func (lg *LogGaugeFunc) Value() int64 {
+	f, _ := lg.f.Load().(func() int64)
+	if f == nil {
+		return 0
+	}
+	return f()
+}
+
+// String returns a string represention of the gauge's current value, or 0
+// if no function was set.
+func (lg *LogGaugeFunc) String() string {
+	return strconv.FormatInt(lg.Value(), 10)
+}
+
+// SummaryVec defines an interface for distributions of observed values,
+// partitioned by label.
+type SummaryVec interface {
+	Observe(label string, v float64)
+}
+
+// LogSummaryVec implements the SummaryVec interface, keeping the count, sum
+// and maximum of the values observed per label.
+// It's safe for concurrent use.
+type LogSummaryVec struct {
+	mu        sync.Mutex
+	summaries map[string]*summary
+}
+
+type summary struct {
+	count    uint64
+	sum, max float64
+}
+
+// Observe accounts for the given value of the given label.
+func (ls *LogSummaryVec) Observe(label string, v float64) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.summaries == nil {
+		ls.summaries = map[string]*summary{}
+	}
+	s, ok := ls.summaries[label]
+	if !ok {
+		s = &summary{}
+		ls.summaries[label] = s
+	}
+	s.count++
+	s.sum += v
+	if v > s.max {
+		s.max = v
+	}
+}
+
+// String returns a string represention of the summaries, ordered by label.
+func (ls *LogSummaryVec) String() string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	parts := make([]string, 0, len(ls.summaries))
+	for label, s := range ls.summaries {
+		parts = append(parts, fmt.Sprintf("%s{count=%d sum=%g max=%g}", label, s.count, s.sum, s.max))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// CounterVec defines an interface for monotonically incrementing values,
+// partitioned by label.
+type CounterVec interface {
+	Add(label string, delta uint64)
+}
+
+// LogCounterVec implements the CounterVec interface with a uint64 register
+// per label.
+// It's safe for concurrent use.
+type LogCounterVec struct {
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// Add increments the counter of the given label by delta.
+func (lc *LogCounterVec) Add(label string, delta uint64) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.values == nil {
+		lc.values = map[string]uint64{}
+	}
+	lc.values[label] += delta
+}
+
+// String returns a string represention of the counters, ordered by label.
+func (lc *LogCounterVec) String() string {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	parts := make([]string, 0, len(lc.values))
+	for label, v := range lc.values {
+		parts = append(parts, fmt.Sprintf("%s=%d", label, v))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
 // LogOut holds metrics captured in an instrumented runtime.
 type LogOut struct {
 	MesosRequests     Counter
@@ -55,19 +198,169 @@ type LogOut struct {
 	NonMesosNXDomain  Counter
 	NonMesosFailed    Counter
 	NonMesosForwarded Counter
+	// InvalidRecordNames counts the generated record names that were
+	// rejected for being structurally invalid.
+	InvalidRecordNames Counter
+	// DroppedRecords counts the generated records dropped by record
+	// transforms.
+	DroppedRecords Counter
+	// NameCollisions counts the record names generated by more than one
+	// framework.
+	NameCollisions Counter
+	// MalformedSlaves counts the slaves skipped for lacking a pid and hostname.
+	MalformedSlaves Counter
+	// MalformedTasks counts the tasks skipped for lacking an id or slave id.
+	MalformedTasks Counter
+	// UnroutableSlaves counts the slaves skipped for advertising an
+	// unspecified or loopback address.
+	UnroutableSlaves Counter
+	// MissingSlaveIPTasks counts the running tasks whose slave IP is unknown.
+	MissingSlaveIPTasks Counter
+	// GeneratedRecords is the number of records of the last generation.
+	GeneratedRecords Gauge
+	// ARecords, AAAARecords and SRVRecords are the number of records of the
+	// last generation per kind.
+	ARecords    Gauge
+	AAAARecords Gauge
+	SRVRecords  Gauge
+	// FrameworkRecords, SlaveRecords, MasterRecords, ListenerRecords,
+	// TaskRecords and StaticRecords are the number of records of the last
+	// generation per source.
+	FrameworkRecords Gauge
+	SlaveRecords     Gauge
+	MasterRecords    Gauge
+	ListenerRecords  Gauge
+	TaskRecords      Gauge
+	StaticRecords    Gauge
+	// SkippedTasks is the number of tasks skipped by the last generation.
+	SkippedTasks Gauge
+	// GenerationMillis is the duration of the last generation in milliseconds.
+	GenerationMillis Gauge
+	// GenerationPhaseMillis summarizes the durations of the generation
+	// passes in milliseconds, per pass.
+	GenerationPhaseMillis SummaryVec
+	// LeaderUnknown is 1 if the last fetched state lacked a leader, 0 otherwise.
+	LeaderUnknown Gauge
+	// RecordsCapped is 1 if the last generation reached the record cap,
+	// cutting off task records, 0 otherwise.
+	RecordsCapped Gauge
+	// StalenessSeconds is the number of seconds since the records being
+	// served were generated, or -1 if none were yet.
+	StalenessSeconds GaugeFunc
+	// RecordsStale is 1 if the last reload failed while the records being
+	// served were older than the StaleSeconds threshold, 0 otherwise.
+	RecordsStale Gauge
+	// GenerationEvents counts the defensive behaviors triggered by the
+	// generations whose records got served, labelled "event/source".
+	GenerationEvents CounterVec
+	// MasterStateAttempts, MasterStateSuccesses and MasterStateBytes count
+	// the state fetches, the successful ones and the bytes received, per
+	// master address.
+	MasterStateAttempts  CounterVec
+	MasterStateSuccesses CounterVec
+	MasterStateBytes     CounterVec
+	// MasterStateFailures counts the failed state fetches, per master
+	// address and failure class, labelled "address/class".
+	MasterStateFailures CounterVec
+	// MasterStateSkips counts the state fetches skipped for the circuit
+	// breaker of the master being open, per master address.
+	MasterStateSkips CounterVec
+	// MasterStateRetries counts the failed state fetches retried, per master
+	// address.
+	MasterStateRetries CounterVec
+	// MasterStateFailovers counts the state fetches moving on from a master
+	// to another: following a redirect or the leader told by the state of a
+	// non-leading master, or trying the next master after a failed fetch.
+	MasterStateFailovers Counter
+	// MasterBreakers counts the transitions of the circuit breakers of the
+	// masters, per master address and state transitioned to, labelled
+	// "address/state".
+	MasterBreakers CounterVec
+	// RecordSwaps counts the snapshots of records swapped in for serving,
+	// labelled "full" for those generated from a fetched state and
+	// "incremental" for those updated with task changes.
+	RecordSwaps CounterVec
+	// Recursors is the number of resolvers non-Mesos queries are forwarded
+	// to by default.
+	Recursors Gauge
+	// RecursorChanges counts the changes of the nameservers of the watched
+	// resolv.conf file.
+	RecursorChanges Counter
+	// PrefetchAttempts and PrefetchSuccesses count the refreshes of popular
+	// forwarded answers before they expire, and the successful ones.
+	PrefetchAttempts  Counter
+	PrefetchSuccesses Counter
+	// PrefetchDrops counts the refreshes not queued for the queue being
+	// full.
+	PrefetchDrops Counter
+	// ExhibitorFailures counts the failed polls of Exhibitor for the
+	// ZooKeeper ensemble.
+	ExhibitorFailures Counter
+	// ZkEnsembleChanges counts the changes of the ZooKeeper ensemble
+	// reported by Exhibitor.
+	ZkEnsembleChanges Counter
+	// ZkDetectorFailures counts the failures to start detecting the masters
+	// through the ZooKeeper ensemble reported by Exhibitor.
+	ZkDetectorFailures Counter
+	// HTTPRequestMillis summarizes the durations of the HTTP API requests in
+	// milliseconds, per route.
+	HTTPRequestMillis SummaryVec
 }
 
 // CurLog is the default package level LogOut.
 var CurLog = LogOut{
-	MesosRequests:     &LogCounter{},
-	MesosSuccess:      &LogCounter{},
-	MesosNXDomain:     &LogCounter{},
-	MesosFailed:       &LogCounter{},
-	NonMesosRequests:  &LogCounter{},
-	NonMesosSuccess:   &LogCounter{},
-	NonMesosNXDomain:  &LogCounter{},
-	NonMesosFailed:    &LogCounter{},
-	NonMesosForwarded: &LogCounter{},
+	MesosRequests:         &LogCounter{},
+	MesosSuccess:          &LogCounter{},
+	MesosNXDomain:         &LogCounter{},
+	MesosFailed:           &LogCounter{},
+	NonMesosRequests:      &LogCounter{},
+	NonMesosSuccess:       &LogCounter{},
+	NonMesosNXDomain:      &LogCounter{},
+	NonMesosFailed:        &LogCounter{},
+	NonMesosForwarded:     &LogCounter{},
+	InvalidRecordNames:    &LogCounter{},
+	DroppedRecords:        &LogCounter{},
+	NameCollisions:        &LogCounter{},
+	MalformedSlaves:       &LogCounter{},
+	MalformedTasks:        &LogCounter{},
+	UnroutableSlaves:      &LogCounter{},
+	MissingSlaveIPTasks:   &LogCounter{},
+	GeneratedRecords:      &LogGauge{},
+	ARecords:              &LogGauge{},
+	AAAARecords:           &LogGauge{},
+	SRVRecords:            &LogGauge{},
+	FrameworkRecords:      &LogGauge{},
+	SlaveRecords:          &LogGauge{},
+	MasterRecords:         &LogGauge{},
+	ListenerRecords:       &LogGauge{},
+	TaskRecords:           &LogGauge{},
+	StaticRecords:         &LogGauge{},
+	SkippedTasks:          &LogGauge{},
+	GenerationMillis:      &LogGauge{},
+	GenerationPhaseMillis: &LogSummaryVec{},
+	LeaderUnknown:         &LogGauge{},
+	RecordsCapped:         &LogGauge{},
+	StalenessSeconds:      &LogGaugeFunc{},
+	RecordsStale:          &LogGauge{},
+	GenerationEvents:      &LogCounterVec{},
+	MasterStateAttempts:   &LogCounterVec{},
+	MasterStateSuccesses:  &LogCounterVec{},
+	MasterStateBytes:      &LogCounterVec{},
+	MasterStateFailures:   &LogCounterVec{},
+	MasterStateSkips:      &LogCounterVec{},
+	MasterStateRetries:    &LogCounterVec{},
+	MasterStateFailovers:  &LogCounter{},
+	MasterBreakers:        &LogCounterVec{},
+	RecordSwaps:           &LogCounterVec{},
+	Recursors:             &LogGauge{},
+	RecursorChanges:       &LogCounter{},
+	PrefetchAttempts:      &LogCounter{},
+	PrefetchSuccesses:     &LogCounter{},
+	PrefetchDrops:         &LogCounter{},
+	ExhibitorFailures:     &LogCounter{},
+	ZkEnsembleChanges:     &LogCounter{},
+	ZkDetectorFailures:    &LogCounter{},
+	HTTPRequestMillis:     &LogSummaryVec{},
 }
 
 // PrintCurLog prints out the current LogOut and then resets
@@ -0,0 +1,45 @@
+package logging
+
+import "testing"
+
+func TestLogSummaryVec(t *testing.T) {
+	var ls LogSummaryVec
+	if got := ls.String(); got != "" {
+		t.Errorf("got %q for an empty summary", got)
+	}
+	for _, o := range []struct {
+		label string
+		v     float64
+	}{
+		{"tasks", 2}, {"fetch", 10}, {"tasks", 5}, {"tasks", 1},
+	} {
+		ls.Observe(o.label, o.v)
+	}
+	want := "fetch{count=1 sum=10 max=10} tasks{count=3 sum=8 max=5}"
+	if got := ls.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLogCounterVec(t *testing.T) {
+	var lc LogCounterVec
+	lc.Add("b", 2)
+	lc.Add("a", 1)
+	lc.Add("b", 3)
+	if got, want := lc.String(), "a=1 b=5"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLogGaugeFunc(t *testing.T) {
+	var lg LogGaugeFunc
+	if got := lg.String(); got != "0" {
+		t.Errorf("got %q for an unset gauge", got)
+	}
+	v := int64(1)
+	lg.SetFunc(func() int64 { return v })
+	v = 42
+	if got := lg.String(); got != "42" {
+		t.Errorf("got %q, want %q", got, "42")
+	}
+}
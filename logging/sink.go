@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Sink defines an interface for forwarding metric updates to a monitoring
+// system. Metrics are named after their LogOut field, suffixed with their
+// sanitized label, if any, e.g. "GenerationEvents.collision_task".
+// Implementations must be safe for concurrent use and must not block.
+type Sink interface {
+	// Count increments the named counter by delta.
+	Count(name string, delta uint64)
+	// Gauge sets the named gauge to the given value.
+	Gauge(name string, v int64)
+	// GaugeFunc registers a function computing the named gauge's value
+	// whenever the sink reports it.
+	GaugeFunc(name string, f func() int64)
+	// Timing records a duration of the named timer in milliseconds.
+	Timing(name string, ms float64)
+}
+
+// AddSink makes every metric of CurLog also forward its updates to the given
+// sink, in addition to the sinks added before. It must be called before the
+// metrics get updated concurrently, e.g. upon startup.
+func AddSink(s Sink) {
+	CurLog = CurLog.withSink(s)
+}
+
+var (
+	counterType    = reflect.TypeOf((*Counter)(nil)).Elem()
+	gaugeType      = reflect.TypeOf((*Gauge)(nil)).Elem()
+	gaugeFuncType  = reflect.TypeOf((*GaugeFunc)(nil)).Elem()
+	summaryVecType = reflect.TypeOf((*SummaryVec)(nil)).Elem()
+	counterVecType = reflect.TypeOf((*CounterVec)(nil)).Elem()
+)
+
+// withSink returns a copy of lo whose metrics forward their updates to the
+// given sink.
+func (lo LogOut) withSink(s Sink) LogOut {
+	v := reflect.ValueOf(&lo).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f, name := v.Field(i), v.Type().Field(i).Name
+		if f.IsNil() {
+			continue
+		}
+		var m interface{}
+		switch f.Type() {
+		case counterType:
+			m = Counter(sinkCounter{f.Interface().(Counter), name, s})
+		case gaugeType:
+			m = Gauge(sinkGauge{f.Interface().(Gauge), name, s})
+		case gaugeFuncType:
+			g := f.Interface().(GaugeFunc)
+			if vg, ok := g.(valuer); ok {
+				// report whichever function is set, now or later
+				s.GaugeFunc(name, vg.Value)
+				continue
+			}
+			m = GaugeFunc(sinkGaugeFunc{g, name, s})
+		case summaryVecType:
+			m = SummaryVec(sinkSummaryVec{f.Interface().(SummaryVec), name, s})
+		case counterVecType:
+			m = CounterVec(sinkCounterVec{f.Interface().(CounterVec), name, s})
+		default:
+			continue
+		}
+		f.Set(reflect.ValueOf(m))
+	}
+	return lo
+}
+
+// valuer is implemented by gauges which can compute their current value.
+type valuer interface {
+	Value() int64
+}
+
+type sinkCounter struct {
+	Counter
+	name string
+	sink Sink
+}
+
+func (c sinkCounter) Inc() {
+	c.Counter.Inc()
+	c.sink.Count(c.name, 1)
+}
+
+func (c sinkCounter) String() string { return fmt.Sprint(c.Counter) }
+
+type sinkGauge struct {
+	Gauge
+	name string
+	sink Sink
+}
+
+func (g sinkGauge) Set(v int64) {
+	g.Gauge.Set(v)
+	g.sink.Gauge(g.name, v)
+}
+
+func (g sinkGauge) String() string { return fmt.Sprint(g.Gauge) }
+
+type sinkGaugeFunc struct {
+	GaugeFunc
+	name string
+	sink Sink
+}
+
+func (g sinkGaugeFunc) SetFunc(f func() int64) {
+	g.GaugeFunc.SetFunc(f)
+	g.sink.GaugeFunc(g.name, f)
+}
+
+func (g sinkGaugeFunc) String() string { return fmt.Sprint(g.GaugeFunc) }
+
+type sinkSummaryVec struct {
+	SummaryVec
+	name string
+	sink Sink
+}
+
+func (sv sinkSummaryVec) Observe(label string, v float64) {
+	sv.SummaryVec.Observe(label, v)
+	sv.sink.Timing(sv.name+"."+sanitizeLabel(label), v)
+}
+
+func (sv sinkSummaryVec) String() string { return fmt.Sprint(sv.SummaryVec) }
+
+type sinkCounterVec struct {
+	CounterVec
+	name string
+	sink Sink
+}
+
+func (cv sinkCounterVec) Add(label string, delta uint64) {
+	cv.CounterVec.Add(label, delta)
+	cv.sink.Count(cv.name+"."+sanitizeLabel(label), delta)
+}
+
+func (cv sinkCounterVec) String() string { return fmt.Sprint(cv.CounterVec) }
+
+// sanitizeLabel replaces the characters of a label that aren't letters,
+// digits, hyphens or underscores, such as the dots and colons of addresses,
+// with underscores so that it forms a single component of a metric name.
+func sanitizeLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, label)
+}
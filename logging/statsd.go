@@ -0,0 +1,209 @@
+package logging
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// statsdBufferSize is the default number of metric updates buffered
+	// between flushes.
+	statsdBufferSize = 4096
+	// statsdPacketSize bounds the size of the UDP packets sent so that they
+	// fit in the MTU of common networks.
+	statsdPacketSize = 1432
+)
+
+// StatsdConfig configures a StatsdSink.
+type StatsdConfig struct {
+	// Address is the host:port of the statsd server.
+	Address string
+	// Prefix, if not empty, is prepended to every metric name, followed by
+	// a dot.
+	Prefix string
+	// FlushInterval is the interval at which the buffered updates are sent.
+	FlushInterval time.Duration
+	// SampleRate is the fraction, in (0, 1], of the counter and timer
+	// updates sent. Gauges are never sampled.
+	SampleRate float64
+	// BufferSize bounds the number of updates buffered between flushes;
+	// further updates are dropped. Defaults to 4096.
+	BufferSize int
+}
+
+// StatsdSink implements the Sink interface by sending metric updates to a
+// statsd server over UDP. Updates are buffered and sent by a background
+// goroutine every flush interval so that updating a metric never blocks;
+// updates which don't fit in the buffer are dropped and counted, and
+// themselves reported as the StatsdDropped counter.
+// It's safe for concurrent use.
+type StatsdSink struct {
+	cfg     StatsdConfig
+	conn    net.Conn
+	updates chan string
+	dropped uint64
+
+	mu     sync.Mutex
+	gauges map[string]func() int64
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewStatsdSink returns a StatsdSink sending updates to the statsd server of
+// the given configuration.
+func NewStatsdSink(cfg StatsdConfig) (*StatsdSink, error) {
+	conn, err := net.Dial("udp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		cfg.SampleRate = 1
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = statsdBufferSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	s := &StatsdSink{
+		cfg:     cfg,
+		conn:    conn,
+		updates: make(chan string, cfg.BufferSize),
+		gauges:  map[string]func() int64{},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.loop()
+	return s, nil
+}
+
+// Count implements the Sink interface.
+func (s *StatsdSink) Count(name string, delta uint64) {
+	if s.sampled() {
+		s.enqueue(name, strconv.FormatUint(delta, 10), "c", true)
+	}
+}
+
+// Gauge implements the Sink interface.
+func (s *StatsdSink) Gauge(name string, v int64) {
+	s.enqueue(name, strconv.FormatInt(v, 10), "g", false)
+}
+
+// GaugeFunc implements the Sink interface: the gauge's value is sent upon
+// every flush.
+func (s *StatsdSink) GaugeFunc(name string, f func() int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gauges[name] = f
+}
+
+// Timing implements the Sink interface.
+func (s *StatsdSink) Timing(name string, ms float64) {
+	if s.sampled() {
+		s.enqueue(name, strconv.FormatFloat(ms, 'f', -1, 64), "ms", true)
+	}
+}
+
+// Dropped returns the number of updates dropped so far for lack of buffer
+// space.
+func (s *StatsdSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close flushes the buffered updates and stops the sink.
+func (s *StatsdSink) Close() error {
+	close(s.done)
+	<-s.stopped
+	return s.conn.Close()
+}
+
+func (s *StatsdSink) sampled() bool {
+	return s.cfg.SampleRate >= 1 || rand.Float64() < s.cfg.SampleRate
+}
+
+// enqueue buffers an update in the statsd line format, e.g.
+// "prefix.name:1|c|@0.5", dropping it if the buffer is full.
+func (s *StatsdSink) enqueue(name, value, typ string, sampled bool) {
+	line := s.line(name, value, typ, sampled)
+	select {
+	case s.updates <- line:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *StatsdSink) line(name, value, typ string, sampled bool) string {
+	if s.cfg.Prefix != "" {
+		name = s.cfg.Prefix + "." + name
+	}
+	line := name + ":" + value + "|" + typ
+	if sampled && s.cfg.SampleRate < 1 {
+		line += "|@" + strconv.FormatFloat(s.cfg.SampleRate, 'f', -1, 64)
+	}
+	return line
+}
+
+// loop sends the buffered updates every flush interval until the sink is
+// closed.
+func (s *StatsdSink) loop() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	var reported uint64 // dropped updates reported so far
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			s.flush(&reported)
+			return
+		}
+		s.flush(&reported)
+	}
+}
+
+// flush sends the buffered updates along with the gauge functions' values
+// and the number of updates dropped since the last flush, packing as many
+// lines as fit in each packet.
+func (s *StatsdSink) flush(reported *uint64) {
+	var lines []string
+	for n := len(s.updates); n > 0; n-- {
+		lines = append(lines, <-s.updates)
+	}
+	s.mu.Lock()
+	for name, f := range s.gauges {
+		lines = append(lines, s.line(name, strconv.FormatInt(f(), 10), "g", false))
+	}
+	s.mu.Unlock()
+	if dropped := s.Dropped(); dropped > *reported {
+		lines = append(lines, s.line("StatsdDropped", strconv.FormatUint(dropped-*reported, 10), "c", false))
+		*reported = dropped
+	}
+
+	var packet bytes.Buffer
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacketSize {
+			s.send(packet.Bytes())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		s.send(packet.Bytes())
+	}
+}
+
+func (s *StatsdSink) send(packet []byte) {
+	if _, err := s.conn.Write(packet); err != nil {
+		VeryVerbose.Printf("failed to send metrics to statsd: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	SetupLogs()
+}
+
+// listenStatsd returns a UDP listener standing in for a statsd server.
+func listenStatsd(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pc
+}
+
+// receive reads the lines sent to pc until all the wanted ones were received
+// or a timeout elapsed, returning the missing ones.
+func receive(t *testing.T, pc net.PacketConn, want ...string) (missing []string) {
+	pending := map[string]bool{}
+	for _, line := range want {
+		pending[line] = true
+	}
+	buf := make([]byte, 2*statsdPacketSize)
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(pending) > 0 {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if n > statsdPacketSize {
+			t.Errorf("got a packet of %d bytes, want at most %d", n, statsdPacketSize)
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			delete(pending, line)
+		}
+	}
+	for line := range pending {
+		missing = append(missing, line)
+	}
+	return missing
+}
+
+func TestStatsdSink(t *testing.T) {
+	pc := listenStatsd(t)
+	defer pc.Close()
+
+	sink, err := NewStatsdSink(StatsdConfig{
+		Address:       pc.LocalAddr().String(),
+		Prefix:        "mesos-dns",
+		FlushInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	lo := LogOut{
+		MesosRequests:         &LogCounter{},
+		GeneratedRecords:      &LogGauge{},
+		GenerationPhaseMillis: &LogSummaryVec{},
+		StalenessSeconds:      &LogGaugeFunc{},
+		MasterStateFailures:   &LogCounterVec{},
+	}.withSink(sink)
+
+	lo.MesosRequests.Inc()
+	lo.GeneratedRecords.Set(42)
+	lo.GenerationPhaseMillis.Observe("fetch", 2.5)
+	lo.StalenessSeconds.SetFunc(func() int64 { return 7 })
+	lo.MasterStateFailures.Add("10.0.0.1:5050/timeout", 2)
+
+	if missing := receive(t, pc,
+		"mesos-dns.MesosRequests:1|c",
+		"mesos-dns.GeneratedRecords:42|g",
+		"mesos-dns.GenerationPhaseMillis.fetch:2.5|ms",
+		"mesos-dns.StalenessSeconds:7|g",
+		"mesos-dns.MasterStateFailures.10_0_0_1_5050_timeout:2|c",
+	); len(missing) > 0 {
+		t.Errorf("didn't receive %q", missing)
+	}
+
+	// the metrics are still updated along with the sink
+	for _, tt := range []struct {
+		metric interface{}
+		want   string
+	}{
+		{lo.MesosRequests, "1"},
+		{lo.GeneratedRecords, "42"},
+		{lo.GenerationPhaseMillis, "fetch{count=1 sum=2.5 max=2.5}"},
+		{lo.StalenessSeconds, "7"},
+		{lo.MasterStateFailures, "10.0.0.1:5050/timeout=2"},
+	} {
+		if got := fmt.Sprint(tt.metric); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestStatsdSink_Dropped(t *testing.T) {
+	pc := listenStatsd(t)
+	defer pc.Close()
+
+	sink, err := NewStatsdSink(StatsdConfig{
+		Address:       pc.LocalAddr().String(),
+		FlushInterval: time.Hour,
+		BufferSize:    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		sink.Count("MesosRequests", 1)
+	}
+	if got, want := sink.Dropped(), uint64(3); got != want {
+		t.Errorf("got %d dropped updates, want %d", got, want)
+	}
+	// closing flushes the buffered updates
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if missing := receive(t, pc, "MesosRequests:1|c", "StatsdDropped:3|c"); len(missing) > 0 {
+		t.Errorf("didn't receive %q", missing)
+	}
+}
+
+func TestStatsdSink_Line(t *testing.T) {
+	for i, tt := range []struct {
+		prefix  string
+		rate    float64
+		typ     string
+		sampled bool
+		want    string
+	}{
+		{"", 1, "c", true, "x:1|c"},
+		{"p", 1, "c", true, "p.x:1|c"},
+		{"p", 0.25, "c", true, "p.x:1|c|@0.25"},
+		{"p", 0.25, "g", false, "p.x:1|g"},
+	} {
+		s := &StatsdSink{cfg: StatsdConfig{Prefix: tt.prefix, SampleRate: tt.rate}}
+		if got := s.line("x", "1", tt.typ, tt.sampled); got != tt.want {
+			t.Errorf("test #%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"time"
 
 	"github.com/mesos/mesos-go/detector"
+	"github.com/mesosphere/mesos-dns/activation"
 	"github.com/mesosphere/mesos-dns/detect"
 	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/mesosphere/mesos-dns/records"
@@ -15,6 +17,14 @@ import (
 	"github.com/mesosphere/mesos-dns/util"
 )
 
+const (
+	// exhibitorPollInterval is the interval at which Exhibitor is polled for
+	// the ZooKeeper ensemble, if configured.
+	exhibitorPollInterval = 30 * time.Second
+	// exhibitorTimeout is the timeout of its requests.
+	exhibitorTimeout = 10 * time.Second
+)
+
 func main() {
 	util.PanicHandlers = append(util.PanicHandlers, func(_ interface{}) {
 		// by default the handler already logs the panic
@@ -40,7 +50,24 @@ func main() {
 
 	// initialize resolver
 	config := records.SetConfig(*cjson)
+	if config.StatsdAddress != "" {
+		sink, err := logging.NewStatsdSink(logging.StatsdConfig{
+			Address:       config.StatsdAddress,
+			Prefix:        config.StatsdPrefix,
+			FlushInterval: time.Second * time.Duration(config.StatsdFlushSeconds),
+			SampleRate:    config.StatsdSampleRate,
+		})
+		if err != nil {
+			logging.Error.Fatalf("failed to set up the statsd sink: %v", err)
+		}
+		logging.AddSink(sink)
+	}
 	res := resolver.New(Version, config)
+	sockets, err := activation.Listen()
+	if err != nil {
+		logging.Error.Fatalf("failed to use the sockets passed by systemd: %v", err)
+	}
+	res.UseSockets(sockets)
 	errch := make(chan error)
 
 	// launch DNS server
@@ -63,8 +90,16 @@ func main() {
 		}()
 	}
 
-	changed := detectMasters(config.Zk, config.Masters)
-	reload := time.NewTicker(time.Second * time.Duration(config.RefreshSeconds))
+	// follow the event stream once the records of a full state are served
+	if config.EventStream {
+		go func() {
+			<-res.Ready()
+			res.FollowEvents()
+		}()
+	}
+
+	changed := detectMasters(config)
+	reload := time.NewTimer(res.RefreshInterval())
 	zkTimeout := time.Second * time.Duration(config.ZkDetectionTimeout)
 	timeout := time.AfterFunc(zkTimeout, func() {
 		if zkTimeout > 0 {
@@ -72,12 +107,25 @@ func main() {
 		}
 	})
 
+	dump := make(chan os.Signal, 1)
+	notifyDump(dump)
+	reconfigure := make(chan os.Signal, 1)
+	notifyReload(reconfigure)
+
 	defer reload.Stop()
 	defer util.HandleCrash()
 	for {
 		select {
 		case <-reload.C:
 			res.Reload()
+			reload.Reset(res.RefreshInterval())
+		case <-res.Resyncs():
+			res.Reload()
+			reload.Reset(res.RefreshInterval())
+		case <-dump:
+			res.RequestDump()
+		case <-reconfigure:
+			_ = res.ReloadConfig() // rejections are logged
 		case masters := <-changed:
 			if len(masters) == 0 || masters[0] == "" { // no leader
 				timeout.Reset(zkTimeout)
@@ -93,9 +141,21 @@ func main() {
 	}
 }
 
-func detectMasters(zk string, masters []string) <-chan []string {
+func detectMasters(config records.Config) <-chan []string {
+	zk, masters := config.Zk, config.Masters
 	changed := make(chan []string, 1)
-	if zk != "" {
+	if config.ExhibitorURL != "" {
+		logging.Verbose.Println("Starting master detection through Exhibitor ", config.ExhibitorURL)
+		e := &detect.Exhibitor{
+			URL:      config.ExhibitorURL,
+			Path:     config.ExhibitorZkPath,
+			Zk:       zk,
+			Masters:  masters,
+			Interval: exhibitorPollInterval,
+			Client:   &http.Client{Timeout: exhibitorTimeout},
+		}
+		go e.Detect(changed, nil)
+	} else if zk != "" {
 		logging.Verbose.Println("Starting master detector for ZK ", zk)
 		if md, err := detector.New(zk); err != nil {
 			log.Fatalf("failed to create master detector: %v", err)
@@ -0,0 +1,3 @@
+// Package mesostest provides a fake Mesos master, serving state fixtures over
+// HTTP or HTTPS, for testing the state loading stack end to end.
+package mesostest
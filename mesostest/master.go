@@ -0,0 +1,286 @@
+package mesostest
+
+import (
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/errorutil"
+)
+
+// StatePaths are the paths a Master serves its state at, as Mesos masters
+// do.
+var StatePaths = []string{"/master/state.json", "/master/state"}
+
+// SummaryPaths are the paths of the endpoints a Master serves parts of its
+// state at, as Mesos masters do: the state summary, without the tasks of the
+// frameworks nor the leader, the slaves and the frameworks.
+var SummaryPaths = []string{"/master/state-summary", "/master/slaves", "/master/frameworks"}
+
+// LoginPath is the path of the IAM login endpoint of a Master with token
+// authentication.
+const LoginPath = "/acs/api/v1/auth/login"
+
+// Master is a fake Mesos master serving a state fixture, as configured by its
+// options. It's used in tests only.
+type Master struct {
+	*httptest.Server
+
+	state     []byte
+	summaries map[string][]byte // by path
+	redirect  string
+	gzip      bool
+	principal string
+	secret    string
+	uid       string
+	token     string
+	delay     time.Duration
+	malformed bool
+	ca        *CA
+	requests  int32
+}
+
+// Option is a functional configuration type that mutates a Master.
+type Option func(*Master)
+
+// State returns an Option serving the given state fixture, with its leader
+// set to the Master itself.
+func State(state []byte) Option {
+	return func(m *Master) { m.state = state }
+}
+
+// Redirect returns an Option making the Master a non-leading master, which
+// redirects state requests to the given leading master, as host:port, with a
+// 307 and a scheme relative location, as Mesos does.
+func Redirect(leader string) Option {
+	return func(m *Master) { m.redirect = leader }
+}
+
+// Gzip returns an Option gzip encoding the state served to requests
+// accepting it.
+func Gzip() Option {
+	return func(m *Master) { m.gzip = true }
+}
+
+// BasicAuth returns an Option challenging state requests lacking the given
+// HTTP Basic credentials.
+func BasicAuth(principal, secret string) Option {
+	return func(m *Master) { m.principal, m.secret = principal, secret }
+}
+
+// TokenAuth returns an Option challenging state requests lacking the given
+// authentication token, which the Master's IAM login endpoint hands out to
+// the given user ID. The JWT posted along isn't verified.
+func TokenAuth(uid, token string) Option {
+	return func(m *Master) { m.uid, m.token = uid, token }
+}
+
+// Delay returns an Option delaying the responses to state requests by the
+// given duration.
+func Delay(d time.Duration) Option {
+	return func(m *Master) { m.delay = d }
+}
+
+// Malformed returns an Option serving the state truncated by half, which
+// fails to decode.
+func Malformed() Option {
+	return func(m *Master) { m.malformed = true }
+}
+
+// TLS returns an Option serving HTTPS, with a certificate for the address of
+// the Master issued by the given CA.
+func TLS(ca *CA) Option {
+	return func(m *Master) { m.ca = ca }
+}
+
+// NewMaster starts and returns a Master configured with the given options,
+// serving an empty state unless given one. It panics on failure, like
+// httptest.NewServer. The caller should call Close when done.
+func NewMaster(options ...Option) *Master {
+	m := &Master{state: []byte("{}")}
+	mux := http.NewServeMux()
+	m.Server = httptest.NewUnstartedServer(mux)
+	for i := range options {
+		if options[i] != nil {
+			options[i](m)
+		}
+	}
+
+	state, err := withLeader(m.state, "master@"+m.Addr())
+	if err != nil {
+		panic(fmt.Sprintf("mesostest: invalid state fixture: %v", err))
+	}
+	m.state = state
+	if m.summaries, err = summaries(m.state); err != nil {
+		panic(fmt.Sprintf("mesostest: invalid state fixture: %v", err))
+	}
+	for _, path := range append(StatePaths, SummaryPaths...) {
+		mux.HandleFunc(path, m.serveState)
+	}
+	if m.token != "" {
+		mux.HandleFunc(LoginPath, m.serveLogin)
+	}
+
+	if m.ca == nil {
+		m.Start()
+		return m
+	}
+	host, _, _ := net.SplitHostPort(m.Addr())
+	cert, err := m.ca.issue(host)
+	if err != nil {
+		panic(fmt.Sprintf("mesostest: failed to issue certificate: %v", err))
+	}
+	m.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	m.StartTLS()
+	return m
+}
+
+// Addr returns the address of the Master, as host:port.
+func (m *Master) Addr() string {
+	return m.Listener.Addr().String()
+}
+
+// LoginURL returns the URL of the IAM login endpoint of the Master.
+func (m *Master) LoginURL() string {
+	return m.URL + LoginPath
+}
+
+// Requests returns the number of state requests the Master received.
+func (m *Master) Requests() int {
+	return int(atomic.LoadInt32(&m.requests))
+}
+
+// withLeader returns the given state with its leader set to the given one.
+func withLeader(state []byte, leader string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(state, &fields); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(leader)
+	if err != nil {
+		return nil, err
+	}
+	fields["leader"] = b
+	return json.Marshal(fields)
+}
+
+// summaries returns the bodies of the SummaryPaths endpoints of the given
+// state, by path.
+func summaries(state []byte) (map[string][]byte, error) {
+	var s struct {
+		Frameworks []map[string]json.RawMessage `json:"frameworks"`
+		Slaves     []json.RawMessage            `json:"slaves"`
+	}
+	if err := json.Unmarshal(state, &s); err != nil {
+		return nil, err
+	}
+	summarized := make([]map[string]json.RawMessage, len(s.Frameworks))
+	for i, f := range s.Frameworks {
+		summarized[i] = make(map[string]json.RawMessage, len(f))
+		for k, v := range f {
+			if k != "tasks" && k != "completed_tasks" {
+				summarized[i][k] = v
+			}
+		}
+	}
+	bodies := map[string][]byte{}
+	for i, v := range []interface{}{
+		map[string]interface{}{"slaves": s.Slaves, "frameworks": summarized},
+		map[string]interface{}{"slaves": s.Slaves},
+		map[string]interface{}{"frameworks": s.Frameworks},
+	} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		bodies[SummaryPaths[i]] = b
+	}
+	return bodies, nil
+}
+
+func (m *Master) serveState(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&m.requests, 1)
+	if !m.authorized(w, r) {
+		return
+	}
+	if m.delay > 0 {
+		select {
+		case <-time.After(m.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if m.redirect != "" {
+		http.Redirect(w, r, "//"+m.redirect+r.URL.Path, http.StatusTemporaryRedirect)
+		return
+	}
+
+	body, ok := m.summaries[r.URL.Path]
+	if !ok {
+		body = m.state
+	}
+	if m.malformed {
+		body = body[:len(body)/2]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	var out io.Writer = w
+	if m.gzip && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer errorutil.Ignore(gz.Close)
+		out = gz
+	}
+	_, _ = out.Write(body)
+}
+
+// authorized tells whether the given request carries the credentials
+// required, challenging it otherwise.
+func (m *Master) authorized(w http.ResponseWriter, r *http.Request) bool {
+	switch {
+	case m.principal != "":
+		if principal, secret, ok := r.BasicAuth(); ok && principal == m.principal && secret == m.secret {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="mesos"`)
+	case m.token != "":
+		if r.Header.Get("Authorization") == "token="+m.token {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `acsjwt realm="mesos"`)
+	default:
+		return true
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+func (m *Master) serveLogin(w http.ResponseWriter, r *http.Request) {
+	var login struct {
+		UID   string `json:"uid"`
+		Token string `json:"token"`
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if login.UID != m.uid || login.Token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Token string `json:"token"`
+	}{m.token})
+}
@@ -0,0 +1,53 @@
+package mesostest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMaster(t *testing.T) {
+	leader := NewMaster(State([]byte(`{"leader": "master@10.0.0.1:5050", "hostname": "m1"}`)), Gzip())
+	defer leader.Close()
+	follower := NewMaster(Redirect(leader.Addr()))
+	defer follower.Close()
+
+	// compression is left to the test so that the encoding can be checked
+	c := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	for _, path := range StatePaths {
+		req, err := http.NewRequest("GET", follower.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if got := resp.Request.URL.Host; got != leader.Addr() {
+			t.Errorf("%s: got response from %s, want %s", path, got, leader.Addr())
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("%s: got Content-Encoding %q, want gzip", path, got)
+		}
+		var body io.Reader
+		if body, err = gzip.NewReader(resp.Body); err != nil {
+			t.Fatal(err)
+		}
+		var state map[string]string
+		if err = json.NewDecoder(body).Decode(&state); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"leader": "master@" + leader.Addr(), "hostname": "m1"}
+		if !reflect.DeepEqual(state, want) {
+			t.Errorf("%s: got state %v, want %v", path, state, want)
+		}
+	}
+	if got := follower.Requests(); got != len(StatePaths) {
+		t.Errorf("got %d requests to the follower, want %d", got, len(StatePaths))
+	}
+}
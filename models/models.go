@@ -8,11 +8,13 @@ type AXFRResourceRecordSet map[string][]string
 // This is the internal structure of how mesos-dns works today and the transformation of string -> DNS Struct
 // happens on actual query time. Why this logic happens at query time? Who knows.
 
-// AXFRRecords are the As, AAAAs, and SRVs that actually make up the Mesos-DNS zone
+// AXFRRecords are the As, AAAAs, SRVs, PTRs and TXTs that actually make up the Mesos-DNS zone
 type AXFRRecords struct {
 	As    AXFRResourceRecordSet
 	AAAAs AXFRResourceRecordSet
 	SRVs  AXFRResourceRecordSet
+	PTRs  AXFRResourceRecordSet `json:",omitempty"`
+	TXTs  AXFRResourceRecordSet `json:",omitempty"`
 }
 
 // AXFR is a rough representation of a "transfer" of the Mesos-DNS data
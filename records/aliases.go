@@ -0,0 +1,97 @@
+package records
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/naming"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// serviceSpec returns a label spec cutting labels a byte shorter than the
+// given one, leaving room for the underscore prefixing the service labels of
+// SRV records.
+func serviceSpec(spec labels.Func) labels.Func {
+	return func(name string) string {
+		lab := spec(name)
+		if len(lab) >= maxLabelLen {
+			lab = strings.TrimRight(lab[:maxLabelLen-1], "-")
+		}
+		return lab
+	}
+}
+
+// aliasRecords inserts the records of the given task under an additional
+// name, in the given zone of the domain:
+//
+//	name.zone.domain.                // resolves to the IPs of the canonical name
+//	_service._{protocol}.zone.domain. // resolves to the ports of the task
+//
+// SRV records of discovery ports target the name.zone.domain. name, while
+// those of the other ports of the task, whose ports are those of its slave,
+// target the canonical slave name in the framework zone, like the usual SRV
+// records. Without a slave IP, only the A and AAAA records are inserted.
+func (rg *RecordGenerator) aliasRecords(ctx context, task state.Task, name, service, zone, fname, domain string, spec labels.Func, enumTask *EnumerableTask) {
+	host := name + "." + zone + "." + domain + "."
+	for _, tIP := range ctx.taskIPs {
+		rg.insertTaskRR(host, tIP.String(), rrsKindForIP(tIP), ctx.source, enumTask)
+	}
+
+	// without a slave IP no SRV records are published, as usual
+	if len(ctx.slaveIPs) == 0 {
+		return
+	}
+
+	asSRV := func(target string) naming.Chain {
+		return func(records ...string) {
+			for i := range records {
+				rg.insertTaskRR(records[i]+"."+domain+".", target, SRV, ctx.source, enumTask)
+			}
+		}
+	}
+	slaveHost := rg.canonicalName(ctx.taskName, ctx.taskID, ctx.slaveID, fname) + ".slave." + domain + "."
+	for _, port := range task.Ports() {
+		naming.WithProtocols(rg.portProtocols(protocolNone, spec), zone,
+			asSRV(slaveHost+":"+port))("_" + service)
+	}
+	for _, port := range ctx.ports {
+		naming.WithProtocols(rg.portProtocols(port.Protocol, spec), zone,
+			naming.WithNamedPort(port.Name, spec, asSRV(host+":"+strconv.Itoa(port.Number))))("_" + service)
+	}
+}
+
+// containerName returns the value of the containerNameLabel label of the
+// given task, if configured and set.
+func (rg *RecordGenerator) containerName(task state.Task) string {
+	if rg.containerNameLabel == "" {
+		return ""
+	}
+	for _, l := range task.Labels {
+		if l.Key == rg.containerNameLabel {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// namedPortRecords inserts the A and AAAA records of the given task under the
+// names of its named discovery ports, listing the addresses of the canonical
+// name, for clients which can't look up SRV records:
+//
+//	port.task.framework.domain.
+//
+// Their SRV records are left as is.
+func (rg *RecordGenerator) namedPortRecords(ctx context, fname, domain string, spec labels.Func, enumTask *EnumerableTask) {
+	tIPs := ctx.taskIPs
+	for _, port := range ctx.ports {
+		lab := spec(port.Name)
+		if lab == "" {
+			continue
+		}
+		name := lab + "." + ctx.taskName + "." + fname + "." + domain + "."
+		for _, tIP := range tIPs {
+			rg.insertTaskRR(name, tIP.String(), rrsKindForIP(tIP), ctx.source, enumTask)
+		}
+	}
+}
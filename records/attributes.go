@@ -0,0 +1,81 @@
+package records
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// Labels of the records of the slaves and tasks of the slaves with a given
+// attribute value, under value.attribute.domain.
+const (
+	attributeSlaves = "slave"
+	attributeTasks  = "tasks"
+)
+
+// unknownAttributeLog rate limits the logging of attribute records of
+// attributes no slave has.
+var unknownAttributeLog = logging.NewLimiter(time.Hour)
+
+// validateAttributeRecords checks that the given slave attribute names are
+// neither empty nor repeated.
+func validateAttributeRecords(names []string) error {
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		if name == "" {
+			return fmt.Errorf("#%d: empty attribute", i)
+		}
+		if seen[name] {
+			return fmt.Errorf("#%d: duplicate attribute %q", i, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// attributeFrags returns the domain fragments of the values of the given
+// slave's attributes with attribute records, value.attribute, both made
+// labels as per the given spec, marking the attributes found.
+func (rg *RecordGenerator) attributeFrags(slave state.Slave, spec labels.Func, found map[string]bool) []string {
+	var frags []string
+	for _, name := range rg.attributeRecords {
+		value, ok := slave.Attributes[name]
+		if !ok {
+			continue
+		}
+		found[name] = true
+		if v, n := spec(value), spec(name); v != "" && n != "" {
+			frags = append(frags, v+"."+n)
+		}
+	}
+	return frags
+}
+
+// logUnknownAttributes logs the attributes with attribute records no slave
+// has, once in a while, e.g. misspelled ones.
+func (rg *RecordGenerator) logUnknownAttributes(found map[string]bool) {
+	for _, name := range rg.attributeRecords {
+		if !found[name] && unknownAttributeLog.Allow(name) {
+			logging.Error.Printf("warning: no slave has the attribute %q of AttributeRecords, ignoring it", name)
+		}
+	}
+}
+
+// attributeTaskRecords inserts the A and AAAA records of the given task under
+// the attribute values of its slave with attribute records, listing the
+// addresses of the canonical name:
+//
+//	tasks.value.attribute.domain.          // every task of such slaves
+//	task.framework.value.attribute.domain. // the instances of the task on such slaves
+func (rg *RecordGenerator) attributeTaskRecords(ctx context, task state.Task, fname, domain string, enumTask *EnumerableTask) {
+	for _, frag := range rg.slaveAttributes[task.SlaveID] {
+		tail := "." + frag + "." + domain + "."
+		for _, tIP := range ctx.taskIPs {
+			rg.insertTaskRR(attributeTasks+tail, tIP.String(), rrsKindForIP(tIP), ctx.source, enumTask)
+			rg.insertTaskRR(ctx.taskName+"."+fname+tail, tIP.String(), rrsKindForIP(tIP), ctx.source, enumTask)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package records
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// How the dots of task IDs are handled in their byid records, as set by
+// Config.TaskIDDots.
+const (
+	// TaskIDDotsReplace replaces the dots of task IDs, like other characters
+	// invalid in labels, with hyphens, so that each task ID is a label.
+	TaskIDDotsReplace = "replace"
+	// TaskIDDotsSplit makes the dots of task IDs label boundaries.
+	TaskIDDotsSplit = "split"
+)
+
+// byIDSubdomain is the subdomain of the records of task IDs.
+const byIDSubdomain = "byid"
+
+// validateTaskIDDots checks that the given handling of the dots of task IDs
+// is known.
+func validateTaskIDDots(dots string) error {
+	switch dots {
+	case "", TaskIDDotsReplace, TaskIDDotsSplit:
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q: use %q or %q", dots, TaskIDDotsReplace, TaskIDDotsSplit)
+	}
+}
+
+// taskIDFrag returns the domain fragment of the given task ID, as is but for
+// characters invalid in labels and its dots, which are label boundaries if
+// split is set. Labels cut short have the end of what's left of them replaced
+// with a hash of what they were cut from, to stay distinct.
+func taskIDFrag(id string, split bool, spec labels.Func) string {
+	parts := []string{id}
+	if split {
+		parts = strings.Split(id, ".")
+	}
+	labs := make([]string, 0, len(parts))
+	for _, part := range parts {
+		lab := spec(part)
+		if truncated(part, lab, spec) {
+			lab = suffixFrag(lab, "-"+hashString(part), spec)
+		}
+		if lab != "" {
+			labs = append(labs, lab)
+		}
+	}
+	return strings.Join(labs, ".")
+}
+
+// byIDRecords inserts the records of the given task under its task ID:
+//
+//	taskid.framework.byid.domain.              // resolves to the IPs of the canonical name
+//	_taskid._{protocol}.framework.byid.domain. // resolves to the ports of the task
+//
+// The dots of task IDs are handled as per taskIDDots in A and AAAA records,
+// but always replaced in SRV records, whose service name is a single label.
+// SRV records of discovery ports target the taskid.framework.byid.domain.
+// name, while those of the other ports of the task, whose ports are those of
+// its slave, target the canonical slave name, like the usual SRV records.
+func (rg *RecordGenerator) byIDRecords(ctx context, task state.Task, fname, domain string, spec labels.Func, enumTask *EnumerableTask) {
+	id := taskIDFrag(task.ID, rg.taskIDDots == TaskIDDotsSplit, spec)
+	if id == "" {
+		return
+	}
+	service := taskIDFrag(task.ID, false, serviceSpec(spec))
+	rg.aliasRecords(ctx, task, id, service, fname+"."+byIDSubdomain, fname, domain, spec, enumTask)
+}
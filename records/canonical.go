@@ -0,0 +1,103 @@
+package records
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/labels"
+)
+
+// DefaultCanonicalNameTemplate is the template of the canonical names of
+// tasks used unless configured otherwise, e.g. web-xxxxx-s1.marathon.
+const DefaultCanonicalNameTemplate = "{{.Name}}-{{.Hash}}-{{.SlaveID}}.{{.Framework}}"
+
+// CanonicalName holds the parts of the canonical name of a task, as given to
+// the CanonicalNameTemplate rendering it.
+type CanonicalName struct {
+	// Name is the name of the task, as in its records.
+	Name string
+	// Hash is the hash of the ID of the task, see TaskHashLength.
+	Hash string
+	// SlaveID is the last part of the ID of the slave of the task, e.g. s1.
+	SlaveID string
+	// Framework is the domain fragment of the framework of the task.
+	Framework string
+}
+
+// parseCanonicalNameTemplate parses the given canonical name template,
+// returning nil for the default one, or empty, which canonicalName renders
+// without going through text/template.
+func parseCanonicalNameTemplate(text string) (*template.Template, error) {
+	if text == "" || text == DefaultCanonicalNameTemplate {
+		return nil, nil
+	}
+	return template.New("CanonicalNameTemplate").Option("missingkey=error").Parse(text)
+}
+
+// validateCanonicalNameTemplate checks that the given canonical name template
+// parses and renders, for sample parts, a name of labels valid as per the
+// given labels spec and at most 63 bytes long, which identifies tasks by their
+// hash.
+func validateCanonicalNameTemplate(text string, spec labels.Func) error {
+	tmpl, err := parseCanonicalNameTemplate(text)
+	if err != nil || tmpl == nil {
+		return err
+	}
+	sample := CanonicalName{Name: "task", Hash: "xxxxx", SlaveID: "s1", Framework: "framework"}
+	name, err := renderCanonicalName(tmpl, sample)
+	if err != nil {
+		return err
+	}
+	for _, label := range strings.Split(name, ".") {
+		switch {
+		case label == "":
+			return fmt.Errorf("renders %q, with an empty label", name)
+		case len(label) > maxLabelLen:
+			return fmt.Errorf("renders %q, with label %q longer than %d bytes", name, label, maxLabelLen)
+		case spec(label) != label:
+			return fmt.Errorf("renders %q, with invalid label %q", name, label)
+		}
+	}
+	sample.Hash = "yyyyy"
+	if other, err := renderCanonicalName(tmpl, sample); err != nil {
+		return err
+	} else if other == name {
+		return errors.New("doesn't render the Hash of tasks, which would share their canonical names")
+	}
+	return nil
+}
+
+// renderCanonicalName renders the given canonical name template with the
+// given parts.
+func renderCanonicalName(tmpl *template.Template, parts CanonicalName) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, parts); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// canonicalTemplateLog rate limits the logging of canonical name templates
+// failing to render.
+var canonicalTemplateLog = logging.NewLimiter(10 * time.Minute)
+
+// canonicalName returns the canonical name, under the domain, of the task of
+// the given name, task ID hash, slave ID tail and framework fragment, as per
+// the configured CanonicalNameTemplate. Should the template fail to render,
+// e.g. slicing a name too short, the default one is used.
+func (rg *RecordGenerator) canonicalName(name, hash, slaveID, fname string) string {
+	if rg.canonicalTemplate != nil {
+		canonical, err := renderCanonicalName(rg.canonicalTemplate, CanonicalName{name, hash, slaveID, fname})
+		if err == nil {
+			return canonical
+		}
+		if canonicalTemplateLog.Allow(name) {
+			logging.Error.Printf("warning: failed to render the canonical name of task %q, using the default one: %v", name, err)
+		}
+	}
+	return name + "-" + hash + "-" + slaveID + "." + fname
+}
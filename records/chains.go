@@ -1,80 +1,93 @@
 package records
 
 import (
+	"strings"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/naming"
+	"github.com/mesosphere/mesos-dns/records/state"
 )
 
-// chain is a generation func that consumes record-like strings and does
-// something with them
-type chain func(...string)
-
 const (
-	protocolNone = "" // for readability
-	domainNone   = "" // for readability
+	protocolNone = ""                   // for readability
+	domainNone   = naming.SubdomainNone // for readability
 )
 
-// withProtocol appends `._{protocol}.{framework}` to records. if protocol is "" then
-// the protocols "tcp" and "udp" are assumed.
-func withProtocol(protocol, framework string, spec labels.Func, gen chain) chain {
-	return func(records ...string) {
-		protocol = spec(protocol)
-		if protocol != protocolNone {
-			for i := range records {
-				records[i] += "._" + protocol + "." + framework
-			}
+// defaultProtocols are the protocols ports of no or an unusable protocol are
+// published under, unless configured otherwise.
+var defaultProtocols = []string{"tcp", "udp"}
+
+// protocolLog rate limits the logging of ports of unusual protocols.
+var protocolLog = logging.NewLimiter(10 * time.Minute)
+
+// duplicatePortLog rate limits the logging of tasks listing distinct
+// discovery ports under the same name and protocol.
+var duplicatePortLog = logging.NewLimiter(10 * time.Minute)
+
+// portProtocols returns the protocols the SRV records of a port of the given
+// protocol are published under: tcp or udp, in any case, as such; none, as
+// the default protocols; any other one sanitized as per the given label spec,
+// with a warning, or as the default protocols should nothing be left of it.
+func (rg *RecordGenerator) portProtocols(protocol string, spec labels.Func) []string {
+	defaults := rg.defaultProtocols
+	if len(defaults) == 0 {
+		defaults = defaultProtocols
+	}
+	switch p := strings.ToLower(strings.TrimSpace(protocol)); p {
+	case "tcp", "udp":
+		return []string{p}
+	case "":
+		return defaults
+	}
+	sanitized := spec(protocol)
+	if protocolLog.Allow(protocol) {
+		if sanitized == "" {
+			logging.Error.Printf("warning: port protocol %q is unusable, publishing the port under %v", protocol, defaults)
 		} else {
-			records = append(records, records...)
-			for i, j := 0, len(records)/2; j < len(records); {
-				records[i] += "._tcp." + framework
-				records[j] += "._udp." + framework
-				i++
-				j++
-			}
+			logging.Error.Printf("warning: port protocol %q is neither tcp nor udp, publishing the port under _%s", protocol, sanitized)
 		}
-		gen(records...)
 	}
+	if sanitized == "" {
+		return defaults
+	}
+	return []string{sanitized}
 }
 
-// withSubdomains appends `.{subdomain}` (for each subdomain spec'd) to records.
-// the empty subdomain "" indicates to generate records w/o a subdomain fragment.
-func withSubdomains(subdomains []string, gen chain) chain {
-	if len(subdomains) == 0 {
-		return gen
-	}
-	return func(records ...string) {
-		var (
-			recordLen = len(records)
-			tmp       = make([]string, recordLen*len(subdomains))
-			offset    = 0
-		)
-		for s := range subdomains {
-			if subdomains[s] == domainNone {
-				copy(tmp[offset:], records)
-			} else {
-				for i := range records {
-					tmp[offset+i] = records[i] + "." + subdomains[s]
-				}
+// discoveryPorts returns the discovery ports of the given task, less the
+// exact duplicates of those listed before, i.e. of the same name and
+// protocol, as normalized in records, and number. Distinct ports listed under
+// the same name and protocol are kept, but counted as EventDuplicatePort and
+// logged once per task so that the owners of the task can fix it.
+func (rg *RecordGenerator) discoveryPorts(task state.Task, spec labels.Func) []state.DiscoveryPort {
+	type key struct{ name, protocol string }
+	var (
+		ports     = task.DiscoveryInfo.Ports.DiscoveryPorts
+		deduped   = make([]state.DiscoveryPort, 0, len(ports))
+		numbers   = make(map[key][]int, len(ports))
+		conflicts []string
+	)
+next:
+	for _, port := range ports {
+		k := key{spec(port.Name), strings.ToLower(strings.TrimSpace(port.Protocol))}
+		for _, n := range numbers[k] {
+			if n == port.Number {
+				continue next
 			}
-			offset += recordLen
 		}
-		gen(tmp...)
-	}
-}
-
-// withNamedPort prepends a `_{discoveryInfo port name}.` to records
-func withNamedPort(portName string, spec labels.Func, gen chain) chain {
-	portName = spec(portName)
-	if portName == "" {
-		return gen
+		if len(numbers[k]) == 1 && k.name != "" {
+			conflicts = append(conflicts, k.name)
+		}
+		numbers[k] = append(numbers[k], port.Number)
+		deduped = append(deduped, port)
 	}
-	return func(records ...string) {
-		// generate without port-name prefix
-		gen(records...)
-
-		// generate with port-name prefix
-		for i := range records {
-			records[i] = "_" + portName + "." + records[i]
+	if len(conflicts) > 0 {
+		rg.Stats.event(EventDuplicatePort)
+		if duplicatePortLog.Allow(task.ID) {
+			logging.Error.Printf("warning: task %q lists distinct discovery ports named %s, "+
+				"publishing all of them under the same names", task.ID, strings.Join(conflicts, ", "))
 		}
-		gen(records...)
 	}
+	return deduped
 }
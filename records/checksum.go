@@ -0,0 +1,55 @@
+package records
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// checksumOnce holds the checksum of a generation of records, computed once.
+type checksumOnce struct {
+	once sync.Once
+	sum  string
+}
+
+// Checksum returns the checksum of the records, for replicas to check they
+// serve the same ones, empty if none were generated. That of the records
+// inserted by InsertState is computed along with them, whereas that of those
+// updated by ApplyTaskUpdate is computed when first asked for, so that
+// updates cost as much as the names they touch.
+func (rg *RecordGenerator) Checksum() string {
+	c := rg.sum
+	if c == nil {
+		return ""
+	}
+	c.once.Do(func() { c.sum = rg.checksum() })
+	return c.sum
+}
+
+// checksum returns the SHA-256 checksum, hex encoded, of the records in a
+// canonical serialization: one record per line with tab separated fields,
+// ordered by kind (A, AAAA, SRV, PTR then TXT), name and host, followed by the
+// priority and weight of weighted SRV records. Unlike WriteTo, hosts
+// are sorted rather than kept in insertion order so that replicas generating
+// the same records from the same state agree on it regardless of the order
+// in which they were inserted.
+func (rg *RecordGenerator) checksum() string {
+	h := sha256.New()
+	for _, kind := range []rrsKind{A, AAAA, SRV, PTR, TXT} {
+		rrs := kind.rrs(rg)
+		for _, name := range rrs.Names() {
+			hosts := rrs.Hosts(name)
+			sort.Strings(hosts)
+			for _, host := range hosts {
+				line := string(kind) + "\t" + name + "\t" + host
+				if d, ok := rrs.set(name).weight(host); ok {
+					line += fmt.Sprintf("\t%d %d", d.Priority, d.Weight)
+				}
+				_, _ = h.Write([]byte(line + "\n"))
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,75 @@
+package records
+
+import (
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// RecordSource identifies the framework, and optionally the task or the
+// executor, whose state produced a record.
+type RecordSource struct {
+	FrameworkID   string `json:"framework_id"`
+	FrameworkName string `json:"framework_name"`
+	TaskID        string `json:"task_id,omitempty"`
+	// Executor is the name of the executor, as in its records.
+	Executor string `json:"executor,omitempty"`
+}
+
+// Collision describes a record name and type that was claimed by more than
+// one framework. Records of colliding names are merged: clients of one
+// framework will also receive the answers of the other.
+type Collision struct {
+	Name   string       `json:"name"`
+	Rtype  string       `json:"rtype"`
+	First  RecordSource `json:"first"`
+	Second RecordSource `json:"second"`
+}
+
+type claimKey struct {
+	name string
+	kind rrsKind
+}
+
+type collisionKey struct {
+	claimKey
+	first, second RecordSource
+}
+
+// framework returns the framework-level part of the source.
+func (src RecordSource) framework() RecordSource {
+	src.TaskID, src.Executor = "", ""
+	return src
+}
+
+// claim records src as the owner of the given record name and kind, unless
+// already owned. A claim by a different framework than the current owner is
+// reported once per generation as a Collision.
+func (rg *RecordGenerator) claim(name string, kind rrsKind, src RecordSource) {
+	if rg.owners == nil {
+		rg.owners = map[claimKey]RecordSource{}
+		rg.collisions = map[collisionKey]struct{}{}
+	}
+	key := claimKey{name, kind}
+	owner, ok := rg.owners[key]
+	if !ok {
+		rg.owners[key] = src
+		return
+	}
+	if owner.framework() == src.framework() {
+		return
+	}
+	ck := collisionKey{key, owner.framework(), src.framework()}
+	if _, seen := rg.collisions[ck]; seen {
+		return
+	}
+	rg.collisions[ck] = struct{}{}
+	logging.CurLog.NameCollisions.Inc()
+	rg.Stats.event(EventCollision)
+	logging.Error.Printf("%s record name %q collides: claimed by framework %q (%s), also generated by framework %q (%s)",
+		kind, name, owner.FrameworkName, owner.FrameworkID, src.FrameworkName, src.FrameworkID)
+	rg.EnumData.Collisions = append(rg.EnumData.Collisions, Collision{
+		Name:   name,
+		Rtype:  string(kind),
+		First:  owner,
+		Second: src,
+	})
+}
@@ -1,9 +1,11 @@
 package records
 
 import (
+	"bufio"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -17,9 +19,46 @@ import (
 	"github.com/mesosphere/mesos-dns/httpcli/basic"
 	"github.com/mesosphere/mesos-dns/httpcli/iam"
 	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/labels"
 	"github.com/miekg/dns"
 )
 
+// State fetch strategies, as set by Config.StateFetchStrategy.
+const (
+	// StateFetchSequential requests the state from the masters in turn,
+	// until one returns it.
+	StateFetchSequential = "sequential"
+	// StateFetchConcurrent requests the state from the masters
+	// concurrently, the first one to return it winning.
+	StateFetchConcurrent = "concurrent"
+)
+
+// Mesos master APIs the state is fetched with, as set by
+// Config.MesosAPIVersion.
+const (
+	// MesosAPIv0 fetches the state from the /master/state.json endpoint.
+	MesosAPIv0 = "v0"
+	// MesosAPIv1 fetches the state with the GET_STATE call of the v1
+	// operator API, falling back to MesosAPIv0 for masters not supporting
+	// it.
+	MesosAPIv1 = "v1"
+)
+
+// Orders of the A and AAAA answers of queries, as set by Config.AnswerOrder.
+const (
+	// AnswerOrderRandom shuffles the answers of every query.
+	AnswerOrderRandom = "random"
+	// AnswerOrderRotate rotates the answers of each name by one more
+	// address every query, so that each comes first in turn.
+	AnswerOrderRotate = "rotate"
+	// AnswerOrderSorted sorts the answers by address.
+	AnswerOrderSorted = "sorted"
+)
+
+// DefaultResyncSeconds is the default interval, in seconds, between the full
+// state fetches with Config.EventStream.
+const DefaultResyncSeconds = 300
+
 // Config holds mesos dns configuration
 type Config struct {
 	// Refresh frequency: the frequency in seconds of regenerating records (default 60)
@@ -31,6 +70,56 @@ type Config struct {
 	Timeout int
 	// Timeout in seconds waiting for the master to return data from StateJson
 	StateTimeoutSeconds int
+	// StateFetchStrategy is how the state is fetched from the masters: in
+	// turn, if "sequential" or empty, or concurrently, if "concurrent", the
+	// first master returning the state of the leader winning.
+	StateFetchStrategy string
+	// StateHedgeMillis staggers the concurrent state requests to the
+	// masters by the given number of milliseconds, each master being
+	// requested only should the previous ones not have returned the state
+	// in time. 0 requests all masters at once.
+	StateHedgeMillis int
+	// MesosAPIVersion is the master API the state is fetched with: the
+	// /master/state.json endpoint, if "v0" or empty, or the GET_STATE call
+	// of the v1 operator API, if "v1".
+	MesosAPIVersion string
+	// StateSummary composes the state from the /master/state-summary,
+	// /master/slaves and /master/frameworks endpoints rather than fetching
+	// the /master/state.json one, which gets too large to fetch and decode
+	// in one go on large clusters. Orphan tasks get no records then.
+	StateSummary bool
+	// EventStream follows the event stream of the v1 operator API of the
+	// leading master, applying the task changes it tells to the records as
+	// they happen, between full state fetches every ResyncSeconds rather
+	// than RefreshSeconds.
+	EventStream bool
+	// ResyncSeconds is the interval, in seconds, between the full state
+	// fetches with EventStream, which reconcile the records with any
+	// change missed. 0 means DefaultResyncSeconds.
+	ResyncSeconds int
+	// StaleSeconds is how long the records being served may go without
+	// being generated from a fresh state, e.g. while the masters can't be
+	// reached, before they're deemed stale: they're still served, but every
+	// failed reload warns about them. 0 means the refresh interval, that of
+	// ResyncSeconds with EventStream.
+	StaleSeconds int
+	// MasterBreakerFailures is the number of consecutive failed state
+	// fetches from a master opening its circuit breaker, which skips it for
+	// MasterBreakerCooldownSeconds before probing it again. 0 disables the
+	// circuit breakers.
+	MasterBreakerFailures        int
+	MasterBreakerCooldownSeconds int
+	// StateMaxMegabytes is the maximum size of the state responses of the
+	// masters, in MiB, larger ones failing to be fetched. 0 uses the
+	// default of 4096.
+	StateMaxMegabytes int
+	// StateRetryAttempts is the maximum number of attempts to fetch the
+	// state from a master failing for transient reasons, such as a 503
+	// response or a reset connection, before moving on to the next master.
+	// Retries back off exponentially, with jitter, up to
+	// StateRetryMaxBackoffMillis. 0 or 1 disables retries.
+	StateRetryAttempts         int
+	StateRetryMaxBackoffMillis int
 	// Zookeeper Detection Timeout: how long in seconds to wait for Zookeeper to
 	// be initially responsive. Default is 30 and 0 means no timeout.
 	ZkDetectionTimeout int
@@ -39,6 +128,21 @@ type Config struct {
 	HTTPPort int `json:"HttpPort"`
 	// TTL: the TTL value used for SRV and A records (default 60)
 	TTL int32
+	// TTLOverrides set the TTL of the records whose names match their
+	// patterns, rather than TTL or that of their framework domain; the
+	// first override matching a name applies.
+	TTLOverrides []TTLOverride
+	// HealthCheckTTLs lowers the TTL of the records of tasks with health
+	// checks to their health check interval times HealthCheckTTLFactor, but
+	// no lower than HealthCheckTTLFloor, so that clients don't keep using
+	// instances long after they're known to be unhealthy.
+	HealthCheckTTLs bool
+	// HealthCheckTTLFactor is the factor of the health check interval of a
+	// task its records' TTL is lowered to. 0 uses the default of 1.
+	HealthCheckTTLFactor float64
+	// HealthCheckTTLFloor is the TTL, in seconds, health check intervals
+	// don't lower that of records below. 0 uses the default of 5.
+	HealthCheckTTLFloor int32
 	// SOA record fields (see http://tools.ietf.org/html/rfc1035#page-18)
 	SOASerial  uint32 // initial version number (incremented on refresh)
 	SOARefresh uint32 // refresh interval
@@ -49,16 +153,85 @@ type Config struct {
 	SOARname   string // email of admin esponsible
 	// Mesos master(s): a list of IP:port pairs for one or more Mesos masters
 	Masters []string
+	// MastersFile is the path of a file listing the Mesos masters, one
+	// host:port pair per line, re-read whenever it changes. It takes
+	// precedence over Masters unless it's missing or empty.
+	MastersFile string
 	// DNS server: IP address of the DNS server for forwarded accesses
 	ZoneResolvers map[string][]string
 	// DNS server: a list of IP addresses or IP:port pairs for DNS servers for forwarded accesses
 	Resolvers []string
+	// WatchResolvConf enables forwarding to the nameservers of
+	// /etc/resolv.conf as they change, after the Resolvers explicitly
+	// configured.
+	WatchResolvConf bool
+	// ForwardCacheSize is the number of forwarded answers cached for their
+	// TTL. 0 disables the cache.
+	ForwardCacheSize int
+	// PrefetchHits is the number of cache hits after which a forwarded
+	// answer is refreshed shortly before it expires, so that popular names
+	// keep being answered from the cache. 0 disables prefetching.
+	PrefetchHits int
+	// AnswerOrder is the order of the A and AAAA answers of queries of
+	// Mesos names: AnswerOrderRandom, the default, AnswerOrderRotate or
+	// AnswerOrderSorted. Other answers are shuffled whatever the order.
+	AnswerOrder string
+	// SearchSuffixes are the domains appended, in turn, to the hostnames
+	// of frameworks and slaves of a single label which don't resolve as is.
+	SearchSuffixes []string
+	// HostResolvers are the IP addresses or IP:port pairs of the DNS servers
+	// the hostnames of frameworks and slaves are looked up through, if any,
+	// instead of the system resolver.
+	HostResolvers []string
+	// HostResolversFallback enables looking hostnames up through the system
+	// resolver when the lookup through HostResolvers fails.
+	HostResolversFallback bool
+	// HostsFile is the path of a file in the /etc/hosts format listing the
+	// IP addresses of hostnames of frameworks and slaves, which are then not
+	// looked up. It's re-read whenever it changes.
+	HostsFile string
+	// HostLookupConcurrency is how many hostnames of frameworks and slaves
+	// are looked up at once during a generation, each lookup being given up
+	// on after 2 seconds. 0 means DefaultHostLookupConcurrency.
+	HostLookupConcurrency int
+	// HostCacheSeconds is how long the outcome of the lookup of a hostname
+	// of a framework or slave, successful or not, is reused rather than
+	// looked up again. 0 disables the cache.
+	HostCacheSeconds int
 	// IPSources is the prioritized list of task IP sources
 	IPSources []string // e.g. ["host", "docker", "mesos", "rkt"]
+	// AutoIPCIDRs are the networks, in CIDR notation, whose NetworkInfo IP
+	// addresses the autoip source deems routable, whatever network they're
+	// on.
+	AutoIPCIDRs []string
 	// Zookeeper: a single Zk url
 	Zk string
+	// ExhibitorURL is the base URL of the REST API of a Netflix Exhibitor
+	// managing the ZooKeeper ensemble the masters are detected through,
+	// which is polled for its servers.
+	ExhibitorURL string
+	// ExhibitorZkPath is the znode path the masters register under on the
+	// ensemble reported by Exhibitor (default "/mesos").
+	ExhibitorZkPath string
 	// Domain: name of the domain used (default "mesos", ie .mesos domain)
 	Domain string
+	// FrameworkDomains maps frameworks to alternate domains their records
+	// are generated under instead of Domain; the first mapping matching a
+	// framework applies. It's configured either as a list of mappings or as
+	// an object mapping framework names to domains.
+	FrameworkDomains FrameworkDomains
+	// FrameworkWhitelist, if not empty, restricts the frameworks whose
+	// records are generated to those whose names, normalized like in their
+	// records, match any of its patterns, as per path.Match, e.g. "spark-*".
+	FrameworkWhitelist []string
+	// FrameworkBlacklist excludes the frameworks whose names, normalized
+	// like in their records, match any of its patterns from record
+	// generation, even if whitelisted.
+	FrameworkBlacklist []string
+	// ReverseZones are the networks, in CIDR notation, whose reverse zones
+	// Mesos-DNS is authoritative for: PTR records are only generated for
+	// their addresses, and reverse queries outside of them are forwarded.
+	ReverseZones []string
 	// File is the location of the config.json file
 	File string
 	// Listen is the server DNS listener IP address
@@ -74,6 +247,124 @@ type Config struct {
 	ExternalOn bool
 	// EnforceRFC952 will enforce an older, more strict set of rules for DNS labels
 	EnforceRFC952 bool
+	// MissingSlaveIPFallback enables publishing the task IP based A and AAAA
+	// records of running tasks whose slave IP is unknown, as long as an IP
+	// can be found for the task in IPSources.
+	MissingSlaveIPFallback bool
+	// PublishOrphanTasks enables publishing the records of orphan tasks, whose
+	// framework hasn't re-registered after a master failover, under the
+	// synthetic "orphans" framework.
+	PublishOrphanTasks bool
+	// ExecutorRecords enables generating the records of the running executors
+	// of frameworks, on their slave, as those of tasks are.
+	ExecutorRecords bool
+	// AllowUnknownLeader enables generating records from a state lacking a
+	// leader, e.g. during a master failover, instead of failing. Leader
+	// records are then omitted.
+	AllowUnknownLeader bool
+	// DisambiguateFrameworks enables suffixing the domain fragment of distinct
+	// frameworks whose names normalize to the same fragment with a hash of
+	// their framework ID, so that each keeps a distinct namespace.
+	DisambiguateFrameworks bool
+	// FrameworkIDRecords enables generating the framework records under a
+	// name qualified with a hash of the framework ID as well, e.g.
+	// marathon-xxxxx.domain, to tell framework incarnations apart.
+	FrameworkIDRecords bool
+	// LatestFrameworkIncarnation restricts the framework records of the
+	// unqualified name of frameworks sharing a name to the most recently
+	// registered of them.
+	LatestFrameworkIncarnation bool
+	// StrictSOAMname causes record generation to fail, rather than
+	// synthesize an address record from the listener, when SOAMname has no
+	// A or AAAA record.
+	StrictSOAMname bool
+	// LocalAgent is the ID or hostname of the agent Mesos-DNS runs on in
+	// agent-local mode, where only it and its tasks get records.
+	LocalAgent string
+	// LocalAgentUpstreams are the IP addresses or IP:port pairs of the
+	// Mesos-DNS servers queries of names without records are forwarded to
+	// in agent-local mode.
+	LocalAgentUpstreams []string
+	// MaxRecords caps the number of records generated, if positive: once
+	// reached, no further task records are generated.
+	MaxRecords int
+	// DefaultPortProtocols are the protocols, tcp and/or udp, the SRV
+	// records of ports of no protocol, or of one unusable as a label, are
+	// published under, defaulting to both.
+	DefaultPortProtocols []string
+	// ShortSRVTargets makes the SRV records of task ports target the short
+	// task names, e.g. task.framework.domain, shared by the instances of a
+	// task, instead of their canonical names.
+	ShortSRVTargets bool
+	// HostPortRecords generates SRV records of the host ports mapped to the
+	// DiscoveryInfo ports of tasks, e.g. in bridge mode, under
+	// _task._protocol.hostport.framework.domain, targeting the canonical
+	// slave names of the tasks.
+	HostPortRecords bool
+	// EmitLegacyDiscoveryNames generates the records of tasks with a
+	// DiscoveryInfo name under that name as is, without normalizing it into
+	// a label, along with the normalized one. It defaults to true.
+	EmitLegacyDiscoveryNames bool
+	// DCOSNames generates the task names of the DC/OS DNS naming spec,
+	// task.framework.{agentip,containerip,autoip}.domain, along with the
+	// usual ones, if "alongside", or instead of the short task names, if
+	// "instead".
+	DCOSNames string
+	// TaskIDRecords generates the records of tasks under their task ID as
+	// well, taskid.framework.byid.domain.
+	TaskIDRecords bool
+	// TaskIDDots is how the dots of task IDs are handled in their records:
+	// replaced with hyphens, if "replace" or empty, or made label
+	// boundaries, if "split".
+	TaskIDDots string
+	// MinimumVisibility is the narrowest DiscoveryInfo visibility of the
+	// tasks whose records are generated: "FRAMEWORK", "CLUSTER" or
+	// "EXTERNAL". Tasks of a narrower visibility get no records, while
+	// those without any are published regardless. Empty means no minimum.
+	MinimumVisibility string
+	// TaskHashLength is the length of the task ID hashes of canonical task
+	// names, e.g. task-xxxxx-s1.framework.domain, between 5 and 16. 0 means
+	// DefaultTaskHashLength.
+	TaskHashLength int
+	// TaskHashAlgorithm is the algorithm the task IDs of canonical task
+	// names are hashed with: "sha1", the default if empty, or "sha256".
+	TaskHashAlgorithm string
+	// CanonicalNameTemplate is the text/template the canonical names of
+	// tasks, under the domain, are rendered with from a CanonicalName,
+	// DefaultCanonicalNameTemplate if empty.
+	CanonicalNameTemplate string
+	// ContainerNameLabel is the key of the task label whose value, e.g. a
+	// Docker container name, names the records of the task as well,
+	// containername.framework.domain, if set.
+	ContainerNameLabel string
+	// PortNameRecords generates the A and AAAA records of tasks under the
+	// names of their named discovery ports as well,
+	// port.task.framework.domain.
+	PortNameRecords bool
+	// PodRecords generates the A and AAAA records of the tasks of pods, or
+	// task groups, sharing an executor and network namespace, under the name
+	// of their pod, pod.framework.domain, and under their own name in it,
+	// task.pod.framework.domain.
+	PodRecords bool
+	// AttributeRecords are the names of the slave attributes, e.g. rack or
+	// zone, whose values the records of slaves and of their tasks are
+	// generated under as well: slave.value.attribute.domain,
+	// tasks.value.attribute.domain and task.framework.value.attribute.domain.
+	AttributeRecords []string
+	// TaskTXTRecords generates TXT records of the metadata of tasks under
+	// their canonical and short names: key=value strings of their ID,
+	// slave ID, framework, state and DiscoveryInfo labels.
+	TaskTXTRecords bool
+	// DNSSDRecords generates the PTR records DNS-SD clients browse the
+	// services of the domains by, see RFC 6763: from
+	// _services._dns-sd._udp.domain to the names of the SRV records of
+	// tasks, their service types, and from those to the targets of the
+	// records, their instances.
+	DNSSDRecords bool
+	// StrictRecordNames causes record generation to panic, rather than skip
+	// the record, when a structurally invalid record name is generated.
+	// Intended for tests and fuzzing.
+	StrictRecordNames bool
 	// SetTruncateBit when `false` ensures responses never have the Truncate bit set even
 	// if they were truncated. When `true` any message that gets truncated will have the
 	// Truncate bit set.
@@ -85,6 +376,22 @@ type Config struct {
 	SetTruncateBit bool
 	// Enumeration enabled via the API enumeration endpoint
 	EnumerationOn bool
+	// DumpDir is the directory the records being served are dumped to upon
+	// SIGUSR1, defaulting to the system's temporary directory.
+	DumpDir string
+	// TopTalkersOn enables tracking the most frequent query sources and
+	// names, listed by the /v1/debug/toptalkers endpoint.
+	TopTalkersOn bool
+	// StatsdAddress is the host:port of the statsd server metrics are sent
+	// to, if not empty.
+	StatsdAddress string
+	// StatsdPrefix is prepended to the names of the metrics sent to statsd.
+	StatsdPrefix string
+	// StatsdFlushSeconds is the interval at which metrics are sent to statsd.
+	StatsdFlushSeconds int
+	// StatsdSampleRate is the fraction of the counter and timer updates sent
+	// to statsd.
+	StatsdSampleRate float64
 	// Communicate with Mesos using HTTPS if set to true
 	MesosHTTPSOn bool
 	// CA certificate to use to verify Mesos Master certificate
@@ -112,6 +419,7 @@ type Config struct {
 func NewConfig() Config {
 	return Config{
 		ZkDetectionTimeout:  30,
+		ExhibitorZkPath:     "/mesos",
 		RefreshSeconds:      60,
 		TTL:                 60,
 		Domain:              "mesos",
@@ -136,107 +444,289 @@ func NewConfig() Config {
 		RecurseOn:           true,
 		IPSources:           []string{"netinfo", "mesos", "host"},
 		EnumerationOn:       true,
+		TopTalkersOn:        true,
+		StatsdPrefix:        "mesos-dns",
+		StatsdFlushSeconds:  1,
+		StatsdSampleRate:    1,
 		MesosAuthentication: httpcli.AuthNone,
+
+		// until the legacy names are phased out
+		EmitLegacyDiscoveryNames: true,
+
+		// hostnames seldom change
+		HostCacheSeconds: 60,
+
+		// ride out masters restarting or briefly overloaded
+		StateRetryAttempts:         3,
+		StateRetryMaxBackoffMillis: 1000,
 	}
 }
 
 // SetConfig instantiates a Config struct read in from config.json
 func SetConfig(cjson string) Config {
-	c, err := readConfig(cjson)
+	c, err := LoadConfig(cjson)
 	if err != nil {
 		logging.Error.Fatal(err)
 	}
-	logging.Verbose.Printf("config loaded from %q", c.File)
-	// validate and complete configuration file
-	err = validateEnabledServices(c)
+	return c
+}
+
+// ConfigError lists the reasons a configuration was rejected for.
+type ConfigError []string
+
+// Error implements the error interface.
+func (e ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e, "; ")
+}
+
+// LoadConfig instantiates a Config struct read in from config.json, returning
+// a ConfigError listing every validation failure if it's invalid.
+func LoadConfig(cjson string) (Config, error) {
+	c, err := readConfig(cjson)
 	if err != nil {
-		logging.Error.Fatalf("service validation failed: %v", err)
-	}
-	if err = validateMasters(c.Masters); err != nil {
-		logging.Error.Fatal(err)
+		return Config{}, ConfigError{err.Error()}
 	}
+	logging.Verbose.Printf("config loaded from %q", c.File)
 
+	// complete and validate configuration file
+	c.Domain = strings.ToLower(c.Domain)
+	c.initFrameworkDomains()
+	c.initTTLOverrides()
 	c.initResolvers()
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
 
-	if err = validateIPSources(c.IPSources); err != nil {
-		logging.Error.Fatalf("IPSources validation failed: %v", err)
+	c.initSOA()
+	var errs ConfigError
+	if err := c.initCertificates(); err != nil {
+		errs = append(errs, err.Error())
 	}
+	if err := c.initMesosAuthentication(); err != nil {
+		errs = append(errs, "MesosAuthentication: "+err.Error())
+	}
+	if len(errs) > 0 {
+		return Config{}, errs
+	}
+	c.log()
 
-	if c.StateTimeoutSeconds <= 0 {
-		logging.Error.Fatal("Invalid HTTP Timeout: ", c.StateTimeoutSeconds)
+	return *c, nil
+}
+
+// Validate checks the configuration, returning a ConfigError listing every
+// problem found, each prefixed with the names of the offending fields, or nil
+// if it's valid.
+func (c *Config) Validate() error {
+	var errs ConfigError
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, field+": "+err.Error())
+		}
 	}
 
-	c.Domain = strings.ToLower(c.Domain)
+	// servers and masters
+	if !c.DNSOn && !c.HTTPOn {
+		check("DNSOn, HTTPOn", errors.New("either the DNS or the HTTP server should be on"))
+	}
+	if c.DNSOn {
+		check("Listener", validateListener(c.Listener))
+		check("Port", validatePort(c.Port))
+	}
+	if c.HTTPOn {
+		check("HTTPListener", validateListener(c.HTTPListener))
+		check("HTTPPort", validatePort(c.HTTPPort))
+	}
+	if len(c.Masters) == 0 && c.Zk == "" && c.MastersFile == "" && c.ExhibitorURL == "" {
+		check("Masters, Zk", errors.New("specify Mesos masters or Zookeeper"))
+	}
+	check("Masters", validateMasters(c.Masters))
+	if c.MastersFile != "" && (c.Zk != "" || c.ExhibitorURL != "") {
+		check("MastersFile", errors.New("not supported along with Zk or ExhibitorURL"))
+	}
+	if c.ExhibitorURL != "" {
+		check("ExhibitorURL", validateHTTPURL(c.ExhibitorURL))
+		if !strings.HasPrefix(c.ExhibitorZkPath, "/") {
+			check("ExhibitorZkPath", fmt.Errorf("%q isn't an absolute znode path", c.ExhibitorZkPath))
+		}
+	}
 
-	err = validateDomainName(c.Domain)
-	if err != nil {
-		logging.Error.Fatalf("%s is not a valid domain name", c.Domain)
+	// record generation
+	check("Domain", validateHostName(c.Domain, c.labelSpec()))
+	check("FrameworkDomains", validateFrameworkDomains(c.FrameworkDomains, c.Domain, c.ZoneResolvers, c.labelSpec()))
+	check("ReverseZones", validateReverseZones(c.ReverseZones, c.ZoneResolvers))
+	check("SOAMname", validateHostName(strings.TrimSuffix(c.SOAMname, "."), c.labelSpec()))
+	check("IPSources", validateIPSources(c.IPSources))
+	check("AutoIPCIDRs", validateCIDRs(c.AutoIPCIDRs))
+	check("SearchSuffixes", validateSearchSuffixes(c.SearchSuffixes))
+	if c.HostsFile != "" {
+		check("HostsFile", validateFile(c.HostsFile))
 	}
+	check("HostResolvers", validateResolvers(c.HostResolvers))
+	if len(c.HostResolvers) > 0 {
+		check("Timeout", validateAtLeast(c.Timeout, 1))
+	} else if c.HostResolversFallback {
+		check("HostResolversFallback", errors.New("requires HostResolvers"))
+	}
+	check("HostLookupConcurrency", validateAtLeast(c.HostLookupConcurrency, 0))
+	check("HostCacheSeconds", validateAtLeast(c.HostCacheSeconds, 0))
+	check("RefreshSeconds", validateAtLeast(c.RefreshSeconds, 1))
+	check("StateTimeoutSeconds", validateAtLeast(c.StateTimeoutSeconds, 1))
+	check("StateFetchStrategy", validateStateFetchStrategy(c.StateFetchStrategy))
+	check("StateHedgeMillis", validateAtLeast(c.StateHedgeMillis, 0))
+	if c.StateHedgeMillis > 0 && c.StateFetchStrategy != StateFetchConcurrent {
+		check("StateHedgeMillis", fmt.Errorf("requires StateFetchStrategy %q", StateFetchConcurrent))
+	}
+	check("MesosAPIVersion", validateMesosAPIVersion(c.MesosAPIVersion))
+	if c.StateSummary && c.MesosAPIVersion == MesosAPIv1 {
+		check("StateSummary", fmt.Errorf("not supported with MesosAPIVersion %q", MesosAPIv1))
+	}
+	if c.StateSummary && c.StateFetchStrategy == StateFetchConcurrent {
+		check("StateSummary", fmt.Errorf("not supported with StateFetchStrategy %q", StateFetchConcurrent))
+	}
+	check("ResyncSeconds", validateAtLeast(c.ResyncSeconds, 0))
+	check("StaleSeconds", validateAtLeast(c.StaleSeconds, 0))
+	if c.ResyncSeconds > 0 && !c.EventStream {
+		check("ResyncSeconds", errors.New("requires EventStream"))
+	}
+	if c.EventStream && c.MaxRecords > 0 {
+		check("EventStream", errors.New("incremental updates are not supported with MaxRecords"))
+	}
+	check("MasterBreakerFailures", validateAtLeast(c.MasterBreakerFailures, 0))
+	if c.MasterBreakerFailures > 0 {
+		check("MasterBreakerCooldownSeconds", validateAtLeast(c.MasterBreakerCooldownSeconds, 1))
+	}
+	check("StateMaxMegabytes", validateAtLeast(c.StateMaxMegabytes, 0))
+	check("StateRetryAttempts", validateAtLeast(c.StateRetryAttempts, 0))
+	check("StateRetryMaxBackoffMillis", validateAtLeast(c.StateRetryMaxBackoffMillis, 0))
+	check("ZkDetectionTimeout", validateAtLeast(c.ZkDetectionTimeout, 0))
+	check("TTL", validateAtLeast(int(c.TTL), 0))
+	check("TTLOverrides", validateTTLOverrides(c.TTLOverrides))
+	check("FrameworkWhitelist", validateFrameworkPatterns(c.FrameworkWhitelist))
+	check("FrameworkBlacklist", validateFrameworkPatterns(c.FrameworkBlacklist))
+	check("AttributeRecords", validateAttributeRecords(c.AttributeRecords))
+	if c.HealthCheckTTLs {
+		if c.HealthCheckTTLFactor < 0 {
+			check("HealthCheckTTLFactor", fmt.Errorf("%v is negative", c.HealthCheckTTLFactor))
+		}
+		check("HealthCheckTTLFloor", validateAtLeast(int(c.HealthCheckTTLFloor), 0))
+	}
+	check("MaxRecords", validateAtLeast(c.MaxRecords, 0))
+	check("DefaultPortProtocols", validatePortProtocols(c.DefaultPortProtocols))
+	check("DCOSNames", validateDCOSNames(c.DCOSNames, c.ShortSRVTargets))
+	check("TaskIDDots", validateTaskIDDots(c.TaskIDDots))
+	check("MinimumVisibility", validateVisibility(c.MinimumVisibility))
+	check("TaskHashLength, TaskHashAlgorithm", validateTaskHash(c.TaskHashAlgorithm, c.TaskHashLength))
+	check("CanonicalNameTemplate", validateCanonicalNameTemplate(c.CanonicalNameTemplate, c.labelSpec()))
 
-	c.initSOA()
-	c.initCertificates()
-	c.initMesosAuthentication()
-	c.log()
+	// forwarding
+	if c.ExternalOn {
+		check("Resolvers", validateResolvers(c.Resolvers))
+		check("ZoneResolvers", validateZoneResolvers(c.ZoneResolvers, c.Domain))
+		check("Timeout", validateAtLeast(c.Timeout, 1))
+	} else if c.WatchResolvConf {
+		check("WatchResolvConf", errors.New("requires ExternalOn"))
+	}
+	check("ForwardCacheSize", validateAtLeast(c.ForwardCacheSize, 0))
+	check("PrefetchHits", validateAtLeast(c.PrefetchHits, 0))
+	if c.PrefetchHits > 0 && c.ForwardCacheSize == 0 {
+		check("PrefetchHits", errors.New("requires ForwardCacheSize"))
+	}
+	check("AnswerOrder", validateAnswerOrder(c.AnswerOrder))
+
+	// agent-local mode
+	if c.LocalAgent != "" {
+		check("LocalAgentUpstreams", validateResolvers(c.LocalAgentUpstreams))
+	} else if len(c.LocalAgentUpstreams) > 0 {
+		check("LocalAgentUpstreams", errors.New("requires LocalAgent"))
+	}
+
+	// statsd
+	if c.StatsdAddress != "" {
+		_, err := normalizeMaster(c.StatsdAddress)
+		check("StatsdAddress", err)
+		check("StatsdFlushSeconds", validateAtLeast(c.StatsdFlushSeconds, 1))
+		if c.StatsdSampleRate <= 0 || c.StatsdSampleRate > 1 {
+			check("StatsdSampleRate", fmt.Errorf("%v is not in (0, 1]", c.StatsdSampleRate))
+		}
+	}
+
+	// TLS and authentication
+	for _, f := range []struct{ field, path string }{
+		{"CACertFile", c.CACertFile},
+		{"CertFile", c.CertFile},
+		{"KeyFile", c.KeyFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if c.MesosHTTPSOn {
+			check(f.field, validateFile(f.path))
+		} else {
+			check(f.field, errors.New("requires MesosHTTPSOn"))
+		}
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		check("CertFile, KeyFile", errors.New("specify both the certificate and its private key"))
+	}
+	if c.IAMConfigFile != "" {
+		check("IAMConfigFile", validateFile(c.IAMConfigFile))
+	}
 
-	return *c
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// labelSpec returns the label spec record names conform to.
+func (c *Config) labelSpec() labels.Func {
+	if c.EnforceRFC952 {
+		return labels.RFC952
+	}
+	return labels.RFC1123
 }
 
-func (c *Config) initCertificates() {
+// initCertificates loads the TLS files, which Validate checked exist.
+func (c *Config) initCertificates() error {
 	if c.CACertFile != "" {
 		pool, err := readCACertFile(c.CACertFile)
 		if err != nil {
-			logging.Error.Fatal(err.Error())
+			return err
 		}
 		c.caPool = pool
 	}
 
-	if c.CertFile != "" && c.KeyFile == "" {
-		logging.Error.Fatalf("Missing private key")
-	}
-
-	if c.CertFile == "" && c.KeyFile != "" {
-		logging.Error.Fatalf("Missing certificate")
-	}
-
 	if c.CertFile != "" && c.KeyFile != "" {
 		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
 		if err != nil {
-			logging.Error.Fatal(err.Error())
+			return fmt.Errorf("CertFile, KeyFile: %v", err)
 		}
 		c.cert = cert
 	}
+	return nil
 }
 
-func (c *Config) initMesosAuthentication() {
+func (c *Config) initMesosAuthentication() error {
 	configMapOpts := httpcli.ConfigMapOptions{
 		basic.Configuration(c.MesosCredentials),
 	}
 	if c.IAMConfigFile != "" {
 		iamConfig, err := iam.LoadFromFile(c.IAMConfigFile)
 		if err != nil {
-			logging.Error.Fatal(err.Error())
+			return err
 		}
 		configMapOpts = append(configMapOpts, iam.Configuration(iamConfig))
 	}
 
 	c.httpConfigMap = configMapOpts.ToConfigMap()
-	err := httpcli.Validate(c.MesosAuthentication, c.httpConfigMap)
-	if err != nil {
-		logging.Error.Fatal(err.Error())
-	}
+	return httpcli.Validate(c.MesosAuthentication, c.httpConfigMap)
 }
 
+// initResolvers defaults the resolvers to the nameservers of
+// /etc/resolv.conf, unless it's watched.
 func (c *Config) initResolvers() {
-	if c.ExternalOn {
-		if len(c.Resolvers) == 0 {
-			c.Resolvers = GetLocalDNS()
-		}
-		if err := validateResolvers(c.Resolvers); err != nil {
-			logging.Error.Fatal(err)
-		}
-		if err := validateZoneResolvers(c.ZoneResolvers, c.Domain); err != nil {
-			logging.Error.Fatal(err)
-		}
+	if c.ExternalOn && len(c.Resolvers) == 0 && !c.WatchResolvConf {
+		c.Resolvers = GetLocalDNS()
 	}
 }
 
@@ -253,22 +743,59 @@ func (c Config) log() {
 	if err != nil {
 		zoneResolversJSON = []byte(fmt.Sprintf("error: %v", err))
 	}
+	frameworkDomainsJSON, err := json.Marshal(c.FrameworkDomains)
+	if err != nil {
+		frameworkDomainsJSON = []byte(fmt.Sprintf("error: %v", err))
+	}
+	ttlOverridesJSON, err := json.Marshal(c.TTLOverrides)
+	if err != nil {
+		ttlOverridesJSON = []byte(fmt.Sprintf("error: %v", err))
+	}
 	logging.Verbose.Println("Mesos-DNS configuration:")
 	logging.Verbose.Println("   - Masters: " + strings.Join(c.Masters, ", "))
+	logging.Verbose.Println("   - MastersFile: " + c.MastersFile)
 	logging.Verbose.Println("   - Zookeeper: ", c.Zk)
+	logging.Verbose.Println("   - ExhibitorURL: ", c.ExhibitorURL)
+	logging.Verbose.Println("   - ExhibitorZkPath: ", c.ExhibitorZkPath)
 	logging.Verbose.Println("   - ZookeeperDetectionTimeout: ", c.ZkDetectionTimeout)
 	logging.Verbose.Println("   - RefreshSeconds: ", c.RefreshSeconds)
 	logging.Verbose.Println("   - Domain: " + c.Domain)
+	logging.Verbose.Println("   - FrameworkDomains: " + string(frameworkDomainsJSON))
+	logging.Verbose.Println("   - FrameworkWhitelist: ", c.FrameworkWhitelist)
+	logging.Verbose.Println("   - FrameworkBlacklist: ", c.FrameworkBlacklist)
+	logging.Verbose.Println("   - ReverseZones: ", c.ReverseZones)
 	logging.Verbose.Println("   - Listener: " + c.Listener)
 	logging.Verbose.Println("   - HTTPListener: " + c.HTTPListener)
 	logging.Verbose.Println("   - Port: ", c.Port)
 	logging.Verbose.Println("   - DnsOn: ", c.DNSOn)
 	logging.Verbose.Println("   - TTL: ", c.TTL)
+	logging.Verbose.Println("   - TTLOverrides: " + string(ttlOverridesJSON))
+	logging.Verbose.Println("   - HealthCheckTTLs: ", c.HealthCheckTTLs)
+	if c.HealthCheckTTLs {
+		logging.Verbose.Println("   - HealthCheckTTLFactor: ", c.HealthCheckTTLFactor)
+		logging.Verbose.Println("   - HealthCheckTTLFloor: ", c.HealthCheckTTLFloor)
+	}
 	logging.Verbose.Println("   - Timeout: ", c.Timeout)
 	logging.Verbose.Println("   - StateTimeoutSeconds: ", c.StateTimeoutSeconds)
+	logging.Verbose.Println("   - StateFetchStrategy: ", c.StateFetchStrategy)
+	logging.Verbose.Println("   - StateHedgeMillis: ", c.StateHedgeMillis)
+	logging.Verbose.Println("   - MesosAPIVersion: ", c.MesosAPIVersion)
+	logging.Verbose.Println("   - StateSummary: ", c.StateSummary)
+	logging.Verbose.Println("   - EventStream: ", c.EventStream)
+	logging.Verbose.Println("   - ResyncSeconds: ", c.ResyncSeconds)
+	logging.Verbose.Println("   - StaleSeconds: ", c.StaleSeconds)
+	logging.Verbose.Println("   - MasterBreakerFailures: ", c.MasterBreakerFailures)
+	logging.Verbose.Println("   - MasterBreakerCooldownSeconds: ", c.MasterBreakerCooldownSeconds)
+	logging.Verbose.Println("   - StateMaxMegabytes: ", c.StateMaxMegabytes)
+	logging.Verbose.Println("   - StateRetryAttempts: ", c.StateRetryAttempts)
+	logging.Verbose.Println("   - StateRetryMaxBackoffMillis: ", c.StateRetryMaxBackoffMillis)
 
 	logging.Verbose.Println("   - ZoneResolvers: " + string(zoneResolversJSON))
 	logging.Verbose.Println("   - Resolvers: " + strings.Join(c.Resolvers, ", "))
+	logging.Verbose.Println("   - WatchResolvConf: ", c.WatchResolvConf)
+	logging.Verbose.Println("   - ForwardCacheSize: ", c.ForwardCacheSize)
+	logging.Verbose.Println("   - PrefetchHits: ", c.PrefetchHits)
+	logging.Verbose.Println("   - AnswerOrder: ", c.AnswerOrder)
 	logging.Verbose.Println("   - ExternalOn: ", c.ExternalOn)
 	logging.Verbose.Println("   - SOAMname: " + c.SOAMname)
 	logging.Verbose.Println("   - SOARname: " + c.SOARname)
@@ -282,9 +809,51 @@ func (c Config) log() {
 	logging.Verbose.Println("   - HttpOn: ", c.HTTPOn)
 	logging.Verbose.Println("   - ConfigFile: ", c.File)
 	logging.Verbose.Println("   - EnforceRFC952: ", c.EnforceRFC952)
+	logging.Verbose.Println("   - MaxRecords: ", c.MaxRecords)
+	logging.Verbose.Println("   - DefaultPortProtocols: ", c.DefaultPortProtocols)
+	logging.Verbose.Println("   - ShortSRVTargets: ", c.ShortSRVTargets)
+	logging.Verbose.Println("   - HostPortRecords: ", c.HostPortRecords)
+	logging.Verbose.Println("   - EmitLegacyDiscoveryNames: ", c.EmitLegacyDiscoveryNames)
+	logging.Verbose.Println("   - DCOSNames: ", c.DCOSNames)
+	logging.Verbose.Println("   - TaskIDRecords: ", c.TaskIDRecords)
+	logging.Verbose.Println("   - TaskIDDots: ", c.TaskIDDots)
+	logging.Verbose.Println("   - MinimumVisibility: ", c.MinimumVisibility)
+	logging.Verbose.Println("   - TaskHashLength: ", c.TaskHashLength)
+	logging.Verbose.Println("   - TaskHashAlgorithm: ", c.TaskHashAlgorithm)
+	logging.Verbose.Println("   - CanonicalNameTemplate: ", c.CanonicalNameTemplate)
+	logging.Verbose.Println("   - ContainerNameLabel: ", c.ContainerNameLabel)
+	logging.Verbose.Println("   - PortNameRecords: ", c.PortNameRecords)
+	logging.Verbose.Println("   - PodRecords: ", c.PodRecords)
+	logging.Verbose.Println("   - AttributeRecords: ", c.AttributeRecords)
+	logging.Verbose.Println("   - TaskTXTRecords: ", c.TaskTXTRecords)
+	logging.Verbose.Println("   - DNSSDRecords: ", c.DNSSDRecords)
+	logging.Verbose.Println("   - LocalAgent: ", c.LocalAgent)
+	logging.Verbose.Println("   - LocalAgentUpstreams: ", c.LocalAgentUpstreams)
+	logging.Verbose.Println("   - StrictRecordNames: ", c.StrictRecordNames)
+	logging.Verbose.Println("   - StrictSOAMname: ", c.StrictSOAMname)
+	logging.Verbose.Println("   - MissingSlaveIPFallback: ", c.MissingSlaveIPFallback)
+	logging.Verbose.Println("   - PublishOrphanTasks: ", c.PublishOrphanTasks)
+	logging.Verbose.Println("   - ExecutorRecords: ", c.ExecutorRecords)
+	logging.Verbose.Println("   - DisambiguateFrameworks: ", c.DisambiguateFrameworks)
+	logging.Verbose.Println("   - FrameworkIDRecords: ", c.FrameworkIDRecords)
+	logging.Verbose.Println("   - LatestFrameworkIncarnation: ", c.LatestFrameworkIncarnation)
+	logging.Verbose.Println("   - AllowUnknownLeader: ", c.AllowUnknownLeader)
 	logging.Verbose.Println("   - SetTruncateBit: ", c.SetTruncateBit)
 	logging.Verbose.Println("   - IPSources: ", c.IPSources)
+	logging.Verbose.Println("   - AutoIPCIDRs: ", c.AutoIPCIDRs)
+	logging.Verbose.Println("   - SearchSuffixes: ", c.SearchSuffixes)
+	logging.Verbose.Println("   - HostResolvers: ", c.HostResolvers)
+	logging.Verbose.Println("   - HostResolversFallback: ", c.HostResolversFallback)
+	logging.Verbose.Println("   - HostsFile: ", c.HostsFile)
+	logging.Verbose.Println("   - HostLookupConcurrency: ", c.HostLookupConcurrency)
+	logging.Verbose.Println("   - HostCacheSeconds: ", c.HostCacheSeconds)
 	logging.Verbose.Println("   - EnumerationOn", c.EnumerationOn)
+	logging.Verbose.Println("   - TopTalkersOn", c.TopTalkersOn)
+	logging.Verbose.Println("   - DumpDir", c.DumpDir)
+	logging.Verbose.Println("   - StatsdAddress", c.StatsdAddress)
+	logging.Verbose.Println("   - StatsdPrefix", c.StatsdPrefix)
+	logging.Verbose.Println("   - StatsdFlushSeconds", c.StatsdFlushSeconds)
+	logging.Verbose.Println("   - StatsdSampleRate", c.StatsdSampleRate)
 	logging.Verbose.Println("   - MesosHTTPSOn", c.MesosHTTPSOn)
 	logging.Verbose.Println("   - CACertFile", c.CACertFile)
 	logging.Verbose.Println("   - CertFile", c.CertFile)
@@ -348,11 +917,29 @@ func readConfig(file string) (*Config, error) {
 		return nil, fmt.Errorf("missing configuration file: %q", c.File)
 	} else if err = json.Unmarshal(bs, &c); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config file %q: %v", c.File, err)
+	} else if c.WatchResolvConf && !hasField(bs, "Resolvers") {
+		// only forward to the nameservers of the watched resolv.conf
+		c.Resolvers = nil
 	}
 
 	return &c, nil
 }
 
+// hasField tells whether the given JSON object has the given field, matched
+// case-insensitively as by json.Unmarshal.
+func hasField(bs []byte, field string) bool {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(bs, &fields) != nil {
+		return false
+	}
+	for name := range fields {
+		if strings.EqualFold(name, field) {
+			return true
+		}
+	}
+	return false
+}
+
 func unique(ss []string) []string {
 	set := make(map[string]struct{}, len(ss))
 	out := make([]string, 0, len(ss))
@@ -368,12 +955,56 @@ func unique(ss []string) []string {
 // GetLocalDNS returns the first nameserver in /etc/resolv.conf
 // Used for non-Mesos queries.
 func GetLocalDNS() []string {
-	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	servers, err := LocalDNS("/etc/resolv.conf")
 	if err != nil {
 		logging.Error.Fatalf("%v", err)
 	}
 
-	return nonLocalAddies(conf.Servers)
+	return servers
+}
+
+// ReadMasters returns the host:port pairs of the Mesos masters listed in the
+// given file, one per line. Blank lines and comments, starting with #, are
+// ignored, while malformed and duplicate lines are skipped with a warning.
+func ReadMasters(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer errorutil.Ignore(f.Close)
+
+	var masters []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		master, err := normalizeMaster(line)
+		if err != nil {
+			logging.Error.Printf("warning: skipping line %d of %s: %v", n, path, err)
+			continue
+		} else if seen[master] {
+			logging.Error.Printf("warning: skipping line %d of %s: duplicate master %s", n, path, master)
+			continue
+		}
+		seen[master] = true
+		masters = append(masters, master)
+	}
+	return masters, scanner.Err()
+}
+
+// LocalDNS returns the non-local nameservers of the given resolv.conf file.
+func LocalDNS(path string) ([]string, error) {
+	conf, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return nonLocalAddies(conf.Servers), nil
 }
 
 // Returns non-local nameserver entries
@@ -1,6 +1,12 @@
 package records
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -26,13 +32,319 @@ func TestNewConfigValidates(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	err = validateEnabledServices(&c)
-	if err == nil {
-		t.Error("expected error because no masters and no zk servers are configured by default")
+	err = c.Validate()
+	if want := (ConfigError{"Masters, Zk: specify Mesos masters or Zookeeper"}); !reflect.DeepEqual(err, want) {
+		t.Errorf("got %v, want %v because no masters and no zk servers are configured by default", err, want)
 	}
 	c.Zk = "foo"
-	err = validateEnabledServices(&c)
+	err = c.Validate()
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+func TestConfig_Validate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, missing := filepath.Join(dir, "file.pem"), filepath.Join(dir, "missing.pem")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		set  func(*Config)
+		want string // prefix of the only problem expected, if any
+	}{
+		{func(c *Config) { c.DNSOn, c.HTTPOn = false, false }, "DNSOn, HTTPOn: either the DNS or the HTTP server should be on"},
+		{func(c *Config) { c.DNSOn = false }, ""},
+		{func(c *Config) { c.Listener = "localhost" }, `Listener: "localhost" is not an IP address`},
+		{func(c *Config) { c.DNSOn, c.Listener = false, "localhost" }, ""},
+		{func(c *Config) { c.Listener = "::1" }, ""},
+		{func(c *Config) { c.Port = 0 }, "Port: 0 is not a port between 1 and 65535"},
+		{func(c *Config) { c.Port = 5353 }, ""},
+		{func(c *Config) { c.HTTPListener = "" }, `HTTPListener: "" is not an IP address`},
+		{func(c *Config) { c.HTTPPort = 65536 }, "HTTPPort: 65536 is not a port between 1 and 65535"},
+		{func(c *Config) { c.HTTPOn, c.HTTPPort = false, 65536 }, ""},
+		{func(c *Config) { c.Masters = nil }, "Masters, Zk: specify Mesos masters or Zookeeper"},
+		{func(c *Config) { c.Masters, c.MastersFile = nil, "/etc/mesos/masters" }, ""},
+		{func(c *Config) { c.Zk, c.MastersFile = "zk://127.0.0.1:2181/mesos", "/etc/mesos/masters" }, "MastersFile: not supported along with Zk or ExhibitorURL"},
+		{func(c *Config) { c.Masters, c.ExhibitorURL = nil, "http://exhibitor:8080" }, ""},
+		{func(c *Config) { c.SearchSuffixes = []string{"corp.example.com", ".dc2.example.com."} }, ""},
+		{func(c *Config) { c.SearchSuffixes = []string{"corp..example.com"} }, "SearchSuffixes: \"corp..example.com\": empty label"},
+		{func(c *Config) { c.HostResolvers, c.HostResolversFallback = []string{"10.0.0.53:5353"}, true }, ""},
+		{func(c *Config) { c.HostResolvers = []string{"dns.example.com"} }, "HostResolvers: Error validating resolvers: Illegal ip specified: dns.example.com"},
+		{func(c *Config) { c.HostResolvers, c.ExternalOn, c.Timeout = []string{"10.0.0.53"}, false, 0 }, "Timeout: 0 is less than 1"},
+		{func(c *Config) { c.HostResolversFallback = true }, "HostResolversFallback: requires HostResolvers"},
+		{func(c *Config) { c.HostsFile = "/nonexistent/hosts" }, "HostsFile: stat /nonexistent/hosts: no such file or directory"},
+		{func(c *Config) { c.HostLookupConcurrency = 8 }, ""},
+		{func(c *Config) { c.HostLookupConcurrency = -1 }, "HostLookupConcurrency: -1 is less than 0"},
+		{func(c *Config) { c.HostCacheSeconds = 0 }, ""},
+		{func(c *Config) { c.HostCacheSeconds = -1 }, "HostCacheSeconds: -1 is less than 0"},
+		{func(c *Config) { c.StateRetryAttempts, c.StateRetryMaxBackoffMillis = 0, 0 }, ""},
+		{func(c *Config) { c.StateRetryAttempts = -1 }, "StateRetryAttempts: -1 is less than 0"},
+		{func(c *Config) { c.StateRetryMaxBackoffMillis = -1 }, "StateRetryMaxBackoffMillis: -1 is less than 0"},
+		{func(c *Config) { c.ExhibitorURL = "exhibitor:8080" }, "ExhibitorURL: \"exhibitor:8080\" is not an absolute HTTP or HTTPS URL"},
+		{func(c *Config) { c.ExhibitorURL, c.ExhibitorZkPath = "http://exhibitor:8080", "mesos" }, "ExhibitorZkPath: \"mesos\" isn't an absolute znode path"},
+		{func(c *Config) { c.Masters, c.Zk = nil, "zk://10.0.0.1:2181/mesos" }, ""},
+		{func(c *Config) { c.Masters = []string{"10.0.0.1"} }, "Masters: Error validating masters: Illegal host:port specified: 10.0.0.1."},
+		{func(c *Config) { c.Domain = "my_domain" }, `Domain: invalid label "my_domain", "my-domain" would be valid`},
+		{func(c *Config) { c.Domain = "a.b-c" }, ""},
+		{func(c *Config) { c.Domain = "mesos." }, "Domain: empty label at offset 6"},
+		{func(c *Config) {
+			c.FrameworkDomains = []FrameworkDomain{{Framework: "marathon", Domain: "apps.example.internal"}}
+		}, ""},
+		{func(c *Config) { c.FrameworkDomains = []FrameworkDomain{{Domain: "apps"}} }, "FrameworkDomains: #0: specify either Framework or FrameworkRegexp"},
+		{func(c *Config) {
+			c.FrameworkDomains = []FrameworkDomain{{Framework: "marathon", Domain: "apps", TaskRegexp: "^web$"}}
+		}, "FrameworkDomains: #0: TaskRegexp requires Mirror"},
+		{func(c *Config) {
+			c.FrameworkDomains = []FrameworkDomain{{FrameworkRegexp: "(", Domain: "apps"}}
+		}, "FrameworkDomains: #0: error parsing regexp"},
+		{func(c *Config) {
+			c.FrameworkDomains = []FrameworkDomain{{Framework: "marathon", Domain: "apps_internal"}}
+		}, `FrameworkDomains: #0: invalid label "apps_internal", "apps-internal" would be valid`},
+		{func(c *Config) {
+			c.FrameworkDomains = []FrameworkDomain{{Framework: "marathon", Domain: "apps.mesos"}}
+		}, "FrameworkDomains: domain apps.mesos is nested in mesos"},
+		{func(c *Config) {
+			c.ZoneResolvers = map[string][]string{"example.internal": {"8.8.8.8"}}
+			c.FrameworkDomains = []FrameworkDomain{{Framework: "marathon", Domain: "apps.example.internal"}}
+		}, "FrameworkDomains: domain apps.example.internal overlaps the ZoneResolvers zone example.internal"},
+		{func(c *Config) {
+			c.FrameworkDomains = []FrameworkDomain{{Framework: "marathon", Domain: "apps.example.internal", TTL: -1}}
+		}, "FrameworkDomains: #0: TTL -1 is less than 0"},
+		{func(c *Config) { c.TTLOverrides = []TTLOverride{{Regexp: `leader\.mesos`, TTL: 5}} }, ""},
+		{func(c *Config) { c.TTLOverrides = []TTLOverride{{Glob: "*.marathon.mesos", TTL: 0}} }, ""},
+		{func(c *Config) { c.TTLOverrides = []TTLOverride{{TTL: 5}} }, "TTLOverrides: #0: specify either Regexp or Glob"},
+		{func(c *Config) { c.FrameworkWhitelist = []string{"marathon", "spark-*"} }, ""},
+		{func(c *Config) { c.FrameworkWhitelist = []string{"spark-[a-"} }, "FrameworkWhitelist: #0: syntax error in pattern"},
+		{func(c *Config) { c.FrameworkBlacklist = []string{"chronos", ""} }, "FrameworkBlacklist: #1: empty pattern"},
+		{func(c *Config) { c.AttributeRecords = []string{"rack", ""} }, "AttributeRecords: #1: empty attribute"},
+		{func(c *Config) { c.AttributeRecords = []string{"rack", "zone", "rack"} }, `AttributeRecords: #2: duplicate attribute "rack"`},
+		{func(c *Config) {
+			c.TTLOverrides = []TTLOverride{{Regexp: "leader", Glob: "leader.*", TTL: 5}}
+		}, "TTLOverrides: #0: specify either Regexp or Glob"},
+		{func(c *Config) { c.TTLOverrides = []TTLOverride{{Regexp: "(", TTL: 5}} }, "TTLOverrides: #0: error parsing regexp"},
+		{func(c *Config) { c.TTLOverrides = []TTLOverride{{Glob: "[", TTL: 5}} }, "TTLOverrides: #0: syntax error in pattern"},
+		{func(c *Config) {
+			c.TTLOverrides = []TTLOverride{{Glob: "*.mesos", TTL: 5}, {Glob: "leader.mesos", TTL: -5}}
+		}, "TTLOverrides: #1: TTL -5 is less than 0"},
+		{func(c *Config) { c.HealthCheckTTLs, c.HealthCheckTTLFactor, c.HealthCheckTTLFloor = true, 0.5, 2 }, ""},
+		{func(c *Config) { c.HealthCheckTTLs = true }, ""},
+		{func(c *Config) { c.HealthCheckTTLs, c.HealthCheckTTLFactor = true, -1 }, "HealthCheckTTLFactor: -1 is negative"},
+		{func(c *Config) { c.HealthCheckTTLs, c.HealthCheckTTLFloor = true, -1 }, "HealthCheckTTLFloor: -1 is less than 0"},
+		{func(c *Config) { c.HealthCheckTTLFactor = -1 }, ""},
+		{func(c *Config) { c.ReverseZones = []string{"10.0.16.0/20", "fd00::/8"} }, ""},
+		{func(c *Config) { c.ReverseZones = []string{"10.0.3/24"} }, "ReverseZones: #0: invalid CIDR address: 10.0.3/24"},
+		{func(c *Config) {
+			c.ZoneResolvers = map[string][]string{"17.0.10.in-addr.arpa": {"8.8.8.8"}}
+			c.ReverseZones = []string{"10.0.16.0/20"}
+		}, "ReverseZones: #0: reverse zone 17.0.10.in-addr.arpa is a ZoneResolvers zone"},
+		{func(c *Config) { c.EnforceRFC952, c.Domain = true, "1mesos" }, `Domain: invalid label "1mesos", "mesos" would be valid`},
+		{func(c *Config) { c.EnforceRFC952, c.Domain = true, "dcos" }, ""},
+		{func(c *Config) { c.SOAMname = "ns1..mesos" }, "SOAMname: empty label at offset 4"},
+		{func(c *Config) { c.SOAMname = "NS1.mesos." }, ""},
+		{func(c *Config) { c.IPSources = []string{"netinfo,hosts"} }, `IPSources: invalid ip source "netinfo,hosts": list each source as a separate string`},
+		{func(c *Config) { c.IPSources = []string{"hosts"} }, `IPSources: invalid ip source "hosts", want one of autoip, docker, host, mesos, netinfo`},
+		{func(c *Config) { c.IPSources = []string{"host", "netinfo"} }, ""},
+		{func(c *Config) { c.IPSources = []string{"netinfo:dcos", "netinfo", "host"} }, ""},
+		{func(c *Config) { c.IPSources = []string{"netinfo:"} }, `IPSources: invalid ip source "netinfo:", want one of autoip, docker, host, mesos, netinfo`},
+		{func(c *Config) { c.RefreshSeconds = 0 }, "RefreshSeconds: 0 is less than 1"},
+		{func(c *Config) { c.StateTimeoutSeconds = 0 }, "StateTimeoutSeconds: 0 is less than 1"},
+		{func(c *Config) { c.StateFetchStrategy, c.StateHedgeMillis = "concurrent", 50 }, ""},
+		{func(c *Config) { c.StateFetchStrategy = "parallel" }, `StateFetchStrategy: unknown strategy "parallel": use "sequential" or "concurrent"`},
+		{func(c *Config) { c.StateHedgeMillis = 50 }, `StateHedgeMillis: requires StateFetchStrategy "concurrent"`},
+		{func(c *Config) { c.MesosAPIVersion = "v1" }, ""},
+		{func(c *Config) { c.MesosAPIVersion = "v2" }, `MesosAPIVersion: unknown version "v2": use "v0" or "v1"`},
+		{func(c *Config) { c.StateSummary = true }, ""},
+		{func(c *Config) { c.StateSummary, c.MesosAPIVersion = true, "v1" }, `StateSummary: not supported with MesosAPIVersion "v1"`},
+		{func(c *Config) { c.StateSummary, c.StateFetchStrategy = true, "concurrent" }, `StateSummary: not supported with StateFetchStrategy "concurrent"`},
+		{func(c *Config) { c.EventStream, c.ResyncSeconds = true, 600 }, ""},
+		{func(c *Config) { c.ResyncSeconds = 600 }, "ResyncSeconds: requires EventStream"},
+		{func(c *Config) { c.EventStream, c.ResyncSeconds = true, -1 }, "ResyncSeconds: -1 is less than 0"},
+		{func(c *Config) { c.StaleSeconds = 600 }, ""},
+		{func(c *Config) { c.StaleSeconds = -1 }, "StaleSeconds: -1 is less than 0"},
+		{func(c *Config) { c.EventStream, c.MaxRecords = true, 100 }, "EventStream: incremental updates are not supported with MaxRecords"},
+		{func(c *Config) { c.StateFetchStrategy, c.StateHedgeMillis = "concurrent", -1 }, "StateHedgeMillis: -1 is less than 0"},
+		{func(c *Config) { c.MasterBreakerFailures, c.MasterBreakerCooldownSeconds = 3, 60 }, ""},
+		{func(c *Config) { c.MasterBreakerFailures = 3 }, "MasterBreakerCooldownSeconds: 0 is less than 1"},
+		{func(c *Config) { c.MasterBreakerFailures = -1 }, "MasterBreakerFailures: -1 is less than 0"},
+		{func(c *Config) { c.StateMaxMegabytes = 512 }, ""},
+		{func(c *Config) { c.StateMaxMegabytes = -1 }, "StateMaxMegabytes: -1 is less than 0"},
+		{func(c *Config) { c.ZkDetectionTimeout = -1 }, "ZkDetectionTimeout: -1 is less than 0"},
+		{func(c *Config) { c.ZkDetectionTimeout = 0 }, ""},
+		{func(c *Config) { c.TTL = -1 }, "TTL: -1 is less than 0"},
+		{func(c *Config) { c.Resolvers = []string{"8.8.8"} }, "Resolvers: Error validating resolvers: Illegal ip specified: 8.8.8"},
+		{func(c *Config) { c.ExternalOn, c.Resolvers = false, []string{"8.8.8"} }, ""},
+		{func(c *Config) { c.ZoneResolvers = map[string][]string{"mesos": {"8.8.8.8"}} }, "ZoneResolvers: Can't specify ZoneResolver for Mesos domain (mesos)"},
+		{func(c *Config) { c.ZoneResolvers = map[string][]string{"corp": {"8.8.8.8"}} }, ""},
+		{func(c *Config) { c.Timeout = 0 }, "Timeout: 0 is less than 1"},
+		{func(c *Config) { c.ExternalOn, c.WatchResolvConf = false, true }, "WatchResolvConf: requires ExternalOn"},
+		{func(c *Config) { c.WatchResolvConf = true }, ""},
+		{func(c *Config) { c.ForwardCacheSize, c.PrefetchHits = 1000, 5 }, ""},
+		{func(c *Config) { c.ForwardCacheSize = -1 }, "ForwardCacheSize: -1 is less than 0"},
+		{func(c *Config) { c.ForwardCacheSize, c.PrefetchHits = 1000, -1 }, "PrefetchHits: -1 is less than 0"},
+		{func(c *Config) { c.PrefetchHits = 5 }, "PrefetchHits: requires ForwardCacheSize"},
+		{func(c *Config) { c.AnswerOrder = AnswerOrderRotate }, ""},
+		{func(c *Config) { c.AnswerOrder = "round-robin" }, `AnswerOrder: unknown order "round-robin": use "random", "rotate" or "sorted"`},
+		{func(c *Config) { c.LocalAgent, c.LocalAgentUpstreams = "agent1", []string{"10.0.0.53"} }, ""},
+		{func(c *Config) { c.LocalAgent, c.LocalAgentUpstreams = "agent1", []string{"upstream"} }, "LocalAgentUpstreams: Error validating resolvers: Illegal ip specified: upstream"},
+		{func(c *Config) { c.LocalAgentUpstreams = []string{"10.0.0.53"} }, "LocalAgentUpstreams: requires LocalAgent"},
+		{func(c *Config) { c.MaxRecords = -1 }, "MaxRecords: -1 is less than 0"},
+		{func(c *Config) { c.DefaultPortProtocols = []string{"sctp"} }, `DefaultPortProtocols: unknown protocol "sctp": list tcp and/or udp`},
+		{func(c *Config) { c.DefaultPortProtocols = []string{"udp", "udp"} }, `DefaultPortProtocols: protocol "udp" listed twice`},
+		{func(c *Config) { c.AutoIPCIDRs = []string{"9.0.0.0"} }, "AutoIPCIDRs: invalid CIDR address: 9.0.0.0"},
+		{func(c *Config) { c.DCOSNames = "both" }, `DCOSNames: unknown mode "both": use "alongside" or "instead"`},
+		{func(c *Config) { c.DCOSNames, c.ShortSRVTargets = "instead", true }, `DCOSNames: "instead" is not supported along with ShortSRVTargets`},
+		{func(c *Config) { c.TaskIDRecords, c.TaskIDDots = true, "split" }, ""},
+		{func(c *Config) { c.TaskIDDots = "keep" }, `TaskIDDots: unknown mode "keep": use "replace" or "split"`},
+		{func(c *Config) { c.MinimumVisibility = VisibilityCluster }, ""},
+		{func(c *Config) { c.TaskHashLength, c.TaskHashAlgorithm = 8, TaskHashSHA256 }, ""},
+		{func(c *Config) { c.TaskHashLength = 4 }, "TaskHashLength, TaskHashAlgorithm: length 4 is not between 5 and 16"},
+		{func(c *Config) { c.TaskHashAlgorithm = "md5" }, `TaskHashLength, TaskHashAlgorithm: unknown algorithm "md5": use "sha1" or "sha256"`},
+		{func(c *Config) { c.CanonicalNameTemplate = DefaultCanonicalNameTemplate }, ""},
+		{func(c *Config) { c.CanonicalNameTemplate = "{{.Name}}.{{.Hash}}.{{.Framework}}" }, ""},
+		{func(c *Config) { c.CanonicalNameTemplate = "{{.Name}}.{{.Hash}" },
+			"CanonicalNameTemplate: template: CanonicalNameTemplate:1: "},
+		{func(c *Config) { c.CanonicalNameTemplate = "{{.Name}}-{{.Role}}.{{.Framework}}" },
+			"CanonicalNameTemplate: template: CanonicalNameTemplate:1:"},
+		{func(c *Config) { c.CanonicalNameTemplate = "{{.Name}}-" + strings.Repeat("x", 60) + "-{{.Hash}}" },
+			`CanonicalNameTemplate: renders "task-` + strings.Repeat("x", 60) + `-xxxxx", with label "task-` + strings.Repeat("x", 60) + `-xxxxx" longer than 63 bytes`},
+		{func(c *Config) { c.CanonicalNameTemplate = "{{.Name}}_{{.Hash}}.{{.Framework}}" },
+			`CanonicalNameTemplate: renders "task_xxxxx.framework", with invalid label "task_xxxxx"`},
+		{func(c *Config) { c.CanonicalNameTemplate = "{{.Name}}..{{.Hash}}" },
+			`CanonicalNameTemplate: renders "task..xxxxx", with an empty label`},
+		{func(c *Config) { c.CanonicalNameTemplate = "{{.Name}}-{{.SlaveID}}.{{.Framework}}" },
+			"CanonicalNameTemplate: doesn't render the Hash of tasks, which would share their canonical names"},
+		{func(c *Config) { c.MinimumVisibility = "cluster" }, `MinimumVisibility: unknown visibility "cluster": use "FRAMEWORK", "CLUSTER" or "EXTERNAL"`},
+		{func(c *Config) { c.StatsdAddress = "localhost" }, "StatsdAddress: Illegal host:port specified: localhost."},
+		{func(c *Config) { c.StatsdAddress, c.StatsdFlushSeconds = "localhost:8125", 0 }, "StatsdFlushSeconds: 0 is less than 1"},
+		{func(c *Config) { c.StatsdAddress, c.StatsdSampleRate = "localhost:8125", 1.5 }, "StatsdSampleRate: 1.5 is not in (0, 1]"},
+		{func(c *Config) { c.StatsdAddress = "localhost:8125" }, ""},
+		{func(c *Config) { c.CACertFile = file }, "CACertFile: requires MesosHTTPSOn"},
+		{func(c *Config) { c.MesosHTTPSOn, c.CACertFile = true, missing }, "CACertFile: stat "},
+		{func(c *Config) { c.MesosHTTPSOn, c.CACertFile = true, dir }, "CACertFile: " + dir + " is not a regular file"},
+		{func(c *Config) { c.MesosHTTPSOn, c.CACertFile = true, file }, ""},
+		{func(c *Config) { c.MesosHTTPSOn, c.CertFile = true, file }, "CertFile, KeyFile: specify both the certificate and its private key"},
+		{func(c *Config) { c.MesosHTTPSOn, c.CertFile, c.KeyFile = true, file, missing }, "KeyFile: stat "},
+		{func(c *Config) { c.MesosHTTPSOn, c.CertFile, c.KeyFile = true, file, file }, ""},
+		{func(c *Config) { c.IAMConfigFile = missing }, "IAMConfigFile: stat "},
+	} {
+		c := NewConfig()
+		c.Masters = []string{"10.0.0.1:5050"}
+		tt.set(&c)
+		err := c.Validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("test #%d: got error %v, want none", i, err)
+			}
+			continue
+		}
+		if errs, ok := err.(ConfigError); !ok || len(errs) != 1 || !strings.HasPrefix(errs[0], tt.want) {
+			t.Errorf("test #%d: got error %v, want %q", i, err, tt.want)
+		}
+	}
+}
+
+func TestConfig_Validate_All(t *testing.T) {
+	c := NewConfig()
+	c.RefreshSeconds = 0
+	c.IPSources = []string{"netinfo", "host", "rkt"}
+	want := ConfigError{
+		"Masters, Zk: specify Mesos masters or Zookeeper",
+		`IPSources: invalid ip source "rkt", want one of autoip, docker, host, mesos, netinfo`,
+		"RefreshSeconds: 0 is less than 1",
+	}
+	if err := c.Validate(); !reflect.DeepEqual(err, want) {
+		t.Errorf("got %v, want %v", err, want)
+	}
+}
+
+func TestReadMasters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-masters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "masters")
+	contents := "# masters\n10.0.0.1:5050\n\n  10.0.0.2:5050  # second\nbogus\n10.0.0.3:x\n10.0.0.1:5050\nmaster.example.com:5050\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadMasters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1:5050", "10.0.0.2:5050", "master.example.com:5050"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got masters %q, want %q", got, want)
+	}
+	if _, err := ReadMasters(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("got error %v for a missing file, want a not exist one", err)
+	}
+}
+
+func TestConfig_ReverseDomains(t *testing.T) {
+	for i, tt := range []struct {
+		cidrs []string
+		want  []string
+	}{
+		{nil, []string{}},
+		{[]string{"10.0.0.0/8"}, []string{"10.in-addr.arpa"}},
+		{[]string{"10.0.3.7/24"}, []string{"3.0.10.in-addr.arpa"}},
+		{[]string{"0.0.0.0/0"}, []string{"in-addr.arpa"}},
+		// cut at the next octet boundary
+		{[]string{"10.0.3.4/30"}, []string{"4.3.0.10.in-addr.arpa", "5.3.0.10.in-addr.arpa", "6.3.0.10.in-addr.arpa", "7.3.0.10.in-addr.arpa"}},
+		{[]string{"172.16.0.0/12"}, []string{
+			"16.172.in-addr.arpa", "17.172.in-addr.arpa", "18.172.in-addr.arpa", "19.172.in-addr.arpa",
+			"20.172.in-addr.arpa", "21.172.in-addr.arpa", "22.172.in-addr.arpa", "23.172.in-addr.arpa",
+			"24.172.in-addr.arpa", "25.172.in-addr.arpa", "26.172.in-addr.arpa", "27.172.in-addr.arpa",
+			"28.172.in-addr.arpa", "29.172.in-addr.arpa", "30.172.in-addr.arpa", "31.172.in-addr.arpa",
+		}},
+		// nibble boundaries
+		{[]string{"fd00::/8"}, []string{"d.f.ip6.arpa"}},
+		{[]string{"2001:db8::/34"}, []string{
+			"0.8.b.d.0.1.0.0.2.ip6.arpa", "1.8.b.d.0.1.0.0.2.ip6.arpa",
+			"2.8.b.d.0.1.0.0.2.ip6.arpa", "3.8.b.d.0.1.0.0.2.ip6.arpa",
+		}},
+		// duplicates
+		{[]string{"10.0.3.0/24", "10.0.2.0/23"}, []string{"3.0.10.in-addr.arpa", "2.0.10.in-addr.arpa"}},
+	} {
+		c := Config{ReverseZones: tt.cidrs}
+		if got := c.ReverseDomains(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got reverse zones %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_FrameworkDomains(t *testing.T) {
+	for i, tt := range []struct {
+		json string
+		want FrameworkDomains
+		ok   bool
+	}{
+		{`{}`, nil, true},
+		{`{"FrameworkDomains": null}`, nil, true},
+		{`{"FrameworkDomains": [{"Framework": "marathon", "Domain": "apps.example.internal"}, {"FrameworkRegexp": "^spark-", "Domain": "spark.example.internal", "TTL": 5}]}`,
+			FrameworkDomains{{Framework: "marathon", Domain: "apps.example.internal"}, {FrameworkRegexp: "^spark-", Domain: "spark.example.internal", TTL: 5}}, true},
+		// the object form maps framework names, listed in order
+		{`{"FrameworkDomains": {"marathon": "apps.example.internal", "chronos": "batch.example.internal"}}`,
+			FrameworkDomains{{Framework: "chronos", Domain: "batch.example.internal"}, {Framework: "marathon", Domain: "apps.example.internal"}}, true},
+		{`{"FrameworkDomains": {}}`, FrameworkDomains{}, true},
+		{`{"FrameworkDomains": {"marathon": 1}}`, nil, false},
+		{`{"FrameworkDomains": "apps.example.internal"}`, nil, false},
+	} {
+		var c Config
+		err := json.Unmarshal([]byte(tt.json), &c)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("test #%d: got error %v, want ok=%t", i, err, tt.ok)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(c.FrameworkDomains, tt.want) {
+			t.Errorf("test #%d: got framework domains %+v, want %+v", i, c.FrameworkDomains, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package records
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// DC/OS naming modes, as set by Config.DCOSNames.
+const (
+	// DCOSNamesOff generates no DC/OS names.
+	DCOSNamesOff = ""
+	// DCOSNamesAlongside generates the DC/OS names along with the usual
+	// ones.
+	DCOSNamesAlongside = "alongside"
+	// DCOSNamesInstead generates the DC/OS names instead of the usual short
+	// task names, task.framework.domain and task.framework.slave.domain.
+	DCOSNamesInstead = "instead"
+)
+
+// dcosFamilies are the record families of the DC/OS DNS naming spec: each
+// generates the A and AAAA records of task.framework.{family}.domain,
+// listing the addresses it picks from those of the container and the agent
+// of a task, if any.
+var dcosFamilies = []struct {
+	family string
+	ips    func(container, agent []net.IP) []net.IP
+}{
+	// the agent IP
+	{"agentip", func(_, agent []net.IP) []net.IP { return agent }},
+	// the container IP, if the task has one of its own
+	{"containerip", func(container, _ []net.IP) []net.IP { return container }},
+	// the container IP, if the task has one of its own, or else the agent
+	// IP
+	{"autoip", func(container, agent []net.IP) []net.IP {
+		if len(container) > 0 {
+			return container
+		}
+		return agent
+	}},
+}
+
+// validateDCOSNames checks that the given DC/OS naming mode is known and, if
+// the short task names aren't generated, that SRV records don't target them.
+func validateDCOSNames(mode string, shortSRVTargets bool) error {
+	switch mode {
+	case DCOSNamesOff, DCOSNamesAlongside:
+		return nil
+	case DCOSNamesInstead:
+		if shortSRVTargets {
+			return fmt.Errorf("%q is not supported along with ShortSRVTargets", mode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q: use %q or %q", mode, DCOSNamesAlongside, DCOSNamesInstead)
+	}
+}
+
+// dcosRecords inserts the records of the DC/OS naming spec families of the
+// given task. The container IPs of the task are those of the given IP
+// sources, other than host, which differ from the agent IPs: tasks using the
+// network of their agent have none of their own.
+func (rg *RecordGenerator) dcosRecords(ctx context, task state.Task, fname, domain string, ipSources []string, enumTask *EnumerableTask) {
+	var agent []net.IP
+	for _, s := range ctx.slaveIPs {
+		if ip := net.ParseIP(s); ip != nil {
+			agent = append(agent, ip)
+		}
+	}
+	var sources []string
+	for _, src := range ipSources {
+		if src != "host" {
+			sources = append(sources, src)
+		}
+	}
+	var container []net.IP
+	for _, ip := range task.RecordIPs(sources...) {
+		if !containsIP(agent, ip) {
+			container = append(container, ip)
+		}
+	}
+
+	for _, f := range dcosFamilies {
+		name := ctx.taskName + "." + fname + "." + f.family + "." + domain + "."
+		for _, ip := range f.ips(container, agent) {
+			rg.insertTaskRR(name, ip.String(), rrsKindForIP(ip), ctx.source, enumTask)
+		}
+	}
+}
+
+// containsIP tells whether the given IPs contain the given one.
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package records
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RecordChange is a record added or removed between two generations of
+// records, see Diff.
+type RecordChange struct {
+	Name  string `json:"name"`
+	Host  string `json:"host"`
+	Rtype string `json:"rtype"`
+}
+
+// ChangeCount counts the records of a type added and removed between two
+// generations of records.
+type ChangeCount struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// RecordDelta holds the records added and removed between two generations of
+// records, see Diff.
+type RecordDelta struct {
+	// From and To are when the generations compared were generated, From
+	// being zero if there was none before To.
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Added and Removed list the records added and removed, ordered by
+	// type, name and host.
+	Added   []RecordChange `json:"added"`
+	Removed []RecordChange `json:"removed"`
+	// Summary counts them, per record type.
+	Summary map[string]ChangeCount `json:"summary"`
+}
+
+// diffKinds are the record types Diff compares.
+var diffKinds = []rrsKind{A, AAAA, SRV}
+
+// Diff returns the A, AAAA and SRV records added and removed between the prev
+// and next generations of records, prev being nil if there was none before
+// next. The hosts of every name are compared, so that a host added to a name,
+// e.g. a new instance of a service, is the only change reported for it.
+func Diff(prev, next *RecordGenerator) RecordDelta {
+	if prev == nil {
+		prev = &RecordGenerator{}
+	}
+	delta := RecordDelta{
+		From:    prev.Timestamp,
+		To:      next.Timestamp,
+		Added:   []RecordChange{},
+		Removed: []RecordChange{},
+		Summary: make(map[string]ChangeCount, len(diffKinds)),
+	}
+	for _, kind := range diffKinds {
+		added := changes(kind.rrs(next), kind.rrs(prev), kind)
+		removed := changes(kind.rrs(prev), kind.rrs(next), kind)
+		delta.Added = append(delta.Added, added...)
+		delta.Removed = append(delta.Removed, removed...)
+		delta.Summary[string(kind)] = ChangeCount{Added: len(added), Removed: len(removed)}
+	}
+	return delta
+}
+
+// changes returns the records of the given type of a which b lacks, ordered
+// by name and host.
+func changes(a, b rrs, kind rrsKind) []RecordChange {
+	var cs []RecordChange
+	a.each(func(name string, set *rrset) {
+		for host := range set.hosts {
+			if _, ok := b.get(name)[host]; !ok {
+				cs = append(cs, RecordChange{Name: name, Host: host, Rtype: string(kind)})
+			}
+		}
+	})
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].Name != cs[j].Name {
+			return cs[i].Name < cs[j].Name
+		}
+		return cs[i].Host < cs[j].Host
+	})
+	return cs
+}
+
+// String returns a summary of the delta, e.g. "A: +2 -1, AAAA: +0 -0,
+// SRV: +4 -0".
+func (d RecordDelta) String() string {
+	counts := make([]string, len(diffKinds))
+	for i, kind := range diffKinds {
+		c := d.Summary[string(kind)]
+		counts[i] = fmt.Sprintf("%s: +%d -%d", kind, c.Added, c.Removed)
+	}
+	return strings.Join(counts, ", ")
+}
@@ -0,0 +1,20 @@
+package records
+
+// dnssdServices is the name, under a domain, DNS-SD clients enumerate the
+// service types of the domain by, see RFC 6763 section 9.
+const dnssdServices = "_services._dns-sd._udp"
+
+// taskDNSSDRecords inserts the DNS-SD browsing records, see RFC 6763, of the
+// given SRV record of a task under the given domain: a PTR record from the
+// service enumeration name of the domain to the name of the SRV record, its
+// service type, e.g. _web._tcp.marathon.domain., and one from the latter to
+// the target of the SRV record, an instance of the service. The instances of
+// a service share its service type record, which is only added once.
+func (rg *RecordGenerator) taskDNSSDRecords(name, host, domain string, src RecordSource, enumTask *EnumerableTask) {
+	d, err := ParseSRV(host)
+	if err != nil {
+		return
+	}
+	rg.insertTaskRR(dnssdServices+"."+domain+".", name, PTR, src, enumTask)
+	rg.insertTaskRR(name, d.Target, PTR, src, enumTask)
+}
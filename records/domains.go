@@ -0,0 +1,257 @@
+package records
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// FrameworkDomain maps frameworks, by name, to an alternate domain their
+// records are generated under instead of the configured Domain.
+type FrameworkDomain struct {
+	// Framework is the name of the frameworks mapped.
+	Framework string
+	// FrameworkRegexp is a regular expression matching the names of the
+	// frameworks mapped, used if Framework is empty.
+	FrameworkRegexp string
+	// Domain is the domain the records of the frameworks mapped are
+	// generated under.
+	Domain string
+	// Mirror makes the records of the frameworks mapped be generated under
+	// Domain as well as, rather than instead of, the domain they'd be
+	// generated under otherwise.
+	Mirror bool
+	// TaskRegexp is a regular expression matching the names of the tasks
+	// whose records are mirrored under Domain, if set; it requires Mirror.
+	TaskRegexp string
+	// TTL is the TTL of the records under Domain, in seconds, if positive,
+	// rather than the global one.
+	TTL int32
+
+	re     *regexp.Regexp
+	taskRe *regexp.Regexp
+}
+
+// FrameworkDomains lists the mappings of frameworks to alternate domains, the
+// first matching a framework applying.
+type FrameworkDomains []FrameworkDomain
+
+// UnmarshalJSON implements the json.Unmarshaler interface for
+// FrameworkDomains, which are either a list of mappings or, for short, an
+// object mapping framework names to their domains, e.g.
+// {"marathon": "apps.example.internal"}, listed by framework name.
+func (fds *FrameworkDomains) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var list []FrameworkDomain
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		*fds = list
+		return nil
+	}
+	var domains map[string]string
+	if err := json.Unmarshal(data, &domains); err != nil {
+		return err
+	}
+	*fds = make(FrameworkDomains, 0, len(domains))
+	for framework, domain := range domains {
+		*fds = append(*fds, FrameworkDomain{Framework: framework, Domain: domain})
+	}
+	sort.Slice(*fds, func(i, j int) bool { return (*fds)[i].Framework < (*fds)[j].Framework })
+	return nil
+}
+
+// matches tells whether the framework of the given name is mapped.
+func (fd *FrameworkDomain) matches(name string) bool {
+	switch {
+	case fd.Framework != "":
+		return name == fd.Framework
+	case fd.re != nil:
+		return fd.re.MatchString(name)
+	default:
+		ok, err := regexp.MatchString(fd.FrameworkRegexp, name)
+		return ok && err == nil
+	}
+}
+
+// mirrors tells whether the task of the given name is mirrored.
+func (fd *FrameworkDomain) mirrors(task string) bool {
+	switch {
+	case fd.TaskRegexp == "":
+		return true
+	case fd.taskRe != nil:
+		return fd.taskRe.MatchString(task)
+	default:
+		ok, err := regexp.MatchString(fd.TaskRegexp, task)
+		return ok && err == nil
+	}
+}
+
+// Domains returns the domains Mesos-DNS is authoritative for: Domain
+// followed by the distinct domains of FrameworkDomains and the reverse zones
+// of ReverseZones.
+func (c *Config) Domains() []string {
+	domains := []string{c.Domain}
+	for _, fd := range c.FrameworkDomains {
+		domains = append(domains, fd.Domain)
+	}
+	domains = append(domains, c.ReverseDomains()...)
+	return unique(domains)
+}
+
+// initFrameworkDomains lowercases the domains of FrameworkDomains and
+// compiles their regular expressions, which Validate checked.
+func (c *Config) initFrameworkDomains() {
+	for i := range c.FrameworkDomains {
+		fd := &c.FrameworkDomains[i]
+		fd.Domain = strings.ToLower(fd.Domain)
+		if fd.Framework == "" {
+			fd.re, _ = regexp.Compile(fd.FrameworkRegexp)
+		}
+		if fd.TaskRegexp != "" {
+			fd.taskRe, _ = regexp.Compile(fd.TaskRegexp)
+		}
+	}
+}
+
+// validateFrameworkDomains checks that each framework domain maps frameworks
+// either by name or by regular expression to a valid domain, and that the
+// domains, including the Mesos one, don't overlap each other or the zones of
+// the zone resolvers.
+func validateFrameworkDomains(fds []FrameworkDomain, domain string, zones map[string][]string, spec labels.Func) error {
+	domains := []string{strings.ToLower(domain)}
+	for i, fd := range fds {
+		if (fd.Framework == "") == (fd.FrameworkRegexp == "") {
+			return fmt.Errorf("#%d: specify either Framework or FrameworkRegexp", i)
+		}
+		if fd.FrameworkRegexp != "" {
+			if _, err := regexp.Compile(fd.FrameworkRegexp); err != nil {
+				return fmt.Errorf("#%d: %v", i, err)
+			}
+		}
+		if fd.TaskRegexp != "" {
+			if !fd.Mirror {
+				return fmt.Errorf("#%d: TaskRegexp requires Mirror", i)
+			}
+			if _, err := regexp.Compile(fd.TaskRegexp); err != nil {
+				return fmt.Errorf("#%d: %v", i, err)
+			}
+		}
+		if err := validateAtLeast(int(fd.TTL), 0); err != nil {
+			return fmt.Errorf("#%d: TTL %v", i, err)
+		}
+		if err := validateHostName(fd.Domain, spec); err != nil {
+			return fmt.Errorf("#%d: %v", i, err)
+		}
+		domains = append(domains, strings.ToLower(fd.Domain))
+	}
+	domains = unique(domains)
+	for i, a := range domains {
+		for _, b := range domains {
+			if a != b && strings.HasSuffix("."+a, "."+b) {
+				return fmt.Errorf("domain %s is nested in %s", a, b)
+			}
+		}
+		if i == 0 {
+			continue // the zone resolvers of the Mesos domain are checked on their own
+		}
+		for zone := range zones {
+			if a == zone || strings.HasSuffix("."+a, "."+zone) || strings.HasSuffix("."+zone, "."+a) {
+				return fmt.Errorf("domain %s overlaps the ZoneResolvers zone %s", a, zone)
+			}
+		}
+	}
+	return nil
+}
+
+// frameworkDomain returns the domain the records of the given framework are
+// generated under: that of the first framework domain, not mirroring,
+// mapping it, if any, or else the given one.
+func (rg *RecordGenerator) frameworkDomain(f state.Framework, domain string) string {
+	for i := range rg.frameworkDomains {
+		if !rg.frameworkDomains[i].Mirror && rg.frameworkDomains[i].matches(f.Name) {
+			return rg.frameworkDomains[i].Domain
+		}
+	}
+	return domain
+}
+
+// frameworkMirrors returns the mirroring framework domains mapping the given
+// framework, but for those of the given domain, which its records are
+// generated under.
+func (rg *RecordGenerator) frameworkMirrors(f state.Framework, domain string) []*FrameworkDomain {
+	var mirrors []*FrameworkDomain
+	for i := range rg.frameworkDomains {
+		fd := &rg.frameworkDomains[i]
+		if fd.Mirror && fd.Domain != domain && fd.matches(f.Name) {
+			mirrors = append(mirrors, fd)
+		}
+	}
+	return mirrors
+}
+
+// frameworkDomainList returns the domains the records of the given framework
+// are generated under: its domain, as per frameworkDomain, followed by those
+// it's mirrored under.
+func (rg *RecordGenerator) frameworkDomainList(f state.Framework, domain string) []string {
+	domains := []string{rg.frameworkDomain(f, domain)}
+	for _, fd := range rg.frameworkMirrors(f, domains[0]) {
+		domains = append(domains, fd.Domain)
+	}
+	return unique(domains)
+}
+
+// mirrorTaskRecords copies the records of the tasks of the given framework,
+// as listed by its enumeration data, from the domain they were generated
+// under to those it's mirrored under, for the tasks each mirrors, adding the
+// enumeration data of the copies.
+func (rg *RecordGenerator) mirrorTaskRecords(f state.Framework, enumFW *EnumerableFramework) {
+	for _, fd := range rg.frameworkMirrors(f, enumFW.Domain) {
+		mirrored := &EnumerableFramework{
+			Name:     enumFW.Name,
+			Fragment: enumFW.Fragment,
+			Domain:   fd.Domain,
+			Tasks:    []*EnumerableTask{},
+		}
+		rg.EnumData.Frameworks = append(rg.EnumData.Frameworks, mirrored)
+		for _, task := range enumFW.Tasks {
+			if !fd.mirrors(task.Name) {
+				continue
+			}
+			copied := &EnumerableTask{ID: task.ID, Name: task.Name, Records: []EnumerableRecord{}, Skipped: task.Skipped,
+				Pod: task.Pod, healthInterval: task.healthInterval, ttl: task.ttl, hasTTL: task.hasTTL}
+			mirrored.Tasks = append(mirrored.Tasks, copied)
+			src := RecordSource{FrameworkID: f.ID, FrameworkName: f.Name, TaskID: task.ID}
+			for _, rec := range task.Records {
+				name, ok := rehome(rec.Name, enumFW.Domain, fd.Domain)
+				if !ok {
+					continue
+				}
+				host := rec.Host
+				if rec.Rtype == SRV {
+					if d, err := ParseSRV(host); err == nil {
+						if d.Target, ok = rehome(d.Target, enumFW.Domain, fd.Domain); ok {
+							host = d.String()
+						}
+					}
+				}
+				rg.insertWeightedTaskRR(name, host, rrsKind(rec.Rtype), rec.Priority, rec.Weight, src, copied)
+			}
+		}
+	}
+}
+
+// rehome returns the given record name moved from the given domain to the
+// other given one, if under the former.
+func rehome(name, from, to string) (string, bool) {
+	if !strings.HasSuffix(name, "."+from+".") {
+		return name, false
+	}
+	return strings.TrimSuffix(name, from+".") + to + ".", true
+}
@@ -0,0 +1,43 @@
+package records
+
+import (
+	"bufio"
+	"io"
+)
+
+// WriteTo writes the records in a canonical text format, one record per line
+// with tab separated fields, ordered by kind (A, AAAA, SRV, PTR then TXT),
+// name and host insertion order:
+//
+//	A	leader.mesos.	10.0.0.1
+//	SRV	_leader._tcp.mesos.	leader.mesos.:5050
+//
+// It implements io.WriterTo.
+func (rg *RecordGenerator) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, kind := range []rrsKind{A, AAAA, SRV, PTR, TXT} {
+		rrs := kind.rrs(rg)
+		for _, name := range rrs.Names() {
+			for _, host := range rrs.Hosts(name) {
+				if _, err := bw.WriteString(string(kind) + "\t" + name + "\t" + host + "\n"); err != nil {
+					return cw.n, err
+				}
+			}
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
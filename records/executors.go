@@ -0,0 +1,110 @@
+package records
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// frameworkExecutorRecords generates the records of the running executors of
+// the given framework under its alternate domain, if mapped to any, or else
+// the given one: executor.framework.domain. A records of their slave IPs and,
+// for each discovery port of the executor, _executor._tcp.framework.domain.
+// SRV records targeting the former, _udp ones for UDP ports. Executors are
+// named after their name, or else their ID, and those whose slave IP is
+// unknown, or which don't run on the local agent in agent-local mode, get no
+// records. Executors aren't listed by the enumeration API.
+func (rg *RecordGenerator) frameworkExecutorRecords(f state.Framework, domain string, spec labels.Func) {
+	tail := "." + rg.frameworkFrag(f, spec) + "." + rg.frameworkDomain(f, domain) + "."
+	for _, e := range f.Executors {
+		if rg.capped() {
+			rg.Stats.cutOff(f.Name)
+			return
+		}
+		if _, ok := rg.localSlaves[e.SlaveID]; rg.localAgent != "" && !ok {
+			continue
+		}
+		name := e.Name
+		if name == "" {
+			name = e.ID
+		}
+		lab := rg.label(name, spec)
+		if lab == "" {
+			continue
+		}
+		src := RecordSource{FrameworkID: f.ID, FrameworkName: f.Name, Executor: lab}
+		host := lab + tail
+		owned := false
+		for _, ip := range rg.SlaveIPs[e.SlaveID] {
+			if sIP := net.ParseIP(ip); sIP != nil {
+				owned = rg.insertExecutorRR(host, sIP.String(), rrsKindForIP(sIP), src) || owned
+			}
+		}
+		if !owned {
+			continue
+		}
+		for _, port := range e.Discovery.Ports.DiscoveryPorts {
+			protocol := strings.ToLower(strings.TrimSpace(port.Protocol))
+			switch protocol {
+			case "":
+				protocol = "tcp"
+			case "tcp", "udp":
+			default:
+				continue
+			}
+			rg.insertExecutorRR("_"+lab+"._"+protocol+tail, host+":"+strconv.Itoa(port.Number), SRV, src)
+		}
+	}
+}
+
+// insertExecutorRR normalizes the given record of an executor, and applies
+// the record transforms to it, before adding it, unless its name was claimed
+// by any other source first, see executorClaim. It tells whether the executor
+// owns the name, even if the record was added already.
+func (rg *RecordGenerator) insertExecutorRR(name, host string, kind rrsKind, src RecordSource) bool {
+	name, host, kind, ok := rg.transformRecord(normalizeRecord(name, host, kind))
+	if !ok || !rg.executorClaim(name, kind, src) {
+		return false
+	}
+	rg.storeRR(name, host, kind)
+	return true
+}
+
+// executorClaim claims the given record name and kind for the executor of
+// the given source, telling whether it now owns them. Unlike those of tasks,
+// the records of executors aren't merged with those of other sources: the
+// first one to claim a name keeps it, the conflict being logged once per
+// generation. Executors of the same name of a framework share their records.
+func (rg *RecordGenerator) executorClaim(name string, kind rrsKind, src RecordSource) bool {
+	key := claimKey{name, kind}
+	owner, ok := rg.owners[key]
+	if !ok {
+		rg.claim(name, kind, src)
+		return true
+	}
+	if owner == src {
+		return true
+	}
+	ck := collisionKey{key, owner, src}
+	if _, seen := rg.collisions[ck]; !seen {
+		rg.collisions[ck] = struct{}{}
+		logging.Error.Printf("warning: %s record name %q of executor %q of framework %q conflicts with %s; keeping the latter's",
+			kind, name, src.Executor, src.FrameworkName, owner.describe())
+	}
+	return false
+}
+
+// describe returns a description of the source for logging.
+func (src RecordSource) describe() string {
+	switch {
+	case src.TaskID != "":
+		return "task " + strconv.Quote(src.TaskID) + " of framework " + strconv.Quote(src.FrameworkName)
+	case src.Executor != "":
+		return "executor " + strconv.Quote(src.Executor) + " of framework " + strconv.Quote(src.FrameworkName)
+	}
+	return "framework " + strconv.Quote(src.FrameworkName)
+}
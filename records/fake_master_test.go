@@ -0,0 +1,314 @@
+package records
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/httpcli"
+	"github.com/mesosphere/mesos-dns/httpcli/basic"
+	"github.com/mesosphere/mesos-dns/httpcli/iam"
+	"github.com/mesosphere/mesos-dns/mesostest"
+	"github.com/mesosphere/mesos-dns/records/state/client"
+)
+
+// loadFakeMasterConfig writes a configuration fetching the state from the
+// given masters, with the given fields set, to the given directory and loads
+// it, as mesos-dns does.
+func loadFakeMasterConfig(t *testing.T, dir string, fields map[string]interface{}, masters ...string) Config {
+	c := map[string]interface{}{
+		"Masters":             masters,
+		"ExternalOn":          false,
+		"StateTimeoutSeconds": 5,
+	}
+	for k, v := range fields {
+		c[k] = v
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "config.json")
+	if err = ioutil.WriteFile(file, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// writeFile writes the given contents to the named file of the given
+// directory, returning its path.
+func writeFile(t *testing.T, dir, name string, contents []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseState_FakeMaster(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Integration test - skipping for short mode.")
+	}
+	basic.Register()
+	iam.Register()
+	defer httpcli.RegistryReset()
+
+	dir, err := ioutil.TempDir("", "mesos-dns-fake-master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fixture, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := mesostest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA, err := mesostest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := writeFile(t, dir, "ca.pem", ca.PEM())
+	otherCAFile := writeFile(t, dir, "other-ca.pem", otherCA.PEM())
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	iamConfig := func(m *mesostest.Master, uid string) map[string]interface{} {
+		b, err := json.Marshal(iam.Config{ID: uid, PrivateKey: string(privateKey), LoginEndpoint: m.LoginURL()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return map[string]interface{}{
+			"MesosAuthentication": httpcli.AuthIAM,
+			"IAMConfigFile":       writeFile(t, dir, "iam.json", b),
+		}
+	}
+	basicConfig := func(principal, secret string) map[string]interface{} {
+		return map[string]interface{}{
+			"MesosAuthentication": httpcli.AuthBasic,
+			"MesosCredentials":    basic.Credentials{Principal: principal, Secret: secret},
+		}
+	}
+
+	leader := mesostest.NewMaster(mesostest.State(fixture))
+	defer leader.Close()
+
+	for _, tt := range []struct {
+		name   string
+		opts   []mesostest.Option
+		fields func(*mesostest.Master) map[string]interface{}
+		class  client.FailureClass // of the failure expected, if any
+	}{
+		{name: "plain"},
+		{name: "redirect", opts: []mesostest.Option{mesostest.Redirect(leader.Addr())}},
+		{name: "gzip", opts: []mesostest.Option{mesostest.Gzip()}},
+		{
+			name: "basic auth",
+			opts: []mesostest.Option{mesostest.BasicAuth("dns", "s3cret")},
+			fields: func(*mesostest.Master) map[string]interface{} {
+				return basicConfig("dns", "s3cret")
+			},
+		},
+		{
+			name: "basic auth, wrong secret",
+			opts: []mesostest.Option{mesostest.BasicAuth("dns", "s3cret")},
+			fields: func(*mesostest.Master) map[string]interface{} {
+				return basicConfig("dns", "guess")
+			},
+			class: client.FailureStatus,
+		},
+		{
+			name:  "basic auth, no credentials",
+			opts:  []mesostest.Option{mesostest.BasicAuth("dns", "s3cret")},
+			class: client.FailureStatus,
+		},
+		{
+			name: "token auth",
+			opts: []mesostest.Option{mesostest.TokenAuth("dns", "t0ken")},
+			fields: func(m *mesostest.Master) map[string]interface{} {
+				return iamConfig(m, "dns")
+			},
+		},
+		{
+			name: "token auth, login refused",
+			opts: []mesostest.Option{mesostest.TokenAuth("dns", "t0ken")},
+			fields: func(m *mesostest.Master) map[string]interface{} {
+				return iamConfig(m, "intruder")
+			},
+			class: client.FailureConnect,
+		},
+		{
+			name:  "token auth, no credentials",
+			opts:  []mesostest.Option{mesostest.TokenAuth("dns", "t0ken")},
+			class: client.FailureStatus,
+		},
+		{
+			name: "slow",
+			opts: []mesostest.Option{mesostest.Delay(3 * time.Second)},
+			fields: func(*mesostest.Master) map[string]interface{} {
+				return map[string]interface{}{"StateTimeoutSeconds": 1}
+			},
+			class: client.FailureTimeout,
+		},
+		{name: "malformed", opts: []mesostest.Option{mesostest.Malformed()}, class: client.FailureDecode},
+		{
+			name: "https",
+			opts: []mesostest.Option{mesostest.TLS(ca)},
+			fields: func(*mesostest.Master) map[string]interface{} {
+				return map[string]interface{}{"MesosHTTPSOn": true, "CACertFile": caFile}
+			},
+		},
+		{
+			name: "https, unknown authority",
+			opts: []mesostest.Option{mesostest.TLS(ca)},
+			fields: func(*mesostest.Master) map[string]interface{} {
+				return map[string]interface{}{"MesosHTTPSOn": true, "CACertFile": otherCAFile}
+			},
+			class: client.FailureConnect,
+		},
+		{name: "http to https", opts: []mesostest.Option{mesostest.TLS(ca)}, class: client.FailureStatus},
+	} {
+		m := mesostest.NewMaster(append([]mesostest.Option{mesostest.State(fixture)}, tt.opts...)...)
+		var fields map[string]interface{}
+		if tt.fields != nil {
+			fields = tt.fields(m)
+		}
+		config := loadFakeMasterConfig(t, dir, fields, m.Addr())
+		rg := NewRecordGenerator(WithConfig(config))
+		err := rg.ParseState(config, config.Masters...)
+		m.Close()
+
+		if tt.class != "" {
+			if err == nil {
+				t.Errorf("%s: got no error, want a %s failure", tt.name, tt.class)
+			} else if rg.Failure == nil || rg.Failure.Class != string(tt.class) || rg.Failure.Master != m.Addr() {
+				t.Errorf("%s: got failure %+v, want a %s failure of %s", tt.name, rg.Failure, tt.class, m.Addr())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		want := m
+		if tt.name == "redirect" {
+			want = leader
+		}
+		host, _, _ := net.SplitHostPort(want.Addr())
+		if got := rg.As.get("leader.mesos."); !reflect.DeepEqual(got, map[string]int{host: 0}) {
+			t.Errorf("%s: got leader.mesos. %v, want %s", tt.name, got, host)
+		}
+		if got := rg.As.get("liquor-store.marathon.mesos."); len(got) != 2 {
+			t.Errorf("%s: got liquor-store.marathon.mesos. %v, want 2 hosts", tt.name, got)
+		}
+	}
+	if leader.Requests() != 1 {
+		t.Errorf("got %d state requests to the leader, want 1 redirected", leader.Requests())
+	}
+}
+
+func TestParseState_StateSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-fake-master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fixture, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader := mesostest.NewMaster(mesostest.State(fixture))
+	defer leader.Close()
+	follower := mesostest.NewMaster(mesostest.Redirect(leader.Addr()))
+	defer follower.Close()
+
+	generate := func(fields map[string]interface{}, master string) *RecordGenerator {
+		config := loadFakeMasterConfig(t, dir, fields, master)
+		rg := NewRecordGenerator(WithConfig(config))
+		if err := rg.ParseState(config, config.Masters...); err != nil {
+			t.Fatal(err)
+		}
+		return rg
+	}
+	want := generate(nil, leader.Addr())
+	if want.As.Len() == 0 || want.SRVs.Len() == 0 {
+		t.Fatal("got no records from the full state")
+	}
+	for _, master := range []string{leader.Addr(), follower.Addr()} {
+		got := generate(map[string]interface{}{"StateSummary": true}, master)
+		for _, tt := range []struct {
+			kind      rrsKind
+			got, want rrs
+		}{
+			{A, got.As, want.As},
+			{AAAA, got.AAAAs, want.AAAAs},
+			{SRV, got.SRVs, want.SRVs},
+			{PTR, got.PTRs, want.PTRs},
+		} {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("%s: got %s records %v from the state summary, want %v", master, tt.kind, tt.got, tt.want)
+			}
+		}
+	}
+	if got, want := leader.Requests(), 1+2*len(mesostest.SummaryPaths); got != want {
+		t.Errorf("got %d requests to the leader, want %d", got, want)
+	}
+}
+
+func TestParseState_Concurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-fake-master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fixture, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var masters []string
+	for i := 0; i < 3; i++ {
+		m := mesostest.NewMaster(mesostest.State(fixture))
+		defer m.Close()
+		masters = append(masters, m.Addr())
+	}
+
+	// the states of all masters are decoded concurrently, see go test -race,
+	// and the time spent decoding that of the first one only is accounted for
+	config := loadFakeMasterConfig(t, dir, map[string]interface{}{"StateFetchStrategy": StateFetchConcurrent}, masters...)
+	rg := NewRecordGenerator(WithConfig(config))
+	for i := 0; i < 3; i++ {
+		if err := rg.ParseState(config, config.Masters...); err != nil {
+			t.Fatal(err)
+		}
+		if rg.As.Len() == 0 || rg.SRVs.Len() == 0 {
+			t.Fatal("got no records from the state")
+		}
+		st := rg.Stats
+		if d := st.Durations[passDecode]; d <= 0 {
+			t.Errorf("got decode duration %v, want it measured", d)
+		}
+		if d, ok := st.Durations[passFetch]; !ok || d < 0 {
+			t.Errorf("got fetch duration %v, want it measured net of the decoding of the state fetched", d)
+		}
+	}
+}
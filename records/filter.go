@@ -0,0 +1,56 @@
+package records
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// frameworkFiltered tells whether the records of the given framework are
+// filtered out by the framework whitelist or blacklist: whether its name, as
+// normalized into a domain fragment, matches a blacklist pattern or, with a
+// whitelist, no whitelist pattern. The blacklist takes precedence.
+func (rg *RecordGenerator) frameworkFiltered(f state.Framework, spec labels.Func) bool {
+	if len(rg.frameworkWhitelist) == 0 && len(rg.frameworkBlacklist) == 0 {
+		return false
+	}
+	name := labels.DomainFrag(f.Name, labels.Sep, spec)
+	return matchAny(rg.frameworkBlacklist, name) ||
+		len(rg.frameworkWhitelist) > 0 && !matchAny(rg.frameworkWhitelist, name)
+}
+
+// skipFiltered accounts for the given framework and its tasks, filtered out.
+func (rg *RecordGenerator) skipFiltered(f state.Framework) {
+	rg.Stats.Tasks += len(f.Tasks)
+	for range f.Tasks {
+		rg.Stats.skip(SkipFiltered)
+	}
+	rg.Stats.filtered(f.Name, len(f.Tasks))
+}
+
+// matchAny tells whether the given name matches any of the given patterns,
+// as per path.Match.
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, name); ok && err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFrameworkPatterns checks that the given framework name patterns
+// are valid.
+func validateFrameworkPatterns(patterns []string) error {
+	for i, p := range patterns {
+		if p == "" {
+			return fmt.Errorf("#%d: empty pattern", i)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("#%d: %v", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package records
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// frameworkFragments returns the domain fragment of each of the given
+// frameworks, keyed by framework ID. When disambiguate is set, distinct
+// frameworks whose names normalize to the same fragment are kept apart by
+// suffixing the fragment of all but the first of them, ordered by ID, with a
+// hash of the framework ID:
+//     spark.domain.
+//     spark-xxxxx.domain.
+// Frameworks without an ID keep their fragment and aren't part of the result.
+func frameworkFragments(frameworks []state.Framework, spec labels.Func, disambiguate bool) map[string]string {
+	frags := make(map[string]string, len(frameworks))
+	claims := map[string][]string{}
+	for _, f := range frameworks {
+		if _, ok := frags[f.ID]; ok && f.ID != "" {
+			continue
+		}
+		frag := labels.DomainFrag(f.Name, labels.Sep, spec)
+		if f.ID != "" {
+			frags[f.ID] = frag
+		}
+		claims[frag] = append(claims[frag], f.ID)
+	}
+	if !disambiguate {
+		return frags
+	}
+	for frag, ids := range claims {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Strings(ids)
+		for _, id := range ids[1:] {
+			if id == "" {
+				continue
+			}
+			frags[id] = suffixFrag(frag, "-"+hashString(id), spec)
+			logging.Verbose.Printf("framework %q shares domain fragment %q with framework %q, using %q",
+				id, frag, ids[0], frags[id])
+		}
+	}
+	return frags
+}
+
+// suffixFrag appends the given suffix to the last label of a domain fragment,
+// shortening the label as needed for the result to remain a valid label.
+func suffixFrag(frag, suffix string, spec labels.Func) string {
+	i := strings.LastIndex(frag, labels.Sep)
+	head, last := frag[:i+1], frag[i+1:]
+	for ; last != ""; last = last[:len(last)-1] {
+		if lab := spec(last + suffix); strings.HasSuffix(lab, suffix) {
+			return head + lab
+		}
+	}
+	return head + spec(suffix)
+}
+
+// frameworkFrag returns the domain fragment assigned to the given framework
+// during the current generation.
+func (rg *RecordGenerator) frameworkFrag(f state.Framework, spec labels.Func) string {
+	if frag, ok := rg.fragments[f.ID]; ok {
+		return frag
+	}
+	return labels.DomainFrag(f.Name, labels.Sep, spec)
+}
+
+// idFrag returns the domain fragment of the given framework qualified with a
+// hash of its ID, telling its incarnations apart, or "" if it has no ID.
+func idFrag(f state.Framework, spec labels.Func) string {
+	if f.ID == "" {
+		return ""
+	}
+	return suffixFrag(labels.DomainFrag(f.Name, labels.Sep, spec), "-"+hashString(f.ID), spec)
+}
+
+// latestRegistrations returns, if latestFrameworks is set, the most recent
+// registration time of the frameworks sharing each domain fragment, or else
+// nil.
+func (rg *RecordGenerator) latestRegistrations(frameworks []state.Framework, spec labels.Func) map[string]float64 {
+	if !rg.latestFrameworks {
+		return nil
+	}
+	latest := map[string]float64{}
+	for _, f := range frameworks {
+		frag := rg.frameworkFrag(f, spec)
+		if t, ok := latest[frag]; !ok || f.RegisteredTime > t {
+			latest[frag] = f.RegisteredTime
+		}
+	}
+	return latest
+}
+
+// frameworkNames returns the domain fragments the records of the given
+// framework are generated under: its fragment, unless a framework sharing it
+// registered more recently as per the given registration times, and, if
+// frameworkIDRecords is set, its ID qualified one.
+func (rg *RecordGenerator) frameworkNames(f state.Framework, spec labels.Func, latest map[string]float64) []string {
+	var frags []string
+	frag := rg.frameworkFrag(f, spec)
+	if t, ok := latest[frag]; !ok || f.RegisteredTime >= t {
+		frags = append(frags, frag)
+	} else {
+		logging.VeryVerbose.Printf("framework %q registered before another one named %q, skipping its unqualified records",
+			f.ID, frag)
+	}
+	if rg.frameworkIDRecords {
+		if id := idFrag(f, spec); id != "" && id != frag {
+			frags = append(frags, id)
+		}
+	}
+	return frags
+}
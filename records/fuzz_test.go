@@ -0,0 +1,68 @@
+package records
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// stateFixtures returns the paths of all the state files used as test
+// fixtures: real master states and regression fixtures of past crashers.
+func stateFixtures(t testing.TB) []string {
+	var files []string
+	for _, pattern := range []string{"../factories/*.json", "testdata/*.json"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+	return files
+}
+
+// insertStateChecked runs InsertState over the given raw state and fails the
+// test if an invalid record name gets published.
+func insertStateChecked(t *testing.T, b []byte) {
+	var sj state.State
+	if err := json.Unmarshal(b, &sj); err != nil {
+		return
+	}
+	var rg RecordGenerator
+	err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", []string{"1.2.3.4:5050"},
+		[]string{"netinfo", "docker", "mesos", "host"}, labels.RFC1123)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rrs := range []rrs{rg.As, rg.AAAAs, rg.SRVs} {
+		for _, name := range rrs.Names() {
+			if err := validateRecordName(name); err != nil {
+				t.Fatalf("invalid record name %q published: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestInsertState_Fixtures(t *testing.T) {
+	for _, file := range stateFixtures(t) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(filepath.Base(file), func(t *testing.T) { insertStateChecked(t, b) })
+	}
+}
+
+func FuzzInsertState(f *testing.F) {
+	for _, file := range stateFixtures(f) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Fuzz(insertStateChecked)
+}
@@ -3,19 +3,26 @@
 package records
 
 import (
+	"bytes"
+	stdcontext "context"
 	"crypto/sha1"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/mesosphere/mesos-dns/httpcli"
 	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/mesosphere/mesos-dns/models"
 	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/naming"
 	"github.com/mesosphere/mesos-dns/records/state"
 	"github.com/mesosphere/mesos-dns/records/state/client"
 	"github.com/mesosphere/mesos-dns/urls"
@@ -25,46 +32,213 @@ import (
 // Map host/service name to DNS answer
 // REFACTOR - when discoveryinfo is integrated
 // Will likely become map[string][]discoveryinfo
-// Effectively we're (ab)using the map type as a set
-// It used to have the type: rrs map[string][]string
-type rrs map[string]map[string]struct{}
+//
+// Hosts are ordered by insertion: each host of a name maps to its insertion
+// rank. First returns the first inserted host and Hosts returns them in
+// insertion order, while Names returns the names in lexical order, so that the
+// same state always yields the same answers and exports.
+//
+// The names are spread over shards by hash, which copies of rrs share, so
+// that incremental updates copy only the shards, and records, of the names
+// they change, see snapshotUpdate, rather than every name.
+type rrs struct {
+	shards *[rrsShards]map[string]*rrset
+}
+
+// rrsShards is the number of shards of rrs.
+const rrsShards = 256
+
+// rrset holds the records of a kind of a name.
+type rrset struct {
+	// hosts maps the hosts to their insertion rank.
+	hosts map[string]int
+	// srv holds the SRVData of the weighted hosts of SRV records; those of
+	// unweighted ones, the usual, have none.
+	srv map[string]SRVData
+	// ttl is the TTL of the records if hasTTL, i.e. unless they get the
+	// global one, see setNameTTLs.
+	ttl    uint32
+	hasTTL bool
+}
+
+// weight returns the SRVData of the given host, if a weighted SRV one.
+func (s *rrset) weight(host string) (SRVData, bool) {
+	if s == nil {
+		return SRVData{}, false
+	}
+	d, ok := s.srv[host]
+	return d, ok
+}
+
+// newRRs returns empty records, whose copies share those added to it.
+func newRRs() rrs {
+	return rrs{shards: new([rrsShards]map[string]*rrset)}
+}
+
+// shardOf returns the shard of the given name, as per its FNV-1a hash.
+func shardOf(name string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= 16777619
+	}
+	return int(h % rrsShards)
+}
+
+// set returns the records of the given name, nil if none.
+func (r rrs) set(name string) *rrset {
+	if r.shards == nil {
+		return nil
+	}
+	return r.shards[shardOf(name)][name]
+}
+
+// get returns the hosts of the given name, mapped to their insertion rank.
+func (r rrs) get(name string) map[string]int {
+	if set := r.set(name); set != nil {
+		return set.hosts
+	}
+	return nil
+}
+
+// each calls the given function with every name and its records, in no
+// particular order.
+func (r rrs) each(f func(name string, set *rrset)) {
+	if r.shards == nil {
+		return
+	}
+	for _, shard := range r.shards {
+		for name, set := range shard {
+			f(name, set)
+		}
+	}
+}
+
+// Len returns the number of names.
+func (r rrs) Len() int {
+	n := 0
+	if r.shards != nil {
+		for _, shard := range r.shards {
+			n += len(shard)
+		}
+	}
+	return n
+}
 
-func (r rrs) add(name, host string) bool {
+// Add adds the given host to the given name, unless already there, telling
+// whether it was. It's meant for records being generated: those served may
+// not be added to.
+func (r *rrs) Add(name, host string) bool {
 	if host == "" {
 		return false
 	}
-	v, ok := r[name]
+	if r.shards == nil {
+		*r = newRRs()
+	}
+	i := shardOf(name)
+	if r.shards[i] == nil {
+		r.shards[i] = map[string]*rrset{}
+	}
+	set, ok := r.shards[i][name]
 	if !ok {
-		v = make(map[string]struct{})
-		r[name] = v
-	} else {
+		set = &rrset{hosts: map[string]int{}}
+		r.shards[i][name] = set
+	} else if _, ok = set.hosts[host]; ok {
 		// don't overwrite existing values
-		_, ok = v[host]
-		if ok {
-			return false
+		return false
+	}
+	set.hosts[host] = len(set.hosts)
+	return true
+}
+
+// remove deletes the given host of the given name, ranking the hosts inserted
+// after it one lower, and the name along with its last host. It tells whether
+// the host was present.
+func (r rrs) remove(name, host string) bool {
+	set := r.set(name)
+	if set == nil {
+		return false
+	}
+	rank, ok := set.hosts[host]
+	if !ok {
+		return false
+	}
+	delete(set.hosts, host)
+	delete(set.srv, host)
+	for h, i := range set.hosts {
+		if i > rank {
+			set.hosts[h] = i - 1
+		}
+	}
+	if len(set.hosts) == 0 {
+		i := shardOf(name)
+		if delete(r.shards[i], name); len(r.shards[i]) == 0 {
+			r.shards[i] = nil
 		}
 	}
-	v[host] = struct{}{}
 	return true
 }
 
+// First returns the first inserted host of the given name.
 func (r rrs) First(name string) (string, bool) {
-	for host := range r[name] {
-		return host, true
+	first, rank := "", -1
+	for host, i := range r.get(name) {
+		if rank < 0 || i < rank {
+			first, rank = host, i
+		}
 	}
-	return "", false
+	return first, rank >= 0
 }
 
-// Transform the record set into something exportable via the REST API
+// Hosts returns the hosts of the given name in insertion order.
+func (r rrs) Hosts(name string) []string {
+	ranked := r.get(name)
+	hosts := make([]string, len(ranked))
+	for host, i := range ranked {
+		hosts[i] = host
+	}
+	return hosts
+}
+
+// Names returns the record names in lexical order.
+func (r rrs) Names() []string {
+	names := make([]string, 0, r.Len())
+	r.each(func(name string, _ *rrset) { names = append(names, name) })
+	sort.Strings(names)
+	return names
+}
+
+// Transform the record set into something exportable via the REST API. The
+// hosts of each name are sorted, see hostLess, so that identical record sets
+// export identically whatever their insertion order.
 func (r rrs) ToAXFRResourceRecordSet() models.AXFRResourceRecordSet {
-	ret := make(models.AXFRResourceRecordSet, len(r))
-	for host, values := range r {
-		ret[host] = make([]string, 0, len(values))
-		for record := range values {
-			ret[host] = append(ret[host], record)
+	ret := make(models.AXFRResourceRecordSet, r.Len())
+	r.each(func(name string, _ *rrset) {
+		hosts := r.Hosts(name)
+		sort.Slice(hosts, func(i, j int) bool { return hostLess(hosts[i], hosts[j]) })
+		ret[name] = hosts
+	})
+	return ret
+}
+
+// hostLess orders record hosts: IP addresses numerically, IPv4 ones first,
+// SRV hosts by target, then port, and others lexically.
+func hostLess(a, b string) bool {
+	if ipA, ipB := net.ParseIP(a), net.ParseIP(b); ipA != nil && ipB != nil {
+		if v4A, v4B := ipA.To4() != nil, ipB.To4() != nil; v4A != v4B {
+			return v4A
 		}
+		return bytes.Compare(ipA.To16(), ipB.To16()) < 0
 	}
-	return ret
+	srvA, errA := ParseSRV(a)
+	srvB, errB := ParseSRV(b)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	if srvA.Target != srvB.Target {
+		return srvA.Target < srvB.Target
+	}
+	return srvA.Port < srvB.Port
 }
 
 type rrsKind string
@@ -76,8 +250,13 @@ const (
 	AAAA rrsKind = "AAAA"
 	// SRV record types
 	SRV = "SRV"
+	// PTR record types
+	PTR rrsKind = "PTR"
+	// TXT record types
+	TXT rrsKind = "TXT"
 )
 
+// rrs returns the records of the given kind of rg, none for unknown kinds.
 func (kind rrsKind) rrs(rg *RecordGenerator) rrs {
 	switch kind {
 	case A:
@@ -86,9 +265,39 @@ func (kind rrsKind) rrs(rg *RecordGenerator) rrs {
 		return rg.AAAAs
 	case SRV:
 		return rg.SRVs
+	case PTR:
+		return rg.PTRs
+	case TXT:
+		return rg.TXTs
+	default:
+		return rrs{}
+	}
+}
+
+// alloc returns the records of the given kind of rg, nil for unknown kinds,
+// allocating them first if need be, e.g. when inserted into a generator which
+// didn't go through InsertState. Unlike rrs it may not be called on
+// generators being served.
+func (kind rrsKind) alloc(rg *RecordGenerator) *rrs {
+	var r *rrs
+	switch kind {
+	case A:
+		r = &rg.As
+	case AAAA:
+		r = &rg.AAAAs
+	case SRV:
+		r = &rg.SRVs
+	case PTR:
+		r = &rg.PTRs
+	case TXT:
+		r = &rg.TXTs
 	default:
 		return nil
 	}
+	if r.shards == nil {
+		*r = newRRs()
+	}
+	return r
 }
 
 // RecordGenerator contains DNS records and methods to access and manipulate
@@ -97,9 +306,181 @@ type RecordGenerator struct {
 	As          rrs
 	AAAAs       rrs
 	SRVs        rrs
+	PTRs        rrs
+	TXTs        rrs
 	SlaveIPs    map[string][]string
 	EnumData    EnumerationData
+	Stats       GenerationStats
 	stateLoader func(masters []string) (state.State, error)
+	// subscriber follows the event stream of the leading master, if
+	// configured; it's shared by the generators configured by the same
+	// Option.
+	subscriber client.Subscriber
+	// Timestamp is when the records were generated; their staleness is
+	// measured from it.
+	Timestamp time.Time
+	// sum holds the checksum of the records, see Checksum.
+	sum *checksumOnce
+	// Leader is the leader of the state the records were generated from.
+	Leader string
+	// Failure describes why ParseState failed, if it did.
+	Failure *GenerationError
+	// masterHealth tracks the state fetch outcomes per master; it's shared
+	// by the generators configured by the same Option.
+	masterHealth *client.MasterHealth
+	// mesosAPIv1 tells whether the state is fetched with the v1 operator
+	// API, which decode must decode responses of.
+	mesosAPIv1 bool
+	// hosts resolves the hostnames of frameworks and slaves; it's shared by
+	// the generators configured by the same Option.
+	hosts *hostResolver
+	// resolver looks the hostnames up instead of the configured host
+	// resolvers or system resolver, if set, see WithResolver.
+	resolver Resolver
+	// hostLookupConcurrency is how many hostnames are looked up at once,
+	// see resolveHosts; 0 means DefaultHostLookupConcurrency.
+	hostLookupConcurrency int
+	// clock measures the generation passes, defaulting to wallClock; it's
+	// overridden in tests.
+	clock clock
+	// interfaces lists the local network interfaces, defaulting to
+	// localInterfaces; it's overridden in tests.
+	interfaces func() ([]ifaceAddrs, error)
+	// missingSlaveFallback enables publishing the task IP based records of
+	// running tasks whose slave IP is unknown.
+	missingSlaveFallback bool
+	// orphanTasks enables publishing the records of orphan tasks.
+	orphanTasks bool
+	// executorRecords enables generating the records of executors.
+	executorRecords bool
+	// disambiguateFrameworks enables suffixing the domain fragment of
+	// distinct frameworks whose names normalize to the same fragment.
+	disambiguateFrameworks bool
+	// frameworkIDRecords enables generating the framework records under a
+	// name qualified with a hash of the framework ID as well.
+	frameworkIDRecords bool
+	// latestFrameworks restricts the framework records of unqualified names
+	// to the most recently registered of the frameworks sharing them.
+	latestFrameworks bool
+	// shortSRVTargets makes the SRV records of task ports target the short
+	// task names instead of the canonical ones.
+	shortSRVTargets bool
+	// noLegacyDiscoveryNames disables the records of tasks under their raw
+	// DiscoveryInfo names, as is, along with the normalized ones.
+	noLegacyDiscoveryNames bool
+	// hostPortRecords generates the SRV records of the host ports mapped to
+	// the discovery ports of tasks, see hostPortRecords.
+	hostPortRecords bool
+	// containerNets are the networks the container IP addresses of the
+	// autoip source are routable on, whatever network they're on.
+	containerNets []*net.IPNet
+	// reverseNets are the networks whose reverse zones Mesos-DNS is
+	// authoritative for, see reverseOwned.
+	reverseNets []*net.IPNet
+	// dcosNames is the DC/OS naming mode, one of the DCOSNames constants.
+	dcosNames string
+	// taskIDRecords enables generating the records of tasks under their
+	// task ID as well, with dots handled as per taskIDDots, one of the
+	// TaskIDDots constants.
+	taskIDRecords bool
+	taskIDDots    string
+	// containerNameLabel is the key of the task label naming the records
+	// of tasks as well, if set.
+	containerNameLabel string
+	// portNameRecords enables generating the records of tasks under the
+	// names of their named discovery ports as well.
+	portNameRecords bool
+	// podRecords enables generating the records of tasks of pods, or task
+	// groups, under the names of their pods, see podTaskRecords.
+	podRecords bool
+	// taskTXTRecords enables generating TXT records of the metadata of
+	// tasks under their names, see taskTXT.
+	taskTXTRecords bool
+	// dnssdRecords enables generating the DNS-SD browsing records of the
+	// SRV records of tasks, see taskDNSSDRecords.
+	dnssdRecords bool
+	// namingLinks are the custom links applied to the names of the SRV
+	// records of task ports, between the protocol and subdomain stages.
+	namingLinks []naming.Link
+	// defaultProtocols are the protocols ports of no or an unusable protocol
+	// are published under, defaulting to tcp and udp.
+	defaultProtocols []string
+	// fragments maps framework IDs to the domain fragment they were assigned
+	// during the current generation.
+	fragments map[string]string
+	// frameworkDomains maps frameworks to the alternate domains their
+	// records are generated under.
+	frameworkDomains []FrameworkDomain
+	// frameworkWhitelist and frameworkBlacklist filter the frameworks whose
+	// records are generated, see frameworkFiltered.
+	frameworkWhitelist, frameworkBlacklist []string
+	// minVisibility is the narrowest DiscoveryInfo visibility of the tasks
+	// whose records are generated, if not empty, see visible.
+	minVisibility string
+	// taskHashLength and taskHashAlgorithm are the length, if not the
+	// default one, and algorithm of the task ID hashes of canonical task
+	// names, see canonicalHash.
+	taskHashLength    int
+	taskHashAlgorithm string
+	// canonicalTemplate renders the canonical task names, if not the
+	// default template, see canonicalName.
+	canonicalTemplate *template.Template
+	// canonicalNames maps the canonical task names generated during the
+	// current generation to their task ID, so that hash collisions are
+	// detected.
+	canonicalNames map[string]string
+	// maxRecords caps the number of records generated, if positive; task
+	// records are cut off once it's reached.
+	maxRecords int
+	// localAgent is the ID or hostname of the only agent whose slave and
+	// task records are generated, if not empty.
+	localAgent string
+	// localSlaves holds the IDs of the slaves matching localAgent found
+	// during the current generation.
+	localSlaves map[string]struct{}
+	// attributeRecords are the names of the slave attributes whose values
+	// the records of slaves and their tasks are generated under as well.
+	attributeRecords []string
+	// slaveAttributes holds the domain fragments of the attribute values of
+	// each slave with attribute records, by slave ID, see attributeFrags.
+	slaveAttributes map[string][]string
+	// strictMname causes InsertState to fail, rather than synthesize an
+	// address record, when the SOA mname doesn't resolve.
+	strictMname bool
+	// strictNames causes insertRR to panic upon structurally invalid record
+	// names instead of rejecting them; useful for catching generator bugs.
+	strictNames bool
+	// invalidNames holds the invalid record names already reported during
+	// the current generation.
+	invalidNames map[string]struct{}
+	// listings indexes the enumerated tasks by the record names they list.
+	listings listings
+	// current holds the *RecordGenerator of the generation of records last
+	// inserted, never mutated once stored, see Snapshot. It's referenced
+	// rather than embedded, so that generators may be copied.
+	current *atomic.Value
+	// owners maps each record name and kind to the source that first claimed it.
+	owners map[claimKey]RecordSource
+	// collisions holds the name collisions already reported.
+	collisions map[collisionKey]struct{}
+	// ttlOverrides set the TTL of the records whose names they match.
+	ttlOverrides []TTLOverride
+	// defaultTTL is the global TTL of the records, as configured.
+	defaultTTL uint32
+	// healthCheckTTLs lowers the TTL of the records of tasks with health
+	// checks, see healthTTL.
+	healthCheckTTLs bool
+	// healthTTLFactor and healthTTLFloor are the configured
+	// HealthCheckTTLFactor and HealthCheckTTLFloor.
+	healthTTLFactor float64
+	healthTTLFloor  uint32
+	// transforms rewrite or drop the records before they're inserted.
+	transforms []RecordTransform
+	// pinned holds the records generated outside of the task pass, which
+	// ApplyTaskUpdate never removes.
+	pinned map[recordKey]struct{}
+	// generation holds the parameters of the last InsertState, if any.
+	generation *generation
 }
 
 // EnumerableRecord is the lowest level object, and should map 1:1 with DNS records
@@ -107,6 +488,38 @@ type EnumerableRecord struct {
 	Name  string `json:"name"`
 	Host  string `json:"host"`
 	Rtype string `json:"rtype"`
+	// Port is the port of SRV records, that of Host.
+	Port int `json:"port,omitempty"`
+	// Priority and Weight are those of weighted SRV records.
+	Priority uint16 `json:"priority,omitempty"`
+	Weight   uint16 `json:"weight,omitempty"`
+	// TTL is the TTL of the record, in seconds, as of when it was
+	// generated.
+	TTL uint32 `json:"ttl"`
+}
+
+// recordKey identifies a record by its name, host and kind.
+type recordKey struct {
+	name, host string
+	kind       rrsKind
+}
+
+// key returns the key of the record.
+func (r EnumerableRecord) key() recordKey {
+	return recordKey{r.Name, r.Host, rrsKind(r.Rtype)}
+}
+
+// enumerableRecord returns the enumerable record of the given normalized
+// record, along with the port of SRV records. Its TTL is set along with
+// those of the others, once generated.
+func enumerableRecord(name, host string, kind rrsKind) EnumerableRecord {
+	rec := EnumerableRecord{Name: name, Host: host, Rtype: string(kind)}
+	if kind == SRV {
+		if d, err := ParseSRV(host); err == nil {
+			rec.Port = int(d.Port)
+		}
+	}
+	return rec
 }
 
 // EnumerableTask consists of the records derived from a task
@@ -114,18 +527,59 @@ type EnumerableTask struct {
 	Name    string             `json:"name"`
 	ID      string             `json:"id"`
 	Records []EnumerableRecord `json:"records"`
+	// Orphan is set for tasks whose framework hasn't re-registered.
+	Orphan bool `json:"orphan,omitempty"`
+	// Skipped holds the reason why the task's records were not, or only
+	// partially, generated.
+	Skipped SkipReason `json:"skipped,omitempty"`
+	// Pod is the name of the pod, or task group, of the task, with
+	// PodRecords.
+	Pod string `json:"pod,omitempty"`
+	// listed holds the records already listed, so that each is listed once.
+	listed map[recordKey]struct{}
+	// healthInterval is the interval between the health checks of the task,
+	// in seconds, or 0 if it has none.
+	healthInterval float64
+	// ttl is the TTL of the records of the task set by its TTLLabel, if
+	// hasTTL is set.
+	ttl    uint32
+	hasTTL bool
+}
+
+// addRecord lists the given record, unless already listed, telling whether it
+// wasn't.
+func (t *EnumerableTask) addRecord(rec EnumerableRecord) bool {
+	if t.listed == nil {
+		t.listed = make(map[recordKey]struct{}, len(t.Records))
+		for _, r := range t.Records {
+			t.listed[r.key()] = struct{}{}
+		}
+	}
+	if _, ok := t.listed[rec.key()]; ok {
+		return false
+	}
+	t.listed[rec.key()] = struct{}{}
+	t.Records = append(t.Records, rec)
+	return true
 }
 
 // EnumerableFramework is consistent of enumerable tasks, and include the name of the framework
 type EnumerableFramework struct {
 	Tasks []*EnumerableTask `json:"tasks"`
 	Name  string            `json:"name"`
+	// Fragment is the domain fragment the framework's records were
+	// generated under.
+	Fragment string `json:"fragment"`
+	// Domain is the domain the records of the framework's tasks were
+	// generated under.
+	Domain string `json:"domain"`
 }
 
 // EnumerationData is the top level container pointing to the
 // enumerable frameworks containing enumerable tasks
 type EnumerationData struct {
 	Frameworks []*EnumerableFramework `json:"frameworks"`
+	Collisions []Collision            `json:"collisions"`
 }
 
 // Option is a functional configuration type that mutates a RecordGenerator
@@ -144,21 +598,107 @@ func WithConfig(config Config) Option {
 		})
 		timeout       = httpcli.Timeout(time.Duration(config.StateTimeoutSeconds) * time.Second)
 		doer          = httpcli.New(config.MesosAuthentication, config.httpConfigMap, transport, timeout)
+		health        = client.NewMasterHealth()
+		hosts         = newHostResolver(config.SearchSuffixes, config.HostResolvers,
+			time.Duration(config.Timeout)*time.Second, config.HostResolversFallback, config.HostsFile)
 		stateEndpoint = urls.Builder{}.With(
-			urls.Path("/master/state.json"),
+			urls.Path(client.LegacyStatePath),
 			opt,
 		)
 	)
+	if config.MesosAPIVersion == MesosAPIv1 {
+		stateEndpoint = stateEndpoint.With(urls.Path(client.APIV1Path))
+	}
+	var subscriber client.Subscriber
+	if config.EventStream {
+		// event streams are long-lived: they're given up on once idle
+		// rather than after the state timeout
+		events := httpcli.New(config.MesosAuthentication, config.httpConfigMap, transport)
+		subscriber = client.NewSubscriber(events, urls.Builder{}.With(opt), health)
+	}
+	health.SetBreaker(config.MasterBreakerFailures, time.Duration(config.MasterBreakerCooldownSeconds)*time.Second)
+	health.SetMaxStateBytes(int64(config.StateMaxMegabytes) << 20)
+	health.SetRetry(config.StateRetryAttempts, time.Duration(config.StateRetryMaxBackoffMillis)*time.Millisecond)
+	hosts.cache = newHostCache(time.Duration(config.HostCacheSeconds) * time.Second)
 	return func(rg *RecordGenerator) {
-		rg.stateLoader = client.NewStateLoader(doer, stateEndpoint, func(b []byte, v *state.State) error {
-			return json.Unmarshal(b, v)
-		})
+		if config.StateSummary {
+			rg.stateLoader = client.NewSummaryStateLoader(doer, stateEndpoint, rg.decode, health)
+		} else if config.StateFetchStrategy == StateFetchConcurrent {
+			hedge := time.Duration(config.StateHedgeMillis) * time.Millisecond
+			rg.stateLoader = client.NewConcurrentStateLoader(doer, stateEndpoint, rg.decode, health, hedge)
+		} else {
+			rg.stateLoader = client.NewStateLoader(doer, stateEndpoint, rg.decode, health)
+		}
+		rg.masterHealth = health
+		rg.subscriber = subscriber
+		rg.mesosAPIv1 = config.MesosAPIVersion == MesosAPIv1
+		rg.hosts = hosts
+		rg.strictNames = config.StrictRecordNames
+		rg.strictMname = config.StrictSOAMname
+		rg.missingSlaveFallback = config.MissingSlaveIPFallback
+		rg.orphanTasks = config.PublishOrphanTasks
+		rg.executorRecords = config.ExecutorRecords
+		rg.disambiguateFrameworks = config.DisambiguateFrameworks
+		rg.frameworkIDRecords = config.FrameworkIDRecords
+		rg.latestFrameworks = config.LatestFrameworkIncarnation
+		rg.frameworkDomains = config.FrameworkDomains
+		rg.frameworkWhitelist = config.FrameworkWhitelist
+		rg.frameworkBlacklist = config.FrameworkBlacklist
+		rg.minVisibility = config.MinimumVisibility
+		rg.taskHashLength = config.TaskHashLength
+		rg.taskHashAlgorithm = config.TaskHashAlgorithm
+		// the default one unless valid, which Validate checked
+		rg.canonicalTemplate, _ = parseCanonicalNameTemplate(config.CanonicalNameTemplate)
+		rg.ttlOverrides = config.TTLOverrides
+		rg.defaultTTL = uint32(config.TTL)
+		rg.healthCheckTTLs = config.HealthCheckTTLs
+		rg.healthTTLFactor = config.HealthCheckTTLFactor
+		rg.healthTTLFloor = uint32(config.HealthCheckTTLFloor)
+		rg.maxRecords = config.MaxRecords
+		rg.localAgent = config.LocalAgent
+		rg.defaultProtocols = config.DefaultPortProtocols
+		rg.shortSRVTargets = config.ShortSRVTargets
+		rg.hostPortRecords = config.HostPortRecords
+		rg.hostLookupConcurrency = config.HostLookupConcurrency
+		rg.noLegacyDiscoveryNames = !config.EmitLegacyDiscoveryNames
+		rg.dcosNames = config.DCOSNames
+		rg.taskIDRecords = config.TaskIDRecords
+		rg.taskIDDots = config.TaskIDDots
+		rg.containerNameLabel = config.ContainerNameLabel
+		rg.portNameRecords = config.PortNameRecords
+		rg.podRecords = config.PodRecords
+		rg.attributeRecords = config.AttributeRecords
+		rg.taskTXTRecords = config.TaskTXTRecords
+		rg.dnssdRecords = config.DNSSDRecords
+		rg.containerNets = parseCIDRs(config.AutoIPCIDRs)
+		rg.reverseNets = parseCIDRs(config.ReverseZones)
+	}
+}
+
+// WithNamingLinks returns an Option applying the given links, in order, to
+// the names of the SRV records of task ports, between the protocol stage,
+// appending `._{protocol}.{framework}`, and the subdomain or, for discovery
+// ports, port name stage.
+func WithNamingLinks(links ...naming.Link) Option {
+	return func(rg *RecordGenerator) {
+		rg.namingLinks = append(rg.namingLinks, links...)
+	}
+}
+
+// WithResolver returns an Option looking the hostnames of frameworks and
+// slaves up through the given Resolver rather than the configured
+// HostResolvers or the system resolver, e.g. to resolve them through an
+// inventory API. Hostnames listed in the HostsFile, search suffixes and the
+// cache of lookups still apply.
+func WithResolver(r Resolver) Option {
+	return func(rg *RecordGenerator) {
+		rg.resolver = r
 	}
 }
 
 // NewRecordGenerator returns a RecordGenerator that's been configured with a timeout.
 func NewRecordGenerator(options ...Option) *RecordGenerator {
-	rg := &RecordGenerator{}
+	rg := &RecordGenerator{current: new(atomic.Value)}
 	rg.stateLoader = func(_ []string) (s state.State, err error) { return }
 	for i := range options {
 		if options[i] != nil {
@@ -168,27 +708,110 @@ func NewRecordGenerator(options ...Option) *RecordGenerator {
 	return rg
 }
 
+// Subscribe follows the event stream of the v1 operator API of the leading
+// master, subscribing to the given masters in turn, as host:port, until one
+// streams events, which are handed to the given function in order. It
+// returns once the stream ends, the given context is done or the function
+// fails, telling why, or right away without EventStream configured.
+func (rg *RecordGenerator) Subscribe(ctx stdcontext.Context, masters []string, handle func(state.Event) error) error {
+	if rg.subscriber == nil {
+		return errors.New("event stream not configured")
+	}
+	return rg.subscriber(ctx, masters, handle)
+}
+
+// Masters returns the state fetch outcomes of every master fetched from,
+// ordered by address.
+func (rg *RecordGenerator) Masters() []client.MasterStats {
+	return rg.masterHealth.Masters()
+}
+
 // ParseState retrieves and parses the Mesos master /state.json and converts it
-// into DNS records.
+// into DNS records, swapped in as the Snapshot of rg as per InsertState.
 func (rg *RecordGenerator) ParseState(c Config, masters ...string) error {
 	// find master -- return if error
+	start := rg.now()
+	rg.Failure = nil
 	sj, err := rg.stateLoader(masters)
+	fetchTime := rg.now().Sub(start)
 	if err != nil {
 		logging.Error.Println("Failed to fetch state.json. Error: ", err)
+		class, master := ErrorClassFetch, ""
+		if addr, fc := rg.masterHealth.LastFailure(); fc != "" {
+			class, master = string(fc), addr
+		}
+		rg.fail(err, class, master)
 		return err
 	}
 	if sj.Leader == "" {
-		logging.Error.Println("Unexpected error")
-		err = errors.New("empty master")
+		logging.CurLog.LeaderUnknown.Set(1)
+		if !c.AllowUnknownLeader {
+			logging.Error.Println("Unexpected error")
+			err = errors.New("empty master")
+			rg.fail(err, ErrorClassLeader, "")
+			return err
+		}
+		logging.Error.Println("warning: leader unknown, generating records without leader")
+	} else {
+		logging.CurLog.LeaderUnknown.Set(0)
+	}
+
+	next, err := rg.generate(sj, c.Domain, c.SOAMname, c.Listener, masters, c.IPSources, c.labelSpec())
+	if err != nil {
+		rg.fail(err, ErrorClassGeneration, "")
 		return err
 	}
+	next.Stats.observe(passDecode, sj.DecodeTime)
+	next.Stats.observe(passFetch, fetchTime-sj.DecodeTime)
+	next.Stats.Duration += fetchTime
+	rg.publish(next)
+	return nil
+}
+
+// fail records the given error of ParseState in rg.Failure.
+func (rg *RecordGenerator) fail(err error, class, master string) {
+	rg.Failure = &GenerationError{
+		Time:    rg.now(),
+		Message: err.Error(),
+		Class:   class,
+		Master:  master,
+	}
+}
+
+// decode decodes a master state read from r, streaming it as per
+// state.Decode, or as per state.UnmarshalV1 with the v1 operator API,
+// rejecting it if corrupt as per state.State.Check, telling the time it takes,
+// reading the state included, in the DecodeTime of v. It's called concurrently
+// by the hedged fetches of StateFetchConcurrent, so it mustn't write to rg.
+func (rg *RecordGenerator) decode(r io.Reader, v *state.State) error {
+	start := rg.now()
+	var err error
+	if rg.mesosAPIv1 {
+		err = client.Unmarshaler(state.UnmarshalV1).Decoder()(r, v)
+	} else {
+		err = state.Decode(r, v)
+	}
+	if err == nil {
+		err = v.Check()
+	}
+	v.DecodeTime = rg.now().Sub(start)
+	return err
+}
 
-	hostSpec := labels.RFC1123
-	if c.EnforceRFC952 {
-		hostSpec = labels.RFC952
+// now returns the current time as told by rg.clock.
+func (rg *RecordGenerator) now() time.Time {
+	if rg.clock == nil {
+		return wallClock{}.Now()
 	}
+	return rg.clock.Now()
+}
 
-	return rg.InsertState(sj, c.Domain, c.SOAMname, c.Listener, masters, c.IPSources, hostSpec)
+// timed runs the given generation pass, recording its duration in rg.Stats
+// and attributing its records to the pass's source.
+func (rg *RecordGenerator) timed(pass string, f func()) {
+	start := rg.now()
+	rg.Stats.attributed(passSources[pass], f)
+	rg.Stats.observe(pass, rg.now().Sub(start))
 }
 
 // hashes a given name using a truncated sha1 hash
@@ -202,35 +825,246 @@ func hashString(s string) string {
 }
 
 // InsertState transforms a StateJSON into RecordGenerator RRs
+// and records the generation statistics in rg.Stats.
+// It fails only if the SOA mname (ns) doesn't resolve and strictMname is set.
+// All generated data, including the enumeration data, is replaced rather than
+// accumulated, so that a RecordGenerator may be reused across polls: inserting
+// the same state twice yields the same records and enumeration.
+// The records are built privately, into a new generation configured like rg,
+// which is swapped in only once complete and successful: should insertion
+// fail, rg keeps its records, and LastUpdated, as is. The generation becomes
+// the Snapshot of rg, and its records, shared, those of rg. The fields of a
+// generator being inserted into may not be read meanwhile, but its Snapshot,
+// and the methods looking records up, may.
 func (rg *RecordGenerator) InsertState(sj state.State, domain, ns, listener string, masters, ipSources []string, spec labels.Func) error {
+	next, err := rg.generate(sj, domain, ns, listener, masters, ipSources, spec)
+	if err != nil {
+		return err
+	}
+	rg.publish(next)
+	return nil
+}
+
+// generate builds the records of the given state, as per InsertState, into a
+// new generation configured like rg, which it returns unless it fails.
+func (rg *RecordGenerator) generate(sj state.State, domain, ns, listener string, masters, ipSources []string, spec labels.Func) (*RecordGenerator, error) {
+	next := *rg
+	next.current = nil
+	if err := next.insertState(sj, domain, ns, listener, masters, ipSources, spec); err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// publish swaps the given generation in as the Snapshot of rg, and of itself,
+// after setting the records of rg to its own. It mustn't be mutated anymore.
+func (rg *RecordGenerator) publish(next *RecordGenerator) {
+	next.freeze()
+	rg.adopt(next)
+	if rg.current == nil {
+		rg.current = new(atomic.Value)
+	}
+	rg.current.Store(next)
+}
+
+// freeze makes rg its own Snapshot.
+func (rg *RecordGenerator) freeze() {
+	rg.current = new(atomic.Value)
+	rg.current.Store(rg)
+}
+
+// adopt sets the records of rg, and everything else generated along with
+// them, to those of the given generation, leaving its configuration and
+// Snapshot as is.
+func (rg *RecordGenerator) adopt(next *RecordGenerator) {
+	rg.As, rg.AAAAs, rg.SRVs, rg.PTRs, rg.TXTs = next.As, next.AAAAs, next.SRVs, next.PTRs, next.TXTs
+	rg.listings = next.listings
+	rg.SlaveIPs, rg.EnumData, rg.Stats = next.SlaveIPs, next.EnumData, next.Stats
+	rg.Timestamp, rg.sum, rg.Leader = next.Timestamp, next.sum, next.Leader
+	rg.generation, rg.fragments = next.generation, next.fragments
+	rg.invalidNames, rg.owners, rg.collisions = next.invalidNames, next.owners, next.collisions
+	rg.localSlaves, rg.slaveAttributes = next.localSlaves, next.slaveAttributes
+	rg.pinned, rg.canonicalNames = next.pinned, next.canonicalNames
+}
+
+// Snapshot returns the generation of records last inserted by InsertState,
+// nil if none was; the generations ApplyTaskUpdate returns are their own.
+// It's never mutated, so that it may be read while rg gets inserted into, and
+// Snapshot may be called concurrently with InsertState, once a state was
+// inserted or if rg was returned by NewRecordGenerator. The record maps of a
+// generation are built anew rather than updated.
+func (rg *RecordGenerator) Snapshot() *RecordGenerator {
+	if rg.current == nil {
+		return nil
+	}
+	next, _ := rg.current.Load().(*RecordGenerator)
+	return next
+}
+
+// view returns the Snapshot of rg, if any, which the methods looking records
+// up read, so that they may be called concurrently with InsertState, or else
+// rg itself, e.g. built by hand.
+func (rg *RecordGenerator) view() *RecordGenerator {
+	if next := rg.Snapshot(); next != nil {
+		return next
+	}
+	return rg
+}
+
+// LastUpdated returns when the records were last generated from a state, the
+// zero time if never. Readers racing InsertState ask the Snapshot of rg.
+func (rg *RecordGenerator) LastUpdated() time.Time {
+	return rg.Timestamp
+}
+
+// insertState implements InsertState, building the records into rg.
+func (rg *RecordGenerator) insertState(sj state.State, domain, ns, listener string, masters, ipSources []string, spec labels.Func) error {
+	start := rg.now()
+	rg.Stats = newGenerationStats()
+	rg.hosts.refresh()
 	rg.SlaveIPs = map[string][]string{}
-	rg.SRVs = rrs{}
-	rg.As = rrs{}
-	rg.AAAAs = rrs{}
-	rg.frameworkRecords(sj, domain, spec)
-	rg.slaveRecords(sj, domain, spec)
-	rg.listenerRecord(listener, ns)
-	rg.masterRecord(domain, masters, sj.Leader)
-	rg.taskRecords(sj, domain, spec, ipSources)
+	rg.SRVs = newRRs()
+	rg.As = newRRs()
+	rg.AAAAs = newRRs()
+	rg.PTRs = newRRs()
+	rg.TXTs = newRRs()
+	rg.listings = newListings()
+	rg.invalidNames = map[string]struct{}{}
+	rg.owners = map[claimKey]RecordSource{}
+	rg.collisions = map[collisionKey]struct{}{}
+	rg.localSlaves = map[string]struct{}{}
+	rg.slaveAttributes = map[string][]string{}
+	rg.pinned = map[recordKey]struct{}{}
+	rg.canonicalNames = map[string]string{}
+	rg.EnumData = EnumerationData{
+		Frameworks: []*EnumerableFramework{},
+		Collisions: []Collision{},
+	}
+	rg.timed(passNormalize, func() {
+		sj = normalizeState(sj, &rg.Stats)
+		rg.fragments = frameworkFragments(sj.Frameworks, spec, rg.disambiguateFrameworks)
+	})
+	rg.timed(passFrameworks, func() { rg.frameworkRecords(sj, domain, spec) })
+	rg.timed(passSlaves, func() { rg.slaveRecords(sj, domain, spec) })
+	rg.timed(passListener, func() {
+		if err := rg.listenerRecord([]string{listener}, ns); err != nil {
+			logging.Error.Println(err)
+		}
+	})
+	rg.timed(passMasters, func() { rg.masterRecord(domain, masters, sj.Leader) })
+	rg.timed(passTasks, func() { rg.taskRecords(sj, domain, spec, ipSources) })
+	if rg.Stats.Capped {
+		logging.Error.Printf("record cap of %d reached, cut off the records of tasks per framework: %s",
+			rg.maxRecords, joinCounts(rg.Stats.CutOff))
+	}
+	if len(rg.Stats.Filtered) > 0 {
+		logging.Verbose.Printf("filtered out %d frameworks by FrameworkWhitelist and FrameworkBlacklist, "+
+			"with their task counts: %s", len(rg.Stats.Filtered), joinCounts(rg.Stats.Filtered))
+	}
+	var err error
+	rg.timed(passSnapshot, func() {
+		rg.Stats.attributed(SourceListener, func() { err = rg.checkMname(ns, listener) })
+		rg.sum, rg.Leader = new(checksumOnce), sj.Leader
+		rg.Checksum() // at each resync, rather than when first asked for
+		rg.setNameTTLs()
+		rg.setRecordTTLs(rg.EnumData.Frameworks)
+	})
+	rg.generation = &generation{domain, spec, ipSources}
+	rg.Timestamp = rg.now()
+	rg.Stats.Duration = rg.Timestamp.Sub(start)
+
+	return err
+}
 
+// checkMname ensures the SOA mname has an address record, recording the
+// outcome in rg.Stats. Unless strictMname is set, a missing record is
+// synthesized from the listener address, or the loopback address if the
+// listener is a wildcard. Otherwise an error is returned.
+func (rg *RecordGenerator) checkMname(ns, listener string) error {
+	if len(rg.As.get(ns)) > 0 || len(rg.AAAAs.get(ns)) > 0 {
+		rg.Stats.Mname = MnameResolves
+		return nil
+	}
+	if rg.strictMname {
+		rg.Stats.Mname = MnameMissing
+		return fmt.Errorf("SOA mname %q has no A or AAAA record, check the SOAMname and Listener settings", ns)
+	}
+
+	addr := "127.0.0.1"
+	if ip := net.ParseIP(listener); ip != nil && !ip.IsUnspecified() {
+		addr = ip.String()
+	}
+	if !rg.insertRR(ns, addr, rrsKindForIPStr(addr)) {
+		rg.Stats.Mname = MnameMissing
+		logging.Error.Printf("SOA mname %q has no A or AAAA record and none could be synthesized, "+
+			"check the SOAMname and Listener settings", ns)
+		return nil
+	}
+	rg.Stats.Mname = MnameSynthesized
+	logging.Error.Printf("SOA mname %q has no A or AAAA record, synthesized one for %s; "+
+		"check the SOAMname and Listener settings", ns, addr)
 	return nil
 }
 
 // frameworkRecords injects A, AAAA, and SRV records into the generator store:
 //     frameworkname.domain.                 // resolves to IPs of each framework
 //     _framework._tcp.frameworkname.domain. // resolves to the driver port and IP of each framework
+// The domain is the framework's alternate one, if mapped to any, and those
+// it's mirrored under. With frameworkIDRecords, the same records are
+// generated under frameworkname-<idhash>.domain. as well. Frameworks without
+// a scheduler host, e.g. registered through the HTTP API, get no records; the
+// SRV record is omitted for frameworks without a port.
+// Frameworks filtered out by the framework whitelist or blacklist are skipped.
+// Scheduler hostnames are all resolved beforehand, see resolveHosts.
 func (rg *RecordGenerator) frameworkRecords(sj state.State, domain string, spec labels.Func) {
+	latest := rg.latestRegistrations(sj.Frameworks, spec)
+	hostnames := make([]string, 0, len(sj.Frameworks))
+	for _, f := range sj.Frameworks {
+		if !rg.frameworkFiltered(f, spec) {
+			host, _ := f.HostPort()
+			hostnames = append(hostnames, host)
+		}
+	}
+	resolved := rg.resolveHosts(hostnames)
 	for _, f := range sj.Frameworks {
+		rg.Stats.Frameworks++
+		if rg.frameworkFiltered(f, spec) {
+			continue
+		}
 		host, port := f.HostPort()
-		if ips := hostToIPs(host); len(ips) > 0 {
-			fname := labels.DomainFrag(f.Name, labels.Sep, spec)
-			a := fname + "." + domain + "."
-			for _, ip := range ips {
-				rg.insertRR(a, ip.String(), rrsKindForIP(ip))
+		host = normalizeHost(host)
+		if host == "" {
+			rg.Stats.FrameworksWithoutHost++
+			continue
+		}
+		if port == "" {
+			rg.Stats.FrameworksWithoutPort++
+		}
+		for _, part := range strings.Split(f.Name, labels.Sep) {
+			if truncated(part, spec(part), spec) {
+				rg.Stats.event(EventTruncation)
 			}
-			if port != "" {
-				srvAddress := net.JoinHostPort(a, port)
-				rg.insertRR("_framework._tcp."+a, srvAddress, SRV)
+		}
+		ips := resolved[host]
+		if len(ips) == 0 {
+			rg.Stats.ResolutionFailures++
+			continue
+		}
+		frags := rg.frameworkNames(f, spec, latest)
+		for _, fdomain := range rg.frameworkDomainList(f, domain) {
+			for _, frag := range frags {
+				a := frag + "." + fdomain + "."
+				src := RecordSource{FrameworkID: f.ID, FrameworkName: f.Name}
+				for _, ip := range ips {
+					kind := rrsKindForIP(ip)
+					rg.claim(a, kind, src)
+					rg.insertRR(a, ip.String(), kind)
+				}
+				if port != "" {
+					srvAddress := net.JoinHostPort(a, port)
+					rg.claim("_framework._tcp."+a, SRV, src)
+					rg.insertRR("_framework._tcp."+a, srvAddress, SRV)
+				}
 			}
 		}
 	}
@@ -239,26 +1073,99 @@ func (rg *RecordGenerator) frameworkRecords(sj state.State, domain string, spec
 // slaveRecords injects A and SRV records into the generator store:
 //     slave.domain.      // resolves to IPs of all slaves
 //     _slave._tcp.domain. // resolves to the driver port and IP of all slaves
+// Slaves advertising only unspecified or loopback addresses are skipped, as
+// are the records of their tasks. In agent-local mode, only the local agent
+// is published. Slave addresses in the networks of ReverseZones get PTR
+// records targeting slave.domain., see insertPTR. Slave hostnames are all
+// resolved beforehand, see resolveHosts.
 func (rg *RecordGenerator) slaveRecords(sj state.State, domain string, spec labels.Func) {
 	a := "slave." + domain + "."
+	found := map[string]bool{}
+	hostnames := make([]string, 0, len(sj.Slaves))
+	for _, slave := range sj.Slaves {
+		if rg.localAgent == "" || isLocalAgent(slave, rg.localAgent) {
+			hostnames = append(hostnames, slave.PID.Host)
+		}
+	}
+	resolved := rg.resolveHosts(hostnames)
 	for _, slave := range sj.Slaves {
+		if rg.localAgent != "" {
+			if !isLocalAgent(slave, rg.localAgent) {
+				continue
+			}
+			rg.localSlaves[slave.ID] = struct{}{}
+		}
+		host := normalizeHost(slave.PID.Host)
+		ips := resolved[host]
+		if len(ips) > 0 {
+			if ips = routableIPs(ips); len(ips) == 0 {
+				logging.CurLog.UnroutableSlaves.Inc()
+				rg.Stats.event(EventUnroutable)
+				if unroutableSlaveLog.Allow(slave.ID) {
+					logging.Error.Printf("skipping slave %q: its pid host %q is an unspecified or loopback address, "+
+						"check the --ip and --advertise_ip flags of the slave", slave.ID, host)
+				}
+				continue
+			}
+		}
 		slaveIPs := []string{}
-		if ips := hostToIPs(slave.PID.Host); len(ips) > 0 {
+		if len(ips) > 0 {
 			for _, ip := range ips {
 				rg.insertRR(a, ip.String(), rrsKindForIP(ip))
+				rg.insertPTR(ip.String(), a)
 				slaveIPs = append(slaveIPs, ip.String())
 			}
-			srv := net.JoinHostPort(a, slave.PID.Port)
-			rg.insertRR("_slave._tcp."+domain+".", srv, SRV)
+			if slave.PID.Port != "" {
+				srv := net.JoinHostPort(a, slave.PID.Port)
+				rg.insertRR("_slave._tcp."+domain+".", srv, SRV)
+			}
 		} else {
-			logging.VeryVerbose.Printf("string %q for slave with id %q is not a valid IP address", slave.PID.Host, slave.ID)
+			rg.Stats.ResolutionFailures++
+			logging.VeryVerbose.Printf("string %q for slave with id %q is not a valid IP address", host, slave.ID)
 		}
 		if len(slaveIPs) == 0 {
-			address := labels.DomainFrag(slave.PID.Host, labels.Sep, spec)
+			rg.Stats.event(EventSanitationFallback)
+			address := labels.DomainFrag(host, labels.Sep, spec)
 			slaveIPs = append(slaveIPs, address)
 		}
 		rg.SlaveIPs[slave.ID] = slaveIPs
+
+		if frags := rg.attributeFrags(slave, spec, found); len(frags) > 0 {
+			rg.slaveAttributes[slave.ID] = frags
+			for _, frag := range frags {
+				for _, ip := range ips {
+					rg.insertRR(attributeSlaves+"."+frag+"."+domain+".", ip.String(), rrsKindForIP(ip))
+				}
+			}
+		}
+	}
+	rg.logUnknownAttributes(found)
+	if rg.localAgent != "" && len(rg.localSlaves) == 0 {
+		logging.Error.Printf("local agent %q is missing from the master state, no task records generated", rg.localAgent)
+	}
+}
+
+// isLocalAgent tells whether the given slave is the local agent, given by its
+// ID or hostname.
+func isLocalAgent(slave state.Slave, agent string) bool {
+	host := normalizeHost(agent)
+	return slave.ID == agent || normalizeHost(slave.Hostname) == host ||
+		(slave.PID.UPID != nil && normalizeHost(slave.PID.Host) == host)
+}
+
+// unroutableSlaveLog rate limits the logging of slaves skipped for advertising
+// unusable addresses.
+var unroutableSlaveLog = logging.NewLimiter(10 * time.Minute)
+
+// routableIPs returns the given IPs without the unspecified and loopback ones.
+func routableIPs(ips []net.IP) []net.IP {
+	var routable []net.IP
+	for _, ip := range ips {
+		if !ip.IsUnspecified() && !ip.IsLoopback() {
+			routable = append(routable, ip)
+		}
 	}
+	return routable
 }
 
 // masterRecord injects A and SRV records into the generator store:
@@ -266,6 +1173,9 @@ func (rg *RecordGenerator) slaveRecords(sj state.State, domain string, spec labe
 //     masterN.domain. // one IP address for each master
 //     leader.domain.  // one IP address for the leading master
 //
+// Master addresses in the networks of ReverseZones get PTR records targeting
+// their masterN and leader names.
+//
 // The current func implementation makes an assumption about the order of masters:
 // it's the order in which you expect the enumerated masterN records to be created.
 // This is probably important: if a new leader is elected, you may not want it to
@@ -287,7 +1197,15 @@ func (rg *RecordGenerator) slaveRecords(sj state.State, domain string, spec labe
 // So the func tries to index the masters as they're listed and begrudgingly assigns
 // the leading master an index out-of-band if it's not actually listed in the masters
 // list. There are probably better ways to do it.
+//
+// When the leader is unknown, e.g. during a master failover, only the masters
+// list contributes to the master records and no leader records are created.
 func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader string) {
+	if leader == "" {
+		rg.masterListRecords(domain, masters, "")
+		return
+	}
+
 	// create records for leader
 	// A and AAAA records
 	h := strings.Split(leader, "@")
@@ -295,15 +1213,21 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 		logging.Error.Println(leader)
 		return // avoid a panic later
 	}
-	leaderAddress := h[1]
+	leaderAddress := normalizeHostPort(h[1])
 	ip, port, err := urls.SplitHostPort(leaderAddress)
 	if err != nil {
 		logging.Error.Println(err)
 		return
 	}
+	if net.ParseIP(ip) == nil {
+		rg.Stats.event(EventInvalidIP)
+		logging.Error.Printf("leader %q does not have a valid IP address", leader)
+		return
+	}
 	ipKind := rrsKindForIPStr(ip)
 	leaderRecord := "leader." + domain + "."
 	rg.insertRR(leaderRecord, ip, ipKind)
+	rg.insertPTR(ip, leaderRecord)
 	allMasterRecord := "master." + domain + "."
 	rg.insertRR(allMasterRecord, ip, ipKind)
 
@@ -315,14 +1239,38 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 	rg.insertRR(udp, host, SRV)
 
 	// if there is a list of masters, insert that as well
-	addedLeaderMasterN := false
-	idx := 0
+	idx, addedLeaderMasterN := rg.masterListRecords(domain, masters, leaderAddress)
+
+	// flake: we ended up with a leader that's not in the list of all masters?
+	if !addedLeaderMasterN {
+		// only a flake if there were fallback masters configured
+		if len(masters) > 0 {
+			logging.Error.Printf("warning: leader %q is not in master list", leader)
+		}
+		extraMasterRecord := "master" + strconv.Itoa(idx) + "." + domain + "."
+		rg.insertRR(extraMasterRecord, ip, ipKind)
+		rg.insertPTR(ip, extraMasterRecord)
+	}
+}
+
+// masterListRecords injects the master and masterN records of the given list
+// of masters, returning the number of masterN records created and whether the
+// leader, given by its normalized address, was among them. Masters are
+// compared by their normalized host:port pairs.
+func (rg *RecordGenerator) masterListRecords(domain string, masters []string, leaderAddress string) (idx int, addedLeaderMasterN bool) {
+	allMasterRecord := "master." + domain + "."
 	for _, master := range masters {
+		master = normalizeHostPort(master)
 		masterIP, _, err := urls.SplitHostPort(master)
 		if err != nil {
 			logging.Error.Println(err)
 			continue
 		}
+		if net.ParseIP(masterIP) == nil {
+			rg.Stats.event(EventInvalidIP)
+			logging.Error.Printf("master %q does not have a valid IP address", master)
+			continue
+		}
 		masterIPKind := rrsKindForIPStr(masterIP)
 
 		// A and AAAA records (master and masterN)
@@ -341,129 +1289,344 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 
 		perMasterRecord := "master" + strconv.Itoa(idx) + "." + domain + "."
 		rg.insertRR(perMasterRecord, masterIP, masterIPKind)
+		rg.insertPTR(masterIP, perMasterRecord)
 		idx++
 		if master == leaderAddress {
 			addedLeaderMasterN = true
 		}
 	}
-	// flake: we ended up with a leader that's not in the list of all masters?
-	if !addedLeaderMasterN {
-		// only a flake if there were fallback masters configured
-		if len(masters) > 0 {
-			logging.Error.Printf("warning: leader %q is not in master list", leader)
-		}
-		extraMasterRecord := "master" + strconv.Itoa(idx) + "." + domain + "."
-		rg.insertRR(extraMasterRecord, ip, ipKind)
-	}
+	return idx, addedLeaderMasterN
 }
 
-// A or AAAA record for mesos-dns (the name is listed in SOA replies)
-func (rg *RecordGenerator) listenerRecord(listener string, ns string) {
-	if listener == "0.0.0.0" {
-		rg.setFromLocal(listener, ns)
-	} else if listener == "127.0.0.1" {
-		rg.insertRR(ns, "127.0.0.1", A)
-	} else {
+// A or AAAA records for mesos-dns (the name is listed in SOA replies), one
+// per listener address so that clients of every network Mesos-DNS is bound
+// to are told an address they can reach. Wildcard addresses get the records
+// of the local interfaces instead, inserted once.
+func (rg *RecordGenerator) listenerRecord(listeners []string, ns string) error {
+	var (
+		err   error
+		local bool
+	)
+	for _, listener := range listeners {
+		if ip := net.ParseIP(listener); ip != nil && ip.IsUnspecified() {
+			if !local {
+				local = true
+				err = rg.setFromLocal(listener, ns)
+			}
+			continue
+		}
 		rg.insertRR(ns, listener, rrsKindForIPStr(listener))
 	}
+	return err
 }
 
+// taskRecords generates the records of the tasks of every framework, after
+// all other records so that only task records get cut off by the record cap.
+// When capped, frameworks are processed by ID so that the same ones, the most
+// recently registered, get cut off every generation. The tasks of frameworks
+// filtered out by the framework whitelist or blacklist are skipped.
 func (rg *RecordGenerator) taskRecords(sj state.State, domain string, spec labels.Func, ipSources []string) {
-	for _, f := range sj.Frameworks {
-		enumerableFramework := &EnumerableFramework{
-			Name:  f.Name,
-			Tasks: []*EnumerableTask{},
+	frameworks := sj.Frameworks
+	if rg.maxRecords > 0 {
+		frameworks = append([]state.Framework(nil), frameworks...)
+		sort.SliceStable(frameworks, func(i, j int) bool { return frameworks[i].ID < frameworks[j].ID })
+	}
+	for _, f := range frameworks {
+		if rg.frameworkFiltered(f, spec) {
+			rg.skipFiltered(f)
+			continue
+		}
+		rg.frameworkTaskRecords(f, domain, spec, ipSources)
+	}
+	if rg.orphanTasks {
+		rg.orphanTaskRecords(sj, domain, spec, ipSources)
+	}
+	if rg.executorRecords {
+		// after every task, so that tasks keep their names
+		for _, f := range frameworks {
+			if !rg.frameworkFiltered(f, spec) {
+				rg.frameworkExecutorRecords(f, domain, spec)
+			}
 		}
-		rg.EnumData.Frameworks = append(rg.EnumData.Frameworks, enumerableFramework)
+	}
+}
 
-		for _, task := range f.Tasks {
-			var ok bool
-			task.SlaveIPs, ok = rg.SlaveIPs[task.SlaveID]
+// orphansFramework is the name of the synthetic framework orphan tasks are
+// published under.
+const orphansFramework = "orphans"
+
+// orphanTaskRecords publishes the records of orphan tasks under the synthetic
+// orphans framework:
+//     task.orphans.domain.
+// Orphan tasks of a registered framework are skipped since their records are
+// generated by that framework. The framework whitelist and blacklist apply to
+// the orphans framework.
+func (rg *RecordGenerator) orphanTaskRecords(sj state.State, domain string, spec labels.Func, ipSources []string) {
+	registered := make(map[string]struct{}, len(sj.Frameworks))
+	published := map[string]struct{}{}
+	for _, f := range sj.Frameworks {
+		registered[f.ID] = struct{}{}
+		for _, t := range f.Tasks {
+			published[t.ID] = struct{}{}
+		}
+	}
+	orphans := state.Framework{Name: orphansFramework}
+	for _, t := range sj.OrphanTasks {
+		if _, ok := registered[t.FrameworkID]; ok {
+			continue
+		}
+		if _, ok := published[t.ID]; ok {
+			continue
+		}
+		orphans.Tasks = append(orphans.Tasks, t)
+	}
+	if len(orphans.Tasks) == 0 {
+		return
+	}
+	if rg.frameworkFiltered(orphans, spec) {
+		rg.skipFiltered(orphans)
+		return
+	}
+	n := len(rg.EnumData.Frameworks)
+	rg.frameworkTaskRecords(orphans, domain, spec, ipSources)
+	for _, enumFW := range rg.EnumData.Frameworks[n:] { // including mirrors
+		for _, t := range enumFW.Tasks {
+			t.Orphan = true
+		}
+	}
+}
 
-			// only do running and discoverable tasks
-			if ok && (task.State == "TASK_RUNNING") {
-				rg.taskRecord(task, f, domain, spec, ipSources, enumerableFramework)
-			}
+// frameworkTaskRecords generates the records of the given framework's tasks
+// under its alternate domain, if mapped to any, or else the given one,
+// returning the framework's enumeration data.
+func (rg *RecordGenerator) frameworkTaskRecords(f state.Framework, domain string, spec labels.Func, ipSources []string) *EnumerableFramework {
+	domain = rg.frameworkDomain(f, domain)
+	enumerableFramework := &EnumerableFramework{
+		Name:     f.Name,
+		Fragment: rg.frameworkFrag(f, spec),
+		Domain:   domain,
+		Tasks:    []*EnumerableTask{},
+	}
+	rg.EnumData.Frameworks = append(rg.EnumData.Frameworks, enumerableFramework)
+
+	for _, task := range f.Tasks {
+		rg.Stats.Tasks++
+		var ok bool
+		task.SlaveIPs, ok = rg.SlaveIPs[task.SlaveID]
+		task.ContainerNets = rg.containerNets
+
+		// only do running and discoverable tasks
+		switch {
+		case task.State != "TASK_RUNNING":
+			rg.Stats.skip(SkipNotRunning)
+		case !rg.isLocal(task):
+			rg.Stats.skip(SkipFiltered)
+		case !rg.visible(task):
+			// listed, so that operators can tell why it has no records
+			rg.Stats.skip(SkipVisibility)
+			enumerableFramework.Tasks = append(enumerableFramework.Tasks, &EnumerableTask{
+				ID:      task.ID,
+				Name:    task.Name,
+				Records: []EnumerableRecord{},
+				Skipped: SkipVisibility,
+			})
+		case rg.capped():
+			rg.Stats.skip(SkipRecordCap)
+			rg.Stats.cutOff(f.Name)
+			enumerableFramework.Tasks = append(enumerableFramework.Tasks, &EnumerableTask{
+				ID:      task.ID,
+				Name:    task.Name,
+				Records: []EnumerableRecord{},
+				Skipped: SkipRecordCap,
+			})
+		case !ok:
+			rg.missingSlaveIP(task, f, domain, spec, ipSources, enumerableFramework)
+		default:
+			rg.taskRecord(task, f, domain, spec, ipSources, enumerableFramework)
 		}
 	}
+	rg.mirrorTaskRecords(f, enumerableFramework)
+	return enumerableFramework
+}
+
+// isLocal tells whether the given task runs on the local agent, or any agent
+// if not in agent-local mode.
+func (rg *RecordGenerator) isLocal(task state.Task) bool {
+	if rg.localAgent == "" {
+		return true
+	}
+	_, ok := rg.localSlaves[task.SlaveID]
+	return ok
+}
+
+// missingSlaveLog rate limits the logging of tasks whose slave IP is unknown.
+var missingSlaveLog = logging.NewLimiter(10 * time.Minute)
+
+// missingSlaveIP handles a running task whose slave IP is unknown: the task is
+// accounted for and listed as skipped. When the fallback is enabled and the
+// task has IPs of its own, its task IP based records are published.
+func (rg *RecordGenerator) missingSlaveIP(task state.Task, f state.Framework, domain string, spec labels.Func, ipSources []string, enumFW *EnumerableFramework) {
+	logging.CurLog.MissingSlaveIPTasks.Inc()
+	if missingSlaveLog.Allow(task.ID) {
+		logging.Error.Printf("task %q of framework %q runs on slave %q whose IP is unknown", task.ID, f.Name, task.SlaveID)
+	}
+	if rg.missingSlaveFallback && len(task.IPs(ipSources...)) > 0 {
+		rg.taskRecord(task, f, domain, spec, ipSources, enumFW)
+		enumFW.Tasks[len(enumFW.Tasks)-1].Skipped = SkipMissingSlaveIP
+		return
+	}
+	rg.Stats.skip(SkipMissingSlaveIP)
+	enumFW.Tasks = append(enumFW.Tasks, &EnumerableTask{
+		ID:      task.ID,
+		Name:    task.Name,
+		Records: []EnumerableRecord{},
+		Skipped: SkipMissingSlaveIP,
+	})
 }
 
 type context struct {
 	taskName string
 	taskID   string
 	slaveID  string
+	// taskIPs are the addresses the task records are published with, see
+	// state.Task.RecordIPs.
 	taskIPs  []net.IP
 	slaveIPs []string
+	source   RecordSource
+	// ports are the discovery ports of the task, less exact duplicates.
+	ports []state.DiscoveryPort
 }
 
 func (rg *RecordGenerator) taskRecord(task state.Task, f state.Framework, domain string, spec labels.Func, ipSources []string, enumFW *EnumerableFramework) {
 
 	newTask := &EnumerableTask{ID: task.ID, Name: task.Name}
+	if task.HealthCheck != nil {
+		newTask.healthInterval = task.HealthCheck.Interval()
+	}
+	newTask.ttl, newTask.hasTTL = labelTTL(&task)
 
 	enumFW.Tasks = append(enumFW.Tasks, newTask)
 
+	// the canonical names of the task are those of its DiscoveryInfo name,
+	// if any
+	name := task.Name
+	if task.HasDiscoveryInfo() {
+		name = task.DiscoveryInfo.Name
+	}
+	slaveID := slaveIDTail(task.SlaveID)
+	hash := rg.canonicalHash(task.ID, spec(name), slaveID, rg.frameworkFrag(f, spec))
+
 	// define context
 	ctx := context{
-		spec(task.Name),
-		hashString(task.ID),
-		slaveIDTail(task.SlaveID),
-		task.IPs(ipSources...),
+		rg.label(task.Name, spec),
+		hash,
+		slaveID,
+		task.RecordIPs(ipSources...),
 		task.SlaveIPs,
+		RecordSource{FrameworkID: f.ID, FrameworkName: f.Name, TaskID: task.ID},
+		rg.discoveryPorts(task, spec),
 	}
 
 	// use DiscoveryInfo name if defined instead of task name
 	if task.HasDiscoveryInfo() {
-		// LEGACY TODO: REMOVE
-		ctx.taskName = task.DiscoveryInfo.Name
-		rg.taskContextRecord(ctx, task, f, domain, spec, newTask)
-		// LEGACY, TODO: REMOVE
+		if !rg.noLegacyDiscoveryNames {
+			// LEGACY TODO: REMOVE
+			ctx.taskName = task.DiscoveryInfo.Name
+			rg.taskContextRecord(ctx, task, f, domain, spec, newTask)
+			// LEGACY, TODO: REMOVE
+		}
 
-		ctx.taskName = spec(task.DiscoveryInfo.Name)
+		ctx.taskName = rg.label(task.DiscoveryInfo.Name, spec)
 		rg.taskContextRecord(ctx, task, f, domain, spec, newTask)
 	} else {
 		rg.taskContextRecord(ctx, task, f, domain, spec, newTask)
 	}
+	rg.taskPTRRecords(ctx, f, domain, spec, newTask)
 
+	if rg.dcosNames != DCOSNamesOff && len(ctx.slaveIPs) > 0 {
+		rg.dcosRecords(ctx, task, rg.frameworkFrag(f, spec), domain, ipSources, newTask)
+	}
+	if rg.taskIDRecords {
+		rg.byIDRecords(ctx, task, rg.frameworkFrag(f, spec), domain, spec, newTask)
+	}
+	if name := rg.containerName(task); name != "" {
+		fname := rg.frameworkFrag(f, spec)
+		if lab := rg.label(name, spec); lab != "" {
+			rg.aliasRecords(ctx, task, lab, serviceSpec(spec)(name), fname, fname, domain, spec, newTask)
+		}
+	}
+	if rg.portNameRecords {
+		rg.namedPortRecords(ctx, rg.frameworkFrag(f, spec), domain, spec, newTask)
+	}
+	if rg.podRecords {
+		rg.podTaskRecords(ctx, task, rg.frameworkFrag(f, spec), domain, spec, newTask)
+	}
+	if len(rg.attributeRecords) > 0 {
+		rg.attributeTaskRecords(ctx, task, rg.frameworkFrag(f, spec), domain, newTask)
+	}
 }
 func (rg *RecordGenerator) taskContextRecord(ctx context, task state.Task, f state.Framework, domain string, spec labels.Func, enumTask *EnumerableTask) {
-	fname := labels.DomainFrag(f.Name, labels.Sep, spec)
+	fname := rg.frameworkFrag(f, spec)
 
 	tail := "." + domain + "."
 
 	// insert canonical A / AAAA records
-	canonical := ctx.taskName + "-" + ctx.taskID + "-" + ctx.slaveID + "." + fname
+	canonical := rg.canonicalName(ctx.taskName, ctx.taskID, ctx.slaveID, fname)
 	arec := ctx.taskName + "." + fname
 
-	// Only use the first ipv4 and first ipv6 found in sources
-	tIPs := ipsTo4And6(ctx.taskIPs)
+	// the addresses of the first sources with any ipv4 and ipv6 ones
+	tIPs := ctx.taskIPs
+	// the short names are replaced by the DC/OS ones in that naming mode
+	short := rg.dcosNames != DCOSNamesInstead
 	for _, tIP := range tIPs {
-		rg.insertTaskRR(arec+tail, tIP.String(), rrsKindForIP(tIP), enumTask)
-		rg.insertTaskRR(canonical+tail, tIP.String(), rrsKindForIP(tIP), enumTask)
+		if short {
+			rg.insertTaskRR(arec+tail, tIP.String(), rrsKindForIP(tIP), ctx.source, enumTask)
+		}
+		rg.insertTaskRR(canonical+tail, tIP.String(), rrsKindForIP(tIP), ctx.source, enumTask)
+	}
+	if rg.taskTXTRecords && len(tIPs) > 0 {
+		txt := taskTXT(task, f)
+		if short {
+			rg.insertTaskRR(arec+tail, txt, TXT, ctx.source, enumTask)
+		}
+		rg.insertTaskRR(canonical+tail, txt, TXT, ctx.source, enumTask)
 	}
 
-	// slaveIPs already only has at most one ipv4 and one ipv6
+	// without a slave IP only the task IP based records can be published
+	if len(ctx.slaveIPs) == 0 {
+		return
+	}
+
+	// every slave address, of either family, is published
 	for _, sIPStr := range ctx.slaveIPs {
 		if sIP := net.ParseIP(sIPStr); sIP != nil {
-			rg.insertTaskRR(arec+".slave"+tail, sIP.String(), rrsKindForIP(sIP), enumTask)
-			rg.insertTaskRR(canonical+".slave"+tail, sIP.String(), rrsKindForIP(sIP), enumTask)
+			if short {
+				rg.insertTaskRR(arec+".slave"+tail, sIP.String(), rrsKindForIP(sIP), ctx.source, enumTask)
+			}
+			rg.insertTaskRR(canonical+".slave"+tail, sIP.String(), rrsKindForIP(sIP), ctx.source, enumTask)
 		} else {
 			// ack: slave IP may not be an actual IP if labels.DomainFrag was used.
 			// Does labels.DomainFrag produce a valid A record value?
 			// Issue to track: https://github.com/mesosphere/mesos-dns/issues/509
-			rg.insertTaskRR(arec+".slave"+tail, sIPStr, A, enumTask)
-			rg.insertTaskRR(canonical+".slave"+tail, sIPStr, A, enumTask)
+			if short {
+				rg.insertTaskRR(arec+".slave"+tail, sIPStr, A, ctx.source, enumTask)
+			}
+			rg.insertTaskRR(canonical+".slave"+tail, sIPStr, A, ctx.source, enumTask)
 		}
 	}
 
 	// recordName generates records for ctx.taskName, given some generation chain
-	recordName := func(gen chain) { gen("_" + ctx.taskName) }
+	recordName := func(gen naming.Chain) { gen("_" + ctx.taskName) }
 
 	// asSRV is always the last link in a chain, it must insert RR's
-	asSRV := func(target string) chain {
+	asSRV := func(target string, priority, weight uint16) naming.Chain {
 		return func(records ...string) {
 			for i := range records {
 				name := records[i] + tail
-				rg.insertTaskRR(name, target, SRV, enumTask)
+				rg.insertWeightedTaskRR(name, target, SRV, priority, weight, ctx.source, enumTask)
+				if rg.dnssdRecords {
+					rg.taskDNSSDRecords(name, target, domain, ctx.source, enumTask)
+				}
 			}
 		}
 	}
@@ -476,43 +1639,96 @@ func (rg *RecordGenerator) taskContextRecord(ctx context, task state.Task, f sta
 		subdomains = []string{"slave", domainNone}
 	}
 
-	slaveHost := canonical + ".slave" + tail
+	// SRV records target the canonical names, identifying the task, unless
+	// configured to target the short ones, shared by its instances
+	host, slaveHost := canonical+tail, canonical+".slave"+tail
+	if rg.shortSRVTargets {
+		host, slaveHost = arec+tail, arec+".slave"+tail
+	}
+	priority, weight := srvWeights(&task, nil)
 	for _, port := range task.Ports() {
 		slaveTarget := slaveHost + ":" + port
-		recordName(withProtocol(protocolNone, fname, spec,
-			withSubdomains(subdomains, asSRV(slaveTarget))))
+		recordName(naming.WithProtocols(rg.portProtocols(protocolNone, spec), fname,
+			naming.WithLinks(rg.namingLinks,
+				naming.WithSubdomains(subdomains, asSRV(slaveTarget, priority, weight)))))
 	}
 
 	if !task.HasDiscoveryInfo() {
 		return
 	}
 
-	for _, port := range task.DiscoveryInfo.Ports.DiscoveryPorts {
-		target := canonical + tail + ":" + strconv.Itoa(port.Number)
-		recordName(withProtocol(port.Protocol, fname, spec,
-			withNamedPort(port.Name, spec, asSRV(target))))
+	for i := range ctx.ports {
+		port := &ctx.ports[i]
+		priority, weight := srvWeights(&task, port)
+		target := host + ":" + strconv.Itoa(port.Number)
+		recordName(naming.WithProtocols(rg.portProtocols(port.Protocol, spec), fname,
+			naming.WithLinks(rg.namingLinks,
+				naming.WithNamedPort(port.Name, spec, asSRV(target, priority, weight)))))
+	}
+	if rg.hostPortRecords {
+		rg.hostPortSRVs(ctx, task, fname, slaveHost, spec, asSRV)
+	}
+}
+
+// hostPortZone is the zone, under the framework one, of the SRV records of
+// the host ports mapped to discovery ports.
+const hostPortZone = "hostport"
+
+// hostPortSRVs inserts, with the given chain, the SRV records of the host
+// ports mapped to the discovery ports of the given task, e.g. in bridge mode,
+// where its container ports aren't reachable from outside its slave:
+//
+//	_task._protocol.hostport.framework.domain.
+//
+// Mappings are paired with the discovery ports by container port and, if
+// both have one, protocol. The records target the given slave host at the
+// host ports.
+func (rg *RecordGenerator) hostPortSRVs(ctx context, task state.Task, fname, slaveHost string, spec labels.Func, asSRV func(string, uint16, uint16) naming.Chain) {
+	mappings := task.PortMappings()
+	if len(mappings) == 0 {
+		return
+	}
+	for i := range ctx.ports {
+		port := &ctx.ports[i]
+		priority, weight := srvWeights(&task, port)
+		for _, m := range mappings {
+			if int(m.ContainerPort) != port.Number ||
+				m.Protocol != "" && port.Protocol != "" && !strings.EqualFold(m.Protocol, port.Protocol) {
+				continue
+			}
+			target := slaveHost + ":" + strconv.Itoa(int(m.HostPort))
+			naming.WithProtocols(rg.portProtocols(port.Protocol, spec), hostPortZone+"."+fname,
+				naming.WithLinks(rg.namingLinks,
+					naming.WithNamedPort(port.Name, spec, asSRV(target, priority, weight))))("_" + ctx.taskName)
+		}
 	}
 }
 
 // A and AAAA records for each local interface
 // If this causes problems you should explicitly set the
 // listener address in config.json
-func (rg *RecordGenerator) setFromLocal(host string, ns string) {
+//
+// Errors listing interfaces or their addresses don't stop the enumeration;
+// they're aggregated into the returned error. Should no address be found, the
+// loopback address is inserted so that the name stays resolvable.
+func (rg *RecordGenerator) setFromLocal(host string, ns string) error {
+	interfaces := rg.interfaces
+	if interfaces == nil {
+		interfaces = localInterfaces
+	}
 
-	ifaces, err := net.Interfaces()
+	var errs []string
+	ifaces, err := interfaces()
 	if err != nil {
-		logging.Error.Println(err)
+		errs = append(errs, err.Error())
 	}
 
-	// handle err
 	for _, i := range ifaces {
-
-		addrs, err := i.Addrs()
-		if err != nil {
-			logging.Error.Println(err)
+		if i.err != nil {
+			errs = append(errs, fmt.Sprintf("interface %s: %v", i.name, i.err))
 		}
 
-		for _, addr := range addrs {
+		for _, addr := range i.addrs {
 			var ip net.IP
 			switch v := addr.(type) {
 			case *net.IPNet:
@@ -528,29 +1744,165 @@ func (rg *RecordGenerator) setFromLocal(host string, ns string) {
 			rg.insertRR(ns, ip.String(), rrsKindForIP(ip))
 		}
 	}
+
+	if len(rg.As.get(ns)) == 0 && len(rg.AAAAs.get(ns)) == 0 {
+		logging.Error.Printf("WARNING: no local address found for %q, falling back to 127.0.0.1; "+
+			"set the listener address in config.json", ns)
+		rg.Stats.event(EventSanitationFallback)
+		rg.insertRR(ns, "127.0.0.1", A)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to list local addresses: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ifaceAddrs holds the addresses of a network interface, or the error
+// encountered while listing them.
+type ifaceAddrs struct {
+	name  string
+	addrs []net.Addr
+	err   error
+}
+
+// localInterfaces lists the addresses of the local network interfaces.
+func localInterfaces() ([]ifaceAddrs, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]ifaceAddrs, 0, len(ifaces))
+	for _, i := range ifaces {
+		addrs, err := i.Addrs()
+		list = append(list, ifaceAddrs{i.Name, addrs, err})
+	}
+	return list, nil
 }
 
 // insertRR adds a record to the appropriate record map for the given name/host pair,
 // but only if the pair is unique. returns true if added, false otherwise.
 // TODO(???): REFACTOR when storage is updated
-func (rg *RecordGenerator) insertTaskRR(name, host string, kind rrsKind, enumTask *EnumerableTask) bool {
-	if rg.insertRR(name, host, kind) {
-		enumRecord := EnumerableRecord{Name: name, Host: host, Rtype: string(kind)}
-		enumTask.Records = append(enumTask.Records, enumRecord)
-		return true
-	}
-	return false
+func (rg *RecordGenerator) insertTaskRR(name, host string, kind rrsKind, src RecordSource, enumTask *EnumerableTask) bool {
+	return rg.insertWeightedTaskRR(name, host, kind, 0, 0, src, enumTask)
 }
 
-func (rg *RecordGenerator) insertRR(name, host string, kind rrsKind) (added bool) {
-	if rrsByKind := kind.rrs(rg); rrsByKind != nil {
-		if added = rrsByKind.add(name, host); added {
-			logging.VeryVerbose.Println("[" + string(kind) + "]\t" + name + ": " + host)
+// insertWeightedTaskRR inserts the given record like insertTaskRR, with the
+// given priority and weight if an SRV record, see storeSRVData.
+func (rg *RecordGenerator) insertWeightedTaskRR(name, host string, kind rrsKind, priority, weight uint16, src RecordSource, enumTask *EnumerableTask) bool {
+	if rg.capped() {
+		if enumTask.Skipped != SkipRecordCap {
+			enumTask.Skipped = SkipRecordCap
+			rg.Stats.cutOff(src.FrameworkName)
+		}
+		return false
+	}
+	name, host, kind, ok := rg.transformRecord(normalizeRecord(name, host, kind))
+	if !ok {
+		return false
+	}
+	if kind != PTR && kind != TXT {
+		// the PTR records of a slave address are generated by the tasks
+		// of every framework running on it, and TXT records share the
+		// names of A and AAAA ones, claimed already
+		rg.claim(name, kind, src)
+	}
+	added := rg.storeRR(name, host, kind)
+	if added && kind == SRV {
+		rg.storeSRVData(name, host, priority, weight)
+	}
+	if set := kind.rrs(rg).set(name); set != nil {
+		if _, stored := set.hosts[host]; stored {
+			rec := enumerableRecord(name, host, kind)
+			if d, ok := set.weight(host); ok {
+				rec.Priority, rec.Weight = d.Priority, d.Weight
+			}
+			if enumTask.addRecord(rec) {
+				rg.listings.add(name, enumTask)
+			}
 		}
 	}
+	return added
+}
+
+// storeSRVData stores the given priority and weight of the given SRV record,
+// just added, along with its target and port, unless both are 0.
+func (rg *RecordGenerator) storeSRVData(name, host string, priority, weight uint16) {
+	if priority == 0 && weight == 0 {
+		return
+	}
+	d, err := ParseSRV(host)
+	if err != nil {
+		return
+	}
+	d.Priority, d.Weight = priority, weight
+	set := rg.SRVs.set(name)
+	if set.srv == nil {
+		set.srv = map[string]SRVData{}
+	}
+	set.srv[host] = d
+}
+
+// capped tells whether the record cap was reached, flagging the generation
+// as capped if so.
+func (rg *RecordGenerator) capped() bool {
+	if rg.maxRecords <= 0 || rg.Stats.TotalRecords() < rg.maxRecords {
+		return false
+	}
+	rg.Stats.Capped = true
+	return true
+}
+
+// insertRR normalizes the given record, see normalizeRecord, and applies the
+// record transforms to it before adding it.
+func (rg *RecordGenerator) insertRR(name, host string, kind rrsKind) bool {
+	name, host, kind, ok := rg.transformRecord(normalizeRecord(name, host, kind))
+	return ok && rg.storeRR(name, host, kind)
+}
+
+// storeRR adds the given normalized record, unless its name or kind is
+// invalid.
+func (rg *RecordGenerator) storeRR(name, host string, kind rrsKind) (added bool) {
+	if err := validateRecordName(name); err != nil {
+		rg.rejectName(name, kind, err)
+		return false
+	}
+	if (kind == A || kind == AAAA) && net.ParseIP(host) == nil {
+		// e.g. a slave hostname standing in for its IP, see slaveRecords
+		rg.Stats.event(EventInvalidIP)
+	}
+	rrsByKind := kind.alloc(rg)
+	if rrsByKind == nil {
+		logging.Error.Printf("dropping %s record %q of unknown type %q", name, host, kind)
+		return false
+	}
+	if added = rrsByKind.Add(name, host); added {
+		rg.Stats.inserted(kind)
+		logging.VeryVerbose.Println("[" + string(kind) + "]\t" + name + ": " + host)
+	}
+	if _, stored := rrsByKind.get(name)[host]; stored && rg.pinned != nil && rg.Stats.source != SourceTask {
+		rg.pinned[recordKey{name, host, kind}] = struct{}{}
+	}
 	return
 }
 
+// rejectName accounts for a structurally invalid record name. In strict mode it
+// panics, otherwise the name is counted and logged once per generation.
+func (rg *RecordGenerator) rejectName(name string, kind rrsKind, err error) {
+	if rg.strictNames {
+		panic(fmt.Sprintf("invalid %s record name %q: %v", kind, name, err))
+	}
+	logging.CurLog.InvalidRecordNames.Inc()
+	rg.Stats.event(EventInvalidName)
+	if rg.invalidNames == nil {
+		rg.invalidNames = map[string]struct{}{}
+	}
+	if _, ok := rg.invalidNames[name]; !ok {
+		rg.invalidNames[name] = struct{}{}
+		logging.Error.Printf("rejecting invalid %s record name %q: %v", kind, name, err)
+	}
+}
+
 func rrsKindForIP(ip net.IP) rrsKind {
 	if ip.To4() != nil {
 		return A
@@ -567,41 +1919,35 @@ func rrsKindForIPStr(ip string) rrsKind {
 	panic("unable to parse ip: " + ip)
 }
 
-// ipsTo4And6 returns a list with at most 1 ipv4 and 1 ipv6
-// from a list of IPs
-func ipsTo4And6(allIPs []net.IP) (ips []net.IP) {
-	var ipv4, ipv6 net.IP
+// uniqueIPs returns the given IPs less duplicates, in order, with IPv4
+// addresses in their 4-byte form.
+func uniqueIPs(allIPs []net.IP) []net.IP {
+	ips := make([]net.IP, 0, len(allIPs))
+	seen := make(map[string]bool, len(allIPs))
 	for _, ip := range allIPs {
-		if ipv4 != nil && ipv6 != nil {
-			break
-		} else if t4 := ip.To4(); t4 != nil {
-			if ipv4 == nil {
-				ipv4 = t4
-			}
-		} else if t6 := ip.To16(); t6 != nil {
-			if ipv6 == nil {
-				ipv6 = t6
-			}
+		if t4 := ip.To4(); t4 != nil {
+			ip = t4
+		} else if ip = ip.To16(); ip == nil {
+			continue
+		}
+		if !seen[ip.String()] {
+			seen[ip.String()] = true
+			ips = append(ips, ip)
 		}
 	}
-	ips = []net.IP{}
-	if ipv4 != nil {
-		ips = append(ips, ipv4)
-	}
-	if ipv6 != nil {
-		ips = append(ips, ipv6)
-	}
-	return
+	return ips
 }
 
-// hostToIPs attempts to parse a hostname into an ip.
-// If that doesn't work it will perform a lookup and try to
-// find one ipv4 and one ipv6 in the results.
-func hostToIPs(hostname string) (ips []net.IP) {
+// hostToIPs attempts to parse a hostname, once normalized, into an ip.
+// If that doesn't work it will perform a lookup, through the Resolver of
+// WithResolver if any, and return all the ipv4 and ipv6 addresses found,
+// e.g. those of dual-homed or dual-stack slaves.
+func (rg *RecordGenerator) hostToIPs(ctx stdcontext.Context, hostname string) (ips []net.IP) {
+	hostname = normalizeHost(hostname)
 	if ip := net.ParseIP(hostname); ip != nil {
 		ips = []net.IP{ip}
-	} else if allIPs, err := net.LookupIP(hostname); err == nil {
-		ips = ipsTo4And6(allIPs)
+	} else if allIPs, err := rg.hosts.lookup(ctx, rg.resolver, hostname); err == nil {
+		ips = uniqueIPs(allIPs)
 	}
 	if len(ips) == 0 {
 		logging.VeryVerbose.Printf("cannot translate hostname %q into an ipv4 or ipv6 address", hostname)
@@ -609,6 +1955,34 @@ func hostToIPs(hostname string) (ips []net.IP) {
 	return
 }
 
+// label mangles the given name into a label with spec, accounting for names
+// that had to be truncated.
+func (rg *RecordGenerator) label(name string, spec labels.Func) string {
+	lab := spec(name)
+	if truncated(name, lab, spec) {
+		rg.Stats.event(EventTruncation)
+	}
+	return lab
+}
+
+// truncated tells whether spec had to cut name short to produce lab: lab is
+// as long as spec's labels get while name holds more valid label characters,
+// not counting the hyphens trimmed from its ends.
+func truncated(name, lab string, spec labels.Func) bool {
+	max := len(spec(strings.Repeat("a", 256)))
+	if len(lab) < max {
+		return false
+	}
+	valid := 0
+	for _, r := range strings.Trim(name, "-_.") {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			valid++
+		}
+	}
+	return valid > max
+}
+
 // return the slave number from a Mesos slave id
 func slaveIDTail(slaveID string) string {
 	fields := strings.Split(slaveID, "-")
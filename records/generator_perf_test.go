@@ -2,8 +2,10 @@ package records
 
 import (
 	"math/rand"
+	"net"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/mesosphere/mesos-dns/records/labels"
 	"github.com/mesosphere/mesos-dns/records/state"
@@ -90,3 +92,34 @@ func BenchmarkTaskRecord_withoutDiscoveryInfo(b *testing.B) {
 		tt.rg.taskRecord(tt.task, tt.f, tt.domain, tt.spec, tt.ipSources, &tt.enumFW)
 	}
 }
+
+// BenchmarkSlaveRecords_lookups generates the records of 1000 slaves whose
+// hostnames take a millisecond each to look up, one at a time and at the
+// default concurrency.
+func BenchmarkSlaveRecords_lookups(b *testing.B) {
+	const clusterSize = 1000
+	var sj state.State
+	for i := 0; i < clusterSize; i++ {
+		sj.Slaves = append(sj.Slaves, slave("ID-"+strconv.Itoa(i), "agent-"+strconv.Itoa(i)))
+	}
+	resolver := fakeResolver(func(host string) ([]net.IP, error) {
+		time.Sleep(time.Millisecond)
+		return []net.IP{net.IPv4(10, 0, byte(len(host)), 1)}, nil
+	})
+	for _, bb := range []struct {
+		name        string
+		concurrency int
+	}{
+		{"serial", 1},
+		{"parallel", 0},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rg := RecordGenerator{resolver: resolver, hostLookupConcurrency: bb.concurrency}
+				if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
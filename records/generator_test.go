@@ -1,7 +1,12 @@
 package records
 
 import (
+	"bytes"
+	stdcontext "context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -9,15 +14,33 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/models"
 	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/naming"
 	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/mesosphere/mesos-dns/records/state/upid"
+	"github.com/miekg/dns"
+	"github.com/tv42/zbase32"
 )
 
+// update rewrites the golden files of the tests comparing against them.
+var update = flag.Bool("update", false, "update the golden files")
+
 func init() {
 	logging.VerboseFlag = false
 	logging.VeryVerboseFlag = false
@@ -25,12 +48,8 @@ func init() {
 }
 
 func (rg *RecordGenerator) exists(name, host string, kind rrsKind) bool {
-	rrsByKind := kind.rrs(rg)
-	if val, ok := rrsByKind[name]; ok {
-		_, ok := val[host]
-		return ok
-	}
-	return false
+	_, ok := kind.rrs(rg).get(name)[host]
+	return ok
 }
 
 func TestParseState_SOAMname(t *testing.T) {
@@ -53,6 +72,88 @@ func TestParseState_SOAMname(t *testing.T) {
 	}
 }
 
+func TestParseState_Idempotent(t *testing.T) {
+	sj := loadState(t, "../factories/fake.json")
+	rg := NewRecordGenerator()
+	rg.stateLoader = func(_ []string) (state.State, error) { return sj, nil }
+	cfg := Config{Domain: "mesos", SOAMname: "ns1.mesos.", Listener: "127.0.0.1", IPSources: []string{"host"}}
+
+	if err := rg.ParseState(cfg); err != nil {
+		t.Fatal(err)
+	}
+	first, err := json.Marshal(rg.EnumData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frameworks, as := len(rg.EnumData.Frameworks), rrsSets(rg.As)
+	if frameworks == 0 {
+		t.Fatal("expected enumerated frameworks")
+	}
+
+	if err := rg.ParseState(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(rg.EnumData.Frameworks); got != frameworks {
+		t.Errorf("got %d enumerated frameworks after second parse, want %d", got, frameworks)
+	}
+	if second, err := json.Marshal(rg.EnumData); err != nil {
+		t.Fatal(err)
+	} else if string(second) != string(first) {
+		t.Errorf("enumeration changed after second parse:\n%s\n%s", first, second)
+	}
+	if !reflect.DeepEqual(rrsSets(rg.As), as) {
+		t.Error("A records changed after second parse")
+	}
+}
+
+func TestParseState_UnknownLeader(t *testing.T) {
+	sj := loadState(t, "testdata/leaderless.json")
+	rg := NewRecordGenerator()
+	rg.stateLoader = func(_ []string) (state.State, error) { return sj, nil }
+	cfg := Config{Domain: "mesos", SOAMname: "ns1.mesos.", Listener: "127.0.0.1", IPSources: []string{"host"}}
+	masters := []string{"10.0.0.1:5050", "10.0.0.3:5050"}
+
+	if err := rg.ParseState(cfg, masters...); err == nil {
+		t.Fatal("expected an error for a state without leader")
+	}
+	if rg.Failure == nil || rg.Failure.Class != ErrorClassLeader {
+		t.Errorf("got failure %+v, want class %q", rg.Failure, ErrorClassLeader)
+	}
+
+	cfg.AllowUnknownLeader = true
+	if err := rg.ParseState(cfg, masters...); err != nil {
+		t.Fatal(err)
+	}
+	if rg.Failure != nil {
+		t.Errorf("unexpected failure %+v", rg.Failure)
+	}
+	if got := fmt.Sprint(logging.CurLog.LeaderUnknown); got != "1" {
+		t.Errorf("got LeaderUnknown gauge %s, want 1", got)
+	}
+	for _, name := range []string{"leader.mesos.", "_leader._tcp.mesos.", "_leader._udp.mesos.", "master2.mesos."} {
+		if rg.As.get(name) != nil {
+			t.Errorf("unexpected A record %q", name)
+		}
+		if rg.SRVs.get(name) != nil {
+			t.Errorf("unexpected SRV record %q", name)
+		}
+	}
+	for _, rr := range []expectedRR{
+		{"master.mesos.", "10.0.0.1", A},
+		{"master.mesos.", "10.0.0.3", A},
+		{"master0.mesos.", "10.0.0.1", A},
+		{"master1.mesos.", "10.0.0.3", A},
+		{"marathon.mesos.", "10.0.0.2", A},
+		{"slave.mesos.", "10.0.1.1", A},
+		{"web.marathon.mesos.", "10.0.1.1", A},
+		{"_web._tcp.marathon.mesos.", "web-" + hashString(sj.Frameworks[0].Tasks[0].ID) + "-s1.marathon.slave.mesos.:31000", SRV},
+	} {
+		if !rg.exists(rr.name, rr.host, rr.kind) {
+			t.Errorf("missing %s record %q -> %q", rr.kind, rr.name, rr.host)
+		}
+	}
+}
+
 type expectedRR struct {
 	name string
 	host string
@@ -154,6 +255,47 @@ func TestMasterRecord(t *testing.T) {
 				{"_leader._tcp.foo.com.", "leader.foo.com.:7", SRV},
 				{"_leader._udp.foo.com.", "leader.foo.com.:7", SRV},
 			}},
+		// masters and leader compared once normalized
+		{"foo.com", []string{" 0.0.0.8.:9", "0.0.0.6.:7 ", "0.0.0.8:9"}, "5@0.0.0.6:7",
+			[]expectedRR{
+				{"leader.foo.com.", "0.0.0.6", A},
+				{"master.foo.com.", "0.0.0.6", A},
+				{"master.foo.com.", "0.0.0.8", A},
+				{"master0.foo.com.", "0.0.0.8", A},
+				{"master1.foo.com.", "0.0.0.6", A},
+				{"_leader._tcp.foo.com.", "leader.foo.com.:7", SRV},
+				{"_leader._udp.foo.com.", "leader.foo.com.:7", SRV},
+			}},
+		{"foo.com", []string{"[2001:DB8::1]:7"}, "5@[2001:db8:0::1]:7",
+			[]expectedRR{
+				{"leader.foo.com.", "2001:db8::1", AAAA},
+				{"master.foo.com.", "2001:db8::1", AAAA},
+				{"master0.foo.com.", "2001:db8::1", AAAA},
+				{"_leader._tcp.foo.com.", "leader.foo.com.:7", SRV},
+				{"_leader._udp.foo.com.", "leader.foo.com.:7", SRV},
+			}},
+		// bare IPv6 addresses, whose port follows the last colon
+		{"foo.com", []string{"2001:db8::1:7", "2001:db8::2:7"}, "5@2001:db8::1:7",
+			[]expectedRR{
+				{"leader.foo.com.", "2001:db8::1", AAAA},
+				{"master.foo.com.", "2001:db8::1", AAAA},
+				{"master.foo.com.", "2001:db8::2", AAAA},
+				{"master0.foo.com.", "2001:db8::1", AAAA},
+				{"master1.foo.com.", "2001:db8::2", AAAA},
+				{"_leader._tcp.foo.com.", "leader.foo.com.:7", SRV},
+				{"_leader._udp.foo.com.", "leader.foo.com.:7", SRV},
+			}},
+		{"foo.com", []string{"[2001:db8::1]:7"}, "5@2001:db8::1:7",
+			[]expectedRR{
+				{"leader.foo.com.", "2001:db8::1", AAAA},
+				{"master.foo.com.", "2001:db8::1", AAAA},
+				{"master0.foo.com.", "2001:db8::1", AAAA},
+				{"_leader._tcp.foo.com.", "leader.foo.com.:7", SRV},
+				{"_leader._udp.foo.com.", "leader.foo.com.:7", SRV},
+			}},
+		// leaders must advertise an IP address
+		{"foo.com", nil, "5@master1.example.com:7", nil},
+		{"foo.com", nil, "5@[master1.example.com]:7", nil},
 		{"foo.com", []string{"0.0.0.8:9", "0.0.0.6:7", "[2001:db8::1]:0"}, "5@0.0.0.6:7",
 			[]expectedRR{
 				{"leader.foo.com.", "0.0.0.6", A},
@@ -169,19 +311,19 @@ func TestMasterRecord(t *testing.T) {
 	}
 	for i, tc := range tt {
 		rg := &RecordGenerator{}
-		rg.As = make(rrs)
-		rg.AAAAs = make(rrs)
-		rg.SRVs = make(rrs)
+		rg.As = rrs{}
+		rg.AAAAs = rrs{}
+		rg.SRVs = rrs{}
 		t.Logf("test case %d", i+1)
 		rg.masterRecord(tc.domain, tc.masters, tc.leader)
 		if tc.expect == nil {
-			if len(rg.As) > 0 {
+			if rg.As.Len() > 0 {
 				t.Fatalf("test case %d: unexpected As: %v", i+1, rg.As)
 			}
-			if len(rg.AAAAs) > 0 {
+			if rg.AAAAs.Len() > 0 {
 				t.Fatalf("test case %d: unexpected AAAAs: %v", i+1, rg.AAAAs)
 			}
-			if len(rg.SRVs) > 0 {
+			if rg.SRVs.Len() > 0 {
 				t.Fatalf("test case %d: unexpected SRVs: %v", i+1, rg.SRVs)
 			}
 		}
@@ -189,22 +331,22 @@ func TestMasterRecord(t *testing.T) {
 		if err != nil {
 			t.Fatalf("test case %d: %s, As=%v, AAAAs=%v, SRVs=%v", i+1, err, rg.As, rg.AAAAs, rg.SRVs)
 		}
-		if !reflect.DeepEqual(rg.As, eA) {
+		if !reflect.DeepEqual(rrsSets(rg.As), rrsSets(eA)) {
 			t.Fatalf("test case %d: expected As of %v instead of %v", i+1, eA, rg.As)
 		}
-		if !reflect.DeepEqual(rg.AAAAs, eAAAA) {
+		if !reflect.DeepEqual(rrsSets(rg.AAAAs), rrsSets(eAAAA)) {
 			t.Fatalf("test case %d: expected AAAAs of %v instead of %v", i+1, eAAAA, rg.AAAAs)
 		}
-		if !reflect.DeepEqual(rg.SRVs, eSRV) {
+		if !reflect.DeepEqual(rrsSets(rg.SRVs), rrsSets(eSRV)) {
 			t.Fatalf("test case %d: expected SRVs of %v instead of %v", i+1, eSRV, rg.SRVs)
 		}
 	}
 }
 
 func expectRecords(rg *RecordGenerator, expect []expectedRR) (eA, eAAAA, eSRV rrs, err error) {
-	eA = make(rrs)
-	eAAAA = make(rrs)
-	eSRV = make(rrs)
+	eA = rrs{}
+	eAAAA = rrs{}
+	eSRV = rrs{}
 	for _, e := range expect {
 		found := rg.exists(e.name, e.host, e.kind)
 		if !found {
@@ -213,11 +355,11 @@ func expectRecords(rg *RecordGenerator, expect []expectedRR) (eA, eAAAA, eSRV rr
 		}
 		switch e.kind {
 		case A:
-			eA.add(e.name, e.host)
+			eA.Add(e.name, e.host)
 		case AAAA:
-			eAAAA.add(e.name, e.host)
+			eAAAA.Add(e.name, e.host)
 		case SRV:
-			eSRV.add(e.name, e.host)
+			eSRV.Add(e.name, e.host)
 		default:
 			err = fmt.Errorf("unexpected kind: %q", e.kind)
 			return
@@ -226,15 +368,58 @@ func expectRecords(rg *RecordGenerator, expect []expectedRR) (eA, eAAAA, eSRV rr
 	return
 }
 
-func testRecordGenerator(t *testing.T, spec labels.Func, ipSources []string) RecordGenerator {
-	var sj state.State
+// hostSet returns the given hosts of a record name as a set, disregarding
+// their insertion order.
+func hostSet(hosts map[string]int) map[string]struct{} {
+	set := make(map[string]struct{}, len(hosts))
+	for host := range hosts {
+		set[host] = struct{}{}
+	}
+	return set
+}
+
+// rrsSets returns the given records as sets of hosts per name.
+func rrsSets(r rrs) map[string]map[string]struct{} {
+	sets := make(map[string]map[string]struct{}, r.Len())
+	r.each(func(name string, set *rrset) {
+		sets[name] = hostSet(set.hosts)
+	})
+	return sets
+}
+
+// rrsOf returns records holding the given hosts, by name, with their ranks.
+func rrsOf(names map[string]map[string]int) rrs {
+	r := newRRs()
+	for name, hosts := range names {
+		for host := range hosts {
+			r.Add(name, host)
+		}
+		r.set(name).hosts = hosts
+	}
+	return r
+}
+
+// rrsRanks returns the hosts of the given records, by name, with their ranks.
+func rrsRanks(r rrs) map[string]map[string]int {
+	ranks := make(map[string]map[string]int, r.Len())
+	r.each(func(name string, set *rrset) {
+		ranks[name] = set.hosts
+	})
+	return ranks
+}
 
-	b, err := ioutil.ReadFile("../factories/fake.json")
+func loadState(t testing.TB, file string) (sj state.State) {
+	b, err := ioutil.ReadFile(file)
 	if err != nil {
 		t.Fatal(err)
 	} else if err = json.Unmarshal(b, &sj); err != nil {
 		t.Fatal(err)
 	}
+	return sj
+}
+
+func testRecordGenerator(t *testing.T, spec labels.Func, ipSources []string) RecordGenerator {
+	sj := loadState(t, "../factories/fake.json")
 
 	sj.Leader = "master@144.76.157.37:5050"
 	masters := []string{"144.76.157.37:5050"}
@@ -346,7 +531,7 @@ func TestInsertState(t *testing.T) {
 		for _, x := range tt.want {
 			want[x] = struct{}{}
 		}
-		if got := tt.rrs[tt.name]; !reflect.DeepEqual(got, want) {
+		if got := hostSet(tt.rrs.get(tt.name)); !reflect.DeepEqual(got, want) {
 			if len(got) == 0 && len(want) == 0 {
 				continue
 			}
@@ -355,22 +540,106 @@ func TestInsertState(t *testing.T) {
 	}
 }
 
+func TestRRsOrder(t *testing.T) {
+	hosts := []string{"10.0.0.9", "10.0.0.1", "10.0.0.5", "10.0.0.3", "10.0.0.7", "10.0.0.2"}
+	names := []string{"b.mesos.", "c.mesos.", "a.mesos."}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	for _, procs := range []int{1, 2, 4, 8} {
+		runtime.GOMAXPROCS(procs)
+		for run := 0; run < 50; run++ {
+			r := rrs{}
+			for _, name := range names {
+				for _, host := range hosts {
+					r.Add(name, host)
+				}
+				r.Add(name, hosts[0]) // duplicates keep their rank
+			}
+			for _, name := range names {
+				if first, ok := r.First(name); !ok || first != hosts[0] {
+					t.Fatalf("GOMAXPROCS=%d: got first %q (%v), want %q", procs, first, ok, hosts[0])
+				}
+				if got := r.Hosts(name); !reflect.DeepEqual(got, hosts) {
+					t.Fatalf("GOMAXPROCS=%d: got hosts %v, want %v", procs, got, hosts)
+				}
+			}
+			if got, want := r.Names(), []string{"a.mesos.", "b.mesos.", "c.mesos."}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("GOMAXPROCS=%d: got names %v, want %v", procs, got, want)
+			}
+			want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.5", "10.0.0.7", "10.0.0.9"}
+			if got := r.ToAXFRResourceRecordSet()["c.mesos."]; !reflect.DeepEqual(got, want) {
+				t.Fatalf("GOMAXPROCS=%d: got AXFR hosts %v, want %v", procs, got, want)
+			}
+		}
+	}
+
+	var r rrs
+	if first, ok := r.First("a.mesos."); ok || first != "" {
+		t.Errorf("got first %q (%v) of a missing name", first, ok)
+	}
+	if got := r.Hosts("a.mesos."); len(got) != 0 {
+		t.Errorf("got hosts %v of a missing name", got)
+	}
+}
+
 // ensure we only generate one A record for each host
 func TestNTasks(t *testing.T) {
 	rg := &RecordGenerator{}
-	rg.As = make(rrs)
+	rg.As = rrs{}
 
 	rg.insertRR("blah.mesos", "10.0.0.1", A)
 	rg.insertRR("blah.mesos", "10.0.0.1", A)
 	rg.insertRR("blah.mesos", "10.0.0.2", A)
 
-	k := rg.As["blah.mesos"]
+	k := rg.As.get("blah.mesos.")
 
 	if len(k) != 2 {
 		t.Error("should only have 2 A records")
 	}
 }
 
+func TestInsertRR_Normalization(t *testing.T) {
+	rg := &RecordGenerator{As: rrs{}, AAAAs: rrs{}, SRVs: rrs{}}
+
+	for _, rr := range []expectedRR{
+		{"web.marathon.mesos.", "10.0.0.1", A},
+		{"web.marathon.mesos", "10.0.0.1", A},
+		{"Web.Marathon.Mesos.", "10.0.0.1", A},
+		{"WEB.marathon.mesos", "::ffff:10.0.0.1", A},
+		{"web.marathon.mesos.", "::ffff:10.0.0.1", AAAA},
+		{"web.marathon.mesos.", "2001:db8::1", AAAA},
+		{"web.marathon.mesos", "2001:DB8:0:0::1", AAAA},
+		{"_web._tcp.marathon.mesos.", "web-s1.marathon.slave.mesos.:31000", SRV},
+		{"_web._tcp.marathon.mesos", "web-s1.marathon.slave.mesos:31000", SRV},
+		{"_Web._TCP.marathon.mesos.", "Web-S1.Marathon.Slave.Mesos.:31000", SRV},
+		{"_web._tcp.marathon.mesos.", "10.0.0.1:31001", SRV},
+		{"_web._tcp.marathon.mesos.", "[::ffff:10.0.0.1]:31001", SRV},
+	} {
+		rg.insertRR(rr.name, rr.host, rr.kind)
+	}
+
+	for _, tt := range []struct {
+		rrs  rrs
+		name string
+		want []string
+	}{
+		{rg.As, "web.marathon.mesos.", []string{"10.0.0.1"}},
+		{rg.AAAAs, "web.marathon.mesos.", []string{"2001:db8::1"}},
+		{rg.SRVs, "_web._tcp.marathon.mesos.", []string{"web-s1.marathon.slave.mesos.:31000", "10.0.0.1:31001"}},
+	} {
+		if got := tt.rrs.Hosts(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	for _, rrs := range []rrs{rg.As, rg.AAAAs, rg.SRVs} {
+		if rrs.Len() != 1 {
+			t.Errorf("expected a single normalized name, got %v", rrs.Names())
+		}
+	}
+	if got, want := rg.Stats.TotalRecords(), 4; got != want {
+		t.Errorf("got %d records inserted, want %d", got, want)
+	}
+}
+
 func TestHashString(t *testing.T) {
 	val := hashString("test")
 	if len(val) != 5 {
@@ -427,3 +696,3485 @@ func TestTimeout(t *testing.T) {
 		t.Errorf("Did not receive a timeout, instead: %#v", err)
 	}
 }
+
+func slave(id, ip string) state.Slave {
+	return state.Slave{ID: id, PID: state.PID{UPID: &upid.UPID{ID: "slave(1)", Host: ip, Port: "5051"}}}
+}
+
+func runningTask(id, name, slaveID string) state.Task {
+	return state.Task{ID: id, Name: name, SlaveID: slaveID, State: "TASK_RUNNING"}
+}
+
+func discoveryTask(id, name, slaveID string) state.Task {
+	t := runningTask(id, name, slaveID)
+	t.DiscoveryInfo.Name = name
+	return t
+}
+
+func TestInsertState_InvalidNames(t *testing.T) {
+	longLabel := strings.Repeat("x", 64)
+	longName := strings.Repeat("abcdefgh.", 30) + "y"
+	sj := state.State{
+		Leader: "master@1.2.3.4:5050",
+		Slaves: []state.Slave{slave("s-1", "1.2.3.10")},
+		Frameworks: []state.Framework{{
+			Name: "marathon",
+			Tasks: []state.Task{
+				discoveryTask("t1", "a..b", "s-1"),
+				discoveryTask("t2", longLabel, "s-1"),
+				discoveryTask("t3", longName, "s-1"),
+			},
+		}},
+	}
+
+	counter := logging.CurLog.InvalidRecordNames.(*logging.LogCounter)
+	before := counter.String()
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	for _, rrs := range []rrs{rg.As, rg.AAAAs, rg.SRVs} {
+		for _, name := range rrs.Names() {
+			if err := validateRecordName(name); err != nil {
+				t.Errorf("invalid record name %q published: %v", name, err)
+			}
+		}
+	}
+	for _, name := range []string{"a--b.marathon.mesos.", longLabel[:63] + ".marathon.mesos."} {
+		if rg.As.get(name) == nil {
+			t.Errorf("missing sanitized A record %q", name)
+		}
+	}
+	if counter.String() == before {
+		t.Error("expected invalid record names to be counted")
+	}
+	for _, name := range []string{"a..b.marathon.mesos.", longLabel + ".marathon.mesos."} {
+		if _, ok := rg.invalidNames[name]; !ok {
+			t.Errorf("expected %q to be reported as invalid", name)
+		}
+	}
+}
+
+func TestInsertRR_StrictNames(t *testing.T) {
+	rg := &RecordGenerator{As: rrs{}, strictNames: true}
+	if !rg.insertRR("a.mesos.", "1.2.3.4", A) {
+		t.Fatal("expected valid name to be inserted")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected strict mode to panic on an invalid name")
+		}
+	}()
+	rg.insertRR("a..b.mesos.", "1.2.3.4", A)
+}
+
+func TestInsertState_Collisions(t *testing.T) {
+	sj := loadState(t, "../factories/collisions.json")
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	// records keep merging
+	want := map[string]struct{}{"10.0.1.1": {}, "10.0.1.2": {}}
+	if got := hostSet(rg.As.get("web.marathon.mesos.")); !reflect.DeepEqual(got, want) {
+		t.Errorf("got merged A records %v, want %v", got, want)
+	}
+
+	first := RecordSource{FrameworkID: "20160107-001256-134875658-5050-27524-0000", FrameworkName: "marathon"}
+	second := RecordSource{FrameworkID: "20160107-001256-134875658-5050-27524-0001", FrameworkName: "Marathon"}
+	collisions := map[claimKey]Collision{}
+	for _, c := range rg.EnumData.Collisions {
+		k := claimKey{c.Name, rrsKind(c.Rtype)}
+		if _, dup := collisions[k]; dup {
+			t.Errorf("collision reported twice: %+v", c)
+		}
+		if c.First.framework() != first || c.Second.framework() != second {
+			t.Errorf("unexpected collision sources: %+v", c)
+		}
+		collisions[k] = c
+	}
+	for _, k := range []claimKey{
+		{"marathon.mesos.", A},
+		{"_framework._tcp.marathon.mesos.", SRV},
+		{"web.marathon.mesos.", A},
+		{"web.marathon.slave.mesos.", A},
+		{"_web._tcp.marathon.mesos.", SRV},
+		{"_web._udp.marathon.slave.mesos.", SRV},
+	} {
+		if _, ok := collisions[k]; !ok {
+			t.Errorf("missing collision for %s %q", k.kind, k.name)
+		}
+	}
+	if c := collisions[claimKey{"web.marathon.mesos.", A}]; c.First.TaskID == "" || c.Second.TaskID == "" {
+		t.Errorf("expected task IDs in task record collision sources: %+v", c)
+	}
+	for k := range collisions {
+		if strings.Contains(k.name, "chronos") {
+			t.Errorf("unexpected collision for %q", k.name)
+		}
+	}
+}
+
+func TestInsertState_Stats(t *testing.T) {
+	sj := loadState(t, "testdata/mixed.json")
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	st := rg.Stats
+	if st.Frameworks != 2 {
+		t.Errorf("got %d frameworks, want 2", st.Frameworks)
+	}
+	if st.Tasks != 7 {
+		t.Errorf("got %d tasks, want 7", st.Tasks)
+	}
+	wantSkipped := map[SkipReason]int{
+		SkipNotRunning:     2,
+		SkipMissingSlaveIP: 1,
+		SkipMalformed:      1,
+	}
+	if !reflect.DeepEqual(st.Skipped, wantSkipped) {
+		t.Errorf("got skipped %v, want %v", st.Skipped, wantSkipped)
+	}
+	// the chronos framework has neither a pid nor a hostname
+	if st.FrameworksWithoutHost != 1 {
+		t.Errorf("got %d frameworks without host, want 1", st.FrameworksWithoutHost)
+	}
+	if st.ResolutionFailures != 0 {
+		t.Errorf("got %d resolution failures, want 0", st.ResolutionFailures)
+	}
+	for kind, rrs := range map[rrsKind]rrs{A: rg.As, AAAA: rg.AAAAs, SRV: rg.SRVs} {
+		n := 0
+		for _, name := range rrs.Names() {
+			hosts := rrs.get(name)
+			n += len(hosts)
+		}
+		if got := st.Records[string(kind)]; got != n {
+			t.Errorf("got %d %s records, want %d", got, kind, n)
+		}
+	}
+	for _, pass := range []string{passFrameworks, passSlaves, passListener, passMasters, passTasks} {
+		if _, ok := st.Durations[pass]; !ok {
+			t.Errorf("missing duration of pass %q", pass)
+		}
+	}
+	if st.Duration <= 0 {
+		t.Errorf("got non-positive duration %v", st.Duration)
+	}
+}
+
+func TestInsertState_MissingSlaveIP(t *testing.T) {
+	sj := loadState(t, "testdata/missing_slave.json")
+	ipSources := []string{"netinfo", "host"}
+
+	for _, fallback := range []bool{false, true} {
+		rg := RecordGenerator{missingSlaveFallback: fallback}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, ipSources, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+
+		if rg.As.get("web.marathon.mesos.") == nil {
+			t.Errorf("fallback=%v: missing records of task with known slave", fallback)
+		}
+		for _, name := range rg.As.Names() {
+			if strings.HasPrefix(name, "host") {
+				t.Errorf("fallback=%v: unexpected record %q of task without IPs", fallback, name)
+			}
+		}
+
+		published := rg.As.get("overlay.marathon.mesos.") != nil
+		if published != fallback {
+			t.Errorf("fallback=%v: got overlay.marathon.mesos. published=%v", fallback, published)
+		}
+		for _, rrs := range []rrs{rg.As, rg.SRVs} {
+			for _, name := range rrs.Names() {
+				if strings.Contains(name, "overlay") && (strings.Contains(name, "slave") || strings.HasPrefix(name, "_")) {
+					t.Errorf("fallback=%v: unexpected record %q of task without slave IP", fallback, name)
+				}
+			}
+		}
+
+		wantSkipped := 2
+		if fallback {
+			wantSkipped = 1
+		}
+		if got := rg.Stats.Skipped[SkipMissingSlaveIP]; got != wantSkipped {
+			t.Errorf("fallback=%v: got %d tasks skipped, want %d", fallback, got, wantSkipped)
+		}
+
+		skipped := map[string]int{}
+		for _, task := range rg.EnumData.Frameworks[0].Tasks {
+			if task.Skipped == SkipMissingSlaveIP {
+				skipped[task.Name] = len(task.Records)
+			}
+		}
+		if n, ok := skipped["host"]; !ok || n != 0 {
+			t.Errorf("fallback=%v: expected task host to be enumerated as skipped without records, got %v", fallback, skipped)
+		}
+		if n, ok := skipped["overlay"]; !ok || (n > 0) != fallback {
+			t.Errorf("fallback=%v: unexpected enumeration of task overlay: %v", fallback, skipped)
+		}
+	}
+}
+
+func TestInsertState_OrphanTasks(t *testing.T) {
+	orphaned := loadState(t, "testdata/orphans.json")
+	reregistered := loadState(t, "testdata/orphans_reregistered.json")
+
+	generate := func(sj state.State, orphans bool) *RecordGenerator {
+		rg := &RecordGenerator{orphanTasks: orphans}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		return rg
+	}
+	enumerated := func(rg *RecordGenerator) map[string][]*EnumerableTask {
+		fws := map[string][]*EnumerableTask{}
+		for _, f := range rg.EnumData.Frameworks {
+			fws[f.Name] = append(fws[f.Name], f.Tasks...)
+		}
+		return fws
+	}
+
+	// disabled
+	rg := generate(orphaned, false)
+	if rg.As.get("web.orphans.mesos.") != nil {
+		t.Error("unexpected orphan task records")
+	}
+
+	// framework failover
+	rg = generate(orphaned, true)
+	if rg.As.get("web.orphans.mesos.") == nil {
+		t.Errorf("missing orphan task A record, As=%v", rg.As)
+	}
+	if rg.SRVs.get("_web._tcp.orphans.mesos.") == nil {
+		t.Errorf("missing orphan task SRV record, SRVs=%v", rg.SRVs)
+	}
+	if tasks := enumerated(rg)[orphansFramework]; len(tasks) != 1 || !tasks[0].Orphan {
+		t.Errorf("expected a single enumerated orphan task, got %+v", tasks)
+	}
+
+	// framework re-registered, master still listing the task as orphan
+	rg = generate(reregistered, true)
+	if rg.As.get("web.marathon.mesos.") == nil {
+		t.Errorf("missing task A record, As=%v", rg.As)
+	}
+	for _, rrs := range []rrs{rg.As, rg.SRVs} {
+		for _, name := range rrs.Names() {
+			if strings.Contains(name, orphansFramework) {
+				t.Errorf("unexpected orphan record %q", name)
+			}
+		}
+	}
+	fws := enumerated(rg)
+	if _, ok := fws[orphansFramework]; ok {
+		t.Error("unexpected enumerated orphans framework")
+	}
+	if tasks := fws["marathon"]; len(tasks) != 1 || tasks[0].Orphan {
+		t.Errorf("expected a single enumerated marathon task, got %+v", tasks)
+	}
+	if rg.Stats.Tasks != 1 {
+		t.Errorf("got %d tasks processed, want 1", rg.Stats.Tasks)
+	}
+}
+
+func TestInsertState_DisambiguateFrameworks(t *testing.T) {
+	sj := loadState(t, "testdata/spark.json")
+	const (
+		sparkID = "20160107-001256-134875658-5050-27524-0001"
+		SparkID = "20160107-001256-134875658-5050-27524-0002"
+	)
+	suffixed := "spark-" + hashString(SparkID)
+
+	generate := func(disambiguate bool) *RecordGenerator {
+		rg := &RecordGenerator{disambiguateFrameworks: disambiguate}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		return rg
+	}
+	fragments := func(rg *RecordGenerator) map[string]string {
+		frags := map[string]string{}
+		for _, f := range rg.EnumData.Frameworks {
+			frags[f.Name] = f.Fragment
+		}
+		return frags
+	}
+
+	// disabled: namespaces are shared
+	rg := generate(false)
+	want := map[string]struct{}{"10.0.1.1": {}, "10.0.1.2": {}}
+	if got := hostSet(rg.As.get("driver.spark.slave.mesos.")); !reflect.DeepEqual(got, want) {
+		t.Errorf("got merged A records %v, want %v", got, want)
+	}
+	if len(rg.EnumData.Collisions) == 0 {
+		t.Error("expected collisions to be reported")
+	}
+	if got, want := fragments(rg), map[string]string{"spark": "spark", "Spark": "spark"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got fragments %v, want %v", got, want)
+	}
+
+	// enabled: the framework with the lowest ID keeps the fragment
+	rg = generate(true)
+	for name, want := range map[string]string{
+		"spark.mesos.":                         "10.0.0.2",
+		suffixed + ".mesos.":                   "10.0.0.3",
+		"driver.spark.slave.mesos.":            "10.0.1.1",
+		"driver." + suffixed + ".slave.mesos.": "10.0.1.2",
+	} {
+		if got := rg.As.Hosts(name); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("got A records %v for %q, want %v", got, name, want)
+		}
+	}
+	if rg.SRVs.get("_driver._tcp."+suffixed+".mesos.") == nil {
+		t.Errorf("missing disambiguated SRV record, SRVs=%v", rg.SRVs)
+	}
+	if len(rg.EnumData.Collisions) != 0 {
+		t.Errorf("unexpected collisions: %+v", rg.EnumData.Collisions)
+	}
+	if got, want := fragments(rg), map[string]string{"spark": "spark", "Spark": suffixed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got fragments %v, want %v", got, want)
+	}
+}
+
+func TestInsertState_FrameworkIDRecords(t *testing.T) {
+	sj := loadState(t, "testdata/failover.json")
+	var (
+		old = "marathon-" + hashString("20160302-101452-16842762-5050-1187-0001")
+		cur = "marathon-" + hashString("20160302-101452-16842762-5050-1187-0002")
+	)
+	for i, tt := range []struct {
+		ids, latest bool
+		want        map[string][]string
+	}{
+		{false, false, map[string][]string{
+			"marathon.mesos.": {"10.0.0.2", "10.0.0.3"},
+			old + ".mesos.":   nil,
+		}},
+		{true, false, map[string][]string{
+			"marathon.mesos.":        {"10.0.0.2", "10.0.0.3"},
+			old + ".mesos.":          {"10.0.0.2"},
+			cur + ".mesos.":          {"10.0.0.3"},
+			"web.marathon.mesos.":    {"10.0.1.1"},
+			"web." + old + ".mesos.": nil, // task records aren't qualified
+		}},
+		{true, true, map[string][]string{
+			"marathon.mesos.": {"10.0.0.3"},
+			old + ".mesos.":   {"10.0.0.2"},
+			cur + ".mesos.":   {"10.0.0.3"},
+		}},
+		{false, true, map[string][]string{
+			"marathon.mesos.": {"10.0.0.3"},
+			cur + ".mesos.":   nil,
+		}},
+	} {
+		rg := &RecordGenerator{frameworkIDRecords: tt.ids, latestFrameworks: tt.latest}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", []string{"10.0.0.1:5050"}, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range tt.want {
+			got := rg.As.Hosts(name)
+			sort.Strings(got)
+			if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+				t.Errorf("test #%d: got A records %v for %q, want %v", i, got, name, want)
+			}
+		}
+		if tt.ids {
+			name := "_framework._tcp." + old + ".mesos."
+			if got, want := rg.SRVs.Hosts(name), []string{old + ".mesos.:8080"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("test #%d: got SRV records %v for %q, want %v", i, got, name, want)
+			}
+		}
+	}
+}
+
+func TestInsertState_FrameworkDomains(t *testing.T) {
+	scheduler := func(ip string) state.PID {
+		return state.PID{UPID: &upid.UPID{ID: "scheduler(1)", Host: ip, Port: "8080"}}
+	}
+	sj := state.State{
+		Frameworks: []state.Framework{
+			{ID: "fw-1", Name: "marathon", PID: scheduler("10.0.0.2"), Tasks: []state.Task{runningTask("web.1", "web", "s1")}},
+			{ID: "fw-2", Name: "chronos", PID: scheduler("10.0.0.3"), Tasks: []state.Task{discoveryTask("job.1", "job", "s1")}},
+		},
+		Slaves: []state.Slave{slave("s1", "10.0.1.1")},
+	}
+	rg := RecordGenerator{frameworkDomains: []FrameworkDomain{
+		{FrameworkRegexp: "^spark", Domain: "spark.example.internal"},
+		{Framework: "marathon", Domain: "apps.example.internal"},
+	}}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"marathon.apps.example.internal.":           "10.0.0.2",
+		"web.marathon.apps.example.internal.":       "10.0.1.1",
+		"web.marathon.slave.apps.example.internal.": "10.0.1.1",
+		"chronos.mesos.":                            "10.0.0.3",
+		"job.chronos.mesos.":                        "10.0.1.1",
+		"job.chronos.slave.mesos.":                  "10.0.1.1",
+	} {
+		if got := rg.As.Hosts(name); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("got A records %v for %q, want %v", got, name, want)
+		}
+	}
+	// neither domain holds the names of the other's frameworks
+	for _, r := range []rrs{rg.As, rg.SRVs} {
+		for _, name := range r.Names() {
+			marathon := strings.Contains(name, "marathon")
+			apps := strings.HasSuffix(name, ".apps.example.internal.")
+			if marathon != apps {
+				t.Errorf("unexpected record name %q", name)
+			}
+			if strings.Contains(name, "spark") {
+				t.Errorf("unexpected record name %q of an unused domain", name)
+			}
+		}
+	}
+	if rg.SRVs.get("_framework._tcp.marathon.apps.example.internal.") == nil {
+		t.Errorf("missing framework SRV record under the alternate domain, SRVs=%v", rg.SRVs)
+	}
+
+	domains := map[string]string{}
+	for _, f := range rg.EnumData.Frameworks {
+		domains[f.Name] = f.Domain
+	}
+	if want := map[string]string{"marathon": "apps.example.internal", "chronos": "mesos"}; !reflect.DeepEqual(domains, want) {
+		t.Errorf("got enumerated domains %v, want %v", domains, want)
+	}
+}
+
+func TestInsertState_MirroredDomains(t *testing.T) {
+	sj := loadState(t, "../factories/fake.json")
+
+	rg := RecordGenerator{frameworkDomains: []FrameworkDomain{
+		{Framework: "marathon", Domain: "public.example.com", Mirror: true, TaskRegexp: `^liquor\.store$`},
+		{Framework: "marathon", Domain: "internal.example.com", Mirror: true, TaskRegexp: `^car\.store$`},
+	}}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"netinfo", "docker", "mesos", "host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	// the records of the Mesos domain are unaffected
+	var mesos []string
+	for _, line := range allRecords(&rg) {
+		if strings.HasSuffix(strings.Fields(line)[1], ".mesos.") {
+			mesos = append(mesos, line)
+		}
+	}
+	checkGolden(t, "testdata/fake.golden", mesos)
+
+	// each mirror only holds the records of the tasks it mirrors
+	for domain, task := range map[string]*regexp.Regexp{
+		".public.example.com.":   regexp.MustCompile(`liquor[.-]store`),
+		".internal.example.com.": regexp.MustCompile(`car[.-]store`),
+	} {
+		var n int
+		for _, line := range allRecords(&rg) {
+			fields := strings.Fields(line)
+			name, host := fields[1], fields[2]
+			if !strings.HasSuffix(name, domain) {
+				continue
+			}
+			n++
+			if name != "marathon"+domain && name != "_framework._tcp.marathon"+domain && !task.MatchString(name) {
+				t.Errorf("unexpected record %q under %s", line, domain)
+			}
+			if fields[0] == SRV && !strings.Contains(host, domain) {
+				t.Errorf("SRV record %q targets a name out of %s", line, domain)
+			}
+		}
+		if n == 0 {
+			t.Errorf("no records under %s", domain)
+		}
+	}
+
+	tasks := map[string][]string{}
+	for _, f := range rg.EnumData.Frameworks {
+		if f.Name != "marathon" {
+			continue
+		}
+		for _, task := range f.Tasks {
+			if f.Domain != "mesos" {
+				tasks[f.Domain] = append(tasks[f.Domain], task.Name)
+			}
+		}
+	}
+	if want := map[string][]string{
+		"public.example.com":   {"liquor.store"},
+		"internal.example.com": {"car.store"},
+	}; !reflect.DeepEqual(tasks, want) {
+		t.Errorf("got enumerated mirrored tasks %v, want %v", tasks, want)
+	}
+}
+
+func TestInsertState_MaxRecords(t *testing.T) {
+	framework := func(id, name, ip string, tasks int) state.Framework {
+		f := state.Framework{ID: id, Name: name, PID: state.PID{UPID: &upid.UPID{ID: "scheduler(1)", Host: ip, Port: "8080"}}}
+		for i := 0; i < tasks; i++ {
+			f.Tasks = append(f.Tasks, runningTask(fmt.Sprintf("%s.%d", name, i), fmt.Sprintf("task%d", i), "s1"))
+		}
+		return f
+	}
+	frameworks := []state.Framework{
+		framework("fw-3", "runaway", "10.0.0.4", 5),
+		framework("fw-1", "marathon", "10.0.0.2", 2),
+		framework("fw-2", "chronos", "10.0.0.3", 2),
+	}
+	generate := func(maxRecords int, frameworks ...state.Framework) *RecordGenerator {
+		sj := state.State{Frameworks: frameworks, Slaves: []state.Slave{slave("s1", "10.0.1.1")}}
+		rg := &RecordGenerator{maxRecords: maxRecords}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		return rg
+	}
+
+	// every framework gets 2 records, the slave 2, the listener 1 and every
+	// task 4: the cap leaves room for the tasks of marathon and one of
+	// chronos
+	const maxRecords = 3*2 + 2 + 1 + 3*4
+	rg := generate(maxRecords, frameworks...)
+	if !rg.Stats.Capped {
+		t.Error("generation not flagged as capped")
+	}
+	if got := rg.Stats.TotalRecords(); got != maxRecords {
+		t.Errorf("got %d records, want %d", got, maxRecords)
+	}
+	if want := map[string]int{"chronos": 1, "runaway": 5}; !reflect.DeepEqual(rg.Stats.CutOff, want) {
+		t.Errorf("got cut off tasks %v, want %v", rg.Stats.CutOff, want)
+	}
+	if got := rg.Stats.Skipped[SkipRecordCap]; got != 6 {
+		t.Errorf("got %d tasks skipped for the record cap, want 6", got)
+	}
+	for _, name := range []string{
+		"runaway.mesos.", "slave.mesos.", "ns1.mesos.",
+		"task0.marathon.mesos.", "task1.marathon.mesos.", "task0.chronos.mesos.",
+	} {
+		if len(rg.As.get(name)) == 0 {
+			t.Errorf("missing A record %q", name)
+		}
+	}
+	for _, name := range []string{"task1.chronos.mesos.", "task0.runaway.mesos."} {
+		if len(rg.As.get(name)) != 0 {
+			t.Errorf("unexpected A record %q", name)
+		}
+	}
+	for _, f := range rg.EnumData.Frameworks {
+		for _, task := range f.Tasks {
+			if cut := task.Skipped == SkipRecordCap; cut != (len(task.Records) == 0) {
+				t.Errorf("task %q of %q: skipped=%q with %d records", task.ID, f.Name, task.Skipped, len(task.Records))
+			}
+		}
+	}
+
+	// the same frameworks get cut off regardless of the order of the state
+	reversed := generate(maxRecords, frameworks[2], frameworks[1], frameworks[0])
+	if !reflect.DeepEqual(rrsSets(reversed.As), rrsSets(rg.As)) || !reflect.DeepEqual(reversed.Stats.CutOff, rg.Stats.CutOff) {
+		t.Errorf("got different records from the reversed state: %v, cut off %v", rrsSets(reversed.As), reversed.Stats.CutOff)
+	}
+
+	// no cap
+	rg = generate(0, frameworks...)
+	if rg.Stats.Capped || len(rg.Stats.CutOff) != 0 {
+		t.Errorf("got capped=%t cut off %v without a cap", rg.Stats.Capped, rg.Stats.CutOff)
+	}
+	if got, want := rg.Stats.TotalRecords(), 3*2+2+1+9*4; got != want {
+		t.Errorf("got %d records, want %d", got, want)
+	}
+}
+
+func TestInsertState_LocalAgent(t *testing.T) {
+	// 1000 tasks spread over 100 agents
+	var sj state.State
+	for i := 0; i < 100; i++ {
+		s := slave(fmt.Sprintf("s%d", i), fmt.Sprintf("10.0.1.%d", i+1))
+		s.Hostname = fmt.Sprintf("agent%d.example.com", i)
+		sj.Slaves = append(sj.Slaves, s)
+	}
+	for i, name := range []string{"marathon", "chronos"} {
+		f := state.Framework{ID: fmt.Sprintf("fw-%d", i), Name: name}
+		for j := 0; j < 500; j++ {
+			f.Tasks = append(f.Tasks, runningTask(fmt.Sprintf("%s.%d", name, j), fmt.Sprintf("task%d", j), fmt.Sprintf("s%d", j%100)))
+		}
+		sj.Frameworks = append(sj.Frameworks, f)
+	}
+
+	for _, agent := range []string{"s7", "agent7.example.com", "10.0.1.8"} {
+		rg := RecordGenerator{localAgent: agent}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := rg.As.Hosts("slave.mesos."), []string{"10.0.1.8"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("agent %q: got slave records %v, want %v", agent, got, want)
+		}
+		// the listener record and 4 A records for each of the 10 local tasks
+		if got, want := rg.Stats.TotalRecords(), 1+1+1+10*4; got != want {
+			t.Errorf("agent %q: got %d records, want %d", agent, got, want)
+		}
+		if got := rg.Stats.Skipped[SkipFiltered]; got != 990 {
+			t.Errorf("agent %q: got %d remote tasks skipped, want 990", agent, got)
+		}
+		tasks := 0
+		for _, f := range rg.EnumData.Frameworks {
+			for _, task := range f.Tasks {
+				tasks++
+				if !strings.HasSuffix(task.Name, "7") {
+					t.Errorf("agent %q: unexpected task %q of %q", agent, task.Name, f.Name)
+				}
+			}
+		}
+		if tasks != 10 {
+			t.Errorf("agent %q: got %d enumerated tasks, want 10", agent, tasks)
+		}
+		if got := rg.As.Hosts("task7.marathon.mesos."); !reflect.DeepEqual(got, []string{"10.0.1.8"}) {
+			t.Errorf("agent %q: got %v for a local task, want 10.0.1.8", agent, got)
+		}
+		if got := rg.As.Hosts("task8.marathon.mesos."); len(got) != 0 {
+			t.Errorf("agent %q: got %v for a remote task, want none", agent, got)
+		}
+	}
+}
+
+func TestSuffixFrag(t *testing.T) {
+	for i, tt := range []struct {
+		frag, suffix string
+		spec         labels.Func
+		want         string
+	}{
+		{"spark", "-abcde", labels.RFC1123, "spark-abcde"},
+		{"a.spark", "-abcde", labels.RFC1123, "a.spark-abcde"},
+		{"abcdefghijklmnopqrstuvwx", "-abcde", labels.RFC952, "abcdefghijklmnopqr-abcde"},
+	} {
+		if got := suffixFrag(tt.frag, tt.suffix, tt.spec); got != tt.want {
+			t.Errorf("test #%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestInsertState_UnroutableSlaves(t *testing.T) {
+	sj := loadState(t, "testdata/unroutable_slaves.json")
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rg.As.Hosts("slave.mesos."), []string{"10.0.1.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got slave A records %v, want %v", got, want)
+	}
+	if got := rg.AAAAs.Hosts("slave.mesos."); len(got) != 0 {
+		t.Errorf("unexpected slave AAAA records %v", got)
+	}
+	if got, want := len(rg.SlaveIPs), 1; got != want {
+		t.Errorf("got %d slave IPs, want %d: %v", got, want, rg.SlaveIPs)
+	}
+	if rg.As.get("web.marathon.mesos.") == nil {
+		t.Error("missing A record of the task on the healthy slave")
+	}
+	for _, rrs := range []rrs{rg.As, rg.AAAAs, rg.SRVs} {
+		for _, name := range rrs.Names() {
+			hosts := rrs.get(name)
+			for _, task := range []string{"zero", "loop", "unspec"} {
+				if strings.Contains(name, task) {
+					t.Errorf("unexpected record %q of task %q", name, task)
+				}
+			}
+			for host := range hosts {
+				if ip := net.ParseIP(host); ip != nil && (ip.IsUnspecified() || ip.IsLoopback()) && name != "ns1.mesos." {
+					t.Errorf("unexpected record %q -> %q", name, host)
+				}
+			}
+		}
+	}
+	if got, want := rg.Stats.Skipped[SkipMissingSlaveIP], 3; got != want {
+		t.Errorf("got %d tasks skipped for a missing slave IP, want %d", got, want)
+	}
+}
+
+func TestSetFromLocal(t *testing.T) {
+	ipNet := func(ip string) net.Addr { return &net.IPNet{IP: net.ParseIP(ip)} }
+	lo := ifaceAddrs{name: "lo", addrs: []net.Addr{ipNet("127.0.0.1"), ipNet("::1")}}
+	eth0 := ifaceAddrs{name: "eth0", addrs: []net.Addr{ipNet("10.0.0.5"), &net.IPAddr{IP: net.ParseIP("2001:db8::5")}}}
+	broken := ifaceAddrs{name: "eth1", err: errors.New("boom")}
+
+	for i, tt := range []struct {
+		ifaces   []ifaceAddrs
+		err      error
+		wantA    []string
+		wantAAAA []string
+		wantErr  string
+	}{
+		{[]ifaceAddrs{lo, eth0}, nil, []string{"10.0.0.5"}, []string{"2001:db8::5"}, ""},
+		{[]ifaceAddrs{broken, eth0}, nil, []string{"10.0.0.5"}, []string{"2001:db8::5"}, "interface eth1: boom"},
+		{[]ifaceAddrs{lo}, nil, []string{"127.0.0.1"}, nil, ""},
+		{[]ifaceAddrs{lo, broken}, nil, []string{"127.0.0.1"}, nil, "interface eth1: boom"},
+		{nil, errors.New("no interfaces"), []string{"127.0.0.1"}, nil, "no interfaces"},
+	} {
+		rg := &RecordGenerator{As: rrs{}, AAAAs: rrs{}, SRVs: rrs{}}
+		rg.interfaces = func() ([]ifaceAddrs, error) { return tt.ifaces, tt.err }
+
+		err := rg.listenerRecord([]string{"0.0.0.0"}, "ns1.mesos.")
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("test #%d: unexpected error: %v", i, err)
+		} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("test #%d: got error %v, want one containing %q", i, err, tt.wantErr)
+		}
+		if got := rg.As.Hosts("ns1.mesos."); !reflect.DeepEqual(got, tt.wantA) && len(got)+len(tt.wantA) > 0 {
+			t.Errorf("test #%d: got A records %v, want %v", i, got, tt.wantA)
+		}
+		if got := rg.AAAAs.Hosts("ns1.mesos."); !reflect.DeepEqual(got, tt.wantAAAA) && len(got)+len(tt.wantAAAA) > 0 {
+			t.Errorf("test #%d: got AAAA records %v, want %v", i, got, tt.wantAAAA)
+		}
+	}
+}
+
+func TestListenerRecord(t *testing.T) {
+	eth0 := ifaceAddrs{name: "eth0", addrs: []net.Addr{&net.IPNet{IP: net.ParseIP("10.0.0.5")}}}
+	for i, tt := range []struct {
+		listeners []string
+		wantA     []string
+		wantAAAA  []string
+	}{
+		{[]string{"10.1.0.53", "10.2.0.53"}, []string{"10.1.0.53", "10.2.0.53"}, nil},
+		{[]string{"10.1.0.53", "2001:db8::53"}, []string{"10.1.0.53"}, []string{"2001:db8::53"}},
+		{[]string{"10.1.0.53", "10.1.0.53"}, []string{"10.1.0.53"}, nil},
+		{[]string{"127.0.0.1"}, []string{"127.0.0.1"}, nil},
+		{[]string{"0.0.0.0", "::", "10.1.0.53"}, []string{"10.0.0.5", "10.1.0.53"}, nil},
+	} {
+		rg := &RecordGenerator{As: rrs{}, AAAAs: rrs{}, SRVs: rrs{}}
+		rg.interfaces = func() ([]ifaceAddrs, error) { return []ifaceAddrs{eth0}, nil }
+		rg.Stats.attributed(SourceListener, func() {
+			if err := rg.listenerRecord(tt.listeners, "ns1.mesos."); err != nil {
+				t.Errorf("test #%d: unexpected error: %v", i, err)
+			}
+		})
+		gotA, gotAAAA := rg.As.Hosts("ns1.mesos."), rg.AAAAs.Hosts("ns1.mesos.")
+		sort.Strings(gotA)
+		if !reflect.DeepEqual(gotA, tt.wantA) {
+			t.Errorf("test #%d: got A records %v, want %v", i, gotA, tt.wantA)
+		}
+		if !reflect.DeepEqual(gotAAAA, tt.wantAAAA) && len(gotAAAA)+len(tt.wantAAAA) > 0 {
+			t.Errorf("test #%d: got AAAA records %v, want %v", i, gotAAAA, tt.wantAAAA)
+		}
+		if got, want := rg.Stats.Sources[SourceListener], len(tt.wantA)+len(tt.wantAAAA); got != want {
+			t.Errorf("test #%d: got %d listener records, want %d", i, got, want)
+		}
+	}
+}
+
+// fakeResolver returns a Resolver looking hostnames up with the given
+// function.
+func fakeResolver(lookup func(host string) ([]net.IP, error)) Resolver {
+	return ResolverFunc(func(_ stdcontext.Context, host string) ([]net.IP, error) {
+		return lookup(host)
+	})
+}
+
+func TestWithResolver(t *testing.T) {
+	config := NewConfig()
+	config.HostsFile = "testdata/dual_stack.hosts"
+	resolver := fakeResolver(func(host string) ([]net.IP, error) {
+		if host == "node-1" {
+			return []net.IP{net.ParseIP("10.0.0.1")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	})
+	rg := NewRecordGenerator(WithResolver(resolver), WithConfig(config))
+	// the hosts file still applies
+	sj := state.State{Slaves: []state.Slave{slave("s1", "node-1"), slave("s2", "node-2"), slave("s3", "agent-1.example.com")}}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	got := rg.As.Hosts("slave.mesos.")
+	sort.Strings(got)
+	if want := []string{"10.0.0.1", "10.0.1.1", "10.0.2.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got slave records %q, want %q", got, want)
+	}
+	if got := rg.Stats.ResolutionFailures; got != 1 {
+		t.Errorf("got %d resolution failures, want 1", got)
+	}
+}
+
+func TestHostResolver(t *testing.T) {
+	hosts := map[string]string{
+		"node-1":                  "10.0.0.1",
+		"node-2.corp.example.com": "10.0.0.2",
+		"node-3.dc2.example.com":  "10.0.0.3",
+		"node-4.example.org":      "10.0.0.4",
+	}
+	var (
+		mu      sync.Mutex
+		lookups []string
+	)
+	r := newHostResolver([]string{"corp.example.com", ".dc2.example.com."}, nil, 0, false, "")
+	r.source = fakeResolver(func(host string) ([]net.IP, error) {
+		mu.Lock()
+		lookups = append(lookups, host)
+		mu.Unlock()
+		if ip, ok := hosts[host]; ok {
+			return []net.IP{net.ParseIP(ip)}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	})
+
+	for i, tt := range []struct {
+		host    string
+		want    string
+		lookups []string
+	}{
+		// bare success
+		{"node-1", "10.0.0.1", []string{"node-1"}},
+		{"node-4.example.org", "10.0.0.4", []string{"node-4.example.org"}},
+		// suffix success
+		{"node-2", "10.0.0.2", []string{"node-2", "node-2.corp.example.com"}},
+		{"node-3", "10.0.0.3", []string{"node-3", "node-3.corp.example.com", "node-3.dc2.example.com"}},
+		// the FQDN chosen is tried first
+		{"node-3", "10.0.0.3", []string{"node-3.dc2.example.com"}},
+		// total failure, not retrying names with dots
+		{"node-5", "", []string{"node-5", "node-5.corp.example.com", "node-5.dc2.example.com"}},
+		{"node-5.example.org", "", []string{"node-5.example.org"}},
+	} {
+		lookups = nil
+		ips, err := r.lookup(stdcontext.Background(), nil, tt.host)
+		var got string
+		if len(ips) > 0 {
+			got = ips[0].String()
+		}
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("test #%d: got %v, %v for %q, want %q", i, ips, err, tt.host, tt.want)
+		}
+		if !reflect.DeepEqual(lookups, tt.lookups) {
+			t.Errorf("test #%d: got lookups %q, want %q", i, lookups, tt.lookups)
+		}
+	}
+
+	// slave records are generated from the hostnames resolved
+	sj := state.State{Slaves: []state.Slave{slave("s1", "node-2"), slave("s2", "node-5")}}
+	rg := RecordGenerator{hosts: r}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rg.As.Hosts("slave.mesos."), []string{"10.0.0.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got slave records %v, want %v", got, want)
+	}
+	if got := rg.Stats.ResolutionFailures; got != 1 {
+		t.Errorf("got %d resolution failures, want 1", got)
+	}
+}
+
+func TestHostResolver_HostsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+	write := func(contents string) {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("# lab machines\n10.0.0.1 lab-1 lab-1.example.com\n2001:db8::1 LAB-1\nbogus lab-2\n10.0.0.2 lab-2 # second\n")
+
+	var lookups []string
+	r := newHostResolver(nil, nil, 0, false, path)
+	r.source = fakeResolver(func(host string) ([]net.IP, error) {
+		lookups = append(lookups, host)
+		if host == "node-9" {
+			return []net.IP{net.ParseIP("10.0.0.9")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	})
+	rg := RecordGenerator{hosts: r}
+	generate := func() {
+		lookups = nil
+		sj := state.State{Slaves: []state.Slave{slave("s1", "lab-1"), slave("s2", "lab-2"), slave("s3", "node-9")}}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// hostnames listed in the file never hit the network, others do
+	generate()
+	if want := []string{"node-9"}; !reflect.DeepEqual(lookups, want) {
+		t.Errorf("got lookups %q, want %q", lookups, want)
+	}
+	for _, tt := range []struct {
+		rrs  rrs
+		want []string
+	}{
+		{rg.As, []string{"10.0.0.1", "10.0.0.2", "10.0.0.9"}},
+		{rg.AAAAs, []string{"2001:db8::1"}},
+	} {
+		got := tt.rrs.Hosts("slave.mesos.")
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("got slave records %q, want %q", got, tt.want)
+		}
+	}
+
+	// the file is re-read once changed
+	write("10.0.0.11 lab-1\n10.0.0.99 node-9\n")
+	generate()
+	if want := []string{"lab-2"}; !reflect.DeepEqual(lookups, want) {
+		t.Errorf("got lookups %q after rewriting the file, want %q", lookups, want)
+	}
+	got := rg.As.Hosts("slave.mesos.")
+	sort.Strings(got)
+	if want := []string{"10.0.0.11", "10.0.0.99"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got slave records %q after rewriting the file, want %q", got, want)
+	}
+}
+
+func TestHostResolver_Servers(t *testing.T) {
+	// internal DNS servers answering for the agent names
+	var (
+		mu      sync.Mutex
+		queries = map[string][]string{}
+	)
+	serve := func(name string, hosts map[string]string) (*dns.Server, string) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			q := r.Question[0]
+			mu.Lock()
+			queries[name] = append(queries[name], q.Name)
+			mu.Unlock()
+			m := new(dns.Msg).SetReply(r)
+			if ip, ok := hosts[q.Name]; !ok {
+				m.Rcode = dns.RcodeNameError
+			} else if q.Qtype == dns.TypeA {
+				m.Answer = append(m.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.ParseIP(ip),
+				})
+			}
+			_ = w.WriteMsg(m)
+		})}
+		go func() { _ = srv.ActivateAndServe() }()
+		return srv, pc.LocalAddr().String()
+	}
+	srv, internal := serve("internal", map[string]string{"node-1.": "10.0.0.1", "node-2.corp.example.com.": "10.0.0.2"})
+	defer func() { _ = srv.Shutdown() }()
+
+	r := newHostResolver([]string{"corp.example.com"}, []string{internal}, time.Second, false, "")
+	var system []string
+	r.system = fakeResolver(func(host string) ([]net.IP, error) {
+		system = append(system, host)
+		return []net.IP{net.ParseIP("192.0.2.1")}, nil
+	})
+	for i, tt := range []struct {
+		host string
+		want string
+	}{
+		{"node-1", "10.0.0.1"},
+		{"node-2", "10.0.0.2"},
+		{"node-3", ""},
+	} {
+		ips, err := r.lookup(stdcontext.Background(), nil, tt.host)
+		if got := fmt.Sprint(ips); (tt.want == "" && err == nil) || (tt.want != "" && got != "["+tt.want+"]") {
+			t.Errorf("test #%d: got %v, %v for %q, want %q", i, ips, err, tt.host, tt.want)
+		}
+	}
+	if len(system) > 0 {
+		t.Errorf("got system lookups %q without fallback, want none", system)
+	}
+	mu.Lock()
+	if got := queries["internal"]; len(got) == 0 || got[0] != "node-1." {
+		t.Errorf("got queries %q, want some starting with node-1.", got)
+	}
+	mu.Unlock()
+
+	// failed lookups fall back to the system resolver if enabled
+	r.fallback = true
+	if ips, err := r.lookup(stdcontext.Background(), nil, "node-3"); err != nil || fmt.Sprint(ips) != "[192.0.2.1]" {
+		t.Errorf("got %v, %v with fallback, want [192.0.2.1]", ips, err)
+	}
+	if want := []string{"node-3"}; !reflect.DeepEqual(system, want) {
+		t.Errorf("got system lookups %q, want %q", system, want)
+	}
+
+	// retries go to the next server
+	srv, other := serve("other", map[string]string{"node-1.": "10.0.0.1"})
+	defer func() { _ = srv.Shutdown() }()
+	r = newHostResolver(nil, []string{"127.0.0.1:1", other}, time.Second, false, "")
+	if ips, err := r.lookup(stdcontext.Background(), nil, "node-1"); err != nil || fmt.Sprint(ips) != "[10.0.0.1]" {
+		t.Errorf("got %v, %v through the second server, want [10.0.0.1]", ips, err)
+	}
+}
+
+func TestInsertState_IncompleteFrameworks(t *testing.T) {
+	sj := loadState(t, "testdata/incomplete_frameworks.json")
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rg.Stats.FrameworksWithoutHost, 1; got != want {
+		t.Errorf("got %d frameworks without host, want %d", got, want)
+	}
+	if got, want := rg.Stats.FrameworksWithoutPort, 1; got != want {
+		t.Errorf("got %d frameworks without port, want %d", got, want)
+	}
+	if got := rg.Stats.ResolutionFailures; got != 0 {
+		t.Errorf("got %d resolution failures, want 0", got)
+	}
+
+	// PID-less framework: no framework records, but task records
+	for _, name := range []string{"http-api.mesos.", "_framework._tcp.http-api.mesos."} {
+		if rg.As.get(name) != nil {
+			t.Errorf("unexpected A record %q", name)
+		}
+		if rg.SRVs.get(name) != nil {
+			t.Errorf("unexpected SRV record %q", name)
+		}
+	}
+	if !rg.exists("web.http-api.mesos.", "10.0.1.1", A) {
+		t.Error("missing task A record of the PID-less framework")
+	}
+
+	// framework with a hostname but no port: A records only
+	if !rg.exists("chronos.mesos.", "10.0.0.4", A) {
+		t.Error("missing A record of the framework without port")
+	}
+	if rg.SRVs.get("_framework._tcp.chronos.mesos.") != nil {
+		t.Error("unexpected SRV record of the framework without port")
+	}
+
+	// complete framework
+	if !rg.exists("_framework._tcp.marathon.mesos.", "marathon.mesos.:15101", SRV) {
+		t.Errorf("missing SRV record of the complete framework, SRVs=%v", rg.SRVs)
+	}
+	for _, name := range rg.SRVs.Names() {
+		hosts := rg.SRVs.get(name)
+		for host := range hosts {
+			if strings.HasPrefix(host, ":") || strings.HasSuffix(host, ":") {
+				t.Errorf("SRV record %q has an incomplete target %q", name, host)
+			}
+		}
+	}
+}
+
+func TestCheckMname(t *testing.T) {
+	const ns = "ns1.mesos."
+	for i, tt := range []struct {
+		listener string
+		strict   bool
+		existing string
+		want     MnameCheck
+		wantA    []string
+		wantErr  bool
+	}{
+		{"10.0.0.9", false, "10.0.0.7", MnameResolves, []string{"10.0.0.7"}, false},
+		{"10.0.0.9", true, "10.0.0.7", MnameResolves, []string{"10.0.0.7"}, false},
+		{"10.0.0.9", false, "", MnameSynthesized, []string{"10.0.0.9"}, false},
+		{"0.0.0.0", false, "", MnameSynthesized, []string{"127.0.0.1"}, false},
+		{"10.0.0.9", true, "", MnameMissing, nil, true},
+	} {
+		rg := &RecordGenerator{As: rrs{}, AAAAs: rrs{}, strictMname: tt.strict}
+		rg.As.Add(ns, tt.existing)
+		err := rg.checkMname(ns, tt.listener)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("test #%d: got error %v, want error: %v", i, err, tt.wantErr)
+		}
+		if rg.Stats.Mname != tt.want {
+			t.Errorf("test #%d: got check %q, want %q", i, rg.Stats.Mname, tt.want)
+		}
+		if got := rg.As.Hosts(ns); !reflect.DeepEqual(got, tt.wantA) && len(got)+len(tt.wantA) > 0 {
+			t.Errorf("test #%d: got A records %v, want %v", i, got, tt.wantA)
+		}
+	}
+}
+
+func TestInsertState_Mname(t *testing.T) {
+	sj := loadState(t, "testdata/missing_slave.json")
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	} else if rg.Stats.Mname != MnameResolves {
+		t.Errorf("got check %q, want %q", rg.Stats.Mname, MnameResolves)
+	}
+
+	// an invalid mname gets no record, synthesized or not
+	if err := rg.InsertState(sj, "mesos", "ns1..mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	} else if rg.Stats.Mname != MnameMissing {
+		t.Errorf("got check %q, want %q", rg.Stats.Mname, MnameMissing)
+	}
+
+	// failed insertions keep the previous records
+	checksum, updated := rg.Checksum(), rg.LastUpdated()
+	rg.strictMname = true
+	if err := rg.InsertState(loadState(t, "testdata/orphans.json"), "mesos", "ns1..mesos.", "127.0.0.1", nil,
+		[]string{"host"}, labels.RFC1123); err == nil {
+		t.Error("expected an error for an unresolvable mname")
+	}
+	if rg.Checksum() != checksum || !rg.LastUpdated().Equal(updated) || rg.Stats.Mname != MnameMissing {
+		t.Errorf("failed insertion replaced the records: got checksum %s, updated %s, mname check %q",
+			rg.Checksum(), rg.LastUpdated(), rg.Stats.Mname)
+	}
+}
+
+func TestInsertState_EnumerationDedup(t *testing.T) {
+	sj := loadState(t, "testdata/discovery_case.json")
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := rg.EnumData.Frameworks[0].Tasks
+	if len(tasks) != 2 {
+		t.Fatalf("got %d enumerated tasks, want 2", len(tasks))
+	}
+	for _, task := range tasks {
+		seen := map[EnumerableRecord]bool{}
+		for _, rec := range task.Records {
+			if seen[rec] {
+				t.Errorf("task %q: record listed twice: %+v", task.ID, rec)
+			}
+			seen[rec] = true
+			if rec.Name != strings.ToLower(rec.Name) {
+				t.Errorf("task %q: record name not normalized: %+v", task.ID, rec)
+			}
+			if !rg.exists(rec.Name, rec.Host, rrsKind(rec.Rtype)) {
+				t.Errorf("task %q: listed record not stored: %+v", task.ID, rec)
+			}
+		}
+		// records shared by both tasks are listed for each
+		for _, rec := range []EnumerableRecord{
+			{Name: "web.marathon.mesos.", Host: "10.0.1.1", Rtype: "A"},
+			{Name: "web.marathon.slave.mesos.", Host: "10.0.1.1", Rtype: "A"},
+		} {
+			if !seen[rec] {
+				t.Errorf("task %q: missing record %+v in %+v", task.ID, rec, task.Records)
+			}
+		}
+	}
+}
+
+// srvRecords returns the SRV records of the given generator, one "name target"
+// line per record, in order.
+func srvRecords(rg *RecordGenerator) []string {
+	var lines []string
+	for _, name := range rg.SRVs.Names() {
+		hosts := rg.SRVs.get(name)
+		for host := range hosts {
+			lines = append(lines, name+" "+host)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// allRecords returns the records of the given generator, one "type name host"
+// line per record, in order.
+func allRecords(rg *RecordGenerator) []string {
+	var lines []string
+	for _, kind := range []rrsKind{A, AAAA, SRV} {
+		for _, name := range kind.rrs(rg).Names() {
+			hosts := kind.rrs(rg).get(name)
+			for host := range hosts {
+				lines = append(lines, string(kind)+" "+name+" "+host)
+			}
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// checkGolden compares the given lines to those of the given golden file,
+// rewriting it instead with -update.
+func checkGolden(t *testing.T, golden string, got []string) {
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(strings.Join(got, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("records differ from %s, rerun with -update and review the diff:\ngot:\n%s\nwant:\n%s",
+			golden, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestInsertState_Golden(t *testing.T) {
+	sj := loadState(t, "../factories/fake.json")
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"netinfo", "docker", "mesos", "host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "testdata/fake.golden", allRecords(&rg))
+}
+
+func TestInsertState_DCOSNames(t *testing.T) {
+	sj := loadState(t, "testdata/dcos.json")
+
+	for _, mode := range []string{DCOSNamesAlongside, DCOSNamesInstead} {
+		rg := RecordGenerator{dcosNames: mode}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"netinfo", "host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "testdata/dcos_"+mode+".golden", allRecords(&rg))
+	}
+}
+
+func TestInsertState_NetworkInfo(t *testing.T) {
+	sj := loadState(t, "testdata/netinfo.json")
+
+	for golden, ipSources := range map[string][]string{
+		// every address of every interface
+		"testdata/netinfo.golden": {"netinfo", "host"},
+		// those of the interfaces on the dcos network only
+		"testdata/netinfo_network.golden": {"netinfo:dcos", "host"},
+	} {
+		var rg RecordGenerator
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, ipSources, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, golden, allRecords(&rg))
+	}
+}
+
+func TestInsertState_AutoIP(t *testing.T) {
+	sj := loadState(t, "testdata/autoip.json")
+
+	rg := NewRecordGenerator(WithConfig(Config{AutoIPCIDRs: []string{"192.168.0.0/16"}}))
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"autoip"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"overlay.marathon.mesos.": "9.0.1.2",
+		"bridge.marathon.mesos.":  "10.0.1.1",
+		"host.marathon.mesos.":    "10.0.1.1",
+		"routed.marathon.mesos.":  "192.168.7.9",
+	} {
+		if got := rg.As.Hosts(name); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestInsertState_NamingLinks(t *testing.T) {
+	sj := loadState(t, "testdata/port_protocols.json")
+
+	rg := NewRecordGenerator(WithNamingLinks(naming.Subdomain("us-east-1")))
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"_worker._tcp.marathon.us-east-1.mesos.",
+		"_worker._udp.marathon.us-east-1.slave.mesos.",
+		"_gateway._tcp.marathon.us-east-1.mesos.",
+		"_http._gateway._tcp.marathon.us-east-1.mesos.",
+	} {
+		if rg.SRVs.get(name) == nil {
+			t.Errorf("missing SRV record %s", name)
+		}
+	}
+	for _, name := range []string{
+		"_worker._tcp.marathon.mesos.",
+		"_gateway._tcp.marathon.mesos.",
+		"_http._gateway._tcp.marathon.mesos.",
+	} {
+		if rg.SRVs.get(name) != nil {
+			t.Errorf("unexpected SRV record %s", name)
+		}
+	}
+	// only the SRV records of task ports are affected
+	if rg.SRVs.get("_framework._tcp.marathon.mesos.") == nil {
+		t.Error("missing framework SRV record")
+	}
+}
+
+func TestInsertState_PortProtocols(t *testing.T) {
+	sj := loadState(t, "testdata/port_protocols.json")
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "testdata/port_protocols.golden", srvRecords(&rg))
+
+	// ports of no or an unusable protocol are published under the
+	// configured default protocols only
+	rg = RecordGenerator{defaultProtocols: []string{"udp"}}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"_worker._tcp.marathon.mesos.",
+		"_gateway._tcp.marathon.slave.mesos.",
+		"_admin._gateway._tcp.marathon.mesos.",
+		"_junk._gateway._tcp.marathon.mesos.",
+	} {
+		if rg.SRVs.get(name) != nil {
+			t.Errorf("unexpected SRV record %s", name)
+		}
+	}
+	for _, name := range []string{
+		"_worker._udp.marathon.mesos.",
+		"_gateway._udp.marathon.slave.mesos.",
+		"_admin._gateway._udp.marathon.mesos.",
+		"_junk._gateway._udp.marathon.mesos.",
+		"_http._gateway._tcp.marathon.mesos.",
+		"_stream._gateway._sctp.marathon.mesos.",
+	} {
+		if rg.SRVs.get(name) == nil {
+			t.Errorf("missing SRV record %s", name)
+		}
+	}
+}
+
+func TestInsertState_ShortSRVTargets(t *testing.T) {
+	sj := loadState(t, "testdata/discovery_case.json")
+
+	targets := func(short bool) []string {
+		rg := RecordGenerator{shortSRVTargets: short}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, name := range []string{"_web._tcp.marathon.slave.mesos.", "_http._web._tcp.marathon.mesos."} {
+			got = append(got, name+" "+strings.Join(rg.SRVs.Hosts(name), " "))
+		}
+		return got
+	}
+	for _, tt := range []struct {
+		short bool
+		want  []string
+	}{
+		{false, []string{
+			"_web._tcp.marathon.slave.mesos. web-fxcsg-s1.marathon.slave.mesos.:31000 web-hyt61-s1.marathon.slave.mesos.:31001",
+			"_http._web._tcp.marathon.mesos. web-fxcsg-s1.marathon.mesos.:31000 web-hyt61-s1.marathon.mesos.:31001",
+		}},
+		{true, []string{
+			"_web._tcp.marathon.slave.mesos. web.marathon.slave.mesos.:31000 web.marathon.slave.mesos.:31001",
+			"_http._web._tcp.marathon.mesos. web.marathon.mesos.:31000 web.marathon.mesos.:31001",
+		}},
+	} {
+		if got := targets(tt.short); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("short targets %t: got SRV records\n%s\nwant\n%s", tt.short, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestInsertState_RecordCounts(t *testing.T) {
+	sj := loadState(t, "testdata/missing_slave.json")
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	// framework: marathon A, _framework._tcp SRV
+	// slave: slave A, _slave._tcp SRV
+	// master: leader, master and master0 A, _leader._tcp and _leader._udp SRV
+	// listener: ns1 A
+	// task web: 4 A (plain and canonical, with and without slave), 4 SRV
+	wantRecords := map[string]int{"A": 10, "SRV": 8}
+	wantSources := map[string]int{
+		SourceFramework: 2,
+		SourceSlave:     2,
+		SourceMaster:    5,
+		SourceListener:  1,
+		SourceTask:      8,
+		SourceStatic:    0,
+	}
+	if got := rg.Stats.Records; !reflect.DeepEqual(got, wantRecords) {
+		t.Errorf("got records per kind %v, want %v", got, wantRecords)
+	}
+	if got := rg.Stats.Sources; !reflect.DeepEqual(got, wantSources) {
+		t.Errorf("got records per source %v, want %v", got, wantSources)
+	}
+}
+
+func TestInsertState_Events(t *testing.T) {
+	sj := loadState(t, "testdata/events.json")
+	masters := []string{"10.0.0.1:5050", "bad-host:5050"}
+
+	rg := &RecordGenerator{interfaces: func() ([]ifaceAddrs, error) { return nil, nil }}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "0.0.0.0", masters, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	// each event is expected from the given sources only
+	want := map[Event][]string{
+		EventCollision:          {SourceFramework},
+		EventTruncation:         {SourceTask},
+		EventInvalidIP:          {SourceMaster},
+		EventInvalidName:        {SourceTask},
+		EventSanitationFallback: {SourceListener},
+		EventMalformed:          {SourceSlave, SourceTask},
+		EventUnroutable:         {SourceSlave},
+	}
+	got := map[Event][]string{}
+	for event, sources := range rg.Stats.Events {
+		for source, n := range sources {
+			if n > 0 {
+				got[event] = append(got[event], source)
+			}
+		}
+		sort.Strings(got[event])
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", rg.Stats.Events, want)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	long := strings.Repeat("x", 70)
+	for i, tt := range []struct {
+		name string
+		spec labels.Func
+		want bool
+	}{
+		{"web", labels.RFC1123, false},
+		{long, labels.RFC1123, true},
+		{long[:63], labels.RFC1123, false},
+		{"--" + long[:63], labels.RFC1123, false},
+		{long[:30], labels.RFC952, true},
+		{long[:24], labels.RFC952, false},
+	} {
+		if got := truncated(tt.name, tt.spec(tt.name), tt.spec); got != tt.want {
+			t.Errorf("test #%d: got %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	rg := &RecordGenerator{As: rrs{}, AAAAs: rrs{}, SRVs: rrs{}}
+	rg.insertRR("web.marathon.mesos.", "10.0.1.2", A)
+	rg.insertRR("leader.mesos.", "10.0.0.1", A)
+	rg.insertRR("web.marathon.mesos.", "10.0.1.1", A)
+	rg.insertRR("web.marathon.mesos.", "fd01:b::1", AAAA)
+	rg.insertRR("_leader._tcp.mesos.", "leader.mesos.:5050", SRV)
+
+	var b bytes.Buffer
+	n, err := rg.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "A\tleader.mesos.\t10.0.0.1\n" +
+		"A\tweb.marathon.mesos.\t10.0.1.2\n" +
+		"A\tweb.marathon.mesos.\t10.0.1.1\n" +
+		"AAAA\tweb.marathon.mesos.\tfd01:b::1\n" +
+		"SRV\t_leader._tcp.mesos.\tleader.mesos.:5050\n"
+	if got := b.String(); got != want || n != int64(len(want)) {
+		t.Errorf("got %d bytes %q, want %q", n, got, want)
+	}
+}
+
+// stepClock is a clock advancing by step every time it's read.
+type stepClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (c *stepClock) Now() time.Time {
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+func TestParseState_Phases(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/missing_slave.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rg := NewRecordGenerator()
+	rg.clock = &stepClock{step: time.Millisecond}
+	rg.stateLoader = func(_ []string) (sj state.State, err error) {
+		err = rg.decode(bytes.NewReader(b), &sj)
+		return
+	}
+	cfg := Config{Domain: "mesos", SOAMname: "ns1.mesos.", Listener: "127.0.0.1", IPSources: []string{"host"}}
+	if err := rg.ParseState(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	st := rg.Stats
+	var sum time.Duration
+	for _, pass := range []string{
+		passFetch, passDecode, passNormalize, passFrameworks, passSlaves,
+		passListener, passMasters, passTasks, passSnapshot,
+	} {
+		d, ok := st.Durations[pass]
+		if !ok || d <= 0 {
+			t.Errorf("pass %q not measured: %v", pass, d)
+		}
+		sum += d
+	}
+	// the loader reads the clock twice, decoding twice more
+	if got, want := st.Durations[passDecode], time.Millisecond; got != want {
+		t.Errorf("got decode duration %v, want %v", got, want)
+	}
+	if got, want := st.Durations[passFetch], 2*time.Millisecond; got != want {
+		t.Errorf("got fetch duration %v, want %v", got, want)
+	}
+	if st.Duration < sum {
+		t.Errorf("got total duration %v, less than the sum of passes %v", st.Duration, sum)
+	}
+	if got := st.Phases(); !strings.Contains(got, "decode=1ms") || !strings.Contains(got, "fetch=2ms") {
+		t.Errorf("unexpected phases summary %q", got)
+	}
+}
+
+func TestInsertState_Checksum(t *testing.T) {
+	rg := testRecordGenerator(t, labels.RFC952, []string{"netinfo", "docker", "mesos", "host"})
+	if len(rg.Checksum()) != 64 {
+		t.Fatalf("got checksum %q, want a hex encoded SHA-256 one", rg.Checksum())
+	}
+	if got, want := rg.Leader, "master@144.76.157.37:5050"; got != want {
+		t.Errorf("got leader %q, want %q", got, want)
+	}
+
+	// the same state, listing frameworks and tasks in reverse order, yields
+	// the same checksum
+	sj := loadState(t, "../factories/fake.json")
+	sj.Leader = "master@144.76.157.37:5050"
+	for i, j := 0, len(sj.Frameworks)-1; i < j; i, j = i+1, j-1 {
+		sj.Frameworks[i], sj.Frameworks[j] = sj.Frameworks[j], sj.Frameworks[i]
+	}
+	for _, f := range sj.Frameworks {
+		for i, j := 0, len(f.Tasks)-1; i < j; i, j = i+1, j-1 {
+			f.Tasks[i], f.Tasks[j] = f.Tasks[j], f.Tasks[i]
+		}
+	}
+	var other RecordGenerator
+	if err := other.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", []string{"144.76.157.37:5050"},
+		[]string{"netinfo", "docker", "mesos", "host"}, labels.RFC952); err != nil {
+		t.Fatal(err)
+	}
+	if other.Checksum() != rg.Checksum() {
+		t.Errorf("got checksum %s, want %s", other.Checksum(), rg.Checksum())
+	}
+
+	// a single record more changes it
+	other.insertRR("extra.mesos.", "10.0.0.1", A)
+	if got := other.checksum(); got == rg.Checksum() {
+		t.Errorf("got unchanged checksum %s after inserting a record", got)
+	}
+}
+
+func TestInsertState_DuplicatePorts(t *testing.T) {
+	sj := loadState(t, "testdata/duplicate_ports.json")
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "testdata/duplicate_ports.golden", srvRecords(&rg))
+
+	// exact duplicates are dropped silently, while distinct ports of the
+	// same name are kept but counted once per task
+	if got := rg.Stats.Events[EventDuplicatePort][SourceTask]; got != 1 {
+		t.Errorf("got %d duplicate port events, want 1", got)
+	}
+	for i, want := range []int{2, 5} {
+		if got := len(rg.discoveryPorts(sj.Frameworks[0].Tasks[i], labels.RFC1123)); got != want {
+			t.Errorf("got %d discovery ports for task %s, want %d", got, sj.Frameworks[0].Tasks[i].ID, want)
+		}
+	}
+
+	// the enumerated records of each task are listed once
+	for _, f := range rg.EnumData.Frameworks {
+		for _, task := range f.Tasks {
+			listed := map[EnumerableRecord]bool{}
+			for _, rec := range task.Records {
+				if listed[rec] {
+					t.Errorf("record %+v of task %s listed twice", rec, task.ID)
+				}
+				listed[rec] = true
+			}
+		}
+	}
+}
+
+func TestInsertState_TaskIDRecords(t *testing.T) {
+	sj := loadState(t, "testdata/byid.json")
+	generate := func(rg *RecordGenerator) []string {
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"netinfo", "host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		var byID []string
+		for _, line := range allRecords(rg) {
+			if strings.Contains(line, ".byid.") {
+				byID = append(byID, line)
+			}
+		}
+		return byID
+	}
+
+	if got := generate(&RecordGenerator{}); len(got) > 0 {
+		t.Errorf("got task ID records while disabled: %q", got)
+	}
+	for _, dots := range []string{TaskIDDotsReplace, TaskIDDotsSplit} {
+		got := generate(&RecordGenerator{taskIDRecords: true, taskIDDots: dots})
+		checkGolden(t, "testdata/byid_"+dots+".golden", got)
+	}
+}
+
+func TestInsertState_ContainerNames(t *testing.T) {
+	sj := loadState(t, "testdata/container_names.json")
+	generate := func(rg *RecordGenerator) []string {
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		return allRecords(rg)
+	}
+
+	// the labelled task gets exactly one extra name family
+	usual := map[string]bool{}
+	for _, line := range generate(&RecordGenerator{}) {
+		usual[line] = true
+	}
+	var extra []string
+	for _, line := range generate(&RecordGenerator{containerNameLabel: "container_name"}) {
+		if !usual[line] {
+			extra = append(extra, line)
+		}
+		delete(usual, line)
+	}
+	if len(usual) > 0 {
+		t.Errorf("records missing along with container names: %v", usual)
+	}
+	want := []string{
+		"A billing-api.marathon.mesos. 10.0.1.1",
+		"SRV _billing-api._tcp.marathon.mesos. 3f2a4c1e-9b8d-4e7f-a6c5-d4e3f2a1b0c9-7bw1b-s1.marathon.slave.mesos.:31000",
+		"SRV _billing-api._udp.marathon.mesos. 3f2a4c1e-9b8d-4e7f-a6c5-d4e3f2a1b0c9-7bw1b-s1.marathon.slave.mesos.:31000",
+	}
+	if !reflect.DeepEqual(extra, want) {
+		t.Errorf("got extra records %q, want %q", extra, want)
+	}
+}
+
+func TestApplyTaskUpdate(t *testing.T) {
+	full := loadState(t, "../factories/fake.json")
+	full.Leader = "master@144.76.157.37:5050"
+	masters := []string{"144.76.157.37:5050"}
+	ipSources := []string{"netinfo", "docker", "mesos", "host"}
+	generate := func(sj state.State) *RecordGenerator {
+		rg := &RecordGenerator{taskIDRecords: true, dcosNames: DCOSNamesAlongside}
+		if err := rg.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", masters, ipSources, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		return rg
+	}
+
+	// every task is either absent, running or finished; updates are keyed
+	// by task ID, which the fixture reuses
+	type taskRef struct{ f, t int }
+	var tasks []taskRef
+	ids := map[string]bool{}
+	for i, f := range full.Frameworks {
+		for j, task := range f.Tasks {
+			if !ids[task.ID] {
+				ids[task.ID] = true
+				tasks = append(tasks, taskRef{i, j})
+			}
+		}
+	}
+	const absent, running, finished = 0, 1, 2
+	current := make([]int, len(tasks))
+	task := func(i int) (state.Task, state.Framework) {
+		f := full.Frameworks[tasks[i].f]
+		task := f.Tasks[tasks[i].t]
+		if current[i] == finished {
+			task.State = "TASK_FINISHED"
+		}
+		return task, f
+	}
+	polled := func() state.State {
+		sj := full
+		sj.Frameworks = make([]state.Framework, len(full.Frameworks))
+		for i, f := range full.Frameworks {
+			f.Tasks = nil
+			sj.Frameworks[i] = f
+		}
+		for i, ref := range tasks {
+			if current[i] != absent {
+				task, _ := task(i)
+				sj.Frameworks[ref.f].Tasks = append(sj.Frameworks[ref.f].Tasks, task)
+			}
+		}
+		return sj
+	}
+
+	rg := generate(polled())
+	first, firstRecords := rg, allRecords(rg)
+	rnd := rand.New(rand.NewSource(1456913692))
+	for step := 1; step <= 300; step++ {
+		i := rnd.Intn(len(tasks))
+		op := TaskAdd
+		if current[i] = rnd.Intn(3); current[i] == absent {
+			op = TaskRemove
+		}
+		task, f := task(i)
+		next, err := rg.ApplyTaskUpdate(task, f, op)
+		if err != nil {
+			t.Fatalf("step #%d: %v", step, err)
+		}
+		rg = next
+		if step%10 != 0 {
+			continue
+		}
+
+		want := generate(polled())
+		if got, want := allRecords(rg), allRecords(want); !reflect.DeepEqual(got, want) {
+			t.Fatalf("step #%d: got records %q, want %q", step, got, want)
+		}
+		if rg.Checksum() != want.Checksum() {
+			t.Errorf("step #%d: got checksum %s, want %s", step, rg.Checksum(), want.Checksum())
+		}
+		if got, want := rg.Stats.TotalRecords(), want.Stats.TotalRecords(); got != want {
+			t.Errorf("step #%d: got %d records counted, want %d", step, got, want)
+		}
+		for _, kind := range []rrsKind{A, AAAA, SRV} {
+			for _, name := range kind.rrs(rg).Names() {
+				for _, host := range kind.rrs(rg).Hosts(name) {
+					if host == "" {
+						t.Fatalf("step #%d: %s %s hosts ranked out of order: %v", step, kind, name, kind.rrs(rg).get(name))
+					}
+				}
+			}
+		}
+	}
+	if got := allRecords(first); !reflect.DeepEqual(got, firstRecords) {
+		t.Error("the snapshot updated from was modified")
+	}
+
+	capped := &RecordGenerator{maxRecords: 1, generation: rg.generation}
+	if _, err := capped.ApplyTaskUpdate(full.Frameworks[0].Tasks[0], full.Frameworks[0], TaskAdd); err == nil {
+		t.Error("applied an update despite the record cap")
+	}
+	if _, err := (&RecordGenerator{}).ApplyTaskUpdate(full.Frameworks[0].Tasks[0], full.Frameworks[0], TaskAdd); err == nil {
+		t.Error("applied an update before any state was inserted")
+	}
+}
+
+func TestApplyTaskUpdate_LargeState(t *testing.T) {
+	const slaves, apps, instances = 100, 1000, 10
+	sj := state.State{Leader: "master@10.0.0.1:5050"}
+	for i := 0; i < slaves; i++ {
+		sj.Slaves = append(sj.Slaves, slave("s"+strconv.Itoa(i), fmt.Sprintf("10.0.1.%d", i+1)))
+	}
+	fw := state.Framework{ID: "fw-1", Name: "marathon"}
+	for i := 0; i < apps*instances; i++ {
+		app := "app" + strconv.Itoa(i/instances)
+		fw.Tasks = append(fw.Tasks, discoveryTask(app+"."+strconv.Itoa(i), app, "s"+strconv.Itoa(i%slaves)))
+	}
+	generate := func(fw state.Framework) *RecordGenerator {
+		sj.Frameworks = []state.Framework{fw}
+		rg := &RecordGenerator{}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		return rg
+	}
+	rg := generate(fw)
+
+	added := discoveryTask("app0.new", "app0", "s1")
+	next, err := rg.ApplyTaskUpdate(added, fw, TaskAdd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.sum.sum != "" {
+		t.Error("checksum computed along with the update")
+	}
+	// only the records of the names of the task added are copied
+	var recs []EnumerableRecord
+	for _, f := range next.EnumData.Frameworks {
+		for _, task := range f.Tasks {
+			if task.ID == added.ID {
+				recs = append(recs, task.Records...)
+			}
+		}
+	}
+	copied := 0
+	for _, kind := range []rrsKind{A, AAAA, SRV, PTR, TXT} {
+		kind.rrs(next).each(func(name string, set *rrset) {
+			if kind.rrs(rg).set(name) != set {
+				copied++
+			}
+		})
+	}
+	if len(recs) == 0 || copied > len(recs) {
+		t.Errorf("got %d records copied for the %d of the task added", copied, len(recs))
+	}
+
+	fw.Tasks = append(fw.Tasks, added)
+	want := generate(fw)
+	if got, want := next.Checksum(), want.Checksum(); got != want {
+		t.Errorf("got checksum %s, want %s", got, want)
+	}
+	if next.sum.sum == "" {
+		t.Error("checksum not kept once computed")
+	}
+}
+
+func TestApplyTaskUpdate_CopyOnWrite(t *testing.T) {
+	sj := loadState(t, "../factories/fake.json")
+	rg := &RecordGenerator{}
+	if err := rg.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", nil, []string{"netinfo", "host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	enumTask := rg.EnumData.Frameworks[0].Tasks[0]
+	touched := map[string]bool{}
+	for _, rec := range enumTask.Records {
+		touched[rec.Name] = true
+	}
+	var f state.Framework
+	var task state.Task
+	for _, fw := range sj.Frameworks {
+		for _, tk := range fw.Tasks {
+			if tk.ID == enumTask.ID && fw.Name == rg.EnumData.Frameworks[0].Name {
+				f, task = fw, tk
+			}
+		}
+	}
+
+	next, err := rg.ApplyTaskUpdate(task, f, TaskRemove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the names the task doesn't touch are shared with the snapshot, those
+	// of its own records aren't
+	copied := 0
+	for _, kind := range []rrsKind{A, AAAA, SRV, PTR, TXT} {
+		kind.rrs(rg).each(func(name string, set *rrset) {
+			switch shared := kind.rrs(next).set(name) == set; {
+			case !shared && !touched[name]:
+				t.Errorf("%s records of %q copied, though untouched", kind, name)
+			case !shared:
+				copied++
+			}
+		})
+	}
+	if copied == 0 {
+		t.Error("no records of the task removed copied")
+	}
+	for name := range touched {
+		for _, listed := range next.listings.tasks(name) {
+			if listed == enumTask {
+				t.Errorf("task removed still listed under %q", name)
+			}
+		}
+	}
+}
+
+func TestInsertState_TTLs(t *testing.T) {
+	scheduler := func(ip string) state.PID {
+		return state.PID{UPID: &upid.UPID{ID: "scheduler(1)", Host: ip, Port: "8080"}}
+	}
+	marathon := state.Framework{ID: "fw-1", Name: "marathon", PID: scheduler("10.0.0.2"), Tasks: []state.Task{runningTask("web.1", "web", "s1")}}
+	sj := state.State{
+		Leader: "master@10.0.0.1:5050",
+		Frameworks: []state.Framework{
+			marathon,
+			{ID: "fw-2", Name: "chronos", PID: scheduler("10.0.0.3"), Tasks: []state.Task{discoveryTask("job.1", "job", "s1")}},
+		},
+		Slaves: []state.Slave{slave("s1", "10.0.1.1")},
+	}
+	rg := RecordGenerator{
+		frameworkDomains: []FrameworkDomain{{Framework: "marathon", Domain: "apps.example.internal", TTL: 30}},
+		ttlOverrides: []TTLOverride{
+			{Regexp: `leader`, TTL: 7}, // anchored, so matches no record
+			{Regexp: `leader\.mesos`, TTL: 5},
+			{Glob: "web.marathon.apps.example.internal", TTL: 1},
+			{Glob: "*.marathon.apps.example.internal", TTL: 10},
+		},
+	}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]int{
+		"leader.mesos.":                                   5,
+		"web.marathon.apps.example.internal.":             1,  // first override matching
+		"web.marathon.slave.apps.example.internal.":       30, // framework domain
+		"marathon.apps.example.internal.":                 30,
+		"job.chronos.mesos.":                              -1, // global
+		"master.mesos.":                                   -1,
+		"api.marathon.apps.example.internal.":             -1, // no record yet
+		"_leader._tcp.mesos.":                             -1,
+		"_framework._tcp.marathon.apps.example.internal.": 10, // later override matching
+	} {
+		kind := A
+		if strings.HasPrefix(name, "_") {
+			kind = SRV
+		}
+		got := -1 // the global TTL
+		if ttl, ok := rg.TTL(Record{Name: name, Type: string(kind)}); ok {
+			got = int(ttl)
+		}
+		if got != want {
+			t.Errorf("got TTL %d for %q, want %d", got, name, want)
+		}
+	}
+
+	// updated names get their TTLs anew
+	next, err := rg.ApplyTaskUpdate(runningTask("api.1", "api", "s1"), marathon, TaskAdd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := Record{Name: "api.marathon.apps.example.internal.", Type: string(A)}
+	if ttl, ok := next.TTL(api); !ok || ttl != 10 {
+		t.Errorf("got TTL %d, %t for an added task, want 10", ttl, ok)
+	}
+	if _, ok := rg.TTL(api); ok {
+		t.Error("update changed the TTLs of the snapshot it was applied to")
+	}
+	next, err = next.ApplyTaskUpdate(marathon.Tasks[0], marathon, TaskRemove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := next.TTL(Record{Name: "web.marathon.apps.example.internal.", Type: string(A)}); ok {
+		t.Error("kept the TTL of the records of a removed task")
+	}
+}
+
+func TestInsertState_HealthCheckTTLs(t *testing.T) {
+	checked := func(id, name string, interval float64) state.Task {
+		task := runningTask(id, name, "s1")
+		task.HealthCheck = &state.HealthCheck{IntervalSeconds: interval}
+		return task
+	}
+	ttl := func(rg *RecordGenerator, name string) int {
+		if ttl, ok := rg.TTL(Record{Name: name, Type: string(A)}); ok {
+			return int(ttl)
+		}
+		return -1 // the global TTL
+	}
+	slaves := []state.Slave{slave("s1", "10.0.1.1")}
+
+	for i, tt := range []struct {
+		interval float64
+		factor   float64
+		floor    uint32
+		want     int
+	}{
+		{10, 0, 0, 10}, // default factor
+		{10, 3, 0, 30},
+		{10, 10, 0, -1}, // never above the global TTL
+		{2, 1, 0, 5},    // default floor
+		{2, 1, 1, 2},
+		{2.5, 3, 1, 7},
+		{0, 1.5, 0, 15}, // default interval
+	} {
+		sj := state.State{
+			Leader:     "master@10.0.0.1:5050",
+			Frameworks: []state.Framework{{ID: "fw-1", Name: "marathon", Tasks: []state.Task{checked("web.1", "web", tt.interval)}}},
+			Slaves:     slaves,
+		}
+		rg := RecordGenerator{defaultTTL: 60, healthCheckTTLs: true, healthTTLFactor: tt.factor, healthTTLFloor: tt.floor}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"web.marathon.mesos.", "web.marathon.slave.mesos."} {
+			if got := ttl(&rg, name); got != tt.want {
+				t.Errorf("test #%d: got TTL %d for %q, want %d", i, got, name, tt.want)
+			}
+		}
+		if got := ttl(&rg, "leader.mesos."); got != -1 {
+			t.Errorf("test #%d: got TTL %d for leader.mesos., want the global one", i, got)
+		}
+	}
+
+	marathon := state.Framework{ID: "fw-1", Name: "marathon", Tasks: []state.Task{
+		checked("web.1", "web", 10),
+		runningTask("web.2", "web", "s1"),
+		runningTask("api.1", "api", "s1"),
+	}}
+	sj := state.State{
+		Leader: "master@10.0.0.1:5050",
+		Frameworks: []state.Framework{
+			marathon,
+			{ID: "fw-2", Name: "chronos", Tasks: []state.Task{checked("job.1", "job", 30)}},
+		},
+		Slaves: slaves,
+	}
+	rg := RecordGenerator{
+		defaultTTL:       60,
+		healthCheckTTLs:  true,
+		frameworkDomains: []FrameworkDomain{{Framework: "chronos", Domain: "jobs.example", TTL: 8}},
+		ttlOverrides:     []TTLOverride{{Glob: "web.marathon.slave.mesos", TTL: 120}},
+	}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int{
+		"web.marathon.mesos.":       10,  // shared with a task without health checks
+		"web.marathon.slave.mesos.": 120, // TTL overrides apply as is
+		"api.marathon.mesos.":       -1,
+		"job.chronos.jobs.example.": 8, // lower framework domain TTL
+	} {
+		if got := ttl(&rg, name); got != want {
+			t.Errorf("got TTL %d for %q, want %d", got, name, want)
+		}
+	}
+	for _, f := range rg.EnumData.Frameworks {
+		for _, task := range f.Tasks {
+			for _, rec := range task.Records {
+				if rec.Name == "web.marathon.mesos." && rec.TTL != 10 {
+					t.Errorf("got TTL %d for the enumerated record %+v, want 10", rec.TTL, rec)
+				}
+			}
+		}
+	}
+
+	// updated names get their TTLs anew
+	next, err := rg.ApplyTaskUpdate(marathon.Tasks[0], marathon, TaskRemove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, err = next.ApplyTaskUpdate(checked("api.2", "api", 20), marathon, TaskAdd); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int{"web.marathon.mesos.": -1, "api.marathon.mesos.": 20} {
+		if got := ttl(next, name); got != want {
+			t.Errorf("got TTL %d for %q after updates, want %d", got, name, want)
+		}
+	}
+
+	// TTLs are set per record kind: the TXT records of a name keep the
+	// global TTL while its A records get that of their health checks
+	rg = RecordGenerator{defaultTTL: 60, healthCheckTTLs: true, taskTXTRecords: true}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	if len(rg.TXTs.Hosts("web.marathon.mesos.")) == 0 {
+		t.Fatal("missing TXT records of web.marathon.mesos.")
+	}
+	if next, err = rg.ApplyTaskUpdate(checked("api.2", "api", 20), marathon, TaskAdd); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		rg   *RecordGenerator
+		name string
+		kind rrsKind
+		want int
+	}{
+		{&rg, "web.marathon.mesos.", A, 10},
+		{&rg, "web.marathon.mesos.", TXT, -1},
+		{next, "api.marathon.mesos.", A, 20},
+		{next, "api.marathon.mesos.", TXT, -1},
+	} {
+		got := -1
+		if ttl, ok := tt.rg.TTL(Record{Name: tt.name, Type: string(tt.kind)}); ok {
+			got = int(ttl)
+		}
+		if got != tt.want {
+			t.Errorf("got TTL %d for the %s records of %q, want %d", got, tt.kind, tt.name, tt.want)
+		}
+	}
+	for _, f := range rg.EnumData.Frameworks {
+		for _, task := range f.Tasks {
+			for _, rec := range task.Records {
+				if rec.Name == "web.marathon.mesos." && rec.Rtype == "TXT" && rec.TTL != 60 {
+					t.Errorf("got TTL %d for the enumerated record %+v, want 60", rec.TTL, rec)
+				}
+			}
+		}
+	}
+}
+
+func TestInsertState_RecordTransforms(t *testing.T) {
+	sj := loadState(t, "testdata/port_protocols.json")
+
+	// moves the records from mesos to dc1.example, SRV targets included
+	migrate := func(name string) string {
+		if strings.HasSuffix(name, ".mesos.") {
+			return strings.TrimSuffix(name, "mesos.") + "dc1.example."
+		}
+		return name
+	}
+	rename := func(rec Record) (Record, bool) {
+		rec.Name = migrate(rec.Name)
+		if target, port, err := net.SplitHostPort(rec.Host); rec.Type == "SRV" && err == nil {
+			rec.Host = net.JoinHostPort(migrate(target), port)
+		}
+		return rec, true
+	}
+	// drops the slave records of tasks and the SRV records targeting them,
+	// as renamed by the previous transform
+	drop := func(rec Record) (Record, bool) {
+		return rec, !strings.HasSuffix(rec.Name, ".slave.dc1.example.") &&
+			!strings.Contains(rec.Host, ".slave.dc1.example.:")
+	}
+
+	dropped := logging.CurLog.DroppedRecords.(*logging.LogCounter)
+	before := dropped.String()
+	rg := NewRecordGenerator(WithRecordTransform(rename), WithRecordTransform(drop))
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "testdata/transforms.golden", allRecords(rg))
+	if dropped.String() == before {
+		t.Error("expected dropped records to be counted")
+	}
+
+	// transforms can't publish invalid names
+	invalid := func(rec Record) (Record, bool) {
+		rec.Name = strings.Replace(rec.Name, "marathon", "mara..thon", 1)
+		return rec, true
+	}
+	rg = NewRecordGenerator(WithRecordTransform(invalid))
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []rrs{rg.As, rg.AAAAs, rg.SRVs} {
+		for _, name := range r.Names() {
+			if err := validateRecordName(name); err != nil {
+				t.Errorf("invalid record name %q published: %v", name, err)
+			}
+		}
+	}
+	if _, ok := rg.invalidNames["mara..thon.mesos."]; !ok {
+		t.Errorf("expected the transformed framework name to be reported as invalid, got %v", rg.invalidNames)
+	}
+}
+
+func TestReverseOwned(t *testing.T) {
+	rg := RecordGenerator{reverseNets: parseCIDRs([]string{"10.3.0.0/20", "fd00::/8"})}
+	for ip, want := range map[string]bool{
+		"10.3.0.0":    true,
+		"10.3.15.255": true,
+		"10.3.16.0":   false,
+		"10.2.255.1":  false,
+		"fd12::1":     true,
+		"fe80::1":     false,
+	} {
+		if got := rg.reverseOwned(net.ParseIP(ip)); got != want {
+			t.Errorf("got %t for %s, want %t", got, ip, want)
+		}
+	}
+}
+
+func TestInsertState_PortNameRecords(t *testing.T) {
+	sj := loadState(t, "testdata/port_protocols.json")
+
+	rg := RecordGenerator{portNameRecords: true}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "testdata/port_names.golden", allRecords(&rg))
+	// the SRV records are unchanged
+	checkGolden(t, "testdata/port_protocols.golden", srvRecords(&rg))
+
+	// port names colliding with the task names of nested frameworks are
+	// detected as such
+	scheduler := func(ip string) state.PID {
+		return state.PID{UPID: &upid.UPID{ID: "scheduler(1)", Host: ip, Port: "8080"}}
+	}
+	web := discoveryTask("web.1", "web", "s1")
+	web.DiscoveryInfo.Ports.DiscoveryPorts = []state.DiscoveryPort{{Name: "http", Number: 80, Protocol: "tcp"}}
+	sj = state.State{
+		Frameworks: []state.Framework{
+			{ID: "fw-1", Name: "marathon", PID: scheduler("10.0.0.2"), Tasks: []state.Task{web}},
+			{ID: "fw-2", Name: "web.marathon", PID: scheduler("10.0.0.3"), Tasks: []state.Task{runningTask("http.1", "http", "s1")}},
+		},
+		Slaves: []state.Slave{slave("s1", "10.0.1.1")},
+	}
+	rg = RecordGenerator{portNameRecords: true}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	var collided bool
+	for _, c := range rg.EnumData.Collisions {
+		collided = collided || c.Name == "http.web.marathon.mesos." && c.First.FrameworkID == "fw-1" && c.Second.FrameworkID == "fw-2"
+	}
+	if !collided {
+		t.Errorf("port name collision not detected, got collisions %+v", rg.EnumData.Collisions)
+	}
+}
+
+func TestInsertState_EnumeratedRecords(t *testing.T) {
+	web := discoveryTask("web.1", "web", "s1")
+	web.DiscoveryInfo.Ports.DiscoveryPorts = []state.DiscoveryPort{{Name: "http", Number: 31500, Protocol: "tcp"}}
+	sj := state.State{
+		Frameworks: []state.Framework{{ID: "fw-1", Name: "marathon", Tasks: []state.Task{web}}},
+		Slaves:     []state.Slave{slave("s1", "10.0.1.1")},
+	}
+	// targets an IPv6 address rather than the task name
+	ipv6 := func(rec Record) (Record, bool) {
+		if _, port, err := net.SplitHostPort(rec.Host); rec.Name == "_http._web._tcp.marathon.mesos." && err == nil {
+			rec.Host = net.JoinHostPort("fd00::1", port)
+		}
+		return rec, true
+	}
+	rg := NewRecordGenerator(WithRecordTransform(ipv6))
+	rg.defaultTTL = 60
+	rg.ttlOverrides = []TTLOverride{{Glob: "web.marathon.mesos", TTL: 5}}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := rg.EnumData.Frameworks[0].Tasks[0].Records
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].Rtype+" "+recs[i].Name+" "+recs[i].Host < recs[j].Rtype+" "+recs[j].Name+" "+recs[j].Host
+	})
+	b, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "testdata/enumerated_records.golden", strings.Split(string(b), "\n"))
+}
+
+func TestLookup(t *testing.T) {
+	rg := &RecordGenerator{
+		As: rrsOf(map[string]map[string]int{
+			"web.marathon.mesos.":   {"10.0.0.1": 0, "10.0.0.2": 1},
+			"*.marathon.mesos.":     {"10.0.0.9": 0},
+			"*.mesos.":              {"10.0.0.8": 0},
+			"*.api.marathon.mesos.": {"10.0.0.7": 0},
+		}),
+		AAAAs: rrsOf(map[string]map[string]int{"db.marathon.mesos.": {"fd01::1": 0}}),
+		SRVs:  rrsOf(map[string]map[string]int{"_web._tcp.marathon.mesos.": {"web.marathon.mesos.:80": 0}}),
+	}
+	for _, tt := range []struct {
+		name string
+		kind rrsKind
+		want []Record
+	}{
+		// exact matches are normalized
+		{"web.marathon.mesos.", A, []Record{{"web.marathon.mesos.", "A", "10.0.0.1"}, {"web.marathon.mesos.", "A", "10.0.0.2"}}},
+		{"WEB.Marathon.mesos", A, []Record{{"web.marathon.mesos.", "A", "10.0.0.1"}, {"web.marathon.mesos.", "A", "10.0.0.2"}}},
+		{"_web._tcp.marathon.mesos", SRV, []Record{{"_web._tcp.marathon.mesos.", "SRV", "web.marathon.mesos.:80"}}},
+		{"web.marathon.mesos.", AAAA, nil},
+		// names of their own take precedence over wildcards, of any kind
+		{"db.marathon.mesos.", A, nil},
+		{"db.marathon.mesos.", AAAA, []Record{{"db.marathon.mesos.", "AAAA", "fd01::1"}}},
+		// the closest wildcard answers the others
+		{"api.marathon.mesos.", A, []Record{{"*.marathon.mesos.", "A", "10.0.0.9"}}},
+		{"v1.api.marathon.mesos.", A, []Record{{"*.api.marathon.mesos.", "A", "10.0.0.7"}}},
+		{"a.b.api.marathon.mesos", A, []Record{{"*.api.marathon.mesos.", "A", "10.0.0.7"}}},
+		{"marathon.mesos.", A, []Record{{"*.mesos.", "A", "10.0.0.8"}}},
+		{"mesos.", A, nil},
+		{"api.marathon.mesos.", SRV, nil},
+		{"web.marathon.dcos.", A, nil},
+	} {
+		if got := rg.Lookup(tt.name, tt.kind); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lookup(%q, %s): got %v, want %v", tt.name, tt.kind, got, tt.want)
+		}
+	}
+	for name, want := range map[string]bool{
+		"DB.marathon.mesos":   true,
+		"api.marathon.mesos.": true,
+		"mesos.":              false,
+		"leader.dcos.":        false,
+	} {
+		if got := rg.Exists(name); got != want {
+			t.Errorf("Exists(%q): got %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestLookupSubtree(t *testing.T) {
+	rg := &RecordGenerator{
+		As: rrsOf(map[string]map[string]int{
+			"marathon.mesos.":          {"10.0.0.1": 0},
+			"web.marathon.mesos.":      {"10.0.0.2": 0, "10.0.0.3": 1},
+			"*.marathon.mesos.":        {"10.0.0.9": 0},
+			"dcos-marathon.mesos.":     {"10.0.0.4": 0},
+			"web.marathon.mesos.dcos.": {"10.0.0.5": 0},
+		}),
+		AAAAs: rrsOf(map[string]map[string]int{"db.marathon.mesos.": {"fd01::1": 0}}),
+		SRVs:  rrsOf(map[string]map[string]int{"_web._tcp.marathon.mesos.": {"web.marathon.mesos.:80": 0}}),
+	}
+	want := []Record{
+		{"*.marathon.mesos.", "A", "10.0.0.9"},
+		{"marathon.mesos.", "A", "10.0.0.1"},
+		{"web.marathon.mesos.", "A", "10.0.0.2"},
+		{"web.marathon.mesos.", "A", "10.0.0.3"},
+		{"db.marathon.mesos.", "AAAA", "fd01::1"},
+		{"_web._tcp.marathon.mesos.", "SRV", "web.marathon.mesos.:80"},
+	}
+	for _, suffix := range []string{"marathon.mesos.", "Marathon.MESOS"} {
+		if got := rg.LookupSubtree(suffix); !reflect.DeepEqual(got, want) {
+			t.Errorf("LookupSubtree(%q): got %v, want %v", suffix, got, want)
+		}
+	}
+	if got := rg.LookupSubtree("athon.mesos."); got != nil {
+		t.Errorf("LookupSubtree(%q): got %v, want none across label boundaries", "athon.mesos.", got)
+	}
+	if got := rg.LookupSubtree("web.marathon.mesos."); len(got) != 2 {
+		t.Errorf("LookupSubtree(%q): got %v, want the 2 records of the name", "web.marathon.mesos.", got)
+	}
+}
+
+func TestInsertState_PTRRecords(t *testing.T) {
+	db := runningTask("db.1", "db", "s2")
+	db.Statuses = []state.Status{{
+		State:     "TASK_RUNNING",
+		Timestamp: 1,
+		Labels:    []state.Label{{Key: state.MesosIPLabel, Value: "10.0.2.5"}},
+	}}
+	fw := state.Framework{ID: "fw-1", Name: "marathon", Tasks: []state.Task{
+		runningTask("web.1", "web", "s1"),
+		runningTask("web.2", "web", "s1"),
+		db,
+		runningTask("api.1", "api", "s3"),
+		runningTask("cache.1", "cache", "s4"),
+	}}
+	sj := state.State{
+		Leader:     "master@10.0.0.1:5050",
+		Frameworks: []state.Framework{fw},
+		Slaves: []state.Slave{
+			slave("s1", "10.0.1.1"),
+			slave("s2", "10.0.1.2"),
+			slave("s3", "192.168.0.3"),
+			slave("s4", "fd00::4"),
+		},
+	}
+	rg := &RecordGenerator{reverseNets: parseCIDRs([]string{"10.0.0.0/8", "fd00::/8"})}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", []string{"10.0.0.1:5050"}, []string{"mesos", "host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	canonical := func(name, id, slaveID string) string {
+		return name + "-" + hashString(id) + "-" + slaveIDTail(slaveID) + ".marathon"
+	}
+	want := map[string]map[string]int{
+		"1.0.0.10.in-addr.arpa.": {"leader.mesos.": 0, "master0.mesos.": 1},
+		// the tasks sharing the address of their slave each get a record
+		"1.1.0.10.in-addr.arpa.": {
+			"slave.mesos.": 0,
+			canonical("web", "web.1", "s1") + ".mesos.": 1,
+			canonical("web", "web.2", "s1") + ".mesos.": 2,
+		},
+		// tasks with addresses of their own get a record of each, and one
+		// of their name on the slave
+		"2.1.0.10.in-addr.arpa.": {"slave.mesos.": 0, canonical("db", "db.1", "s2") + ".slave.mesos.": 1},
+		"5.2.0.10.in-addr.arpa.": {canonical("db", "db.1", "s2") + ".mesos.": 0},
+		reverseName(net.ParseIP("fd00::4")): {
+			"slave.mesos.": 0,
+			canonical("cache", "cache.1", "s4") + ".mesos.": 1,
+		},
+	}
+	if got := rrsRanks(rg.PTRs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got PTR records %v, want %v", got, want)
+	}
+	if got, want := reverseName(net.ParseIP("fd00::4")), "4.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa."; got != want {
+		t.Errorf("got reverse name %q, want %q", got, want)
+	}
+
+	// the records of removed tasks go, those of the others sharing their
+	// slave stay
+	next, err := rg.ApplyTaskUpdate(fw.Tasks[1], fw, TaskRemove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := next.PTRs.Hosts("1.1.0.10.in-addr.arpa."); !reflect.DeepEqual(got, []string{"slave.mesos.", canonical("web", "web.1", "s1") + ".mesos."}) {
+		t.Errorf("got PTR records %v after removing web.2", got)
+	}
+}
+
+func TestInsertState_TaskTXTRecords(t *testing.T) {
+	web := discoveryTask("web.1", "web", "s1")
+	web.DiscoveryInfo.Labels.Labels = []state.Label{
+		{Key: "team", Value: `say "hi"`},
+		// truncated before the multibyte character straddling the limit
+		{Key: "notes", Value: strings.Repeat("x", 248) + "é"},
+	}
+	fw := state.Framework{ID: "fw-1", Name: "marathon", Tasks: []state.Task{web}}
+	sj := state.State{Frameworks: []state.Framework{fw}, Slaves: []state.Slave{slave("s1", "10.0.1.1")}}
+	rg := RecordGenerator{taskTXTRecords: true}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	want := `"task-id=web.1" "slave-id=s1" "framework=marathon" "state=TASK_RUNNING" ` +
+		`"team=say \"hi\"" "notes=` + strings.Repeat("x", 248) + `"`
+	canonical := "web-" + hashString("web.1") + "-" + slaveIDTail("s1") + ".marathon.mesos."
+	for _, name := range []string{"web.marathon.mesos.", canonical} {
+		if got := rg.TXTs.Hosts(name); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("%s: got TXT records %q, want %q", name, got, want)
+		}
+	}
+	var enumerated int
+	for _, rec := range rg.EnumData.Frameworks[0].Tasks[0].Records {
+		if rec.Rtype == "TXT" {
+			enumerated++
+		}
+	}
+	if enumerated != 2 {
+		t.Errorf("got %d TXT records enumerated, want 2", enumerated)
+	}
+	if got := quoteTXT("a\tb\xff"); got != `"a\009b\255"` {
+		t.Errorf("got %s, want non printable octets escaped", got)
+	}
+}
+
+func TestStoreRR_NilMaps(t *testing.T) {
+	// generators which didn't go through InsertState get their record
+	// maps allocated as needed
+	var rg RecordGenerator
+	if !rg.insertRR("master0.mesos.", "2001:db8::1", AAAA) || !rg.insertRR("master0.mesos.", "10.0.0.1", A) {
+		t.Fatal("records not inserted")
+	}
+	if got := rg.AAAAs.Hosts("master0.mesos."); !reflect.DeepEqual(got, []string{"2001:db8::1"}) {
+		t.Errorf("got AAAA records %v", got)
+	}
+	// records of unknown kinds are dropped
+	if rg.insertRR("master0.mesos.", "10.0.0.1", rrsKind("MX")) {
+		t.Error("record of unknown kind inserted")
+	}
+}
+
+func TestRRs_Remove(t *testing.T) {
+	r := rrs{}
+	for _, host := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		r.Add("web.mesos.", host)
+	}
+	if r.remove("web.mesos.", "10.0.0.9") || r.remove("api.mesos.", "10.0.0.1") {
+		t.Error("removed missing host")
+	}
+	if !r.remove("web.mesos.", "10.0.0.2") {
+		t.Fatal("host not removed")
+	}
+	if got, want := r.Hosts("web.mesos."), []string{"10.0.0.1", "10.0.0.3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got hosts %v, want %v", got, want)
+	}
+	if r.Add("web.mesos.", "10.0.0.4"); !reflect.DeepEqual(r.get("web.mesos."), map[string]int{"10.0.0.1": 0, "10.0.0.3": 1, "10.0.0.4": 2}) {
+		t.Errorf("got ranks %v", r.get("web.mesos."))
+	}
+	r.remove("web.mesos.", "10.0.0.1")
+	r.remove("web.mesos.", "10.0.0.3")
+	r.remove("web.mesos.", "10.0.0.4")
+	if r.set("web.mesos.") != nil || r.Len() != 0 {
+		t.Error("name kept without hosts")
+	}
+}
+
+func TestInsertState_SRVWeights(t *testing.T) {
+	web := discoveryTask("web.1", "web", "s1")
+	web.Resources.PortRanges = "[31000-31000]"
+	web.DiscoveryInfo.Labels.Labels = []state.Label{
+		{Key: "priority", Value: "1"},
+		{Key: "weight", Value: "10"},
+	}
+	var http, admin state.DiscoveryPort
+	http.Number, http.Name, http.Protocol = 80, "http", "tcp"
+	admin.Number, admin.Name, admin.Protocol = 81, "admin", "tcp"
+	admin.Labels.Labels = []state.Label{{Key: "weight", Value: "5"}, {Key: "priority", Value: "-1"}}
+	web.DiscoveryInfo.Ports.DiscoveryPorts = []state.DiscoveryPort{http, admin}
+	api := discoveryTask("api.1", "api", "s1")
+	api.Resources.PortRanges = "[31001-31001]"
+
+	fw := state.Framework{ID: "fw-1", Name: "marathon", Tasks: []state.Task{web, api}}
+	sj := state.State{Frameworks: []state.Framework{fw}, Slaves: []state.Slave{slave("s1", "10.0.1.1")}}
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	target := "web-" + hashString("web.1") + "-" + slaveIDTail("s1") + ".marathon"
+	apiTarget := "api-" + hashString("api.1") + "-" + slaveIDTail("s1") + ".marathon.slave.mesos."
+	web80, web81 := SRVData{target + ".mesos.", 80, 1, 10}, SRVData{target + ".mesos.", 81, 1, 5}
+	for name, want := range map[string][]SRVData{
+		// task level labels apply to every port, port level ones take
+		// precedence, invalid values are ignored
+		"_web._tcp.marathon.mesos.":        {web80, web81},
+		"_admin._web._tcp.marathon.mesos.": {web81},
+		"_web._tcp.marathon.slave.mesos.":  {{target + ".slave.mesos.", 31000, 1, 10}},
+		// without labels, records are unweighted as ever
+		"_api._tcp.marathon.slave.mesos.": {{apiTarget, 31001, 0, 0}},
+	} {
+		// the hosts of weighted records are target:port, as those of others
+		var got []SRVData
+		for _, rec := range rg.Lookup(name, SRV) {
+			d, err := rg.SRVData(rec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Host != d.String() {
+				t.Errorf("%s: got SRV host %q, want %q", name, rec.Host, d.String())
+			}
+			got = append(got, d)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got SRV records %+v, want %+v", name, got, want)
+		}
+	}
+	for _, task := range rg.EnumData.Frameworks[0].Tasks {
+		for _, rec := range task.Records {
+			if rec.Name == "_web._tcp.marathon.mesos." && rec.Port == 81 && (rec.Priority != 1 || rec.Weight != 5) {
+				t.Errorf("got enumerated SRV record %+v, want priority 1 and weight 5", rec)
+			}
+		}
+	}
+
+	// incremental updates keep the priorities and weights of the records
+	// along with them
+	checksum := rg.Checksum()
+	api.DiscoveryInfo.Labels.Labels = []state.Label{{Key: "weight", Value: "20"}}
+	next, err := rg.ApplyTaskUpdate(api, fw, TaskAdd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := Record{Name: "_api._tcp.marathon.slave.mesos.", Type: SRV, Host: apiTarget + ":31001"}
+	if d, err := next.SRVData(rec); err != nil || d.Weight != 20 {
+		t.Errorf("got SRV record %+v, %v, want weight 20", d, err)
+	}
+	if d, err := rg.SRVData(rec); err != nil || d.Weight != 0 {
+		t.Errorf("got SRV record %+v, %v of the snapshot updated, want it unweighted", d, err)
+	}
+	if next.Checksum() == checksum {
+		t.Error("got the same checksum for records weighted differently")
+	}
+	if next, err = next.ApplyTaskUpdate(api, fw, TaskRemove); err != nil {
+		t.Fatal(err)
+	}
+	if set := next.SRVs.set(rec.Name); set != nil {
+		t.Errorf("got SRV records %v of the task removed", set)
+	}
+}
+
+func TestParseSRV(t *testing.T) {
+	for i, tt := range []struct {
+		host string
+		want SRVData
+		err  string
+	}{
+		{"web.mesos.:80", SRVData{Target: "web.mesos.", Port: 80}, ""},
+		{"[2001:db8::1]:80", SRVData{Target: "2001:db8::1", Port: 80}, ""},
+		{"web.mesos.", SRVData{}, `invalid SRV target "web.mesos."`},
+		{"web.mesos.:65536", SRVData{}, `invalid SRV port "65536"`},
+		// hosts don't hold priorities and weights, see RecordGenerator.SRVData
+		{"1 10 web.mesos.:80", SRVData{}, `invalid SRV target "1 10 web.mesos.:80"`},
+	} {
+		got, err := ParseSRV(tt.host)
+		if (err != nil || tt.err != "") && fmt.Sprint(err) != tt.err {
+			t.Errorf("test #%d: got error %v, want %q", i, err, tt.err)
+			continue
+		}
+		if err == nil && (got != tt.want || got.String() != tt.host) {
+			t.Errorf("test #%d: got %+v (%s), want %+v", i, got, got, tt.want)
+		}
+	}
+}
+
+func TestInsertState_FrameworkFilter(t *testing.T) {
+	framework := func(id, name string) state.Framework {
+		return state.Framework{
+			ID:    id,
+			Name:  name,
+			PID:   state.PID{UPID: &upid.UPID{ID: "scheduler", Host: "10.0.0.1", Port: "8080"}},
+			Tasks: []state.Task{runningTask(id+".1", "web", "s1"), runningTask(id+".2", "api", "s1")},
+		}
+	}
+	sj := state.State{
+		Frameworks: []state.Framework{
+			framework("fw-1", "marathon"),
+			framework("fw-2", "spark-etl"),
+			// whitelisted and blacklisted, once normalized
+			framework("fw-3", "Spark Batch"),
+			framework("fw-4", "chronos"),
+		},
+		Slaves: []state.Slave{slave("s1", "10.0.1.1")},
+	}
+	rg := RecordGenerator{
+		frameworkWhitelist: []string{"marathon", "spark-*"},
+		frameworkBlacklist: []string{"spark-batch"},
+	}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"marathon.mesos.":        true,
+		"web.marathon.mesos.":    true,
+		"spark-etl.mesos.":       true,
+		"api.spark-etl.mesos.":   true,
+		"spark-batch.mesos.":     false,
+		"web.spark-batch.mesos.": false,
+		"chronos.mesos.":         false,
+		"web.chronos.mesos.":     false,
+	} {
+		if got := len(rg.As.get(name)) > 0; got != want {
+			t.Errorf("%s: got A records %v, want %v", name, got, want)
+		}
+	}
+	if want := map[string]int{"Spark Batch": 2, "chronos": 2}; !reflect.DeepEqual(rg.Stats.Filtered, want) {
+		t.Errorf("got filtered frameworks %v, want %v", rg.Stats.Filtered, want)
+	}
+	if got := rg.Stats.Skipped[SkipFiltered]; got != 4 {
+		t.Errorf("got %d filtered tasks, want 4", got)
+	}
+	if rg.Stats.Tasks != 8 || rg.Stats.Frameworks != 4 {
+		t.Errorf("got %d tasks of %d frameworks, want 8 of 4", rg.Stats.Tasks, rg.Stats.Frameworks)
+	}
+
+	// updates of the tasks of filtered out frameworks change nothing
+	next, err := rg.ApplyTaskUpdate(runningTask("fw-4.3", "db", "s1"), sj.Frameworks[3], TaskAdd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != &rg {
+		t.Error("update of a filtered out framework's task generated records")
+	}
+}
+
+func TestInsertState_MinimumVisibility(t *testing.T) {
+	visible := func(id, name, visibility string) state.Task {
+		t := discoveryTask(id, name, "s1")
+		t.DiscoveryInfo.Visibilty = visibility
+		return t
+	}
+	sj := state.State{
+		Frameworks: []state.Framework{{ID: "fw-1", Name: "marathon", Tasks: []state.Task{
+			visible("internal.1", "internal", VisibilityFramework),
+			visible("web.1", "web", VisibilityCluster),
+			visible("public.1", "public", "external"),
+			// no DiscoveryInfo
+			runningTask("legacy.1", "legacy", "s1"),
+		}}},
+		Slaves: []state.Slave{slave("s1", "10.0.1.1")},
+	}
+	rg := RecordGenerator{minVisibility: VisibilityCluster}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"internal.marathon.mesos.": false,
+		"web.marathon.mesos.":      true,
+		"public.marathon.mesos.":   true,
+		"legacy.marathon.mesos.":   true,
+	} {
+		if got := len(rg.As.get(name)) > 0; got != want {
+			t.Errorf("%s: got A records %v, want %v", name, got, want)
+		}
+	}
+	if got := rg.Stats.Skipped[SkipVisibility]; got != 1 {
+		t.Errorf("got %d tasks skipped for their visibility, want 1", got)
+	}
+	tasks := rg.EnumData.Frameworks[0].Tasks
+	if len(tasks) != 4 {
+		t.Fatalf("got %d enumerated tasks, want 4", len(tasks))
+	}
+	if task := tasks[0]; task.ID != "internal.1" || task.Skipped != SkipVisibility || len(task.Records) != 0 {
+		t.Errorf("got enumerated task %+v, want internal.1 skipped for its visibility, without records", task)
+	}
+}
+
+func TestInsertState_DualStackSlave(t *testing.T) {
+	sj := loadState(t, "testdata/dual_stack.json")
+	// pids must resolve to be parsed, so the agent's is set by hand
+	sj.Slaves[0].PID.Host = sj.Slaves[0].Hostname
+	rg := RecordGenerator{hosts: newHostResolver(nil, nil, 0, false, "testdata/dual_stack.hosts")}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	const slaveID = "20160107-001256-134875658-5050-27524-S1"
+	if got, want := rg.SlaveIPs[slaveID], []string{"10.0.1.1", "10.0.2.1", "2001:db8::1:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got slave IPs %q, want %q", got, want)
+	}
+	canonical := "web-" + hashString("web.8f1b2c04-b5a4-11e5-9ef5-0242ac110002") + "-" + slaveIDTail(slaveID) + ".marathon"
+	for _, tt := range []struct {
+		name      string
+		kind      rrsKind
+		addresses []string
+	}{
+		{"slave.mesos.", A, []string{"10.0.1.1", "10.0.2.1"}},
+		{"slave.mesos.", AAAA, []string{"2001:db8::1:1"}},
+		{"web.marathon.slave.mesos.", A, []string{"10.0.1.1", "10.0.2.1"}},
+		{"web.marathon.slave.mesos.", AAAA, []string{"2001:db8::1:1"}},
+		{canonical + ".slave.mesos.", A, []string{"10.0.1.1", "10.0.2.1"}},
+		{canonical + ".slave.mesos.", AAAA, []string{"2001:db8::1:1"}},
+		// as do the task records of the host IP source
+		{"web.marathon.mesos.", A, []string{"10.0.1.1", "10.0.2.1"}},
+		{"web.marathon.mesos.", AAAA, []string{"2001:db8::1:1"}},
+	} {
+		if got := tt.kind.rrs(&rg).Hosts(tt.name); !reflect.DeepEqual(got, tt.addresses) {
+			t.Errorf("%s %s: got %q, want %q", tt.name, tt.kind, got, tt.addresses)
+		}
+	}
+}
+
+func TestInsertState_TaskHashCollision(t *testing.T) {
+	// both task IDs hash to h8g8j
+	sj := loadState(t, "testdata/hash_collision.json")
+	if hashString("web.1572") != hashString("web.1751") {
+		t.Fatal("task IDs of the fixture don't collide")
+	}
+
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	first := "web-" + hashString("web.1572") + "-s1.marathon.mesos."
+	second := "web-" + rg.taskHash("web.1751", 6) + "-s1.marathon.mesos."
+	for name, want := range map[string][]string{first: {"10.0.1.1"}, second: {"10.0.1.1"}} {
+		if got := rg.As.Hosts(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got A records %q, want %q", name, got, want)
+		}
+	}
+	if got := rg.Stats.Events[EventHashCollision][SourceTask]; got != 1 {
+		t.Errorf("got %d hash collisions, want 1", got)
+	}
+
+	// the task keeps its longer hash as long as the other one runs, and
+	// gets the usual one back once it's gone
+	next, err := rg.ApplyTaskUpdate(sj.Frameworks[0].Tasks[1], sj.Frameworks[0], TaskAdd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := next.As.Hosts(second); len(got) != 1 {
+		t.Errorf("%s: got A records %q after an update, want one", second, got)
+	}
+	if next, err = next.ApplyTaskUpdate(sj.Frameworks[0].Tasks[0], sj.Frameworks[0], TaskRemove); err != nil {
+		t.Fatal(err)
+	}
+	if next, err = next.ApplyTaskUpdate(sj.Frameworks[0].Tasks[1], sj.Frameworks[0], TaskAdd); err != nil {
+		t.Fatal(err)
+	}
+	if got := next.As.Hosts(first); len(got) != 1 {
+		t.Errorf("%s: got A records %q once the other task is gone, want one", first, got)
+	}
+}
+
+func TestInsertState_TaskHash(t *testing.T) {
+	sj := loadState(t, "testdata/hash_collision.json")
+	rg := RecordGenerator{taskHashLength: 8, taskHashAlgorithm: TaskHashSHA256}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("web.1572"))
+	name := "web-" + zbase32.EncodeToString(sum[:])[:8] + "-s1.marathon.mesos."
+	if got := rg.As.Hosts(name); !reflect.DeepEqual(got, []string{"10.0.1.1"}) {
+		t.Errorf("%s: got A records %q, want 10.0.1.1", name, got)
+	}
+	if got := rg.Stats.Events[EventHashCollision][SourceTask]; got != 0 {
+		t.Errorf("got %d hash collisions, want none", got)
+	}
+}
+
+func TestInsertState_HostPortRecords(t *testing.T) {
+	web := discoveryTask("web.1", "web", "s1")
+	var http, admin, metrics state.DiscoveryPort
+	http.Number, http.Name, http.Protocol = 80, "http", "tcp"
+	admin.Number, admin.Name, admin.Protocol = 81, "admin", "tcp"
+	// not mapped
+	metrics.Number, metrics.Protocol = 9090, "tcp"
+	web.DiscoveryInfo.Ports.DiscoveryPorts = []state.DiscoveryPort{http, admin, metrics}
+	web.Statuses = []state.Status{{State: "TASK_RUNNING", ContainerStatus: state.ContainerStatus{
+		NetworkInfos: []state.NetworkInfo{{
+			IPAddresses: []state.IPAddress{{IPAddress: "172.17.0.2"}},
+			PortMappings: []state.PortMapping{
+				{HostPort: 31000, ContainerPort: 80, Protocol: "tcp"},
+				{HostPort: 31001, ContainerPort: 81},
+				// of another protocol
+				{HostPort: 31002, ContainerPort: 80, Protocol: "udp"},
+			},
+		}},
+	}}}
+	sj := state.State{
+		Frameworks: []state.Framework{{ID: "fw-1", Name: "marathon", Tasks: []state.Task{web}}},
+		Slaves:     []state.Slave{slave("s1", "10.0.1.1")},
+	}
+
+	target := "web-" + hashString("web.1") + "-" + slaveIDTail("s1") + ".marathon.slave.mesos."
+	for _, enabled := range []bool{false, true} {
+		rg := RecordGenerator{hostPortRecords: enabled}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"netinfo", "host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range map[string][]string{
+			"_web._tcp.hostport.marathon.mesos.":        {target + ":31000", target + ":31001"},
+			"_http._web._tcp.hostport.marathon.mesos.":  {target + ":31000"},
+			"_admin._web._tcp.hostport.marathon.mesos.": {target + ":31001"},
+		} {
+			if !enabled {
+				want = []string{}
+			}
+			if got := rg.SRVs.Hosts(name); !reflect.DeepEqual(got, want) {
+				t.Errorf("enabled %t: %s: got SRV records %q, want %q", enabled, name, got, want)
+			}
+		}
+		// the container ports are published as ever
+		if got := rg.SRVs.Hosts("_http._web._tcp.marathon.mesos."); len(got) != 1 {
+			t.Errorf("enabled %t: got container port SRV records %q, want one", enabled, got)
+		}
+	}
+}
+
+func TestInsertState_LabelTTLs(t *testing.T) {
+	labeled := func(id, name, ttl string) state.Task {
+		task := runningTask(id, name, "s1")
+		task.DiscoveryInfo.Labels.Labels = []state.Label{{Key: TTLLabel, Value: ttl}}
+		return task
+	}
+	ttl := func(rg *RecordGenerator, name string) int {
+		if ttl, ok := rg.TTL(Record{Name: name, Type: string(A)}); ok {
+			return int(ttl)
+		}
+		return -1 // the global TTL
+	}
+	marathon := state.Framework{ID: "fw-1", Name: "marathon", Tasks: []state.Task{
+		labeled("web.1", "web", "5"),
+		labeled("web.2", "web", "30"),
+		labeled("api.1", "api", " 0 "),
+		labeled("db.1", "db", "86401"), // out of range
+		labeled("db.2", "db", "-1"),
+		labeled("db.3", "db", "soon"),
+		labeled("canary.1", "canary", "300"),
+	}}
+	sj := state.State{
+		Leader:     "master@10.0.0.1:5050",
+		Frameworks: []state.Framework{marathon},
+		Slaves:     []state.Slave{slave("s1", "10.0.1.1")},
+	}
+	rg := RecordGenerator{
+		defaultTTL:   60,
+		ttlOverrides: []TTLOverride{{Glob: "canary.marathon.slave.mesos", TTL: 120}},
+	}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int{
+		"web.marathon.mesos.":          5, // the shortest of the tasks sharing it
+		"api.marathon.mesos.":          0,
+		"db.marathon.mesos.":           -1,
+		"canary.marathon.mesos.":       300, // above the global TTL
+		"canary.marathon.slave.mesos.": 120, // TTL overrides take precedence
+		"leader.mesos.":                -1,
+	} {
+		if got := ttl(&rg, name); got != want {
+			t.Errorf("got TTL %d for %q, want %d", got, name, want)
+		}
+	}
+
+	// updated names get their TTLs anew
+	next, err := rg.ApplyTaskUpdate(marathon.Tasks[0], marathon, TaskRemove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, err = next.ApplyTaskUpdate(marathon.Tasks[2], marathon, TaskRemove); err != nil {
+		t.Fatal(err)
+	}
+	if next, err = next.ApplyTaskUpdate(labeled("db.4", "db", "10"), marathon, TaskAdd); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int{"web.marathon.mesos.": 30, "db.marathon.mesos.": 10} {
+		if got := ttl(next, name); got != want {
+			t.Errorf("got TTL %d for %q after updates, want %d", got, name, want)
+		}
+	}
+}
+
+func TestInsertState_LegacyDiscoveryNames(t *testing.T) {
+	sj := state.State{
+		Leader: "master@10.0.0.1:5050",
+		Slaves: []state.Slave{slave("s1", "10.0.1.1")},
+		Frameworks: []state.Framework{{
+			ID:    "fw-1",
+			Name:  "marathon",
+			Tasks: []state.Task{discoveryTask("web.1", "Web_App", "s1")},
+		}},
+	}
+	for _, tt := range []struct {
+		noLegacy bool
+		want     bool
+	}{
+		{false, true},
+		{true, false},
+	} {
+		rg := RecordGenerator{noLegacyDiscoveryNames: tt.noLegacy}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(rg.As.get("web_app.marathon.mesos.")) > 0; got != tt.want {
+			t.Errorf("noLegacy=%t: got raw name records %t, want %t", tt.noLegacy, got, tt.want)
+		}
+		if got := rg.As.get("web-app.marathon.mesos."); len(got) != 1 {
+			t.Errorf("noLegacy=%t: got %v for the normalized name, want one address", tt.noLegacy, got)
+		}
+		raw := false
+		for _, f := range rg.EnumData.Frameworks {
+			for _, task := range f.Tasks {
+				for _, rec := range task.Records {
+					raw = raw || strings.HasPrefix(rec.Name, "web_app.")
+				}
+			}
+		}
+		if raw != tt.want {
+			t.Errorf("noLegacy=%t: got enumerated raw name records %t, want %t", tt.noLegacy, raw, tt.want)
+		}
+	}
+}
+
+func TestInsertState_Pods(t *testing.T) {
+	sj := loadState(t, "testdata/pods.json")
+
+	rg := RecordGenerator{podRecords: true}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"netinfo", "host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "testdata/pods.golden", allRecords(&rg))
+
+	pods := map[string]string{}
+	for _, f := range rg.EnumData.Frameworks {
+		for _, task := range f.Tasks {
+			pods[task.ID] = task.Pod
+		}
+	}
+	for id, want := range map[string]string{
+		"web.instance-1f8a3c2e-bbb7-11e6-8e2f-0242ac110002.nginx":       "web",
+		"web.instance-2a7c9d31-bbb7-11e6-8e2f-0242ac110002.log-shipper": "web",
+		"api.5c3e1f42-bbb7-11e6-8e2f-0242ac110002":                      "", // command task
+	} {
+		if got := pods[id]; got != want {
+			t.Errorf("got pod %q for task %q, want %q", got, id, want)
+		}
+	}
+}
+
+func TestPodName(t *testing.T) {
+	for _, tt := range []struct{ executorID, want string }{
+		{"instance-web.1f8a3c2e-bbb7-11e6-8e2f-0242ac110002", "web"},
+		{"instance-dev_web.1f8a3c2e-bbb7-11e6-8e2f-0242ac110002", "dev_web"},
+		{"instance-web", "web"},
+		{"thermos-web-0", "thermos-web-0"},
+	} {
+		if got := podName(tt.executorID); got != tt.want {
+			t.Errorf("podName(%q) = %q, want %q", tt.executorID, got, tt.want)
+		}
+	}
+}
+
+func TestInsertState_AttributeRecords(t *testing.T) {
+	sj := loadState(t, "testdata/attributes.json")
+
+	// gpu is an attribute of no slave
+	rg := RecordGenerator{attributeRecords: []string{"rack", "zone", "gpu"}}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "testdata/attributes.golden", allRecords(&rg))
+}
+
+func TestToAXFRResourceRecordSet_Sorted(t *testing.T) {
+	insert := func(r rrs, name string, hosts []string, reverse bool) {
+		for i := range hosts {
+			if reverse {
+				r.Add(name, hosts[len(hosts)-1-i])
+			} else {
+				r.Add(name, hosts[i])
+			}
+		}
+	}
+	ips := []string{"10.0.0.10", "10.0.0.9", "fd01::1", "::1", "192.168.0.1"}
+	srvs := []string{"b.mesos.:80", "a.mesos.:8080", "a.mesos.:443", "[fd01::1]:80"}
+	axfr := func(reverse bool) []byte {
+		as, srv := newRRs(), newRRs()
+		insert(as, "a.mesos.", ips, reverse)
+		insert(srv, "_a._tcp.mesos.", srvs, reverse)
+		b, err := json.Marshal(models.AXFRRecords{As: as.ToAXFRResourceRecordSet(), SRVs: srv.ToAXFRResourceRecordSet()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	first := axfr(false)
+	if second := axfr(false); !bytes.Equal(first, second) {
+		t.Errorf("got %s, then %s", first, second)
+	}
+	if reversed := axfr(true); !bytes.Equal(first, reversed) {
+		t.Errorf("got %s, and %s for hosts inserted in reverse order", first, reversed)
+	}
+
+	var got models.AXFRRecords
+	if err := json.Unmarshal(first, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		got, want []string
+	}{
+		{got.As["a.mesos."], []string{"10.0.0.9", "10.0.0.10", "192.168.0.1", "::1", "fd01::1"}},
+		{got.SRVs["_a._tcp.mesos."], []string{"a.mesos.:443", "a.mesos.:8080", "b.mesos.:80", "[fd01::1]:80"}},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("got hosts %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestResolveHosts(t *testing.T) {
+	defer func(timeout time.Duration) { hostLookupTimeout = timeout }(hostLookupTimeout)
+	hostLookupTimeout = 100 * time.Millisecond
+
+	var (
+		mu             sync.Mutex
+		inFlight, peak int
+		lookups        = map[string]int{}
+		dead           = make(chan struct{})
+		hostnames      = []string{"10.0.0.1", "dead", "Node-1", "node-1."}
+		want           = map[string][]net.IP{"10.0.0.1": {net.ParseIP("10.0.0.1")}, "dead": nil}
+	)
+	defer close(dead)
+	resolver := fakeResolver(func(host string) ([]net.IP, error) {
+		mu.Lock()
+		lookups[host]++
+		if inFlight++; inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		if host == "dead" {
+			<-dead
+			return nil, &net.DNSError{Err: "timeout", Name: host}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return []net.IP{net.ParseIP("10.1.0.1")}, nil
+	})
+	rg := RecordGenerator{resolver: resolver, hostLookupConcurrency: 3}
+	for i := 1; i <= 10; i++ {
+		host := "node-" + strconv.Itoa(i)
+		hostnames = append(hostnames, host)
+		want[host] = []net.IP{net.ParseIP("10.1.0.1").To4()}
+	}
+
+	if got := rg.resolveHosts(hostnames); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if peak > 3 {
+		t.Errorf("got %d lookups at once, want at most 3", peak)
+	}
+	if lookups["10.0.0.1"] != 0 || lookups["node-1"] != 1 {
+		t.Errorf("got lookups %v, want IP addresses not looked up and hostnames once", lookups)
+	}
+}
+
+func TestResolveHosts_Fallback(t *testing.T) {
+	defer func(timeout time.Duration) { hostLookupTimeout = timeout }(hostLookupTimeout)
+	hostLookupTimeout = 100 * time.Millisecond
+
+	// a host resolver which never answers
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	r := newHostResolver(nil, []string{pc.LocalAddr().String()}, 200*time.Millisecond, true, "")
+	r.system = ResolverFunc(func(ctx stdcontext.Context, host string) ([]net.IP, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return []net.IP{net.ParseIP("192.0.2.1")}, nil
+	})
+	if got := r.lookupTimeout(); got != 400*time.Millisecond {
+		t.Errorf("got lookup timeout %s, want 400ms", got)
+	}
+	rg := RecordGenerator{hosts: r}
+	got := rg.resolveHosts([]string{"node-1"})
+	if want := map[string][]net.IP{"node-1": {net.ParseIP("192.0.2.1").To4()}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v through the system resolver", got, want)
+	}
+}
+
+func TestHostResolver_Cache(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		lookups = map[string]int{}
+		now     = time.Unix(0, 0)
+		r       = newHostResolver(nil, nil, 0, false, "")
+	)
+	resolver := fakeResolver(func(host string) ([]net.IP, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lookups[host]++
+		if host == "node-1" {
+			return []net.IP{net.ParseIP("10.0.0.1")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	})
+	r.cache = newHostCache(time.Minute)
+	r.cache.now = func() time.Time { return now }
+	generate := func() *RecordGenerator {
+		sj := state.State{Slaves: []state.Slave{slave("s1", "node-1"), slave("s2", "node-2")}}
+		rg := &RecordGenerator{hosts: r, resolver: resolver}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		return rg
+	}
+
+	for i, tt := range []struct {
+		elapsed time.Duration
+		want    map[string]int
+	}{
+		{0, map[string]int{"node-1": 1, "node-2": 1}},
+		// failures are cached as well
+		{59 * time.Second, map[string]int{"node-1": 1, "node-2": 1}},
+		{time.Second, map[string]int{"node-1": 2, "node-2": 2}},
+	} {
+		now = now.Add(tt.elapsed)
+		rg := generate()
+		if !reflect.DeepEqual(lookups, tt.want) {
+			t.Errorf("test #%d: got lookups %v, want %v", i, lookups, tt.want)
+		}
+		if got, want := rg.As.Hosts("slave.mesos."), []string{"10.0.0.1"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("test #%d: got slave records %v, want %v", i, got, want)
+		}
+	}
+
+	// expired lookups are dropped
+	now = now.Add(time.Minute)
+	r.refresh()
+	if n := len(r.cache.entries); n != 0 {
+		t.Errorf("got %d cached lookups after expiry, want none", n)
+	}
+
+	// interrupted lookups aren't cached
+	r.cache = newHostCache(time.Minute)
+	r.cache.now = func() time.Time { return now }
+	for i, tt := range []struct {
+		err   error
+		calls int
+	}{
+		{stdcontext.Canceled, 2},
+		{fmt.Errorf("lookup: %w", stdcontext.DeadlineExceeded), 2},
+		{&net.DNSError{Err: "i/o timeout", Name: "node-3", IsTimeout: true}, 2},
+		{&net.DNSError{Err: "no such host", Name: "node-3", IsNotFound: true}, 1},
+	} {
+		var calls int
+		lookup := ResolverFunc(func(stdcontext.Context, string) ([]net.IP, error) {
+			calls++
+			return nil, tt.err
+		})
+		host := "node-3-" + strconv.Itoa(i)
+		for j := 0; j < 2; j++ {
+			_, _ = r.lookup(stdcontext.Background(), lookup, host)
+		}
+		if calls != tt.calls {
+			t.Errorf("test #%d: got %d lookups failing with %v, want %d", i, calls, tt.err, tt.calls)
+		}
+	}
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	cancel()
+	_, _ = r.lookup(ctx, fakeResolver(func(host string) ([]net.IP, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}), "node-4")
+	if _, ok := r.cache.get("node-4"); ok {
+		t.Error("got the lookup of a cancelled context cached")
+	}
+
+	// a disabled cache caches nothing
+	r.cache = newHostCache(0)
+	generate()
+	generate()
+	if want := map[string]int{"node-1": 4, "node-2": 4}; !reflect.DeepEqual(lookups, want) {
+		t.Errorf("got lookups %v without a cache, want %v", lookups, want)
+	}
+}
+
+func TestInsertState_ExecutorRecords(t *testing.T) {
+	sj := loadState(t, "testdata/executors.json")
+	generate := func(executors bool) *RecordGenerator {
+		rg := &RecordGenerator{executorRecords: executors}
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		return rg
+	}
+
+	// disabled
+	rg := generate(false)
+	if hosts := rg.As.Hosts("thermosexecutor.marathon.mesos."); len(hosts) != 0 {
+		t.Errorf("got executor records %v while disabled", hosts)
+	}
+
+	rg = generate(true)
+	for i, tt := range []struct {
+		rrs   rrs
+		name  string
+		hosts []string
+	}{
+		// executors of the same name share their records
+		{rg.As, "thermosexecutor.marathon.mesos.", []string{"10.0.1.1", "10.0.1.2"}},
+		{rg.SRVs, "_thermosexecutor._tcp.marathon.mesos.", []string{
+			"thermosexecutor.marathon.mesos.:31500",
+			"thermosexecutor.marathon.mesos.:31600",
+		}},
+		{rg.SRVs, "_thermosexecutor._udp.marathon.mesos.", []string{"thermosexecutor.marathon.mesos.:31501"}},
+		// named after their ID without a name
+		{rg.As, "default.marathon.mesos.", []string{"10.0.1.2"}},
+		// tasks keep their names
+		{rg.As, "web.marathon.mesos.", []string{"10.0.1.1"}},
+		// unknown slave
+		{rg.As, "lost.marathon.mesos.", nil},
+		// completed
+		{rg.As, "done.marathon.mesos.", nil},
+	} {
+		hosts := tt.rrs.Hosts(tt.name)
+		sort.Strings(hosts)
+		if !reflect.DeepEqual(hosts, tt.hosts) && (len(hosts) != 0 || len(tt.hosts) != 0) {
+			t.Errorf("test #%d: got %s records %v, want %v", i, tt.name, hosts, tt.hosts)
+		}
+	}
+	for _, host := range rg.SRVs.Hosts("_web._tcp.marathon.mesos.") {
+		if strings.HasSuffix(host, ":31700") {
+			t.Errorf("got SRV record %q of the executor named after a task", host)
+		}
+	}
+}
+
+func TestInsertState_DNSSDRecords(t *testing.T) {
+	sj := loadState(t, "testdata/dnssd.json")
+
+	rg := RecordGenerator{dnssdRecords: true}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, kind := range []rrsKind{SRV, PTR} {
+		for _, name := range kind.rrs(&rg).Names() {
+			hosts := kind.rrs(&rg).get(name)
+			for host := range hosts {
+				lines = append(lines, string(kind)+" "+name+" "+host)
+			}
+		}
+	}
+	sort.Strings(lines)
+	checkGolden(t, "testdata/dnssd.golden", lines)
+
+	// every service type is enumerated once, and browses to instances
+	// with addresses
+	types := rg.PTRs.Hosts("_services._dns-sd._udp.mesos.")
+	for _, name := range []string{
+		"_web._tcp.marathon.mesos.",
+		"_dns._udp.marathon.mesos.",
+		"_report._tcp.chronos.mesos.",
+	} {
+		n := 0
+		for _, typ := range types {
+			if typ == name {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Errorf("got service type %s enumerated %d times, want once", name, n)
+		}
+		instances := rg.PTRs.Hosts(name)
+		if len(instances) == 0 {
+			t.Errorf("got no instances of %s", name)
+		}
+		for _, instance := range instances {
+			if len(rg.As.Hosts(instance)) == 0 {
+				t.Errorf("got no addresses of instance %s of %s", instance, name)
+			}
+		}
+	}
+	if n := len(rg.PTRs.Hosts("_web._tcp.marathon.mesos.")); n != 2 {
+		t.Errorf("got %d instances of the web service, want 2", n)
+	}
+
+	// disabled
+	rg = RecordGenerator{}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	if rg.PTRs.Len() != 0 {
+		t.Errorf("got PTR records %v while disabled", rg.PTRs)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	orphaned := loadState(t, "testdata/orphans.json")
+	reregistered := loadState(t, "testdata/orphans_reregistered.json")
+	insert := func(rg *RecordGenerator, sj state.State) {
+		if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rg := &RecordGenerator{orphanTasks: true}
+	if s := rg.Snapshot(); s != nil {
+		t.Fatalf("got snapshot %p before any insertion", s)
+	}
+	insert(rg, orphaned)
+	first := rg.Snapshot()
+	if first == nil || first == rg || first.Snapshot() != first {
+		t.Fatalf("got snapshot %p of generator %p, want a distinct one of itself", first, rg)
+	}
+	// the generator inserted into holds the records of its snapshot
+	if rg.As.Len() == 0 || rg.LastUpdated().IsZero() || !rg.Exists("web.orphans.mesos.") {
+		t.Error("generator inserted into lacks the records inserted")
+	}
+	if !reflect.DeepEqual(withCurrent(*first, rg.current), *rg) {
+		t.Error("generator inserted into differs from its snapshot")
+	}
+	insert(rg, reregistered)
+	if first.As.get("web.orphans.mesos.") == nil {
+		t.Error("snapshot mutated by a later insertion")
+	}
+	if rg.Snapshot().As.get("web.marathon.mesos.") == nil {
+		t.Error("snapshot not swapped by a later insertion")
+	}
+
+	// snapshots are read while states get inserted, see go test -race
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				s := rg.Snapshot()
+				if len(s.As.get("leader.mesos.")) == 0 || s.Stats.TotalRecords() == 0 {
+					t.Error("got an incomplete snapshot")
+					return
+				}
+				s.Lookup("web.marathon.mesos.", A)
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		insert(rg, [...]state.State{orphaned, reregistered}[i%2])
+	}
+	close(done)
+	wg.Wait()
+}
+
+// withCurrent returns the given generator with the given Snapshot.
+func withCurrent(rg RecordGenerator, current *atomic.Value) RecordGenerator {
+	rg.current = current
+	return rg
+}
+
+func TestDiff(t *testing.T) {
+	generate := func(file string) *RecordGenerator {
+		var rg RecordGenerator
+		if err := rg.InsertState(loadState(t, file), "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+			t.Fatal(err)
+		}
+		return &rg
+	}
+	prev, next := generate("testdata/orphans_reregistered.json"), generate("testdata/dnssd.json")
+
+	delta := Diff(nil, prev)
+	if delta.Summary["A"].Added != prev.Stats.Records["A"] || len(delta.Removed) != 0 {
+		t.Errorf("got %s from no records, want %d A records added", delta, prev.Stats.Records["A"])
+	}
+	if delta = Diff(prev, prev); len(delta.Added) != 0 || len(delta.Removed) != 0 {
+		t.Errorf("got %s from the same records, want none", delta)
+	}
+
+	delta = Diff(prev, next)
+	if !delta.From.Equal(prev.Timestamp) || !delta.To.Equal(next.Timestamp) {
+		t.Errorf("got delta from %s to %s, want %s to %s", delta.From, delta.To, prev.Timestamp, next.Timestamp)
+	}
+	has := func(changes []RecordChange, rc RecordChange) bool {
+		for _, c := range changes {
+			if c == rc {
+				return true
+			}
+		}
+		return false
+	}
+	// only the host added to a name is reported
+	for _, tt := range []struct {
+		changes []RecordChange
+		rc      RecordChange
+		want    bool
+	}{
+		{delta.Added, RecordChange{"web.marathon.mesos.", "10.0.1.2", "A"}, true},
+		{delta.Added, RecordChange{"web.marathon.mesos.", "10.0.1.1", "A"}, false},
+		{delta.Removed, RecordChange{"web.marathon.mesos.", "10.0.1.1", "A"}, false},
+		{delta.Added, RecordChange{"report.chronos.mesos.", "10.0.1.2", "A"}, true},
+		{delta.Removed, RecordChange{"_web._udp.marathon.mesos.", "web-hoozk-s1.marathon.slave.mesos.:31000", "SRV"}, true},
+	} {
+		if got := has(tt.changes, tt.rc); got != tt.want {
+			t.Errorf("got %+v listed %t, want %t", tt.rc, got, tt.want)
+		}
+	}
+	for kind, c := range delta.Summary {
+		added, removed := 0, 0
+		for _, rc := range delta.Added {
+			if rc.Rtype == kind {
+				added++
+			}
+		}
+		for _, rc := range delta.Removed {
+			if rc.Rtype == kind {
+				removed++
+			}
+		}
+		if c.Added != added || c.Removed != removed {
+			t.Errorf("got %s summary %+v, want %d added and %d removed", kind, c, added, removed)
+		}
+	}
+	if !sort.SliceIsSorted(delta.Added, func(i, j int) bool {
+		a, b := delta.Added[i], delta.Added[j]
+		return a.Rtype < b.Rtype || a.Rtype == b.Rtype && (a.Name < b.Name || a.Name == b.Name && a.Host < b.Host)
+	}) {
+		t.Errorf("got unordered records added %v", delta.Added)
+	}
+}
+
+func TestInsertState_CanonicalNameTemplate(t *testing.T) {
+	config := NewConfig()
+	config.CanonicalNameTemplate = "{{.Name}}.{{.Hash}}.{{.Framework}}"
+	rg := NewRecordGenerator(WithConfig(config))
+	if rg.canonicalTemplate == nil {
+		t.Fatal("canonical name template not configured")
+	}
+	if err := rg.InsertState(loadState(t, "testdata/orphans_reregistered.json"), "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		rrs        rrs
+		name, host string
+	}{
+		{rg.As, "web.hoozk.marathon.mesos.", "10.0.1.1"},
+		{rg.As, "web.hoozk.marathon.slave.mesos.", "10.0.1.1"},
+		{rg.SRVs, "_web._tcp.marathon.mesos.", "web.hoozk.marathon.slave.mesos.:31000"},
+	} {
+		if _, ok := tt.rrs.get(tt.name)[tt.host]; !ok {
+			t.Errorf("missing record %s %s", tt.name, tt.host)
+		}
+	}
+	for _, line := range allRecords(rg) {
+		if strings.Contains(line, "web-hoozk-s1") {
+			t.Errorf("got record %q of the default canonical name", line)
+		}
+	}
+	enumerated := false
+	for _, f := range rg.EnumData.Frameworks {
+		for _, task := range f.Tasks {
+			for _, rec := range task.Records {
+				enumerated = enumerated || rec.Name == "web.hoozk.marathon.mesos."
+			}
+		}
+	}
+	if !enumerated {
+		t.Error("canonical name not enumerated")
+	}
+}
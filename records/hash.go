@@ -0,0 +1,100 @@
+package records
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/tv42/zbase32"
+)
+
+// Hash algorithms of the task IDs in canonical task names, as set by
+// Config.TaskHashAlgorithm.
+const (
+	TaskHashSHA1   = "sha1"
+	TaskHashSHA256 = "sha256"
+)
+
+const (
+	// DefaultTaskHashLength is the default length of the task ID hashes of
+	// canonical task names.
+	DefaultTaskHashLength = 5
+	// maxTaskHashLength is the longest task ID hash of canonical task names,
+	// keeping them short enough for a label.
+	maxTaskHashLength = 16
+)
+
+// validateTaskHash checks that the given task ID hash algorithm is known
+// and that the given length is 0, meaning DefaultTaskHashLength, or between
+// DefaultTaskHashLength and maxTaskHashLength.
+func validateTaskHash(algorithm string, length int) error {
+	switch algorithm {
+	case "", TaskHashSHA1, TaskHashSHA256:
+	default:
+		return fmt.Errorf("unknown algorithm %q: use %q or %q", algorithm, TaskHashSHA1, TaskHashSHA256)
+	}
+	if length != 0 && (length < DefaultTaskHashLength || length > maxTaskHashLength) {
+		return fmt.Errorf("length %d is not between %d and %d", length, DefaultTaskHashLength, maxTaskHashLength)
+	}
+	return nil
+}
+
+// taskHash returns the hash of the given task ID, of the given length, as
+// per the configured algorithm, zbase32 encoded like hashString.
+func (rg *RecordGenerator) taskHash(id string, length int) string {
+	var hash []byte
+	if rg.taskHashAlgorithm == TaskHashSHA256 {
+		sum := sha256.Sum256([]byte(id))
+		hash = sum[:]
+	} else {
+		sum := sha1.Sum([]byte(id))
+		hash = sum[:]
+	}
+	return zbase32.EncodeToString(hash)[:length]
+}
+
+// withoutTask returns a copy of the given canonical names less those of the
+// given task ID.
+func withoutTask(canonicalNames map[string]string, id string) map[string]string {
+	names := make(map[string]string, len(canonicalNames))
+	for name, owner := range canonicalNames {
+		if owner != id {
+			names[name] = owner
+		}
+	}
+	return names
+}
+
+// canonicalHash returns the hash of the given task ID in the canonical name
+// of the task, made of the given other parts around the hash: one of the
+// configured length, unless another task ID got the same canonical name
+// during the current generation, in which case the hash is lengthened until
+// unique, with a warning, up to maxTaskHashLength.
+func (rg *RecordGenerator) canonicalHash(id, name, slaveID, fname string) string {
+	length := rg.taskHashLength
+	if length == 0 {
+		length = DefaultTaskHashLength
+	}
+	if rg.canonicalNames == nil {
+		rg.canonicalNames = map[string]string{}
+	}
+	hash := rg.taskHash(id, length)
+	for {
+		canonical := rg.canonicalName(name, hash, slaveID, fname)
+		owner, ok := rg.canonicalNames[canonical]
+		if !ok || owner == id {
+			rg.canonicalNames[canonical] = id
+			return hash
+		}
+		rg.Stats.event(EventHashCollision)
+		if length >= maxTaskHashLength {
+			logging.Error.Printf("warning: tasks %q and %q share the canonical name %q", owner, id, canonical)
+			return hash
+		}
+		logging.Error.Printf("warning: tasks %q and %q hash to the canonical name %q, lengthening the hash of the latter",
+			owner, id, canonical)
+		length++
+		hash = rg.taskHash(id, length)
+	}
+}
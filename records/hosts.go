@@ -0,0 +1,489 @@
+package records
+
+import (
+	"bufio"
+	stdcontext "context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/errorutil"
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// Resolver looks up the IP addresses of the hostnames of frameworks and
+// slaves. Implementations must be safe for concurrent use.
+type Resolver interface {
+	LookupIP(ctx stdcontext.Context, host string) ([]net.IP, error)
+}
+
+// ResolverFunc adapts a function into a Resolver.
+type ResolverFunc func(ctx stdcontext.Context, host string) ([]net.IP, error)
+
+// LookupIP implements the Resolver interface.
+func (f ResolverFunc) LookupIP(ctx stdcontext.Context, host string) ([]net.IP, error) {
+	return f(ctx, host)
+}
+
+// SystemResolver is the Resolver looking hostnames up through the system
+// resolver.
+var SystemResolver Resolver = ResolverFunc(func(ctx stdcontext.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+})
+
+// hostResolver resolves hostnames into IP addresses, retrying short ones, of
+// a single label, with each search suffix appended should they not resolve
+// as is. The FQDN a short hostname resolved as is remembered and tried first
+// next time. It's shared by the generators configured by the same Option.
+type hostResolver struct {
+	// source looks hostnames up, unless the generator has a Resolver of
+	// its own: lookupServers if host resolvers are configured, or else
+	// SystemResolver.
+	source   Resolver
+	suffixes []string
+
+	// resolver is the dedicated resolver of the configured host resolvers,
+	// if any, whose lookups time out after timeout; should they fail, the
+	// system resolver is used if fallback is set.
+	resolver *net.Resolver
+	timeout  time.Duration
+	fallback bool
+	system   Resolver
+
+	// hostsFile lists the IP addresses of hostnames which aren't looked
+	// up, if configured.
+	hostsFile *hostsFile
+	// cache holds the outcome of recent lookups, if enabled.
+	cache *hostCache
+
+	mu    sync.Mutex
+	fqdns map[string]string // by short hostname
+}
+
+// newHostResolver returns a hostResolver appending the given search
+// suffixes which looks hostnames up through the given DNS servers, if any,
+// giving up on each lookup after the given timeout, or else the system
+// resolver. Lookups failing through the given servers are retried with the
+// system resolver if fallback is set. Hostnames listed in the given hosts
+// file, if any, aren't looked up.
+func newHostResolver(suffixes, servers []string, timeout time.Duration, fallback bool, hosts string) *hostResolver {
+	r := &hostResolver{
+		source:   SystemResolver,
+		suffixes: suffixes,
+		fqdns:    map[string]string{},
+	}
+	if hosts != "" {
+		r.hostsFile = &hostsFile{path: hosts, errs: logging.NewLimiter(time.Minute)}
+	}
+	if len(servers) > 0 {
+		r.resolver = dedicatedResolver(servers)
+		r.timeout, r.fallback = timeout, fallback
+		r.system, r.source = SystemResolver, ResolverFunc(r.lookupServers)
+	}
+	return r
+}
+
+// dedicatedResolver returns a resolver querying the given DNS servers, IP
+// addresses optionally followed by a port, instead of those of the system,
+// in turn so that retries go to the next one.
+func dedicatedResolver(servers []string) *net.Resolver {
+	addrs := make([]string, len(servers))
+	for i, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		addrs[i] = server
+	}
+	var next uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx stdcontext.Context, network, _ string) (net.Conn, error) {
+			addr := addrs[int(atomic.AddUint32(&next, 1)-1)%len(addrs)]
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// lookupServers looks up the IP addresses of the given hostname through the
+// dedicated resolver, then the system one if it fails and fallback is set,
+// each given up on after the timeout of r. The hostname is looked up as is,
+// without the search domains of the system.
+func (r *hostResolver) lookupServers(ctx stdcontext.Context, host string) ([]net.IP, error) {
+	sctx, cancel := stdcontext.WithTimeout(ctx, r.timeout)
+	addrs, err := r.resolver.LookupIPAddr(sctx, strings.TrimSuffix(host, ".")+".")
+	cancel()
+	if err != nil {
+		if !r.fallback {
+			return nil, err
+		}
+		logging.VeryVerbose.Printf("failed to look up %q through the host resolvers, falling back to the system resolver: %v", host, err)
+		ctx, cancel = stdcontext.WithTimeout(ctx, r.timeout)
+		defer cancel()
+		return r.system.LookupIP(ctx, host)
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// lookup returns the IP addresses of the given hostname, as cached, if it
+// was looked up recently, looking it up through the given Resolver, if any,
+// or else the source of r. A nil hostResolver looks hostnames up as is,
+// through SystemResolver by default.
+func (r *hostResolver) lookup(ctx stdcontext.Context, src Resolver, hostname string) ([]net.IP, error) {
+	if r == nil {
+		if src == nil {
+			src = SystemResolver
+		}
+		return src.LookupIP(ctx, hostname)
+	}
+	if src == nil {
+		src = r.source
+	}
+	if ips := r.listed(hostname); len(ips) > 0 {
+		return ips, nil
+	}
+	if e, ok := r.cache.get(hostname); ok {
+		return e.ips, e.err
+	}
+	ips, err := r.search(ctx, src, hostname)
+	if err == nil || !interrupted(ctx, err) {
+		r.cache.put(hostname, ips, err)
+	}
+	return ips, err
+}
+
+// interrupted tells whether a lookup failed with the given error, under the
+// given context, because it was cancelled or timed out rather than answered,
+// e.g. given up on by lookupWithin, in which case its failure isn't cached.
+func interrupted(ctx stdcontext.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, stdcontext.Canceled) || errors.Is(err, stdcontext.DeadlineExceeded) {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// search looks up the IP addresses of the given hostname through the given
+// Resolver, then, if short, with each search suffix appended.
+func (r *hostResolver) search(ctx stdcontext.Context, src Resolver, hostname string) ([]net.IP, error) {
+	if strings.Contains(hostname, ".") || len(r.suffixes) == 0 {
+		return src.LookupIP(ctx, hostname)
+	}
+
+	r.mu.Lock()
+	fqdn, ok := r.fqdns[hostname]
+	r.mu.Unlock()
+	if ok {
+		if ips, err := r.resolve(ctx, src, fqdn); err == nil {
+			return ips, nil
+		}
+	}
+	ips, err := src.LookupIP(ctx, hostname)
+	if err == nil {
+		return ips, nil
+	}
+	for _, suffix := range r.suffixes {
+		name := hostname + "." + strings.Trim(suffix, ".")
+		if name == fqdn {
+			continue // just failed
+		}
+		if ips, serr := r.resolve(ctx, src, name); serr == nil {
+			r.mu.Lock()
+			r.fqdns[hostname] = name
+			r.mu.Unlock()
+			logging.Verbose.Printf("resolved hostname %q as %q", hostname, name)
+			return ips, nil
+		}
+	}
+	return nil, err
+}
+
+// resolve returns the IP addresses of the given name listed in the hosts
+// file, if any, or else looks it up through the given Resolver.
+func (r *hostResolver) resolve(ctx stdcontext.Context, src Resolver, name string) ([]net.IP, error) {
+	if ips := r.listed(name); len(ips) > 0 {
+		return ips, nil
+	}
+	return src.LookupIP(ctx, name)
+}
+
+// listed returns the IP addresses of the given name listed in the hosts
+// file, if any.
+func (r *hostResolver) listed(name string) []net.IP {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hostsFile.lookup(name)
+}
+
+// lookupTimeout returns how long resolveHosts waits for the lookup of a
+// hostname through r: hostLookupTimeout, unless the lookups through the host
+// resolvers, falling back to the system resolver if enabled, may take longer.
+func (r *hostResolver) lookupTimeout() time.Duration {
+	if r == nil || r.resolver == nil {
+		return hostLookupTimeout
+	}
+	timeout := r.timeout
+	if r.fallback {
+		timeout *= 2
+	}
+	if timeout < hostLookupTimeout {
+		return hostLookupTimeout
+	}
+	return timeout
+}
+
+// refresh re-reads the hosts file, if any, should it have changed, and
+// drops the expired lookups of the cache. It's called at the start of every
+// generation.
+func (r *hostResolver) refresh() {
+	if r == nil {
+		return
+	}
+	r.cache.expire()
+	if r.hostsFile == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hostsFile.refresh()
+}
+
+// hostCache caches the outcome of hostname lookups, answered failures
+// included, so that hostnames, which seldom change, aren't looked up every
+// generation, and unresolvable ones retried for every record. It's safe for
+// concurrent use; a nil hostCache caches nothing.
+type hostCache struct {
+	ttl time.Duration
+	// now tells the time, defaulting to time.Now; it's overridden in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]hostCacheEntry // by hostname
+}
+
+// hostCacheEntry is the outcome of the lookup of a hostname, until expiry.
+type hostCacheEntry struct {
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// newHostCache returns a hostCache keeping lookups for the given TTL, or
+// nil, caching nothing, if it isn't positive.
+func newHostCache(ttl time.Duration) *hostCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &hostCache{ttl: ttl, now: time.Now, entries: map[string]hostCacheEntry{}}
+}
+
+// get returns the unexpired lookup of the given hostname, if any.
+func (c *hostCache) get(hostname string) (hostCacheEntry, bool) {
+	if c == nil {
+		return hostCacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[hostname]
+	if !ok || !c.now().Before(e.expires) {
+		return hostCacheEntry{}, false
+	}
+	return e, true
+}
+
+// put caches the given lookup of the given hostname.
+func (c *hostCache) put(hostname string, ips []net.IP, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[hostname] = hostCacheEntry{ips: ips, err: err, expires: c.now().Add(c.ttl)}
+}
+
+// expire drops the expired lookups, e.g. of hostnames gone from the state.
+func (c *hostCache) expire() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for hostname, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, hostname)
+		}
+	}
+}
+
+// DefaultHostLookupConcurrency is the default number of hostnames looked up
+// at once during a generation, as set by Config.HostLookupConcurrency.
+const DefaultHostLookupConcurrency = 32
+
+// hostLookupTimeout is how long resolveHosts waits at least for the lookup of
+// a hostname before giving up on it, see hostResolver.lookupTimeout; it's
+// overridden in tests.
+var hostLookupTimeout = 2 * time.Second
+
+// slowLookupLog limits the logging of hostname lookups given up on.
+var slowLookupLog = logging.NewLimiter(time.Hour)
+
+// resolveHosts returns the IP addresses of the given hostnames, as per
+// hostToIPs, by normalized hostname. Hostnames which aren't IP addresses
+// are looked up concurrently, by up to hostLookupConcurrency workers, so
+// that a slow resolver doesn't stall the generation once per hostname;
+// lookups taking longer than the lookup timeout of the host resolver are
+// given up on, leaving their hostnames without IP addresses.
+func (rg *RecordGenerator) resolveHosts(hostnames []string) map[string][]net.IP {
+	resolved := make(map[string][]net.IP, len(hostnames))
+	var lookups []string
+	for _, host := range hostnames {
+		host = normalizeHost(host)
+		if _, ok := resolved[host]; ok || host == "" {
+			continue
+		}
+		resolved[host] = nil
+		if ip := net.ParseIP(host); ip != nil {
+			resolved[host] = []net.IP{ip}
+		} else {
+			lookups = append(lookups, host)
+		}
+	}
+
+	workers := rg.hostLookupConcurrency
+	if workers == 0 {
+		workers = DefaultHostLookupConcurrency
+	}
+	if workers > len(lookups) {
+		workers = len(lookups)
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		todo    = make(chan string)
+		timeout = rg.hosts.lookupTimeout()
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range todo {
+				ips := rg.lookupWithin(host, timeout)
+				mu.Lock()
+				resolved[host] = ips
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, host := range lookups {
+		todo <- host
+	}
+	close(todo)
+	wg.Wait()
+	return resolved
+}
+
+// lookupWithin returns the IP addresses of the given hostname, as per
+// hostToIPs, unless its lookup takes longer than the given timeout, in which
+// case it returns none, with a warning. Lookups are cancelled through their
+// context then, or else left to finish in the background, for Resolvers
+// ignoring it.
+func (rg *RecordGenerator) lookupWithin(host string, timeout time.Duration) []net.IP {
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), timeout)
+	defer cancel()
+	done := make(chan []net.IP, 1)
+	go func() { done <- rg.hostToIPs(ctx, host) }()
+	select {
+	case ips := <-done:
+		return ips
+	case <-ctx.Done():
+		if slowLookupLog.Allow(host) {
+			logging.Error.Printf("warning: gave up on looking up hostname %q after %s", host, timeout)
+		}
+		return nil
+	}
+}
+
+// hostsFile holds the entries of a hosts file, in the /etc/hosts format,
+// tracking its changes as told by its modification time and size.
+type hostsFile struct {
+	path  string
+	mtime time.Time
+	size  int64
+	hosts map[string][]net.IP // by normalized hostname
+	// errs limits the logging of failures to read the file.
+	errs *logging.Limiter
+}
+
+// lookup returns the IP addresses of the given hostname listed in the file.
+func (hf *hostsFile) lookup(hostname string) []net.IP {
+	if hf == nil {
+		return nil
+	}
+	return hf.hosts[normalizeHost(hostname)]
+}
+
+// refresh re-reads the file if it changed, keeping the entries read last
+// should it fail to.
+func (hf *hostsFile) refresh() {
+	fi, err := os.Stat(hf.path)
+	if err == nil && fi.ModTime().Equal(hf.mtime) && fi.Size() == hf.size {
+		return
+	}
+	var hosts map[string][]net.IP
+	if err == nil {
+		hosts, err = readHosts(hf.path)
+	}
+	if err != nil {
+		if hf.errs.Allow(hf.path) {
+			logging.Error.Printf("failed to read the hosts file %s: %v", hf.path, err)
+		}
+		return
+	}
+	hf.mtime, hf.size, hf.hosts = fi.ModTime(), fi.Size(), hosts
+	logging.Verbose.Printf("read %d hostnames from %s", len(hosts), hf.path)
+}
+
+// readHosts returns the IP addresses of the hostnames, and their aliases,
+// listed in the given hosts file, one IP address per line followed by the
+// hostnames. Hostnames may be listed on several lines. Comments, starting
+// with #, are ignored, while lines not starting with an IP address are
+// skipped with a warning.
+func readHosts(path string) (map[string][]net.IP, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer errorutil.Ignore(f.Close)
+
+	hosts := map[string][]net.IP{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil || len(fields) < 2 {
+			logging.Error.Printf("warning: skipping line %d of %s: want an IP address followed by hostnames", n, path)
+			continue
+		}
+		for _, host := range fields[1:] {
+			host = normalizeHost(host)
+			hosts[host] = append(hosts[host], ip)
+		}
+	}
+	return hosts, scanner.Err()
+}
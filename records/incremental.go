@@ -0,0 +1,477 @@
+package records
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// TaskOp is an incremental update of the records of a task, as applied by
+// ApplyTaskUpdate.
+type TaskOp int
+
+const (
+	// TaskAdd adds the records of a task, replacing those it had, if any.
+	TaskAdd TaskOp = iota
+	// TaskRemove removes the records of a task, but for those still
+	// generated by other tasks or sources.
+	TaskRemove
+)
+
+// generation holds the parameters of the last InsertState, which the
+// records of tasks updated by ApplyTaskUpdate are generated with.
+type generation struct {
+	domain    string
+	spec      labels.Func
+	ipSources []string
+}
+
+// ApplyTaskUpdate returns a new snapshot of the records of rg with the
+// records of the given task of the given framework added or removed as per
+// the given operation, leaving rg untouched so that it may keep being served
+// meanwhile. Tasks are identified by their ID within their framework. The
+// records of the task are generated as by InsertState, on the slaves and
+// frameworks of the last state inserted, and only those are inserted or
+// removed: only the records of the names the task touches are copied, and no
+// state is fetched nor any other record generated.
+//
+// Records shared with other tasks, e.g. the short names of the instances of
+// an application, or generated by other sources are only removed along with
+// the last task generating them, so that applying the updates of a sequence
+// of task changes converges to the records a full rebuild from the resulting
+// state generates, as long as its slaves and frameworks don't change. Hosts
+// are ordered by insertion though, which may differ. The generation stats
+// but for the record counts, the collisions and the timestamp are those of
+// the last full rebuild. The checksum of the records is computed only when
+// first asked for, as it covers every record. The TTLs of the names of the task are set anew, as
+// its health checks and TTL label may change them, though the enumerated
+// records of other tasks keep theirs until the next full rebuild.
+//
+// Updates are refused with a record cap, as the tasks it cuts off depend on
+// all the others. Those of tasks of frameworks filtered out by the framework
+// whitelist or blacklist change nothing, and rg itself is returned.
+func (rg *RecordGenerator) ApplyTaskUpdate(task state.Task, f state.Framework, op TaskOp) (*RecordGenerator, error) {
+	switch {
+	case rg.generation == nil:
+		return nil, errors.New("no state inserted to update")
+	case rg.maxRecords > 0:
+		return nil, errors.New("incremental updates are not supported with a record cap")
+	case task.ID == "" || task.SlaveID == "":
+		return nil, fmt.Errorf("task %q of framework %q is missing an id or slave id", task.Name, f.Name)
+	case op != TaskAdd && op != TaskRemove:
+		return nil, fmt.Errorf("unknown task update %d", op)
+	case rg.frameworkFiltered(f, rg.generation.spec):
+		return rg, nil
+	}
+
+	next := *rg
+	next.As, next.AAAAs, next.SRVs = rg.As.clone(), rg.AAAAs.clone(), rg.SRVs.clone()
+	next.PTRs, next.TXTs = rg.PTRs.clone(), rg.TXTs.clone()
+	next.listings = rg.listings.clone()
+	next.Stats.Records = copyCounts(rg.Stats.Records)
+	next.Stats.Sources = copyCounts(rg.Stats.Sources)
+	next.EnumData.Frameworks = append([]*EnumerableFramework(nil), rg.EnumData.Frameworks...)
+	u := snapshotUpdate{
+		rg:       &next,
+		shards:   map[shardKey]bool{},
+		copied:   map[claimKey]bool{},
+		listings: map[int]bool{},
+		names:    map[string]bool{},
+	}
+
+	frag := rg.frameworkFrag(f, rg.generation.spec)
+	stale := u.unlist(f.Name, frag, task.ID)
+	var scratch *RecordGenerator
+	if op == TaskAdd {
+		scratch = rg.taskRecordSet(task, f)
+		for _, enumFW := range scratch.EnumData.Frameworks {
+			for _, enumTask := range enumFW.Tasks {
+				u.list(enumFW, enumTask)
+				for _, rec := range enumTask.Records {
+					u.add(rec)
+				}
+			}
+		}
+	}
+	u.prune(stale)
+	// the health checks and TTL label of the task may change the TTLs of
+	// its names even if their hosts are unchanged
+	for _, rec := range stale {
+		u.names[rec.Name] = true
+	}
+	if scratch != nil {
+		for _, enumFW := range scratch.EnumData.Frameworks {
+			for _, enumTask := range enumFW.Tasks {
+				for _, rec := range enumTask.Records {
+					u.names[rec.Name] = true
+				}
+			}
+		}
+	}
+	u.setTTLs()
+
+	if scratch != nil {
+		next.setRecordTTLs(scratch.EnumData.Frameworks)
+		next.canonicalNames = scratch.canonicalNames
+	} else {
+		next.canonicalNames = withoutTask(rg.canonicalNames, task.ID)
+	}
+	next.sum = new(checksumOnce)
+	next.freeze()
+	return &next, nil
+}
+
+// taskRecordSet generates the records of the given task of the given
+// framework, as InsertState would have, on a scratch generator sharing the
+// configuration, slaves and framework fragments of rg but none of its
+// records. The records of the task are listed by the enumeration data of the
+// scratch generator, that of the domain of the framework and of those it's
+// mirrored under.
+func (rg *RecordGenerator) taskRecordSet(task state.Task, f state.Framework) *RecordGenerator {
+	scratch := *rg
+	scratch.As, scratch.AAAAs, scratch.SRVs = rrs{}, rrs{}, rrs{}
+	scratch.PTRs, scratch.TXTs = rrs{}, rrs{}
+	scratch.listings = listings{}
+	scratch.Stats = newGenerationStats()
+	scratch.EnumData = EnumerationData{
+		Frameworks: []*EnumerableFramework{},
+		Collisions: []Collision{},
+	}
+	scratch.invalidNames = map[string]struct{}{}
+	scratch.owners = map[claimKey]RecordSource{}
+	scratch.collisions = map[collisionKey]struct{}{}
+	scratch.pinned = nil
+	scratch.canonicalNames = withoutTask(rg.canonicalNames, task.ID)
+
+	f.Tasks = []state.Task{task}
+	g := rg.generation
+	scratch.Stats.attributed(SourceTask, func() { scratch.frameworkTaskRecords(f, g.domain, g.spec, g.ipSources) })
+	return &scratch
+}
+
+// snapshotUpdate applies an incremental update to a snapshot sharing its
+// records, listings and enumeration data with the one it was copied from: the
+// shards and records of a record name, the listings of a name and the
+// enumeration data of a framework are copied before they're first written to,
+// so that an update costs as much as the names it touches rather than every
+// record.
+type snapshotUpdate struct {
+	rg *RecordGenerator
+	// shards holds the record shards copied, by kind and index.
+	shards map[shardKey]bool
+	// copied holds the record names and kinds whose records were copied.
+	copied map[claimKey]bool
+	// listings holds the indexes of the listings shards copied.
+	listings map[int]bool
+	// names holds the record names whose TTLs are set anew, those whose
+	// records were updated among them.
+	names map[string]bool
+}
+
+// shardKey identifies a shard of the records of a kind.
+type shardKey struct {
+	kind  rrsKind
+	shard int
+}
+
+// set returns the records of the given name and kind, copied along with
+// their shard if not yet, creating them if needed.
+func (u *snapshotUpdate) set(name string, kind rrsKind) *rrset {
+	r := kind.alloc(u.rg)
+	i := shardOf(name)
+	shard := r.shards[i]
+	if key := (shardKey{kind, i}); !u.shards[key] {
+		shard = make(map[string]*rrset, len(r.shards[i])+1)
+		for n, set := range r.shards[i] {
+			shard[n] = set
+		}
+		r.shards[i] = shard
+		u.shards[key] = true
+	} else if shard == nil {
+		shard = map[string]*rrset{}
+		r.shards[i] = shard
+	}
+	key := claimKey{name, kind}
+	if set := shard[name]; set == nil || !u.copied[key] {
+		shard[name] = set.clone()
+		u.copied[key] = true
+		u.names[name] = true
+	}
+	return shard[name]
+}
+
+// clone returns a copy of s, empty records if nil.
+func (s *rrset) clone() *rrset {
+	if s == nil {
+		return &rrset{hosts: map[string]int{}}
+	}
+	c := *s
+	c.hosts = make(map[string]int, len(s.hosts)+1)
+	for host, i := range s.hosts {
+		c.hosts[host] = i
+	}
+	if s.srv != nil {
+		c.srv = make(map[string]SRVData, len(s.srv)+1)
+		for host, d := range s.srv {
+			c.srv[host] = d
+		}
+	}
+	return &c
+}
+
+// add inserts the given record, unless already present, and sets the
+// priority and weight of SRV records, which the task may have changed.
+func (u *snapshotUpdate) add(rec EnumerableRecord) {
+	kind := rrsKind(rec.Rtype)
+	if kind == SRV {
+		u.weigh(rec)
+	}
+	if _, ok := kind.rrs(u.rg).get(rec.Name)[rec.Host]; ok {
+		return
+	}
+	set := u.set(rec.Name, kind)
+	set.hosts[rec.Host] = len(set.hosts)
+	u.rg.Stats.attributed(SourceTask, func() { u.rg.Stats.inserted(kind) })
+}
+
+// weigh sets the SRVData of the given SRV record to its priority and
+// weight, removing it if both are 0.
+func (u *snapshotUpdate) weigh(rec EnumerableRecord) {
+	d, err := ParseSRV(rec.Host)
+	if err != nil {
+		return
+	}
+	d.Priority, d.Weight = rec.Priority, rec.Weight
+	prev, ok := u.rg.SRVs.set(rec.Name).weight(rec.Host)
+	switch {
+	case ok && prev == d, !ok && d.Priority == 0 && d.Weight == 0:
+		return
+	case d.Priority == 0 && d.Weight == 0:
+		delete(u.set(rec.Name, SRV).srv, rec.Host)
+	default:
+		set := u.set(rec.Name, SRV)
+		if set.srv == nil {
+			set.srv = map[string]SRVData{}
+		}
+		set.srv[rec.Host] = d
+	}
+}
+
+// remove deletes the given record, if present, along with its SRVData,
+// ranking the hosts inserted after it one lower.
+func (u *snapshotUpdate) remove(rec EnumerableRecord) {
+	kind := rrsKind(rec.Rtype)
+	if _, ok := kind.rrs(u.rg).get(rec.Name)[rec.Host]; !ok {
+		return
+	}
+	u.set(rec.Name, kind)
+	kind.rrs(u.rg).remove(rec.Name, rec.Host)
+	u.rg.Stats.attributed(SourceTask, func() { u.rg.Stats.removed(kind) })
+}
+
+// unlist removes the given task from the enumeration data of the given
+// framework, under every domain, and from the listings of its names,
+// returning the records it listed.
+func (u *snapshotUpdate) unlist(name, frag, taskID string) []EnumerableRecord {
+	var recs []EnumerableRecord
+	for i, enumFW := range u.rg.EnumData.Frameworks {
+		if enumFW.Name != name || enumFW.Fragment != frag {
+			continue
+		}
+		copied := *enumFW
+		copied.Tasks = make([]*EnumerableTask, 0, len(enumFW.Tasks))
+		for _, t := range enumFW.Tasks {
+			if t.ID == taskID {
+				recs = append(recs, t.Records...)
+				u.relist(t, false)
+				continue
+			}
+			copied.Tasks = append(copied.Tasks, t)
+		}
+		if len(copied.Tasks) < len(enumFW.Tasks) {
+			u.rg.EnumData.Frameworks[i] = &copied
+		}
+	}
+	return recs
+}
+
+// list adds the given task to the enumeration data of the framework of the
+// same name, fragment and domain as the given one, adding the framework if
+// missing, and to the listings of its names.
+func (u *snapshotUpdate) list(enumFW *EnumerableFramework, task *EnumerableTask) {
+	u.relist(task, true)
+	for i, fw := range u.rg.EnumData.Frameworks {
+		if fw.Name == enumFW.Name && fw.Fragment == enumFW.Fragment && fw.Domain == enumFW.Domain {
+			copied := *fw
+			copied.Tasks = append(append(make([]*EnumerableTask, 0, len(fw.Tasks)+1), fw.Tasks...), task)
+			u.rg.EnumData.Frameworks[i] = &copied
+			return
+		}
+	}
+	u.rg.EnumData.Frameworks = append(u.rg.EnumData.Frameworks, &EnumerableFramework{
+		Name:     enumFW.Name,
+		Fragment: enumFW.Fragment,
+		Domain:   enumFW.Domain,
+		Tasks:    []*EnumerableTask{task},
+	})
+}
+
+// relist adds the given task to the listings of the names of its records, or
+// removes it from them, copying those first.
+func (u *snapshotUpdate) relist(task *EnumerableTask, listed bool) {
+	l := u.rg.listings
+	if l.shards == nil {
+		l = newListings()
+		u.rg.listings = l
+	}
+	done := map[string]bool{}
+	for _, rec := range task.Records {
+		if done[rec.Name] {
+			continue
+		}
+		done[rec.Name] = true
+		i := shardOf(rec.Name)
+		if !u.listings[i] {
+			shard := make(map[string][]*EnumerableTask, len(l.shards[i])+1)
+			for name, tasks := range l.shards[i] {
+				shard[name] = tasks
+			}
+			l.shards[i] = shard
+			u.listings[i] = true
+		} else if l.shards[i] == nil {
+			l.shards[i] = map[string][]*EnumerableTask{}
+		}
+		prev := l.shards[i][rec.Name]
+		tasks := make([]*EnumerableTask, 0, len(prev)+1)
+		for _, t := range prev {
+			if t != task {
+				tasks = append(tasks, t)
+			}
+		}
+		if listed {
+			tasks = append(tasks, task)
+		}
+		if len(tasks) == 0 {
+			delete(l.shards[i], rec.Name)
+		} else {
+			l.shards[i][rec.Name] = tasks
+		}
+	}
+}
+
+// prune removes those of the given records which neither a task lists nor
+// another source generated.
+func (u *snapshotUpdate) prune(recs []EnumerableRecord) {
+	for _, rec := range recs {
+		if _, ok := u.rg.pinned[rec.key()]; ok || u.rg.listings.lists(rec) {
+			continue
+		}
+		u.remove(rec)
+	}
+}
+
+// setTTLs sets anew the TTLs of the records of the names updated, as per the
+// TTL labels and health checks of the tasks listing them.
+func (u *snapshotUpdate) setTTLs() {
+	rg := u.rg
+	labeled, intervals := map[claimKey]uint32{}, map[claimKey]float64{}
+	for name := range u.names {
+		for _, t := range rg.listings.tasks(name) {
+			t.noteTTL(labeled, name)
+			if rg.healthCheckTTLs {
+				t.noteInterval(intervals, name)
+			}
+		}
+	}
+	custom := rg.customTTLs() || len(labeled) > 0
+	for name := range u.names {
+		for _, kind := range []rrsKind{A, AAAA, SRV, PTR, TXT} {
+			ttl, ok := uint32(0), false
+			if custom {
+				ttl, _, ok = rg.nameTTL(claimKey{name, kind}, intervals, labeled)
+			}
+			if set := kind.rrs(rg).set(name); set != nil && (set.ttl != ttl || set.hasTTL != ok) {
+				set = u.set(name, kind)
+				set.ttl, set.hasTTL = ttl, ok
+			}
+		}
+	}
+}
+
+// clone returns a copy of r sharing its shards, which snapshotUpdate copies
+// before writing to them.
+func (r rrs) clone() rrs {
+	if r.shards == nil {
+		return r
+	}
+	shards := *r.shards
+	return rrs{shards: &shards}
+}
+
+// listings indexes the enumerated tasks by the names of the records they
+// list, so that incremental updates find the tasks listing the records of the
+// names they touch without going through every task. It's sharded like rrs,
+// whose copies share its shards likewise.
+type listings struct {
+	shards *[rrsShards]map[string][]*EnumerableTask
+}
+
+// newListings returns empty listings, whose copies share those added to it.
+func newListings() listings {
+	return listings{shards: new([rrsShards]map[string][]*EnumerableTask)}
+}
+
+// add lists the given task under the given name, unless it was just listed
+// under it.
+func (l *listings) add(name string, t *EnumerableTask) {
+	if l.shards == nil {
+		*l = newListings()
+	}
+	i := shardOf(name)
+	if l.shards[i] == nil {
+		l.shards[i] = map[string][]*EnumerableTask{}
+	}
+	if tasks := l.shards[i][name]; len(tasks) == 0 || tasks[len(tasks)-1] != t {
+		l.shards[i][name] = append(tasks, t)
+	}
+}
+
+// tasks returns the tasks listed under the given name.
+func (l listings) tasks(name string) []*EnumerableTask {
+	if l.shards == nil {
+		return nil
+	}
+	return l.shards[shardOf(name)][name]
+}
+
+// lists tells whether a task lists the given record.
+func (l listings) lists(rec EnumerableRecord) bool {
+	key := rec.key()
+	for _, t := range l.tasks(rec.Name) {
+		if _, ok := t.listed[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a copy of l sharing its shards, which snapshotUpdate copies
+// before writing to them.
+func (l listings) clone() listings {
+	if l.shards == nil {
+		return l
+	}
+	shards := *l.shards
+	return listings{shards: &shards}
+}
+
+// copyCounts returns a copy of the given counts.
+func copyCounts(m map[string]int) map[string]int {
+	c := make(map[string]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,103 @@
+package records
+
+import (
+	"strings"
+)
+
+// Lookup returns the records of the given kind named the given name, in
+// insertion order. Names are matched case insensitively, with or without
+// their trailing dot.
+//
+// A name without records of any kind of its own is answered with the records
+// of the closest wildcard name enclosing it, e.g. *.marathon.mesos. for
+// web.marathon.mesos., as in RFC 4592: names with records of other kinds only
+// take precedence over wildcards. Records answered by a wildcard keep its
+// name, which their TTL is that of. The records are those of the Snapshot of
+// rg, if any.
+func (rg *RecordGenerator) Lookup(name string, kind rrsKind) []Record {
+	rg = rg.view()
+	name = normalizeName(name)
+	if !rg.has(name) {
+		name = rg.wildcard(name)
+	}
+	return rg.lookup(name, kind)
+}
+
+// Exists tells whether the given name has records of any kind, its own or
+// those of an enclosing wildcard name, as matched by Lookup.
+func (rg *RecordGenerator) Exists(name string) bool {
+	rg = rg.view()
+	name = normalizeName(name)
+	return rg.has(name) || rg.wildcard(name) != ""
+}
+
+// LookupSubtree returns the records named the given suffix or any name under
+// it, wildcard names included, ordered by kind (A, AAAA, SRV, PTR then TXT),
+// name and insertion order like WriteTo. The suffix is matched case insensitively, with
+// or without its trailing dot, and on label boundaries: the subtree of
+// mesos. holds marathon.mesos. but not dcos-mesos. The records are those of
+// the Snapshot of rg, if any, like those of Lookup.
+func (rg *RecordGenerator) LookupSubtree(suffix string) []Record {
+	rg = rg.view()
+	suffix = normalizeName(suffix)
+	var recs []Record
+	for _, kind := range []rrsKind{A, AAAA, SRV, PTR, TXT} {
+		for _, name := range kind.rrs(rg).Names() {
+			if inSubtree(name, suffix) {
+				recs = append(recs, rg.lookup(name, kind)...)
+			}
+		}
+	}
+	return recs
+}
+
+// lookup returns the records of the given kind named the given normalized
+// name, in insertion order.
+func (rg *RecordGenerator) lookup(name string, kind rrsKind) []Record {
+	hosts := kind.rrs(rg).Hosts(name)
+	if len(hosts) == 0 {
+		return nil
+	}
+	recs := make([]Record, len(hosts))
+	for i, host := range hosts {
+		recs[i] = Record{Name: name, Type: string(kind), Host: host}
+	}
+	return recs
+}
+
+// SRVData returns the rdata of the given SRV record, as returned by Lookup:
+// the target and port of its host, along with its priority and weight, 0 but
+// for weighted records.
+func (rg *RecordGenerator) SRVData(rec Record) (SRVData, error) {
+	if d, ok := rg.view().SRVs.set(rec.Name).weight(rec.Host); ok {
+		return d, nil
+	}
+	return ParseSRV(rec.Host)
+}
+
+// has tells whether the given normalized name has records of any kind of its
+// own.
+func (rg *RecordGenerator) has(name string) bool {
+	return len(rg.As.get(name))+len(rg.AAAAs.get(name))+len(rg.SRVs.get(name))+len(rg.PTRs.get(name))+len(rg.TXTs.get(name)) > 0
+}
+
+// wildcard returns the closest wildcard name with records enclosing the given
+// normalized name, if any.
+func (rg *RecordGenerator) wildcard(name string) string {
+	for parent := name; ; {
+		i := strings.IndexByte(parent, '.')
+		if i < 0 || i == len(parent)-1 {
+			return ""
+		}
+		parent = parent[i+1:]
+		if w := "*." + parent; rg.has(w) {
+			return w
+		}
+	}
+}
+
+// inSubtree tells whether the given normalized name is the given normalized
+// suffix or under it.
+func inSubtree(name, suffix string) bool {
+	return suffix == "." || name == suffix || strings.HasSuffix(name, "."+suffix)
+}
@@ -0,0 +1,95 @@
+// Package naming provides the pipeline generating the names of the SRV
+// records of task ports: chains of links, each deriving record names from
+// those it's given before handing them over to the next one, the last of
+// which inserts the records.
+package naming
+
+import (
+	"github.com/mesosphere/mesos-dns/records/labels"
+)
+
+// SubdomainNone is the subdomain indicating to generate records without a
+// subdomain fragment.
+const SubdomainNone = ""
+
+// Chain is a generation func that consumes record-like strings and does
+// something with them.
+type Chain func(...string)
+
+// Link returns a Chain deriving record names from those it consumes before
+// handing them over to the given one, e.g. to append a naming dimension.
+type Link func(gen Chain) Chain
+
+// Subdomain returns a Link appending `.{subdomain}` to records.
+func Subdomain(subdomain string) Link {
+	return func(gen Chain) Chain {
+		return WithSubdomains([]string{subdomain}, gen)
+	}
+}
+
+// WithLinks returns a Chain applying the given links, in order, before
+// handing records over to the given Chain.
+func WithLinks(links []Link, gen Chain) Chain {
+	for i := len(links) - 1; i >= 0; i-- {
+		gen = links[i](gen)
+	}
+	return gen
+}
+
+// WithProtocols appends `._{protocol}.{framework}` to records, for each of
+// the given protocols.
+func WithProtocols(protocols []string, framework string, gen Chain) Chain {
+	return func(records ...string) {
+		all := make([]string, 0, len(records)*len(protocols))
+		for _, protocol := range protocols {
+			for _, record := range records {
+				all = append(all, record+"._"+protocol+"."+framework)
+			}
+		}
+		gen(all...)
+	}
+}
+
+// WithSubdomains appends `.{subdomain}` (for each subdomain spec'd) to records.
+// the empty subdomain "" indicates to generate records w/o a subdomain fragment.
+func WithSubdomains(subdomains []string, gen Chain) Chain {
+	if len(subdomains) == 0 {
+		return gen
+	}
+	return func(records ...string) {
+		var (
+			recordLen = len(records)
+			tmp       = make([]string, recordLen*len(subdomains))
+			offset    = 0
+		)
+		for s := range subdomains {
+			if subdomains[s] == SubdomainNone {
+				copy(tmp[offset:], records)
+			} else {
+				for i := range records {
+					tmp[offset+i] = records[i] + "." + subdomains[s]
+				}
+			}
+			offset += recordLen
+		}
+		gen(tmp...)
+	}
+}
+
+// WithNamedPort prepends a `_{discoveryInfo port name}.` to records
+func WithNamedPort(portName string, spec labels.Func, gen Chain) Chain {
+	portName = spec(portName)
+	if portName == "" {
+		return gen
+	}
+	return func(records ...string) {
+		// generate without port-name prefix
+		gen(records...)
+
+		// generate with port-name prefix
+		for i := range records {
+			records[i] = "_" + portName + "." + records[i]
+		}
+		gen(records...)
+	}
+}
@@ -0,0 +1,71 @@
+package naming
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+)
+
+// collect returns a Chain appending the records it consumes to the given
+// slice.
+func collect(records *[]string) Chain {
+	return func(names ...string) { *records = append(*records, names...) }
+}
+
+// region is a custom link appending a constant region subdomain to records.
+func region(gen Chain) Chain {
+	return func(records ...string) {
+		for i := range records {
+			records[i] += ".us-east-1"
+		}
+		gen(records...)
+	}
+}
+
+func TestChains(t *testing.T) {
+	for i, tt := range []struct {
+		gen  func(Chain) Chain
+		want []string
+	}{
+		{
+			func(gen Chain) Chain { return WithProtocols([]string{"tcp", "udp"}, "marathon", gen) },
+			[]string{"_web._tcp.marathon", "_web._udp.marathon"},
+		},
+		{
+			func(gen Chain) Chain {
+				return WithProtocols([]string{"tcp"}, "marathon", WithSubdomains([]string{"slave", SubdomainNone}, gen))
+			},
+			[]string{"_web._tcp.marathon.slave", "_web._tcp.marathon"},
+		},
+		{
+			func(gen Chain) Chain {
+				return WithProtocols([]string{"udp"}, "marathon", WithNamedPort("DNS", labels.RFC1123, gen))
+			},
+			[]string{"_web._udp.marathon", "_dns._web._udp.marathon"},
+		},
+		{
+			func(gen Chain) Chain {
+				return WithProtocols([]string{"tcp"}, "marathon", WithNamedPort("", labels.RFC1123, gen))
+			},
+			[]string{"_web._tcp.marathon"},
+		},
+		{ // custom links apply in order
+			func(gen Chain) Chain {
+				return WithProtocols([]string{"tcp"}, "marathon",
+					WithLinks([]Link{region, Subdomain("zone-a")}, WithSubdomains([]string{"slave"}, gen)))
+			},
+			[]string{"_web._tcp.marathon.us-east-1.zone-a.slave"},
+		},
+		{ // no links
+			func(gen Chain) Chain { return WithLinks(nil, WithSubdomains(nil, gen)) },
+			[]string{"_web"},
+		},
+	} {
+		var got []string
+		tt.gen(collect(&got))("_web")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package records
+
+import (
+	"net"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/mesosphere/mesos-dns/records/state/upid"
+	"github.com/mesosphere/mesos-dns/urls"
+)
+
+// normalizeState returns a copy of the given state which is safe to generate
+// records from: nil slices are defaulted, slaves lacking a PID fall back to
+// their hostname (or are dropped if they have none) and tasks missing an ID
+// or a slave ID are dropped. Dropped entities are counted and logged; dropped
+// tasks are accounted for in the given stats.
+func normalizeState(sj state.State, stats *GenerationStats) state.State {
+	slaves := make([]state.Slave, 0, len(sj.Slaves))
+	for _, s := range sj.Slaves {
+		if s.PID.UPID == nil {
+			if s.Hostname == "" {
+				logging.CurLog.MalformedSlaves.Inc()
+				stats.eventFrom(EventMalformed, SourceSlave)
+				logging.VeryVerbose.Printf("skipping slave %q: neither pid nor hostname", s.ID)
+				continue
+			}
+			s.PID.UPID = &upid.UPID{Host: s.Hostname}
+		}
+		slaves = append(slaves, s)
+	}
+	sj.Slaves = slaves
+
+	frameworks := make([]state.Framework, 0, len(sj.Frameworks))
+	for _, f := range sj.Frameworks {
+		f.Tasks = normalizeTasks(f.Tasks, f.Name, stats)
+		frameworks = append(frameworks, f)
+	}
+	sj.Frameworks = frameworks
+	sj.OrphanTasks = normalizeTasks(sj.OrphanTasks, orphansFramework, stats)
+	return sj
+}
+
+// normalizeTasks returns the given tasks of the named framework without the
+// ones missing an ID or a slave ID.
+func normalizeTasks(ts []state.Task, framework string, stats *GenerationStats) []state.Task {
+	tasks := make([]state.Task, 0, len(ts))
+	for _, t := range ts {
+		if t.ID == "" || t.SlaveID == "" {
+			logging.CurLog.MalformedTasks.Inc()
+			stats.eventFrom(EventMalformed, SourceTask)
+			stats.Tasks++
+			stats.skip(SkipMalformed)
+			logging.VeryVerbose.Printf("skipping task %q of framework %q: missing id or slave id", t.Name, framework)
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// normalizeHost returns the given hostname or IP address in the form it's
+// resolved and compared in: trimmed of surrounding whitespace and of one
+// trailing dot, lowercased, and, for IP addresses, in their canonical form.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(host), "."))
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// normalizeHostPort returns the given host:port pair with its host
+// normalized as per normalizeHost, or trimmed of surrounding whitespace if
+// it can't be split.
+func normalizeHostPort(hostPort string) string {
+	hostPort = strings.TrimSpace(hostPort)
+	host, port, err := urls.SplitHostPort(hostPort)
+	if err != nil {
+		return hostPort
+	}
+	return net.JoinHostPort(normalizeHost(host), port)
+}
@@ -0,0 +1,107 @@
+package records
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+func TestNormalizeState(t *testing.T) {
+	var stats GenerationStats
+	sj := normalizeState(state.State{
+		Slaves: []state.Slave{
+			{ID: "no-pid-no-hostname"},
+			{ID: "no-pid", Hostname: "10.0.1.2"},
+			slave("s-1", "10.0.1.1"),
+		},
+		Frameworks: []state.Framework{
+			{Name: "no-tasks"},
+			{Name: "marathon", Tasks: []state.Task{
+				runningTask("", "no-id", "s-1"),
+				runningTask("no-slave-id", "no-slave-id", ""),
+				runningTask("ok", "ok", "s-1"),
+			}},
+		},
+	}, &stats)
+
+	if got := len(sj.Slaves); got != 2 {
+		t.Fatalf("got %d slaves, want 2", got)
+	}
+	if pid := sj.Slaves[0].PID; pid.UPID == nil || pid.Host != "10.0.1.2" {
+		t.Errorf("expected pid to fall back to hostname, got %+v", pid.UPID)
+	}
+	if sj.Frameworks[0].Tasks == nil {
+		t.Error("expected nil tasks to be defaulted")
+	}
+	if tasks := sj.Frameworks[1].Tasks; len(tasks) != 1 || tasks[0].ID != "ok" {
+		t.Errorf("got tasks %+v, want only task %q", tasks, "ok")
+	}
+
+	if got := stats.Skipped[SkipMalformed]; got != 2 {
+		t.Errorf("got %d malformed tasks, want 2", got)
+	}
+
+	if sj := normalizeState(state.State{}, &stats); sj.Slaves == nil || sj.Frameworks == nil {
+		t.Error("expected nil slices to be defaulted")
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	for i, tt := range []struct {
+		host, want string
+	}{
+		{"master01.example.com", "master01.example.com"},
+		{"Master01.example.com.", "master01.example.com"},
+		{" master01.EXAMPLE.com \n", "master01.example.com"},
+		{"10.0.0.1.", "10.0.0.1"},
+		{"2001:DB8:0::1", "2001:db8::1"},
+		{"", ""},
+	} {
+		if got := normalizeHost(tt.host); got != tt.want {
+			t.Errorf("test #%d: got %q for %q, want %q", i, got, tt.host, tt.want)
+		}
+	}
+	for i, tt := range []struct {
+		hostPort, want string
+	}{
+		{"Master01.example.com.:5050", "master01.example.com:5050"},
+		{" 10.0.0.1.:5050 ", "10.0.0.1:5050"},
+		{"[2001:DB8::1]:5050", "[2001:db8::1]:5050"},
+		{" bogus ", "bogus"},
+	} {
+		if got := normalizeHostPort(tt.hostPort); got != tt.want {
+			t.Errorf("test #%d: got %q for %q, want %q", i, got, tt.hostPort, tt.want)
+		}
+	}
+}
+
+func TestInsertState_NormalizedHosts(t *testing.T) {
+	var lookups []string
+	resolver := fakeResolver(func(host string) ([]net.IP, error) {
+		lookups = append(lookups, host)
+		return []net.IP{net.ParseIP("10.0.0.1")}, nil
+	})
+	sj := state.State{
+		Slaves: []state.Slave{slave("s1", "Master01.example.com."), slave("s2", " master01.example.com")},
+		Frameworks: []state.Framework{
+			{ID: "f1", Name: "marathon", Hostname: "MASTER01.example.com."},
+		},
+	}
+	rg := RecordGenerator{resolver: resolver}
+	if err := rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"host"}, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	// looked up once by the framework pass and once by the slave one
+	if want := []string{"master01.example.com", "master01.example.com"}; !reflect.DeepEqual(lookups, want) {
+		t.Errorf("got lookups %q, want %q", lookups, want)
+	}
+	if got, want := rg.As.Hosts("slave.mesos."), []string{"10.0.0.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got slave records %q, want %q", got, want)
+	}
+	if got, want := rg.As.Hosts("marathon.mesos."), []string{"10.0.0.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got framework records %q, want %q", got, want)
+	}
+}
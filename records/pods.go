@@ -0,0 +1,51 @@
+package records
+
+import (
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// marathonInstancePrefix prefixes the executor IDs of the instances of
+// Marathon pods, e.g. instance-web.1f8a3c2e-..., web being the pod ID.
+const marathonInstancePrefix = "instance-"
+
+// podName returns the name of the pod, or task group, of the given executor
+// ID: the pod ID of the executors of Marathon pod instances, or else the
+// executor ID as is.
+func podName(executorID string) string {
+	if !strings.HasPrefix(executorID, marathonInstancePrefix) {
+		return executorID
+	}
+	name := strings.TrimPrefix(executorID, marathonInstancePrefix)
+	if i := strings.LastIndex(name, "."); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// podTaskRecords inserts the A and AAAA records of the given task of a pod, or
+// task group, whose tasks share the executor and network namespace, under
+// the name of the pod and under its own name within it, listing the
+// addresses of the canonical name:
+//
+//	pod.framework.domain.           // shared by the tasks of the pod
+//	container.pod.framework.domain. // the task in the pod
+//
+// The pod of the task, if any, is listed with its enumerated records.
+func (rg *RecordGenerator) podTaskRecords(ctx context, task state.Task, fname, domain string, spec labels.Func, enumTask *EnumerableTask) {
+	if task.ExecutorID == "" {
+		return
+	}
+	pod := rg.label(podName(task.ExecutorID), spec)
+	if pod == "" {
+		return
+	}
+	enumTask.Pod = pod
+	tail := "." + fname + "." + domain + "."
+	for _, tIP := range ctx.taskIPs {
+		rg.insertTaskRR(pod+tail, tIP.String(), rrsKindForIP(tIP), ctx.source, enumTask)
+		rg.insertTaskRR(ctx.taskName+"."+pod+tail, tIP.String(), rrsKindForIP(tIP), ctx.source, enumTask)
+	}
+}
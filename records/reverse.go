@@ -0,0 +1,146 @@
+package records
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// ReverseDomains returns the reverse zones of the networks of ReverseZones,
+// see reverseZones, which Validate checked.
+func (c *Config) ReverseDomains() []string {
+	var zones []string
+	for _, n := range parseCIDRs(c.ReverseZones) {
+		zones = append(zones, reverseZones(n)...)
+	}
+	return unique(zones)
+}
+
+// validateReverseZones checks that each of the given networks is in CIDR
+// notation and that none of their reverse zones is a zone of ZoneResolvers,
+// whose queries are forwarded.
+func validateReverseZones(cidrs []string, zoneResolvers map[string][]string) error {
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("#%d: %v", i, err)
+		}
+		for _, zone := range reverseZones(n) {
+			if _, ok := zoneResolvers[zone]; ok {
+				return fmt.Errorf("#%d: reverse zone %s is a ZoneResolvers zone", i, zone)
+			}
+		}
+	}
+	return nil
+}
+
+// reverseZones returns the names of the reverse zones, under in-addr.arpa for
+// IPv4 networks and ip6.arpa for IPv6 ones, which exactly cover the given
+// network. Zones are cut at octet, or nibble, boundaries, so that's the zone
+// of the network itself if its prefix length is a multiple of 8, or 4, and
+// otherwise those of its subnets of the next such prefix length, e.g. the 16
+// /24 zones of a /20 or the 4 /32 ones of a /30.
+func reverseZones(n *net.IPNet) []string {
+	ones, _ := n.Mask.Size()
+	ip, step, suffix := n.IP.To4(), 8, "in-addr.arpa"
+	if ip == nil {
+		ip, step, suffix = n.IP.To16(), 4, "ip6.arpa"
+	}
+	cut := (ones + step - 1) / step * step
+	width := uint(cut - ones)
+	zones := make([]string, 0, 1<<width)
+	for i := 0; i < 1<<width; i++ {
+		sub := append(net.IP(nil), ip...)
+		for b := uint(0); b < width; b++ {
+			if i>>(width-1-b)&1 == 1 {
+				p := uint(ones) + b
+				sub[p/8] |= 0x80 >> (p % 8)
+			}
+		}
+		labels := []string{suffix}
+		for j := 0; j < cut/step; j++ {
+			labels = append(labels, reverseLabel(sub, j, step))
+		}
+		for l, r := 0, len(labels)-1; l < r; l, r = l+1, r-1 {
+			labels[l], labels[r] = labels[r], labels[l]
+		}
+		zones = append(zones, strings.Join(labels, "."))
+	}
+	return zones
+}
+
+// reverseLabel returns the label of the given IP address for the j-th octet,
+// as a decimal number, if step is 8, or else nibble, as a hexadecimal digit.
+func reverseLabel(ip net.IP, j, step int) string {
+	if step == 8 {
+		return strconv.Itoa(int(ip[j]))
+	}
+	nibble := ip[j/2] >> 4
+	if j%2 == 1 {
+		nibble = ip[j/2] & 0xf
+	}
+	return strconv.FormatUint(uint64(nibble), 16)
+}
+
+// reverseOwned tells whether the given IP address is in one of the networks
+// of ReverseZones, which PTR records are restricted to.
+func (rg *RecordGenerator) reverseOwned(ip net.IP) bool {
+	for _, n := range rg.reverseNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reverseName returns the name of the PTR records of the given IP address,
+// under in-addr.arpa for IPv4 addresses and ip6.arpa for IPv6 ones.
+func reverseName(ip net.IP) string {
+	sub, step, suffix := ip.To4(), 8, "in-addr.arpa."
+	if sub == nil {
+		sub, step, suffix = ip.To16(), 4, "ip6.arpa."
+	}
+	n := len(sub) * 8 / step
+	labels := make([]string, 0, n+1)
+	for j := n - 1; j >= 0; j-- {
+		labels = append(labels, reverseLabel(sub, j, step))
+	}
+	return strings.Join(append(labels, suffix), ".")
+}
+
+// insertPTR inserts a PTR record of the given IP address targeting the given
+// name, if the address is in one of the networks of ReverseZones.
+func (rg *RecordGenerator) insertPTR(ip, target string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && rg.reverseOwned(parsed) && rg.insertRR(reverseName(parsed), target, PTR)
+}
+
+// taskPTRRecords inserts the PTR records of the given task, if it has any
+// address in the networks of ReverseZones: its IP addresses target its
+// canonical name, and those of its slave the canonical name of the task on
+// the slave, so that the tasks sharing a slave each get a PTR record of its
+// address. Slave addresses which are task addresses too, e.g. with host
+// networking, only get the former.
+func (rg *RecordGenerator) taskPTRRecords(ctx context, f state.Framework, domain string, spec labels.Func, enumTask *EnumerableTask) {
+	if len(rg.reverseNets) == 0 {
+		return
+	}
+	canonical := rg.canonicalName(ctx.taskName, ctx.taskID, ctx.slaveID, rg.frameworkFrag(f, spec))
+	tail := "." + domain + "."
+	taskIPs := map[string]bool{}
+	for _, ip := range ctx.taskIPs {
+		taskIPs[ip.String()] = true
+		if rg.reverseOwned(ip) {
+			rg.insertTaskRR(reverseName(ip), canonical+tail, PTR, ctx.source, enumTask)
+		}
+	}
+	for _, s := range ctx.slaveIPs {
+		if ip := net.ParseIP(s); ip != nil && !taskIPs[ip.String()] && rg.reverseOwned(ip) {
+			rg.insertTaskRR(reverseName(ip), canonical+".slave"+tail, PTR, ctx.source, enumTask)
+		}
+	}
+}
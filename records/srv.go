@@ -0,0 +1,81 @@
+package records
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// Keys of the DiscoveryInfo and discovery port labels setting the priority
+// and weight of the SRV records of task ports.
+const (
+	PriorityLabel = "priority"
+	WeightLabel   = "weight"
+)
+
+// SRVData holds the rdata of an SRV record. The host of the record is its
+// String, target:port, like that of unweighted records, its priority and
+// weight being stored along with it, see RecordGenerator.SRVData.
+type SRVData struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// ParseSRV parses the given host of an SRV record, target:port, into the
+// SRVData of an unweighted record.
+func ParseSRV(host string) (SRVData, error) {
+	var d SRVData
+	target, port, err := net.SplitHostPort(host)
+	if err != nil || strings.ContainsAny(target, " \t") {
+		return d, fmt.Errorf("invalid SRV target %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return d, fmt.Errorf("invalid SRV port %q", port)
+	}
+	d.Target, d.Port = target, uint16(p)
+	return d, nil
+}
+
+// String returns the host of the SRV record of d.
+func (d SRVData) String() string {
+	return net.JoinHostPort(d.Target, strconv.Itoa(int(d.Port)))
+}
+
+// srvWeights returns the priority and weight of the SRV records of the given
+// task's given discovery port or, if nil, of its other ports: those of the
+// labels of the port, if any, or else of its DiscoveryInfo labels, 0 without
+// either. Values which aren't 16 bit unsigned integers are ignored.
+func srvWeights(task *state.Task, port *state.DiscoveryPort) (priority, weight uint16) {
+	lists := [][]state.Label{task.DiscoveryInfo.Labels.Labels}
+	if port != nil {
+		// port labels take precedence
+		lists = append(lists, port.Labels.Labels)
+	}
+	for _, ls := range lists {
+		for _, l := range ls {
+			var v *uint16
+			switch l.Key {
+			case PriorityLabel:
+				v = &priority
+			case WeightLabel:
+				v = &weight
+			default:
+				continue
+			}
+			n, err := strconv.ParseUint(strings.TrimSpace(l.Value), 10, 16)
+			if err != nil {
+				logging.VeryVerbose.Printf("ignoring label %s=%q of task %q: not a 16 bit unsigned integer", l.Key, l.Value, task.ID)
+				continue
+			}
+			*v = uint16(n)
+		}
+	}
+	return priority, weight
+}
@@ -1,12 +1,17 @@
 package client
 
 import (
+	"compress/gzip"
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/mesosphere/mesos-dns/errorutil"
 	"github.com/mesosphere/mesos-dns/httpcli"
@@ -15,31 +20,79 @@ import (
 	"github.com/mesosphere/mesos-dns/urls"
 )
 
+const (
+	// APIV1Path is the path of the Mesos v1 operator API. State endpoints with it are POSTed a GET_STATE call,
+	// whose response an Unmarshaler such as state.UnmarshalV1 must handle. Masters not supporting the call
+	// are fetched the state from LegacyStatePath instead.
+	APIV1Path = "/api/v1"
+	// LegacyStatePath is the path of the /state.json Mesos HTTP endpoint.
+	LegacyStatePath = "/master/state.json"
+)
+
+// getStateCall is the body of the GET_STATE call of the Mesos v1 operator API.
+const getStateCall = `{"type":"GET_STATE"}`
+
 type (
 	// StateLoader attempts to read state from the leading Mesos master and return the parsed content.
 	StateLoader func(masters []string) (state.State, error)
 
-	// Unmarshaler parses raw byte content into a State object
+	// Decoder decodes the state read from the given reader, the body of a state response, into a State, e.g.
+	// state.Decode, which streams it.
+	Decoder func(io.Reader, *state.State) error
+
+	// Unmarshaler parses raw byte content into a State object; its Decoder adapts it into a Decoder.
 	Unmarshaler func([]byte, *state.State) error
+
+	// StateSizeError is returned when the state response of a master exceeds the maximum state size.
+	StateSizeError struct {
+		// Address is the host:port the state was fetched from
+		Address string
+		// Max is the maximum state size, in bytes
+		Max int64
+		// ContentLength is the size the master declared, if any, or -1
+		ContentLength int64
+	}
 )
 
+// Decoder returns a Decoder reading the whole state before unmarshaling it with u.
+func (u Unmarshaler) Decoder() Decoder {
+	return func(r io.Reader, s *state.State) error {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return u(b, s)
+	}
+}
+
+func (e *StateSizeError) Error() string {
+	if e.ContentLength >= 0 {
+		return fmt.Sprintf("state from %s declared as %d bytes exceeds the maximum of %d bytes", e.Address, e.ContentLength, e.Max)
+	}
+	return fmt.Sprintf("state from %s exceeds the maximum of %d bytes", e.Address, e.Max)
+}
+
 // NewStateLoader generates a new Mesos master state loader using the given http client and initial endpoint.
-func NewStateLoader(doer httpcli.Doer, initialEndpoint urls.Builder, unmarshal Unmarshaler) StateLoader {
+// The outcome of every fetch is tracked in the given, optional, MasterHealth which is also consulted to
+// try healthy masters before failing ones.
+func NewStateLoader(doer httpcli.Doer, initialEndpoint urls.Builder, decode Decoder, health *MasterHealth) StateLoader {
 	return func(masters []string) (state.State, error) {
-		return LoadMasterStateTryAll(masters, func(ip, port string) (state.State, error) {
+		return LoadMasterStateTryAll(health.prefer(masters), func(ip, port string) (state.State, error) {
 			return LoadMasterStateFailover(ip, func(tryIP string) (state.State, error) {
-				return LoadMasterState(doer, initialEndpoint, tryIP, port, unmarshal)
+				return LoadMasterState(doer, initialEndpoint, tryIP, port, decode, health)
 			})
 		})
 	}
 
 }
 
-// LoadMasterStateTryAll tries each master and looks for the leader; if no leader responds it errors.
+// LoadMasterStateTryAll tries each master and looks for the leader; if no leader responds it errors,
+// listing the error of every master tried should there be several.
 // The first master in the list is assumed to be the leading mesos master.
 func LoadMasterStateTryAll(masters []string, stateLoader func(ip, port string) (state.State, error)) (state.State, error) {
 	var sj state.State
 	var leader string
+	var errs []string
 
 	if len(masters) > 0 {
 		leader, masters = masters[0], masters[1:]
@@ -61,6 +114,7 @@ func LoadMasterStateTryAll(masters []string, stateLoader func(ip, port string) (
 				return sj, err
 			}
 			logging.Error.Println("Falling back to remaining masters: ", masters)
+			errs = append(errs, fmt.Sprintf("%s: %v", leader, err))
 		}
 	}
 
@@ -76,14 +130,21 @@ func LoadMasterStateTryAll(masters []string, stateLoader func(ip, port string) (
 			continue
 		}
 
+		if len(errs) > 0 {
+			logging.CurLog.MasterStateFailovers.Inc()
+		}
 		if sj, err = stateLoader(ip, port); err != nil {
 			logging.Error.Println("Failed to fetch state.json - trying next one. Error: ", err)
+			errs = append(errs, fmt.Sprintf("%s: %v", master, err))
 			continue
 		}
 		return sj, nil
 	}
 
 	logging.Error.Println("No more masters eligible for state.json query, returning last error")
+	if len(errs) > 1 {
+		return sj, fmt.Errorf("failed to fetch state.json from all masters: %s", strings.Join(errs, "; "))
+	}
 	return sj, err
 }
 
@@ -108,6 +169,7 @@ func LoadMasterStateFailover(initialMasterIP string, stateLoader func(ip string)
 		}
 		if stateLeaderIP != initialMasterIP {
 			logging.VeryVerbose.Println("Warning: master changed to " + stateLeaderIP)
+			logging.CurLog.MasterStateFailovers.Inc()
 			return stateLoader(stateLeaderIP)
 		}
 		return sj, nil
@@ -116,43 +178,233 @@ func LoadMasterStateFailover(initialMasterIP string, stateLoader func(ip string)
 	return sj, err
 }
 
-// LoadMasterState loads state.json from mesos master
-func LoadMasterState(client httpcli.Doer, stateEndpoint urls.Builder, ip, port string, unmarshal Unmarshaler) (sj state.State, _ error) {
+// LoadMasterState loads state.json from mesos master, accounting for the outcome in the given, optional,
+// MasterHealth. The response is asked for gzip compressed, decompressed if so, and decoded as it's read,
+// with the given Decoder. Responses larger than the maximum state size of the MasterHealth, or
+// DefaultMaxStateBytes without one, once decompressed, fail with a StateSizeError rather than being read
+// in full.
+func LoadMasterState(client httpcli.Doer, stateEndpoint urls.Builder, ip, port string, decode Decoder, health *MasterHealth) (state.State, error) {
+	return LoadMasterStateContext(context.Background(), client, stateEndpoint, ip, port, decode, health)
+}
+
+// LoadMasterStateContext is like LoadMasterState, but gives up once the given context is done. Requests
+// given up on this way count as attempts, but not as failures, in the MasterHealth.
+//
+// State endpoints with the APIV1Path are POSTed a GET_STATE call. Responses to it, as those of the
+// StateSummaryPath endpoint, don't tell the leader, which is taken to be the master answering, after
+// redirects. Masters answering the call with a status telling they don't support it are fetched the state
+// from the LegacyStatePath instead, with a warning.
+//
+// With retries enabled by MasterHealth.SetRetry, fetches failing for transient reasons, the master being
+// unreachable, breaking the connection or responding with a 5xx or 429 status, are retried after an
+// exponentially growing, jittered, backoff. Should every attempt fail, an AttemptsError lists them.
+func LoadMasterStateContext(ctx context.Context, client httpcli.Doer, stateEndpoint urls.Builder, ip, port string, decode Decoder, health *MasterHealth) (state.State, error) {
+	addr := net.JoinHostPort(ip, port)
+	attempts, maxBackoff := health.retry()
+	var errs []error
+	for retry := 1; ; retry++ {
+		sj, err := loadMasterState(ctx, client, stateEndpoint, ip, port, decode, health)
+		if err == nil {
+			return sj, nil
+		}
+		errs = append(errs, err)
+		if len(errs) >= attempts || !retryable(err) || ctx.Err() != nil {
+			break
+		}
+		d := backoff(retry, maxBackoff)
+		logging.Verbose.Printf("retrying to fetch the state from %s in %s", addr, d)
+		health.retried(addr)
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return state.State{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if len(errs) == 1 {
+		return state.State{}, errs[0]
+	}
+	return state.State{}, &AttemptsError{Address: addr, Errs: errs}
+}
+
+// loadMasterState makes a single attempt of LoadMasterStateContext.
+func loadMasterState(ctx context.Context, client httpcli.Doer, stateEndpoint urls.Builder, ip, port string, decode Decoder, health *MasterHealth) (sj state.State, _ error) {
 	// REFACTOR: state.json security
 
-	u := url.URL(stateEndpoint.With(urls.Host(net.JoinHostPort(ip, port))))
+	addr := net.JoinHostPort(ip, port)
+	u := url.URL(stateEndpoint.With(urls.Host(addr)))
+	v1 := u.Path == APIV1Path
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+	var (
+		req *http.Request
+		err error
+	)
+	if v1 {
+		req, err = http.NewRequest("POST", u.String(), strings.NewReader(getStateCall))
+	} else {
+		req, err = http.NewRequest("GET", u.String(), nil)
+	}
 	if err != nil {
 		logging.Error.Println(err)
 		return state.State{}, err
 	}
+	req = req.WithContext(ctx)
+
+	if !health.allow(addr) {
+		return sj, fmt.Errorf("skipped %s: circuit breaker open", addr)
+	}
 
-	req.Header.Set("Content-Type", "application/json") // TODO(jdef) unclear why Content-Type vs. Accept
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", "Mesos-DNS")
+	// asking for gzip explicitly keeps the transport from decompressing responses itself, which would hide
+	// the compressed size from us
+	req.Header.Set("Accept-Encoding", "gzip")
 
+	health.attempt(addr)
 	resp, err := client.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			health.abandoned(addr)
+			return sj, ctx.Err()
+		}
 		logging.Error.Println(err)
+		health.failed(addr, classify(err))
 		return sj, err
 	}
 
+	if v1 && unsupportedV1(resp.StatusCode) {
+		errorutil.Ignore(resp.Body.Close)
+		health.abandoned(addr)
+		logging.Error.Printf("warning: %s doesn't support the v1 operator API (%s), falling back to %s",
+			addr, resp.Status, LegacyStatePath)
+		return loadMasterState(ctx, client, stateEndpoint.With(urls.Path(LegacyStatePath)), ip, port, decode, health)
+	}
+
 	defer errorutil.Ignore(resp.Body.Close)
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	limit := health.maxStateBytes()
+	if resp.ContentLength > limit {
+		err = &StateSizeError{Address: addr, Max: limit, ContentLength: resp.ContentLength}
 		logging.Error.Println(err)
+		health.failed(addr, FailureSize)
+		return sj, err
+	}
+	ok := resp.StatusCode >= 200 && resp.StatusCode <= 299
+	gzipped := ok && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip")
+	wire := &countingReader{r: resp.Body}
+	var content io.Reader = wire
+	if gzipped {
+		content = &gzipReader{r: wire}
+	}
+	// read one byte past the maximum to tell responses of exactly the maximum size from larger ones
+	body := &countingReader{r: io.LimitReader(content, limit+1)}
+	if ok {
+		err = decode(body, &sj)
+	} else {
+		_, err = io.Copy(ioutil.Discard, body)
+	}
+	health.received(addr, int(wire.n))
+	if gzipped {
+		logging.VeryVerbose.Printf("state from %s: %d bytes, %d gzip compressed", addr, body.n, wire.n)
+	}
+	if body.n > limit {
+		err = &StateSizeError{Address: addr, Max: limit, ContentLength: -1}
+		logging.Error.Println(err)
+		health.failed(addr, FailureSize)
+		return state.State{}, err
+	}
+	if wire.err != nil {
+		if ctx.Err() != nil {
+			health.abandoned(addr)
+			return state.State{}, ctx.Err()
+		}
+		logging.Error.Println(wire.err)
+		health.failed(addr, classify(wire.err))
+		return state.State{}, wire.err
+	}
+	if body.err != nil { // only decompression fails past the wire
+		err = fmt.Errorf("malformed gzip response from %s: %v", addr, body.err)
+		logging.Error.Println(err)
+		health.failed(addr, FailureDecode)
+		return state.State{}, err
+	}
+
+	if !ok {
+		err = &StatusError{Address: addr, Status: resp.Status, Code: resp.StatusCode}
+		logging.Error.Println(err)
+		health.failed(addr, FailureStatus)
 		return sj, err
 	}
 
-	err = unmarshal(body, &sj)
 	if err != nil {
 		logging.Error.Println(err)
+		health.failed(addr, FailureDecode)
 		return sj, err
 	}
 
+	answering := addr
+	if resp.Request != nil && resp.Request.URL != nil && resp.Request.URL.Host != "" {
+		answering = resp.Request.URL.Host
+	}
+	if answering != addr {
+		logging.VeryVerbose.Printf("state request to %s redirected to %s", addr, answering)
+		logging.CurLog.MasterStateFailovers.Inc()
+	}
+	if (v1 || u.Path == StateSummaryPath) && sj.Leader == "" {
+		sj.Leader = "master@" + answering
+	}
+
+	health.succeeded(addr)
+	health.led(sj.Leader)
 	return
 }
 
+// countingReader reads from r, counting the bytes read and keeping the first error other than io.EOF, so
+// that read errors can be told from those of decoders reading from it.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// gzipReader decompresses the gzip stream read from r, from its first read on, so that malformed streams
+// fail reads rather than its creation.
+type gzipReader struct {
+	r  io.Reader
+	zr *gzip.Reader
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	if g.zr == nil {
+		zr, err := gzip.NewReader(g.r)
+		if err != nil {
+			return 0, err
+		}
+		g.zr = zr
+	}
+	return g.zr.Read(p)
+}
+
+// unsupportedV1 tells whether the given status of a response to a v1 operator API call tells the master
+// doesn't support it: Mesos masters predating the API don't serve it and those predating the call reject it.
+func unsupportedV1(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
 // leaderIP returns the ip for the mesos master
 // input format master@ip:port
 func leaderIP(leader string) (string, error) {
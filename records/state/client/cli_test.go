@@ -1,9 +1,31 @@
 package client
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/httpcli"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/mesosphere/mesos-dns/urls"
 )
 
+func init() {
+	logging.VerboseFlag = false
+	logging.SetupLogs()
+}
+
 func TestInvalidLeaderIP(t *testing.T) {
 	l := "master!144.76.157.37;5050"
 
@@ -27,3 +49,376 @@ func TestLeaderIP(t *testing.T) {
 		t.Error("not parsing ip")
 	}
 }
+
+func TestLoadMasterState_Health(t *testing.T) {
+	const leader = `{"leader":"master@1.2.3.4:5050"}`
+	done := make(chan struct{})
+	handlers := map[string]http.HandlerFunc{
+		"ok": func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(leader))
+		},
+		"status": func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		},
+		"decode": func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"leader":`))
+		},
+		"timeout": func(w http.ResponseWriter, _ *http.Request) {
+			<-done
+		},
+	}
+	addrs := map[string]string{}
+	for name, h := range handlers {
+		server := httptest.NewServer(h)
+		defer server.Close()
+		addrs[name] = server.Listener.Addr().String()
+	}
+	// deferred last so that the blocked handler returns before the servers close
+	defer close(done)
+
+	// a listener closed right away gives an address refusing connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs["connect"] = l.Addr().String()
+	_ = l.Close()
+
+	var (
+		doer     = &http.Client{Timeout: 100 * time.Millisecond}
+		endpoint = urls.Builder{}.With(urls.Scheme("http"), urls.Path("/master/state.json"))
+		decode   = state.Decode
+		health   = NewMasterHealth()
+		now      = time.Unix(1456913692, 0)
+	)
+	health.now = func() time.Time { return now }
+
+	for _, name := range []string{"ok", "ok", "status", "decode", "timeout", "connect"} {
+		ip, port, _ := net.SplitHostPort(addrs[name])
+		_, err := LoadMasterState(doer, endpoint, ip, port, decode, health)
+		if (err == nil) != (name == "ok") {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+
+	got := map[string]MasterStats{}
+	for _, s := range health.Masters() {
+		got[s.Address] = s
+	}
+	for name, want := range map[string]MasterStats{
+		"ok": {
+			Attempts:    2,
+			Successes:   2,
+			Failures:    map[FailureClass]uint64{},
+			Bytes:       2 * uint64(len(leader)),
+			LastSuccess: now,
+		},
+		"status":  {Attempts: 1, Failures: map[FailureClass]uint64{FailureStatus: 1}, ConsecutiveFailures: 1, Bytes: 12},
+		"decode":  {Attempts: 1, Failures: map[FailureClass]uint64{FailureDecode: 1}, ConsecutiveFailures: 1, Bytes: 10},
+		"timeout": {Attempts: 1, Failures: map[FailureClass]uint64{FailureTimeout: 1}, ConsecutiveFailures: 1},
+		"connect": {Attempts: 1, Failures: map[FailureClass]uint64{FailureConnect: 1}, ConsecutiveFailures: 1},
+	} {
+		want.Address = addrs[name]
+		if !reflect.DeepEqual(got[want.Address], want) {
+			t.Errorf("%s: got %+v, want %+v", name, got[want.Address], want)
+		}
+	}
+}
+
+func TestMasterHealth_Prefer(t *testing.T) {
+	health := NewMasterHealth()
+	health.failed("10.0.0.2:5050", FailureTimeout)
+	health.failed("10.0.0.2:5050", FailureTimeout)
+	health.failed("10.0.0.3:5050", FailureConnect)
+	health.succeeded("10.0.0.4:5050")
+
+	masters := []string{"10.0.0.2:5050", "10.0.0.2:5050", "10.0.0.3:5050", "10.0.0.4:5050", "10.0.0.5:5050"}
+	want := []string{"10.0.0.2:5050", "10.0.0.4:5050", "10.0.0.5:5050", "10.0.0.3:5050", "10.0.0.2:5050"}
+	if got := health.prefer(masters); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if masters[1] != "10.0.0.2:5050" {
+		t.Errorf("prefer modified its input: %v", masters)
+	}
+
+	health.led("master@10.0.0.3:5050")
+	want = []string{"10.0.0.3:5050", "10.0.0.2:5050", "10.0.0.4:5050", "10.0.0.5:5050", "10.0.0.2:5050"}
+	if got := health.prefer(masters); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v with a leader, want %v", got, want)
+	}
+	health.led("master@10.0.0.6:5050")
+	want = []string{"10.0.0.6:5050", "10.0.0.2", "10.0.0.3:5050"}
+	if got := health.prefer([]string{"10.0.0.2", "10.0.0.3:5050"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v with an unlisted leader, want %v", got, want)
+	}
+	health.failed("10.0.0.6:5050", FailureConnect)
+	if got := health.Leader(); got != "" {
+		t.Errorf("got leader %q after it failed", got)
+	}
+
+	var none *MasterHealth
+	if got := none.prefer(masters); !reflect.DeepEqual(got, masters) {
+		t.Errorf("nil MasterHealth reordered masters: %v", got)
+	}
+}
+
+func TestMasterHealth_Breaker(t *testing.T) {
+	// the doer fails or succeeds as scripted, one outcome per request
+	var script []bool
+	doer := httpcli.DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if len(script) == 0 {
+			t.Fatalf("unexpected request to %s", req.URL.Host)
+		}
+		ok := script[0]
+		script = script[1:]
+		if !ok {
+			return nil, errors.New("boom")
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       ioutil.NopCloser(strings.NewReader(`{"leader":"master@1.2.3.4:5050"}`)),
+		}, nil
+	})
+	var (
+		endpoint = urls.Builder{}.With(urls.Scheme("http"), urls.Path("/master/state.json"))
+		decode   = state.Decode
+		health   = NewMasterHealth()
+		now      = time.Unix(1456913692, 0)
+	)
+	health.now = func() time.Time { return now }
+	health.SetBreaker(2, time.Minute)
+
+	stats := func() MasterStats {
+		ms := health.Masters()
+		if len(ms) != 1 {
+			t.Fatalf("got stats of %d masters, want 1", len(ms))
+		}
+		return ms[0]
+	}
+	for i, step := range []struct {
+		elapse  time.Duration
+		outcome []bool // scripted outcomes, none if skipped
+		ok      bool
+		breaker BreakerState
+	}{
+		{0, []bool{false}, false, BreakerClosed},
+		{0, []bool{false}, false, BreakerOpen},
+		{0, nil, false, BreakerOpen},                          // skipped
+		{30 * time.Second, nil, false, BreakerOpen},           // still cooling down
+		{30 * time.Second, []bool{false}, false, BreakerOpen}, // failed probe
+		{0, nil, false, BreakerOpen},
+		{time.Minute, []bool{true}, true, BreakerClosed}, // successful probe
+		{0, []bool{false}, false, BreakerClosed},
+	} {
+		now = now.Add(step.elapse)
+		script = step.outcome
+		_, err := LoadMasterState(doer, endpoint, "10.0.0.1", "5050", decode, health)
+		if (err == nil) != step.ok {
+			t.Errorf("step #%d: unexpected error: %v", i, err)
+		}
+		if len(script) > 0 {
+			t.Errorf("step #%d: master not requested", i)
+		}
+		if got := stats().Breaker; got != step.breaker {
+			t.Errorf("step #%d: got breaker %q, want %q", i, got, step.breaker)
+		}
+	}
+	if s := stats(); s.Skips != 3 || s.Attempts != 5 {
+		t.Errorf("got %d skips and %d attempts, want 3 and 5", s.Skips, s.Attempts)
+	}
+
+	// the breaker is half-open while probing, letting a single probe through
+	health = NewMasterHealth()
+	health.now = func() time.Time { return now }
+	health.SetBreaker(1, time.Minute)
+	health.failed("10.0.0.2:5050", FailureTimeout)
+	now = now.Add(time.Minute)
+	if !health.allow("10.0.0.2:5050") {
+		t.Error("probe not allowed")
+	}
+	if health.allow("10.0.0.2:5050") {
+		t.Error("second probe allowed")
+	}
+	if got := health.Masters()[0].Breaker; got != BreakerHalfOpen {
+		t.Errorf("got breaker %q while probing, want %q", got, BreakerHalfOpen)
+	}
+	health.abandoned("10.0.0.2:5050")
+	if !health.allow("10.0.0.2:5050") {
+		t.Error("probe not allowed after the previous one was abandoned")
+	}
+}
+
+// endless is a reader of an endless stream of bytes.
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	return len(p), nil
+}
+
+func TestLoadMasterState_MaxSize(t *testing.T) {
+	const leader = `{"leader":"master@10.0.0.1:5050"}`
+	var (
+		endpoint = urls.Builder{}.With(urls.Scheme("http"), urls.Path("/master/state.json"))
+		decode   = state.Decode
+		health   = NewMasterHealth()
+	)
+	health.SetMaxStateBytes(int64(len(leader)))
+
+	for i, tt := range []struct {
+		body   io.Reader
+		length int64
+		bytes  uint64 // response bytes read
+		err    error
+	}{
+		{strings.NewReader(leader), int64(len(leader)), uint64(len(leader)), nil},
+		{strings.NewReader(leader), -1, uint64(len(leader)), nil},
+		{endless{}, -1, uint64(len(leader)) + 1, &StateSizeError{"10.0.0.1:5050", int64(len(leader)), -1}},
+		{endless{}, 1 << 40, 0, &StateSizeError{"10.0.0.1:5050", int64(len(leader)), 1 << 40}},
+	} {
+		health.masters = map[string]*MasterStats{}
+		doer := httpcli.DoerFunc(func(*http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode:    http.StatusOK,
+				Status:        "200 OK",
+				ContentLength: tt.length,
+				Body:          ioutil.NopCloser(tt.body),
+			}, nil
+		})
+		_, err := LoadMasterState(doer, endpoint, "10.0.0.1", "5050", decode, health)
+		if !reflect.DeepEqual(err, tt.err) {
+			t.Errorf("test #%d: got error %v, want %v", i, err, tt.err)
+		}
+		s := health.Masters()[0]
+		if s.Bytes != tt.bytes {
+			t.Errorf("test #%d: got %d bytes read, want %d", i, s.Bytes, tt.bytes)
+		}
+		if tt.err != nil && s.Failures[FailureSize] != 1 {
+			t.Errorf("test #%d: got failures %v, want a %q one", i, s.Failures, FailureSize)
+		}
+	}
+}
+
+func TestLoadMasterState_Gzip(t *testing.T) {
+	const leader = `{"leader":"master@10.0.0.1:5050"}`
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	var (
+		endpoint = urls.Builder{}.With(urls.Scheme("http"), urls.Path("/master/state.json"))
+		health   = NewMasterHealth()
+		large    = `{"leader":"master@10.0.0.1:5050","frameworks":[` + strings.Repeat(`{},`, 100) + `{}]}`
+		zleader  = gzipped(leader)
+	)
+	health.SetMaxStateBytes(int64(len(large)) - 1)
+
+	for i, tt := range []struct {
+		encoding string
+		body     []byte
+		failure  FailureClass
+		err      string
+	}{
+		{"gzip", zleader, "", ""},
+		{"GZIP", zleader, "", ""},
+		{"", []byte(leader), "", ""}, // master ignoring Accept-Encoding
+		{"gzip", []byte(leader), FailureDecode,
+			"malformed gzip response from 10.0.0.1:5050: gzip: invalid header"},
+		{"gzip", zleader[:len(zleader)-4], FailureDecode,
+			"malformed gzip response from 10.0.0.1:5050: unexpected EOF"},
+		{"gzip", gzipped(large), FailureSize,
+			(&StateSizeError{"10.0.0.1:5050", int64(len(large)) - 1, -1}).Error()},
+	} {
+		health.masters = map[string]*MasterStats{}
+		doer := httpcli.DoerFunc(func(req *http.Request) (*http.Response, error) {
+			if got := req.Header.Get("Accept-Encoding"); got != "gzip" {
+				t.Errorf("test #%d: got Accept-Encoding %q, want gzip", i, got)
+			}
+			return &http.Response{
+				StatusCode:    http.StatusOK,
+				Status:        "200 OK",
+				Header:        http.Header{"Content-Encoding": {tt.encoding}},
+				ContentLength: int64(len(tt.body)),
+				Body:          ioutil.NopCloser(bytes.NewReader(tt.body)),
+			}, nil
+		})
+		sj, err := LoadMasterState(doer, endpoint, "10.0.0.1", "5050", state.Decode, health)
+		if got := fmt.Sprint(err); (err != nil || tt.err != "") && got != tt.err {
+			t.Errorf("test #%d: got error %q, want %q", i, got, tt.err)
+		}
+		if err == nil && sj.Leader != "master@10.0.0.1:5050" {
+			t.Errorf("test #%d: got leader %q", i, sj.Leader)
+		}
+		s := health.Masters()[0]
+		if tt.failure != "" && s.Failures[tt.failure] != 1 {
+			t.Errorf("test #%d: got failures %v, want a %q one", i, s.Failures, tt.failure)
+		}
+		if tt.err == "" && s.Bytes != uint64(len(tt.body)) {
+			t.Errorf("test #%d: got %d bytes read, want the %d received", i, s.Bytes, len(tt.body))
+		}
+	}
+}
+
+func TestLoadMasterState_V1(t *testing.T) {
+	v1State, err := ioutil.ReadFile("../testdata/v1_state.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.URL.Path != APIV1Path:
+			http.NotFound(w, r)
+		case r.Method != "POST" || string(body) != getStateCall:
+			http.Error(w, "unexpected call", http.StatusBadRequest)
+		case r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Accept") != "application/json":
+			http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+		default:
+			_, _ = w.Write(v1State)
+		}
+	}))
+	defer v1.Close()
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != LegacyStatePath {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"leader":"master@10.0.0.1:5050","slaves":[{"id":"s1"}]}`))
+	}))
+	defer legacy.Close()
+
+	endpoint := urls.Builder{}.With(urls.Scheme("http"), urls.Path(APIV1Path))
+	for _, tt := range []struct {
+		server *httptest.Server
+		leader string
+		slaves int
+	}{
+		{v1, "master@" + v1.Listener.Addr().String(), 2},
+		{legacy, "master@10.0.0.1:5050", 1},
+	} {
+		health := NewMasterHealth()
+		ip, port, _ := net.SplitHostPort(tt.server.Listener.Addr().String())
+		sj, err := LoadMasterState(http.DefaultClient, endpoint, ip, port, Unmarshaler(state.UnmarshalV1).Decoder(), health)
+		if err != nil {
+			t.Errorf("%s: %v", tt.server.URL, err)
+			continue
+		}
+		if sj.Leader != tt.leader || len(sj.Slaves) != tt.slaves {
+			t.Errorf("%s: got leader %q and %d slaves, want %q and %d",
+				tt.server.URL, sj.Leader, len(sj.Slaves), tt.leader, tt.slaves)
+		}
+		if s := health.Masters()[0]; s.Successes != 1 || s.ConsecutiveFailures != 0 {
+			t.Errorf("%s: got stats %+v, want a success", tt.server.URL, s)
+		}
+	}
+}
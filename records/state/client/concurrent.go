@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/httpcli"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/mesosphere/mesos-dns/urls"
+)
+
+// NewConcurrentStateLoader generates a new Mesos master state loader like NewStateLoader, but which
+// requests the state from the masters concurrently, as per LoadMasterStateConcurrent, rather than in
+// turn: the first master to return the state of the leader wins. The requests to all but the first
+// master are staggered by the given hedge delay, if any, so that healthy masters aren't all loaded.
+func NewConcurrentStateLoader(doer httpcli.Doer, initialEndpoint urls.Builder, decode Decoder, health *MasterHealth, hedge time.Duration) StateLoader {
+	return func(masters []string) (state.State, error) {
+		return LoadMasterStateConcurrent(health.prefer(masters), hedge, func(ctx context.Context, ip, port string) (state.State, error) {
+			return LoadMasterStateFailover(ip, func(tryIP string) (state.State, error) {
+				return LoadMasterStateContext(ctx, doer, initialEndpoint, tryIP, port, decode, health)
+			})
+		})
+	}
+}
+
+// fetch is the outcome of a state request to a master.
+type fetch struct {
+	master string
+	sj     state.State
+	err    error
+}
+
+// LoadMasterStateConcurrent requests the state from the given masters concurrently, returning the first
+// state having a leader and cancelling the other requests. With a positive hedge delay, the masters are
+// requested in turn, each after the given delay or, should all those requested so far have failed, right
+// away. Should all of them fail, their errors are aggregated.
+func LoadMasterStateConcurrent(masters []string, hedge time.Duration, stateLoader func(ctx context.Context, ip, port string) (state.State, error)) (state.State, error) {
+	if len(masters) == 0 {
+		return state.State{}, errors.New("no masters to fetch the state from")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fetches := make(chan fetch, len(masters))
+	started, pending := 0, 0
+	start := func() {
+		master := masters[started]
+		started++
+		pending++
+		go func() {
+			ip, port, err := urls.SplitHostPort(master)
+			var sj state.State
+			if err == nil {
+				sj, err = stateLoader(ctx, ip, port)
+			}
+			if err == nil && sj.Leader == "" {
+				err = errors.New("fetched state.json does not contain leader information")
+			}
+			fetches <- fetch{master, sj, err}
+		}()
+	}
+
+	var (
+		errs  []string
+		timer *time.Timer
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		var next <-chan time.Time
+		if started < len(masters) {
+			if hedge <= 0 || pending == 0 {
+				start()
+				continue
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(hedge)
+			next = timer.C
+		}
+		select {
+		case <-next:
+			logging.VeryVerbose.Printf("no state fetched after %s, also requesting %s", hedge, masters[started])
+			start()
+		case f := <-fetches:
+			pending--
+			if f.err == nil {
+				return f.sj, nil
+			}
+			logging.Error.Printf("failed to fetch state.json from %s: %v", f.master, f.err)
+			errs = append(errs, fmt.Sprintf("%s: %v", f.master, f.err))
+			if pending == 0 && started == len(masters) {
+				return state.State{}, fmt.Errorf("failed to fetch state.json from all masters: %s", strings.Join(errs, "; "))
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/mesosphere/mesos-dns/urls"
+)
+
+// fakeMaster serves the state of a leading master, named after the master,
+// after the given delay, or fails if unhealthy, counting its requests and
+// signaling those cancelled by the client.
+type fakeMaster struct {
+	name      string
+	delay     time.Duration
+	healthy   bool
+	requests  int32
+	cancelled chan struct{}
+}
+
+func (m *fakeMaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&m.requests, 1)
+	select {
+	case <-time.After(m.delay):
+	case <-r.Context().Done():
+		m.cancelled <- struct{}{}
+		return
+	}
+	if !m.healthy {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, `{"leader":"master@127.0.0.1:5050","frameworks":[{"name":%q}]}`, m.name)
+}
+
+func TestLoadMasterStateConcurrent(t *testing.T) {
+	var servers []*httptest.Server
+	defer func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}()
+	newMaster := func(name string, delay time.Duration, healthy bool) (*fakeMaster, string) {
+		m := &fakeMaster{name: name, delay: delay, healthy: healthy, cancelled: make(chan struct{}, 10)}
+		server := httptest.NewServer(m)
+		servers = append(servers, server)
+		return m, server.Listener.Addr().String()
+	}
+
+	var (
+		doer     = &http.Client{Timeout: 5 * time.Second}
+		endpoint = urls.Builder{}.With(urls.Scheme("http"), urls.Path("/master/state.json"))
+		decode   = state.Decode
+	)
+	load := func(hedge time.Duration, masters ...string) (state.State, error) {
+		return NewConcurrentStateLoader(doer, endpoint, decode, nil, hedge)(masters)
+	}
+	winner := func(sj state.State, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sj.Frameworks[0].Name
+	}
+
+	down, downAddr := newMaster("down", 0, false)
+	slow, slowAddr := newMaster("slow", time.Minute, true)
+	fast, fastAddr := newMaster("fast", 10*time.Millisecond, true)
+
+	// the fastest healthy master wins, while the slower requests are
+	// cancelled
+	if got := winner(load(0, downAddr, slowAddr, fastAddr)); got != "fast" {
+		t.Errorf("got state of %s, want fast", got)
+	}
+	select {
+	case <-slow.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("slow request not cancelled")
+	}
+
+	// hedged requests only reach the next master after the delay, or as soon
+	// as the previous ones failed
+	slowRequests := atomic.LoadInt32(&slow.requests)
+	if got := winner(load(time.Hour, fastAddr, slowAddr)); got != "fast" {
+		t.Errorf("got state of %s, want fast", got)
+	}
+	if got := atomic.LoadInt32(&slow.requests); got != slowRequests {
+		t.Errorf("slow master requested while hedging")
+	}
+	if got := winner(load(time.Hour, downAddr, fastAddr)); got != "fast" {
+		t.Errorf("got state of %s, want fast", got)
+	}
+	if got := winner(load(20*time.Millisecond, slowAddr, fastAddr)); got != "fast" {
+		t.Errorf("got state of %s, want fast", got)
+	}
+	select {
+	case <-slow.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("slow hedged request not cancelled")
+	}
+
+	// failures of all masters are aggregated
+	_, err := load(0, downAddr, "bad-address")
+	if err == nil || !strings.Contains(err.Error(), downAddr) || !strings.Contains(err.Error(), "bad-address") {
+		t.Errorf("got error %v, want one listing every master", err)
+	}
+	if atomic.LoadInt32(&down.requests) == 0 || atomic.LoadInt32(&fast.requests) == 0 {
+		t.Error("masters not requested")
+	}
+}
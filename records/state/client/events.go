@@ -0,0 +1,157 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/errorutil"
+	"github.com/mesosphere/mesos-dns/httpcli"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/mesosphere/mesos-dns/urls"
+)
+
+// DefaultEventIdleTimeout is how long subscriptions wait for an event before giving up on the stream: three
+// times the interval between the heartbeats Mesos masters send.
+const DefaultEventIdleTimeout = 45 * time.Second
+
+// subscribeCall is the body of the SUBSCRIBE call of the Mesos v1 operator API.
+const subscribeCall = `{"type":"SUBSCRIBE"}`
+
+// Subscriber follows the event stream of the leading Mesos master, handing its events to the given function,
+// until the stream ends, the given context is done or the function fails, and returns why.
+type Subscriber func(ctx context.Context, masters []string, handle func(state.Event) error) error
+
+// NewSubscriber returns a Subscriber subscribing to the given masters in turn, as host:port, with the given http
+// client and endpoint, until one streams events. Empty masters, of unknown leaders, are skipped. Events larger
+// than the maximum state size of the given, optional, MasterHealth fail the stream.
+func NewSubscriber(doer httpcli.Doer, endpoint urls.Builder, health *MasterHealth) Subscriber {
+	return func(ctx context.Context, masters []string, handle func(state.Event) error) error {
+		err := fmt.Errorf("no master to subscribe to")
+		for _, master := range masters {
+			if master == "" {
+				// leader unknown
+				continue
+			}
+			ip, port, splitErr := urls.SplitHostPort(master)
+			if splitErr != nil {
+				logging.Error.Println(splitErr)
+				continue
+			}
+			streamed := false
+			err = Subscribe(ctx, doer, endpoint, ip, port, DefaultEventIdleTimeout, health.maxStateBytes(),
+				func(ev state.Event) error {
+					streamed = true
+					return handle(ev)
+				})
+			if streamed || ctx.Err() != nil {
+				return err
+			}
+			logging.Error.Printf("Failed to subscribe to %s - trying next one. Error: %v", master, err)
+		}
+		return err
+	}
+}
+
+// Subscribe follows the event stream of the Mesos v1 operator API of the master at the given address: it
+// POSTs a SUBSCRIBE call to the given endpoint, at the APIV1Path, and hands the events of the stream to the
+// given function, in order. Non-leading masters redirect the call to the leader. It returns once the stream
+// ends, the given context is done, no event arrived for the given idle timeout, heartbeats included, an event
+// exceeds the given maximum size, in bytes, or the function fails, telling why.
+func Subscribe(ctx context.Context, client httpcli.Doer, endpoint urls.Builder, ip, port string, idle time.Duration, max int64, handle func(state.Event) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	addr := net.JoinHostPort(ip, port)
+	u := url.URL(endpoint.With(urls.Host(addr), urls.Path(APIV1Path)))
+	req, err := http.NewRequest("POST", u.String(), strings.NewReader(subscribeCall))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "Mesos-DNS")
+
+	var idled int32
+	watchdog := time.AfterFunc(idle, func() {
+		atomic.StoreInt32(&idled, 1)
+		cancel()
+	})
+	defer watchdog.Stop()
+	// the error of an idle stream is that of its cancelled request
+	fail := func(err error) error {
+		if atomic.LoadInt32(&idled) == 1 {
+			return fmt.Errorf("no event from %s for %s", addr, idle)
+		}
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer errorutil.Ignore(resp.Body.Close)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %q from %s", resp.Status, addr)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	for {
+		b, err := readRecord(r, max)
+		switch err := err.(type) {
+		case nil:
+		case *StateSizeError:
+			err.Address = addr
+			return err
+		default:
+			if err == io.EOF {
+				return fmt.Errorf("event stream of %s ended", addr)
+			}
+			return fail(err)
+		}
+		watchdog.Reset(idle)
+
+		ev, err := state.UnmarshalEvent(b)
+		if err != nil {
+			return err
+		}
+		if err = handle(ev); err != nil {
+			return err
+		}
+	}
+}
+
+// readRecord reads a record of the RecordIO format events are streamed in: its length, in bytes, in decimal
+// and followed by a newline, then the record itself. Records larger than the given maximum size fail with a
+// StateSizeError, without address. It returns io.EOF if the stream ends before the record does start.
+func readRecord(r *bufio.Reader, max int64) ([]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("malformed record length %q", strings.TrimSpace(line))
+	}
+	if n > max {
+		return nil, &StateSizeError{Max: max, ContentLength: n}
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/mesosphere/mesos-dns/urls"
+)
+
+// eventMaster streams the given events, in RecordIO format, to SUBSCRIBE
+// calls, then hangs until the client gives up if it should, or ends the
+// stream.
+type eventMaster struct {
+	events []string
+	hang   bool
+}
+
+func (m *eventMaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case r.URL.Path != APIV1Path:
+		http.NotFound(w, r)
+		return
+	case r.Method != "POST" || string(body) != subscribeCall ||
+		r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Accept") != "application/json":
+		http.Error(w, "unexpected call", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	for _, ev := range m.events {
+		fmt.Fprintf(w, "%d\n%s", len(ev), ev)
+		w.(http.Flusher).Flush()
+	}
+	if m.hang {
+		<-r.Context().Done()
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	const (
+		subscribed = `{"type":"SUBSCRIBED","subscribed":{"get_state":{}}}`
+		heartbeat  = `{"type":"HEARTBEAT"}`
+		taskAdded  = `{"type":"TASK_ADDED","task_added":{"task":{"task_id":{"value":"web.1"}}}}`
+	)
+	endpoint := urls.Builder{}.With(urls.Scheme("http"))
+	for i, tt := range []struct {
+		master *eventMaster
+		max    int64
+		types  []string
+		err    string
+	}{
+		{&eventMaster{events: []string{subscribed, heartbeat, taskAdded}}, 1 << 10,
+			[]string{state.EventSubscribed, state.EventHeartbeat, state.EventTaskAdded}, "event stream of %s ended"},
+		{&eventMaster{events: []string{subscribed}, hang: true}, 1 << 10,
+			[]string{state.EventSubscribed}, "no event from %s for 50ms"},
+		{&eventMaster{events: []string{heartbeat, taskAdded}}, int64(len(taskAdded) - 1),
+			[]string{state.EventHeartbeat}, fmt.Sprintf("state from %%s declared as %d bytes exceeds the maximum of %d bytes",
+				len(taskAdded), len(taskAdded)-1)},
+	} {
+		server := httptest.NewServer(tt.master)
+		addr := server.Listener.Addr().String()
+		ip, port, _ := net.SplitHostPort(addr)
+		var types []string
+		err := Subscribe(context.Background(), http.DefaultClient, endpoint, ip, port, 50*time.Millisecond, tt.max,
+			func(ev state.Event) error {
+				types = append(types, ev.Type)
+				return nil
+			})
+		server.Close()
+		if want := fmt.Sprintf(tt.err, addr); fmt.Sprint(err) != want {
+			t.Errorf("test #%d: got error %v, want %q", i, err, want)
+		}
+		if !reflect.DeepEqual(types, tt.types) {
+			t.Errorf("test #%d: got events %v, want %v", i, types, tt.types)
+		}
+	}
+}
+
+func TestNewSubscriber(t *testing.T) {
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+	master := httptest.NewServer(&eventMaster{events: []string{`{"type":"HEARTBEAT"}`}})
+	defer master.Close()
+
+	subscribe := NewSubscriber(http.DefaultClient, urls.Builder{}.With(urls.Scheme("http")), nil)
+	masters := []string{"", failing.Listener.Addr().String(), master.Listener.Addr().String()}
+	events := 0
+	err := subscribe(context.Background(), masters, func(state.Event) error {
+		events++
+		return nil
+	})
+	if want := fmt.Sprintf("event stream of %s ended", master.Listener.Addr()); fmt.Sprint(err) != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	if events != 1 {
+		t.Errorf("got %d events, want 1", events)
+	}
+}
@@ -0,0 +1,448 @@
+package client
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/urls"
+)
+
+// FailureClass classifies failed state fetches.
+type FailureClass string
+
+const (
+	// FailureConnect is used when the master couldn't be reached, or the
+	// connection broke while reading the response.
+	FailureConnect FailureClass = "connect"
+	// FailureTimeout is used when the master didn't respond in time.
+	FailureTimeout FailureClass = "timeout"
+	// FailureStatus is used when the master responded with a non-2xx status.
+	FailureStatus FailureClass = "status"
+	// FailureDecode is used when the response couldn't be decoded.
+	FailureDecode FailureClass = "decode"
+	// FailureSize is used when the response exceeded the maximum state
+	// size.
+	FailureSize FailureClass = "size"
+)
+
+// DefaultMaxStateBytes is the maximum size of the state responses read from
+// masters unless set otherwise with SetMaxStateBytes.
+const DefaultMaxStateBytes int64 = 4 << 30
+
+// BreakerState is the state of the circuit breaker of a master.
+type BreakerState string
+
+const (
+	// BreakerClosed is used while the state is fetched from the master as
+	// usual.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen is used while fetches from the master are skipped, after
+	// too many consecutive failed ones.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen is used once the cool-down period of an open breaker
+	// elapsed, while a single probing fetch is let through.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// MasterStats holds the state fetch outcomes of a single master.
+type MasterStats struct {
+	// Address is the host:port the state was fetched from
+	Address string `json:"address"`
+	// Attempts is the number of state fetches
+	Attempts uint64 `json:"attempts"`
+	// Successes is the number of successful state fetches
+	Successes uint64 `json:"successes"`
+	// Failures is the number of failed state fetches, per class
+	Failures map[FailureClass]uint64 `json:"failures"`
+	// ConsecutiveFailures is the number of failed state fetches since the
+	// last successful one
+	ConsecutiveFailures uint64 `json:"consecutive_failures"`
+	// Bytes is the number of response bytes received
+	Bytes uint64 `json:"bytes"`
+	// LastSuccess is the time of the last successful state fetch
+	LastSuccess time.Time `json:"last_success"`
+	// Breaker is the state of the circuit breaker of the master, if enabled
+	Breaker BreakerState `json:"breaker,omitempty"`
+	// BreakerOpened is the time the circuit breaker last opened
+	BreakerOpened time.Time `json:"breaker_opened"`
+	// Skips is the number of state fetches skipped while the circuit
+	// breaker was open
+	Skips uint64 `json:"skips"`
+	// Retries is the number of state fetches retried after failing for
+	// transient reasons
+	Retries uint64 `json:"retries"`
+	// Leader tells whether the master is the leader told by the last state
+	// fetched, which is tried first
+	Leader bool `json:"leader,omitempty"`
+}
+
+// MasterHealth tracks the state fetch outcomes per master address. A nil
+// MasterHealth tracks nothing. It's safe for concurrent use.
+type MasterHealth struct {
+	mu      sync.Mutex
+	masters map[string]*MasterStats
+	now     func() time.Time
+	// last is the address and class of the last failed fetch.
+	last struct {
+		addr  string
+		class FailureClass
+	}
+	// threshold is the number of consecutive failed fetches opening the
+	// circuit breaker of a master, 0 disabling breakers, which stay open
+	// for cooldown before letting a probing fetch through.
+	threshold uint64
+	cooldown  time.Duration
+	// probing holds the masters a probing fetch is in flight from.
+	probing map[string]bool
+	// maxBytes is the maximum size of state responses, 0 meaning
+	// DefaultMaxStateBytes.
+	maxBytes int64
+	// attempts is the maximum number of fetches of the state from a master
+	// failing for transient reasons, retried after backoffs of up to
+	// maxBackoff.
+	attempts   int
+	maxBackoff time.Duration
+	// leader is the address of the leading master told by the last state
+	// fetched, until a fetch from it fails.
+	leader string
+}
+
+// NewMasterHealth returns a new, empty MasterHealth.
+func NewMasterHealth() *MasterHealth {
+	return &MasterHealth{masters: map[string]*MasterStats{}, now: time.Now, probing: map[string]bool{}}
+}
+
+// SetBreaker enables a circuit breaker per master, opening after the given
+// number of consecutive failed fetches, and skipping fetches from the master
+// for the given cool-down period. A single probing fetch is then let through:
+// the breaker closes should it succeed, or opens again otherwise. A threshold
+// of 0 disables the breakers.
+func (h *MasterHealth) SetBreaker(threshold int, cooldown time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.threshold, h.cooldown = uint64(threshold), cooldown
+}
+
+// SetMaxStateBytes sets the maximum size of the state responses read from
+// masters, larger ones failing with a StateSizeError. A size of 0 restores
+// DefaultMaxStateBytes.
+func (h *MasterHealth) SetMaxStateBytes(n int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maxBytes = n
+}
+
+// SetRetry enables the retries of the state fetches from a master failing for
+// transient reasons, up to the given number of fetches in all, as per
+// LoadMasterStateContext, after exponentially growing backoffs of up to the
+// given maximum. Fewer than 2 attempts disable retries.
+func (h *MasterHealth) SetRetry(attempts int, maxBackoff time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.attempts, h.maxBackoff = attempts, maxBackoff
+}
+
+// retry returns the maximum number of fetches of the state from a master and
+// the maximum backoff between them.
+func (h *MasterHealth) retry() (int, time.Duration) {
+	if h == nil {
+		return 1, 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.attempts, h.maxBackoff
+}
+
+// maxStateBytes returns the maximum size of state responses.
+func (h *MasterHealth) maxStateBytes() int64 {
+	if h == nil {
+		return DefaultMaxStateBytes
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.maxBytes <= 0 {
+		return DefaultMaxStateBytes
+	}
+	return h.maxBytes
+}
+
+// Masters returns the stats of every master fetched from, ordered by address.
+func (h *MasterHealth) Masters() []MasterStats {
+	if h == nil {
+		return []MasterStats{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ms := make([]MasterStats, 0, len(h.masters))
+	for _, s := range h.masters {
+		c := *s
+		c.Failures = make(map[FailureClass]uint64, len(s.Failures))
+		for k, v := range s.Failures {
+			c.Failures[k] = v
+		}
+		c.Leader = s.Address == h.leader
+		ms = append(ms, c)
+	}
+	sort.Sort(byAddress(ms))
+	return ms
+}
+
+type byAddress []MasterStats
+
+func (s byAddress) Len() int           { return len(s) }
+func (s byAddress) Less(i, j int) bool { return s[i].Address < s[j].Address }
+func (s byAddress) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// stats returns the stats of the given master, creating them if needed.
+// It must be called with h.mu held.
+func (h *MasterHealth) stats(addr string) *MasterStats {
+	s, ok := h.masters[addr]
+	if !ok {
+		s = &MasterStats{Address: addr, Failures: map[FailureClass]uint64{}}
+		if h.threshold > 0 {
+			s.Breaker = BreakerClosed
+		}
+		h.masters[addr] = s
+	}
+	return s
+}
+
+// attempt accounts for a state fetch from the given master.
+func (h *MasterHealth) attempt(addr string) {
+	logging.CurLog.MasterStateAttempts.Add(addr, 1)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stats(addr).Attempts++
+}
+
+// received accounts for the given number of response bytes from the given
+// master.
+func (h *MasterHealth) received(addr string, n int) {
+	logging.CurLog.MasterStateBytes.Add(addr, uint64(n))
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stats(addr).Bytes += uint64(n)
+}
+
+// succeeded accounts for a successful state fetch from the given master.
+func (h *MasterHealth) succeeded(addr string) {
+	logging.CurLog.MasterStateSuccesses.Add(addr, 1)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stats(addr)
+	s.Successes++
+	s.ConsecutiveFailures = 0
+	s.LastSuccess = h.now()
+	if h.threshold > 0 {
+		delete(h.probing, addr)
+		if s.Breaker != BreakerClosed {
+			h.transition(s, BreakerClosed)
+		}
+	}
+}
+
+// failed accounts for a state fetch from the given master failed for the
+// given class of reasons.
+func (h *MasterHealth) failed(addr string, class FailureClass) {
+	logging.CurLog.MasterStateFailures.Add(addr+"/"+string(class), 1)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if addr == h.leader {
+		logging.VeryVerbose.Printf("leading master %s failed, forgetting it", addr)
+		h.leader = ""
+	}
+	s := h.stats(addr)
+	s.Failures[class]++
+	s.ConsecutiveFailures++
+	h.last.addr, h.last.class = addr, class
+	if h.threshold > 0 {
+		delete(h.probing, addr)
+		if s.Breaker == BreakerHalfOpen || (s.Breaker == BreakerClosed && s.ConsecutiveFailures >= h.threshold) {
+			s.BreakerOpened = h.now()
+			h.transition(s, BreakerOpen)
+		}
+	}
+}
+
+// retried accounts for a failed state fetch from the given master being
+// retried.
+func (h *MasterHealth) retried(addr string) {
+	logging.CurLog.MasterStateRetries.Add(addr, 1)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stats(addr).Retries++
+}
+
+// abandoned accounts for a state fetch from the given master given up on
+// before its outcome was known, e.g. because another master returned the
+// state first.
+func (h *MasterHealth) abandoned(addr string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.probing, addr)
+}
+
+// allow tells whether a state fetch from the given master may proceed as per
+// its circuit breaker, if enabled. Once the cool-down period of an open
+// breaker elapsed, it turns half-open and lets a single probing fetch
+// through. Skipped fetches are accounted for.
+func (h *MasterHealth) allow(addr string) bool {
+	if h == nil {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.threshold == 0 {
+		return true
+	}
+	s := h.stats(addr)
+	switch s.Breaker {
+	case BreakerOpen:
+		if h.now().Sub(s.BreakerOpened) >= h.cooldown {
+			h.transition(s, BreakerHalfOpen)
+			h.probing[addr] = true
+			return true
+		}
+	case BreakerHalfOpen:
+		if !h.probing[addr] {
+			h.probing[addr] = true
+			return true
+		}
+	default:
+		return true
+	}
+	s.Skips++
+	logging.CurLog.MasterStateSkips.Add(addr, 1)
+	return false
+}
+
+// transition sets the state of the circuit breaker of the given master.
+// It must be called with h.mu held.
+func (h *MasterHealth) transition(s *MasterStats, state BreakerState) {
+	logging.CurLog.MasterBreakers.Add(s.Address+"/"+string(state), 1)
+	switch state {
+	case BreakerOpen:
+		logging.Error.Printf("circuit breaker of master %s open after %d consecutive failures, skipping it for %s",
+			s.Address, s.ConsecutiveFailures, h.cooldown)
+	case BreakerClosed:
+		logging.Verbose.Printf("circuit breaker of master %s closed", s.Address)
+	}
+	s.Breaker = state
+}
+
+// Leader returns the address of the leading master told by the last state
+// fetched, which the state loaders try first, or "" if unknown or if the last
+// fetch from it failed.
+func (h *MasterHealth) Leader() string {
+	if h == nil {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.leader
+}
+
+// led remembers the leading master told by the given leader of a fetched
+// state, a PID such as master@10.0.0.1:5050.
+func (h *MasterHealth) led(leader string) {
+	i := strings.LastIndex(leader, "@")
+	if h == nil || i < 0 {
+		return
+	}
+	addr := leader[i+1:]
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.leader != addr {
+		logging.VeryVerbose.Printf("leading master is %s", addr)
+		h.leader = addr
+	}
+}
+
+// LastFailure returns the address of the master the last failed fetch was
+// from and the class of the failure. Both are empty if no fetch failed.
+func (h *MasterHealth) LastFailure() (addr string, class FailureClass) {
+	if h == nil {
+		return "", ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last.addr, h.last.class
+}
+
+// prefer returns the given masters with the first one kept in place and the
+// rest stably ordered by their consecutive failures, so that healthy masters
+// are tried before failing ones. Unknown masters are considered healthy. The
+// leading master told by the last state fetched, if known, goes first, even
+// if not given, so that non-leading masters aren't asked first at every
+// fetch; should it fail, the given masters are tried as usual.
+func (h *MasterHealth) prefer(masters []string) []string {
+	if h == nil {
+		return masters
+	}
+	h.mu.Lock()
+	leader := h.leader
+	failures := make(map[string]uint64, len(masters))
+	addrs := make(map[string]string, len(masters))
+	for _, m := range masters {
+		addr := m
+		if ip, port, err := urls.SplitHostPort(m); err == nil {
+			addr = net.JoinHostPort(ip, port)
+		}
+		addrs[m] = addr
+		if s, ok := h.masters[addr]; ok {
+			failures[m] = s.ConsecutiveFailures
+		}
+	}
+	h.mu.Unlock()
+
+	ordered := make([]string, 0, len(masters)+1)
+	if leader != "" {
+		ordered = append(ordered, leader)
+	}
+	kept := len(ordered) // masters kept in place
+	for i, m := range masters {
+		if leader != "" && addrs[m] == leader {
+			continue
+		}
+		ordered = append(ordered, m)
+		if i == 0 {
+			kept = len(ordered)
+		}
+	}
+	rest := ordered[kept:]
+	sort.SliceStable(rest, func(i, j int) bool { return failures[rest[i]] < failures[rest[j]] })
+	return ordered
+}
+
+// classify returns the failure class of the given transport error.
+func classify(err error) FailureClass {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return FailureTimeout
+	}
+	return FailureConnect
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// retryBase is the backoff before the first retry of a failed state fetch,
+// unless the maximum backoff is lower.
+const retryBase = 100 * time.Millisecond
+
+// StatusError is returned when a master responds to a state request with a
+// non-2xx status.
+type StatusError struct {
+	// Address is the host:port the state was fetched from
+	Address string
+	// Status and Code are the status of the response
+	Status string
+	Code   int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %q from %s", e.Status, e.Address)
+}
+
+// AttemptsError is returned when every attempt to fetch the state from a
+// master, retries included, failed.
+type AttemptsError struct {
+	// Address is the host:port the state was fetched from
+	Address string
+	// Errs are the errors of the attempts, in order
+	Errs []error
+}
+
+func (e *AttemptsError) Error() string {
+	errs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		errs[i] = fmt.Sprintf("#%d: %v", i+1, err)
+	}
+	return fmt.Sprintf("%d attempts to fetch the state from %s failed: %s", len(e.Errs), e.Address,
+		strings.Join(errs, "; "))
+}
+
+// retryable tells whether a state fetch failed with the given error for
+// transient reasons worth retrying it: the master couldn't be reached, broke
+// the connection or responded with a 5xx or 429 status. Fetches timing out,
+// or of states too large or which couldn't be decoded, aren't retried.
+func retryable(err error) bool {
+	switch err := err.(type) {
+	case *StatusError:
+		return err.Code >= 500 || err.Code == http.StatusTooManyRequests
+	case net.Error:
+		return !err.Timeout()
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// backoff returns the delay before the given retry, from 1: retryBase,
+// doubled with every retry, capped by the given maximum, less up to a half of
+// it, at random, so that the masters don't get retried in lockstep.
+func backoff(retry int, max time.Duration) time.Duration {
+	d := retryBase
+	for i := 1; i < retry && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if d < 2 {
+		return d
+	}
+	return d - time.Duration(rand.Int63n(int64(d/2)))
+}
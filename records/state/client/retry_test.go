@@ -0,0 +1,210 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/mesosphere/mesos-dns/urls"
+)
+
+// flakyMaster fails its first requests with the given status, then serves
+// its state as the leading master, counting its requests.
+type flakyMaster struct {
+	failures int32
+	status   int
+	requests int32
+}
+
+func (m *flakyMaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt32(&m.requests, 1) <= m.failures {
+		http.Error(w, "failing", m.status)
+		return
+	}
+	fmt.Fprintf(w, `{"leader":"master@%s"}`, r.Host)
+}
+
+func TestLoadMasterState_Retry(t *testing.T) {
+	var (
+		doer     = &http.Client{Timeout: 5 * time.Second}
+		endpoint = urls.Builder{}.With(urls.Scheme("http"), urls.Path("/master/state.json"))
+	)
+	for i, tt := range []struct {
+		attempts int
+		failures int32
+		status   int
+		requests int32
+		err      string
+	}{
+		{3, 0, http.StatusServiceUnavailable, 1, ""},
+		{3, 2, http.StatusServiceUnavailable, 3, ""},
+		{3, 2, http.StatusTooManyRequests, 3, ""},
+		{0, 1, http.StatusServiceUnavailable, 1, `unexpected HTTP status "503 Service Unavailable" from %[1]s`},
+		{3, 1, http.StatusNotFound, 1, `unexpected HTTP status "404 Not Found" from %[1]s`},
+		{2, 2, http.StatusBadGateway, 2, `2 attempts to fetch the state from %[1]s failed: ` +
+			`#1: unexpected HTTP status "502 Bad Gateway" from %[1]s; ` +
+			`#2: unexpected HTTP status "502 Bad Gateway" from %[1]s`},
+	} {
+		m := &flakyMaster{failures: tt.failures, status: tt.status}
+		server := httptest.NewServer(m)
+		addr := server.Listener.Addr().String()
+		ip, port, _ := net.SplitHostPort(addr)
+
+		health := NewMasterHealth()
+		health.SetRetry(tt.attempts, 10*time.Millisecond)
+		_, err := LoadMasterState(doer, endpoint, ip, port, state.Decode, health)
+		server.Close()
+
+		want := ""
+		if tt.err != "" {
+			want = fmt.Sprintf(tt.err, addr)
+		}
+		if got := fmt.Sprint(err); (err != nil || want != "") && got != want {
+			t.Errorf("test #%d: got error %q, want %q", i, got, want)
+		}
+		if m.requests != tt.requests {
+			t.Errorf("test #%d: got %d requests, want %d", i, m.requests, tt.requests)
+		}
+		s := health.Masters()[0]
+		if s.Attempts != uint64(tt.requests) || s.Retries != uint64(tt.requests-1) {
+			t.Errorf("test #%d: got %d attempts and %d retries, want %d and %d",
+				i, s.Attempts, s.Retries, tt.requests, tt.requests-1)
+		}
+	}
+}
+
+func TestLoadMasterState_RetryConnect(t *testing.T) {
+	// a closed server refuses connections
+	server := httptest.NewServer(http.NotFoundHandler())
+	ip, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	server.Close()
+
+	health := NewMasterHealth()
+	health.SetRetry(3, time.Millisecond)
+	endpoint := urls.Builder{}.With(urls.Scheme("http"), urls.Path("/master/state.json"))
+	_, err := LoadMasterState(http.DefaultClient, endpoint, ip, port, state.Decode, health)
+	if ae, ok := err.(*AttemptsError); !ok || len(ae.Errs) != 3 {
+		t.Fatalf("got error %v, want 3 attempts failed", err)
+	}
+	if s := health.Masters()[0]; s.Failures[FailureConnect] != 3 || s.Retries != 2 {
+		t.Errorf("got failures %v and %d retries, want 3 connect failures and 2 retries", s.Failures, s.Retries)
+	}
+}
+
+func TestNewStateLoader_Failover(t *testing.T) {
+	var (
+		doer     = &http.Client{Timeout: 5 * time.Second}
+		endpoint = urls.Builder{}.With(urls.Scheme("http"), urls.Path("/master/state.json"))
+	)
+	down := &flakyMaster{failures: 1 << 30, status: http.StatusServiceUnavailable}
+	up := &flakyMaster{failures: 1, status: http.StatusServiceUnavailable}
+	servers := map[*flakyMaster]string{}
+	for _, m := range []*flakyMaster{down, up} {
+		server := httptest.NewServer(m)
+		defer server.Close()
+		servers[m] = server.Listener.Addr().String()
+	}
+	// a non-leading master redirecting to the leader
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "//"+servers[up]+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer follower.Close()
+
+	newLoader := func() StateLoader {
+		health := NewMasterHealth()
+		health.SetRetry(2, time.Millisecond)
+		return NewStateLoader(doer, endpoint, state.Decode, health)
+	}
+
+	failovers := fmt.Sprint(logging.CurLog.MasterStateFailovers)
+	sj, err := newLoader()([]string{servers[down], servers[up]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "master@" + servers[up]; sj.Leader != want {
+		t.Errorf("got leader %q, want %q", sj.Leader, want)
+	}
+	if down.requests != 2 || up.requests != 2 {
+		t.Errorf("got %d and %d requests, want 2 and 2", down.requests, up.requests)
+	}
+	if got := fmt.Sprint(logging.CurLog.MasterStateFailovers); got == failovers {
+		t.Errorf("got %s failovers, want more", got)
+	}
+
+	failovers = fmt.Sprint(logging.CurLog.MasterStateFailovers)
+	if _, err = newLoader()([]string{follower.Listener.Addr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(logging.CurLog.MasterStateFailovers); got == failovers {
+		t.Errorf("got %s failovers after a redirect, want more", got)
+	}
+
+	_, err = newLoader()([]string{servers[down], servers[down]})
+	want := fmt.Sprintf("failed to fetch state.json from all masters: %[1]s: 2 attempts to fetch the state from %[1]s failed", servers[down])
+	if got := fmt.Sprint(err); !strings.HasPrefix(got, want) || strings.Count(got, "#2:") != 2 {
+		t.Errorf("got error %q, want one listing every attempt", got)
+	}
+}
+
+func TestNewStateLoader_Leader(t *testing.T) {
+	var (
+		doer     = &http.Client{Timeout: 5 * time.Second}
+		endpoint = urls.Builder{}.With(urls.Scheme("http"), urls.Path("/master/state.json"))
+		leader   = &flakyMaster{}
+		server   = httptest.NewServer(leader)
+		addr     = server.Listener.Addr().String()
+		elected  int32 // whether the follower got elected
+		requests int32 // of the follower
+	)
+	defer server.Close()
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&elected) == 0 {
+			http.Redirect(w, r, "//"+addr+r.URL.Path, http.StatusTemporaryRedirect)
+			return
+		}
+		fmt.Fprintf(w, `{"leader":"master@%s"}`, r.Host)
+	}))
+	defer follower.Close()
+	masters := []string{follower.Listener.Addr().String()}
+
+	health := NewMasterHealth()
+	load := NewStateLoader(doer, endpoint, state.Decode, health)
+	if got := health.Leader(); got != "" {
+		t.Errorf("got leader %q before any fetch", got)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := load(masters); err != nil {
+			t.Fatal(err)
+		}
+		if got := health.Leader(); got != addr {
+			t.Errorf("fetch #%d: got leader %q, want %q", i, got, addr)
+		}
+	}
+	// the leader is asked first once known
+	if requests != 1 || leader.requests != 2 {
+		t.Errorf("got %d follower and %d leader requests, want 1 and 2", requests, leader.requests)
+	}
+	for _, s := range health.Masters() {
+		if s.Leader != (s.Address == addr) {
+			t.Errorf("%s: got leader %t", s.Address, s.Leader)
+		}
+	}
+
+	// the listed masters are tried once the leader stops answering
+	server.Close()
+	atomic.StoreInt32(&elected, 1)
+	if _, err := load(masters); err != nil {
+		t.Fatal(err)
+	}
+	if got := health.Leader(); got != masters[0] {
+		t.Errorf("got leader %q, want %q", got, masters[0])
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/httpcli"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/mesosphere/mesos-dns/urls"
+)
+
+// Paths of the Mesos HTTP endpoints LoadMasterSummaryContext composes the state from.
+const (
+	// StateSummaryPath is the path of the /state-summary endpoint, listing the frameworks and slaves
+	// without their tasks.
+	StateSummaryPath = "/master/state-summary"
+	// SlavesPath is the path of the /slaves endpoint.
+	SlavesPath = "/master/slaves"
+	// FrameworksPath is the path of the /frameworks endpoint, listing the frameworks along with their tasks.
+	FrameworksPath = "/master/frameworks"
+)
+
+// NewSummaryStateLoader generates a new Mesos master state loader like NewStateLoader, but which composes
+// the state from smaller endpoints, as per LoadMasterSummaryContext, rather than fetching the full state of
+// the LegacyStatePath endpoint, which gets too large to fetch and decode in one go on large clusters.
+func NewSummaryStateLoader(doer httpcli.Doer, endpoint urls.Builder, decode Decoder, health *MasterHealth) StateLoader {
+	return func(masters []string) (state.State, error) {
+		return LoadMasterStateTryAll(health.prefer(masters), func(ip, port string) (state.State, error) {
+			return LoadMasterSummaryContext(context.Background(), doer, endpoint, ip, port, decode, health)
+		})
+	}
+}
+
+// LoadMasterSummaryContext composes the state of the master at the given address from the StateSummaryPath,
+// SlavesPath and FrameworksPath endpoints, each fetched and decoded as per LoadMasterStateContext. The
+// leader is the master answering the state summary request, after redirects, which the other endpoints are
+// fetched from. The frameworks and slaves are those of the state summary, completed with those of the
+// other endpoints by ID; the state has no orphan tasks. Its DecodeTime sums those of the three endpoints.
+func LoadMasterSummaryContext(ctx context.Context, client httpcli.Doer, endpoint urls.Builder, ip, port string, decode Decoder, health *MasterHealth) (state.State, error) {
+	summary, err := LoadMasterStateContext(ctx, client, endpoint.With(urls.Path(StateSummaryPath)), ip, port, decode, health)
+	if err != nil {
+		return state.State{}, err
+	}
+	ip, port, err = urls.SplitHostPort(strings.TrimPrefix(summary.Leader, "master@"))
+	if err != nil {
+		return state.State{}, err
+	}
+	slaves, err := LoadMasterStateContext(ctx, client, endpoint.With(urls.Path(SlavesPath)), ip, port, decode, health)
+	if err != nil {
+		return state.State{}, err
+	}
+	frameworks, err := LoadMasterStateContext(ctx, client, endpoint.With(urls.Path(FrameworksPath)), ip, port, decode, health)
+	if err != nil {
+		return state.State{}, err
+	}
+	sj := composeState(summary, slaves.Slaves, frameworks.Frameworks)
+	sj.DecodeTime += slaves.DecodeTime + frameworks.DecodeTime
+	return sj, nil
+}
+
+// composeState returns the given state summary with its slaves and frameworks replaced by the given ones of
+// the same IDs, if any. Slaves and frameworks missing from the summary, e.g. which registered in between
+// requests, are left for the next state fetch.
+func composeState(summary state.State, slaves []state.Slave, frameworks []state.Framework) state.State {
+	slavesByID := make(map[string]state.Slave, len(slaves))
+	for _, s := range slaves {
+		slavesByID[s.ID] = s
+	}
+	for i, s := range summary.Slaves {
+		if full, ok := slavesByID[s.ID]; ok {
+			summary.Slaves[i] = full
+		}
+	}
+	frameworksByID := make(map[string]state.Framework, len(frameworks))
+	for _, f := range frameworks {
+		frameworksByID[f.ID] = f
+	}
+	for i, f := range summary.Frameworks {
+		if full, ok := frameworksByID[f.ID]; ok {
+			summary.Frameworks[i] = full
+		}
+	}
+	return summary
+}
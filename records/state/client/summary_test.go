@@ -0,0 +1,37 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+func TestComposeState(t *testing.T) {
+	summary := state.State{
+		Leader:     "master@10.0.0.1:5050",
+		Slaves:     []state.Slave{{ID: "s1"}, {ID: "s2"}},
+		Frameworks: []state.Framework{{ID: "f1", Name: "marathon"}, {ID: "f2", Name: "chronos"}},
+	}
+	slaves := []state.Slave{
+		{ID: "s2", Hostname: "agent-2", Attributes: state.Attributes{"rack": "r2"}},
+		{ID: "s3", Hostname: "agent-3"}, // registered since the summary
+	}
+	frameworks := []state.Framework{
+		{ID: "f1", Name: "marathon", Tasks: []state.Task{{ID: "t1", FrameworkID: "f1"}}},
+		{ID: "f3", Name: "spark"}, // registered since the summary
+	}
+
+	got := composeState(summary, slaves, frameworks)
+	want := state.State{
+		Leader: "master@10.0.0.1:5050",
+		Slaves: []state.Slave{{ID: "s1"}, {ID: "s2", Hostname: "agent-2", Attributes: state.Attributes{"rack": "r2"}}},
+		Frameworks: []state.Framework{
+			{ID: "f1", Name: "marathon", Tasks: []state.Task{{ID: "t1", FrameworkID: "f1"}}},
+			{ID: "f2", Name: "chronos"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Keys of the arrays of the /state.json Mesos HTTP endpoint, of the state and
+// of its frameworks, which no State field is decoded from. Decode skips them
+// without decoding their elements.
+var (
+	skippedStateKeys     = map[string]bool{"completed_frameworks": true, "unregistered_frameworks": true}
+	skippedFrameworkKeys = map[string]bool{
+		"completed_tasks":     true,
+		"unreachable_tasks":   true,
+		"completed_executors": true,
+		"offers":              true,
+	}
+)
+
+// Decode decodes the state, as in the /state.json Mesos HTTP endpoint, read
+// from the given reader into the given State, like json.Unmarshal does, but
+// streams it: the frameworks, their tasks, the slaves and the orphan tasks
+// are decoded one at a time as they're read, and the completed frameworks,
+// tasks and executors skipped, rather than the whole state being read in
+// memory first. The state must be the last value of the reader.
+func Decode(r io.Reader, s *State) error {
+	dec := json.NewDecoder(r)
+	err := decodeObject(dec, s, func(key string) (bool, error) {
+		switch key {
+		case "frameworks":
+			s.Frameworks = []Framework{}
+			return true, decodeArray(dec, func() error {
+				f, err := decodeFramework(dec)
+				s.Frameworks = append(s.Frameworks, f)
+				return err
+			}, func() { s.Frameworks = nil })
+		case "slaves":
+			s.Slaves = []Slave{}
+			return true, decodeArray(dec, func() error {
+				var sl Slave
+				err := dec.Decode(&sl)
+				s.Slaves = append(s.Slaves, sl)
+				return err
+			}, func() { s.Slaves = nil })
+		case "orphan_tasks":
+			s.OrphanTasks = []Task{}
+			return true, decodeArray(dec, func() error {
+				var t Task
+				err := dec.Decode(&t)
+				s.OrphanTasks = append(s.OrphanTasks, t)
+				return err
+			}, func() { s.OrphanTasks = nil })
+		}
+		if skippedStateKeys[key] {
+			return true, dec.Decode(new(skipped))
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = dec.Token(); err != io.EOF {
+		return fmt.Errorf("invalid character after the state")
+	}
+	return nil
+}
+
+// decodeFramework decodes the framework of the next value of the given
+// decoder, its tasks one at a time.
+func decodeFramework(dec *json.Decoder) (Framework, error) {
+	var (
+		f         Framework
+		tasks     []Task
+		tasksRead bool
+	)
+	err := decodeObject(dec, &f, func(key string) (bool, error) {
+		if key == "tasks" {
+			tasks, tasksRead = []Task{}, true
+			return true, decodeArray(dec, func() error {
+				var t Task
+				err := dec.Decode(&t)
+				tasks = append(tasks, t)
+				return err
+			}, func() { tasks = nil })
+		}
+		if skippedFrameworkKeys[key] {
+			return true, dec.Decode(new(skipped))
+		}
+		return false, nil
+	})
+	if tasksRead {
+		f.Tasks = tasks
+	}
+	return f, err
+}
+
+// decodeObject decodes the JSON object of the next value of the given
+// decoder, or null, into the given value. Each key is handed to the given
+// function first, which tells whether it decoded, or skipped, the value of
+// the key itself; those of the other keys are unmarshaled into the given
+// value at once, once the object is read, so that they're decoded as by
+// json.Unmarshal.
+func decodeObject(dec *json.Decoder, v interface{}, field func(key string) (bool, error)) error {
+	t, err := dec.Token()
+	if err != nil || t == nil {
+		return err
+	}
+	if t != json.Delim('{') {
+		return fmt.Errorf("cannot decode %v into an object", t)
+	}
+	rest := map[string]json.RawMessage{}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := t.(string) // object keys are strings
+		if done, err := field(key); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		} else if done {
+			continue
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		rest[key] = raw
+	}
+	if _, err := dec.Token(); err != nil { // }
+		return err
+	}
+	b, err := json.Marshal(rest)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// decodeArray decodes the JSON array of the next value of the given decoder,
+// or null, handing each element to the given elem function, which decodes it,
+// in order, or calling the given null function if null.
+func decodeArray(dec *json.Decoder, elem func() error, null func()) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		null()
+		return nil
+	}
+	if t != json.Delim('[') {
+		return fmt.Errorf("cannot decode %v into an array", t)
+	}
+	for i := 0; dec.More(); i++ {
+		if err := elem(); err != nil {
+			return fmt.Errorf("#%d: %v", i, err)
+		}
+	}
+	_, err = dec.Token() // ]
+	return err
+}
+
+// skipped is a json.Unmarshaler ignoring the values it's decoded from.
+type skipped struct{}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for skipped.
+func (*skipped) UnmarshalJSON([]byte) error { return nil }
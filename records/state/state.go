@@ -2,9 +2,13 @@ package state
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/mesosphere/mesos-dns/records/state/upid"
@@ -16,12 +20,18 @@ type Resources struct {
 }
 
 // Ports returns a slice of individual ports expanded from PortRanges.
+// Malformed ranges and ranges outside of the valid port space are skipped.
 func (r Resources) Ports() []string {
 	if r.PortRanges == "" || r.PortRanges == "[]" {
 		return []string{}
 	}
 
-	rhs := strings.Split(r.PortRanges, "[")[1]
+	brackets := strings.SplitN(r.PortRanges, "[", 2)
+	if len(brackets) != 2 {
+		logging.Error.Printf("malformed port ranges %q", r.PortRanges)
+		return []string{}
+	}
+	rhs := brackets[1]
 	lhs := strings.Split(rhs, "]")[0]
 
 	yports := []string{}
@@ -30,6 +40,10 @@ func (r Resources) Ports() []string {
 	for _, port := range mports {
 		tmp := strings.TrimSpace(port)
 		pz := strings.Split(tmp, "-")
+		if len(pz) != 2 {
+			logging.Error.Printf("malformed port range %q", tmp)
+			continue
+		}
 		lo, err := strconv.Atoi(pz[0])
 		if err != nil {
 			logging.Error.Println(err)
@@ -40,6 +54,10 @@ func (r Resources) Ports() []string {
 			logging.Error.Println(err)
 			continue
 		}
+		if lo < 0 || hi > 65535 {
+			logging.Error.Printf("port range %q out of bounds", tmp)
+			continue
+		}
 
 		for t := lo; t <= hi; t++ {
 			yports = append(yports, strconv.Itoa(t))
@@ -74,6 +92,35 @@ type NetworkInfo struct {
 	IPAddresses []IPAddress `json:"ip_addresses,omitempty"`
 	// back-compat with 0.25 IPAddress format
 	IPAddress string `json:"ip_address,omitempty"`
+	// Name is the name of the network the interface is on, e.g. an overlay,
+	// if any.
+	Name         string        `json:"name,omitempty"`
+	PortMappings []PortMapping `json:"port_mappings,omitempty"`
+}
+
+// ips returns the IP addresses configured on the interface.
+func (n *NetworkInfo) ips() []string {
+	if len(n.IPAddresses) == 0 {
+		// Fall back to v0.25 syntax of single IPAddress if that's being used.
+		if n.IPAddress != "" {
+			return []string{n.IPAddress}
+		}
+		return nil
+	}
+	// In v0.26, we use the IPAddresses field.
+	ips := make([]string, 0, len(n.IPAddresses))
+	for _, ipAddress := range n.IPAddresses {
+		ips = append(ips, ipAddress.IPAddress)
+	}
+	return ips
+}
+
+// PortMapping maps a port of the host to one of the container, as defined in
+// the /state.json Mesos HTTP endpoint.
+type PortMapping struct {
+	HostPort      uint32 `json:"host_port"`
+	ContainerPort uint32 `json:"container_port"`
+	Protocol      string `json:"protocol,omitempty"`
 }
 
 // IPAddress holds a single IP address configured on an interface,
@@ -92,9 +139,18 @@ type Task struct {
 	Statuses      []Status `json:"statuses"`
 	Resources     `json:"resources"`
 	DiscoveryInfo DiscoveryInfo `json:"discovery"`
-
-	// SlaveIPs is used internally and contains ipv4, ipv6, or both
+	Labels        []Label       `json:"labels,omitempty"`
+	HealthCheck   *HealthCheck  `json:"health_check,omitempty"`
+	// ExecutorID is the ID of the executor of the task, shared by the tasks
+	// of a task group, if not a command task.
+	ExecutorID string `json:"executor_id,omitempty"`
+
+	// SlaveIPs is used internally and contains every ipv4 and ipv6 address
+	// of the slave
 	SlaveIPs []string `json:"-"`
+	// ContainerNets is used internally and contains the networks the
+	// NetworkInfo IP addresses of the autoip source are routable on.
+	ContainerNets []*net.IPNet `json:"-"`
 }
 
 // HasDiscoveryInfo return whether the DiscoveryInfo was provided in the state.json
@@ -117,7 +173,7 @@ func (t *Task) IPs(srcs ...string) (ips []net.IP) {
 		return nil
 	}
 	for i := range srcs {
-		if src, ok := sources[srcs[i]]; ok {
+		if src, ok := source(srcs[i]); ok {
 			for _, srcIP := range src(t) {
 				if ip := net.ParseIP(srcIP); len(ip) > 0 {
 					ips = append(ips, ip)
@@ -128,12 +184,87 @@ func (t *Task) IPs(srcs ...string) (ips []net.IP) {
 	return ips
 }
 
+// RecordIPs returns the IPs the records of the Task are published with, as
+// sourced from the given sources with ascending priority: every IPv4 address
+// of the first source with any, followed by every IPv6 address of the first
+// source with any, less duplicates.
+func (t *Task) RecordIPs(srcs ...string) []net.IP {
+	if t == nil {
+		return nil
+	}
+	var v4, v6 []net.IP
+	for i := range srcs {
+		src, ok := source(srcs[i])
+		if !ok {
+			continue
+		}
+		var src4, src6 []net.IP
+		for _, srcIP := range src(t) {
+			if ip := net.ParseIP(srcIP); ip == nil {
+				continue
+			} else if ip.To4() != nil {
+				src4 = appendIP(src4, ip)
+			} else {
+				src6 = appendIP(src6, ip)
+			}
+		}
+		if len(v4) == 0 {
+			v4 = src4
+		}
+		if len(v6) == 0 {
+			v6 = src6
+		}
+	}
+	return append(v4, v6...)
+}
+
+// appendIP appends the given IP to the given ones, unless among them.
+func appendIP(ips []net.IP, ip net.IP) []net.IP {
+	for _, other := range ips {
+		if other.Equal(ip) {
+			return ips
+		}
+	}
+	return append(ips, ip)
+}
+
 // sources maps the string representation of IP sources to their functions.
 var sources = map[string]func(*Task) []string{
 	"host":    hostIPs,
 	"mesos":   mesosIPs,
 	"docker":  dockerIPs,
 	"netinfo": networkInfoIPs,
+	"autoip":  autoIPs,
+}
+
+// networkSourcePrefix prefixes the names of the netinfo IP sources restricted
+// to the interfaces on a named network, e.g. netinfo:dcos.
+const networkSourcePrefix = "netinfo:"
+
+// source returns the function of the given IP source, if known.
+func source(name string) (func(*Task) []string, bool) {
+	if network := strings.TrimPrefix(name, networkSourcePrefix); network != name && network != "" {
+		return func(t *Task) []string { return networkIPs(t, network) }, true
+	}
+	src, ok := sources[name]
+	return src, ok
+}
+
+// IsIPSource tells whether the given IP source is known: one of IPSources,
+// or a netinfo source restricted to a named network, e.g. netinfo:dcos.
+func IsIPSource(name string) bool {
+	_, ok := source(name)
+	return ok
+}
+
+// IPSources returns the names of the known IP sources, sorted.
+func IPSources() []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // hostIPs is an IPSource which returns the IP addresses of the slave a Task
@@ -142,24 +273,57 @@ func hostIPs(t *Task) []string { return t.SlaveIPs }
 
 // networkInfoIPs returns IP addresses from a given Task's
 // []Status.ContainerStatus.[]NetworkInfos.[]IPAddresses.IPAddress
-func networkInfoIPs(t *Task) []string {
+func networkInfoIPs(t *Task) []string { return networkIPs(t, "") }
+
+// networkIPs returns the NetworkInfo IP addresses of all the interfaces of a
+// given Task on the named network, or on any network if empty, e.g. those of
+// CNI networks.
+func networkIPs(t *Task, network string) []string {
 	return statusIPs(t.Statuses, func(s *Status) []string {
-		ips := make([]string, len(s.ContainerStatus.NetworkInfos))
-		for _, netinfo := range s.ContainerStatus.NetworkInfos {
-			if len(netinfo.IPAddresses) > 0 {
-				// In v0.26, we use the IPAddresses field.
-				for _, ipAddress := range netinfo.IPAddresses {
-					ips = append(ips, ipAddress.IPAddress)
-				}
-			} else {
-				// Fall back to v0.25 syntax of single IPAddress if that's being used.
-				if netinfo.IPAddress != "" {
-					ips = append(ips, netinfo.IPAddress)
+		var ips []string
+		for i := range s.ContainerStatus.NetworkInfos {
+			if netinfo := &s.ContainerStatus.NetworkInfos[i]; network == "" || netinfo.Name == network {
+				ips = append(ips, netinfo.ips()...)
+			}
+		}
+		return ips
+	})
+}
+
+// autoIPs is an IPSource which returns the NetworkInfo IP addresses of a
+// Task which are routable, as the DC/OS autoip names do: those of interfaces
+// on a named network, e.g. an overlay, without port mappings, and those in
+// ContainerNets. Should there be none, e.g. for tasks in bridge or host mode,
+// the IP addresses of its slave are returned instead.
+func autoIPs(t *Task) []string {
+	ips := statusIPs(t.Statuses, func(s *Status) []string {
+		var ips []string
+		for i := range s.ContainerStatus.NetworkInfos {
+			netinfo := &s.ContainerStatus.NetworkInfos[i]
+			overlay := netinfo.Name != "" && len(netinfo.PortMappings) == 0
+			for _, ip := range netinfo.ips() {
+				if overlay || containedIn(t.ContainerNets, net.ParseIP(ip)) {
+					ips = append(ips, ip)
 				}
 			}
 		}
 		return ips
 	})
+	if len(ips) == 0 {
+		return t.SlaveIPs
+	}
+	return ips
+}
+
+// containedIn tells whether the given IP address is in any of the given
+// networks.
+func containedIn(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 const (
@@ -184,6 +348,15 @@ func mesosIPs(t *Task) []string {
 
 // statusIPs returns the latest running status IPs extracted with the given src
 func statusIPs(st []Status, src func(*Status) []string) []string {
+	if s := latestRunning(st); s != nil {
+		return src(s)
+	}
+	return nil
+}
+
+// latestRunning returns the latest running status among the given ones, if
+// any.
+func latestRunning(st []Status) *Status {
 	// the state.json we extract from mesos makes no guarantees re: the order
 	// of the task statuses so we should check the timestamps to avoid problems
 	// down the line. we can't rely on seeing the same sequence. (@joris)
@@ -195,11 +368,26 @@ func statusIPs(st []Status, src func(*Status) []string) []string {
 		}
 	}
 	if j >= 0 {
-		return src(&st[j])
+		return &st[j]
 	}
 	return nil
 }
 
+// PortMappings returns the mappings of host ports to container ports of the
+// interfaces of the Task, e.g. in bridge mode, as of its latest running
+// status.
+func (t *Task) PortMappings() []PortMapping {
+	s := latestRunning(t.Statuses)
+	if s == nil {
+		return nil
+	}
+	var mappings []PortMapping
+	for i := range s.ContainerStatus.NetworkInfos {
+		mappings = append(mappings, s.ContainerStatus.NetworkInfos[i].PortMappings...)
+	}
+	return mappings
+}
+
 // labels returns all given Status.[]Labels' values whose keys are equal
 // to the given key
 func labels(key string) func(*Status) []string {
@@ -216,10 +404,27 @@ func labels(key string) func(*Status) []string {
 
 // Framework holds a framework as defined in the /state.json Mesos HTTP endpoint.
 type Framework struct {
+	ID       string `json:"id"`
 	Tasks    []Task `json:"tasks"`
 	PID      PID    `json:"pid"`
 	Name     string `json:"name"`
 	Hostname string `json:"hostname"`
+	// RegisteredTime is when the framework registered, in seconds since the
+	// epoch.
+	RegisteredTime float64 `json:"registered_time"`
+	// Executors are the running executors of the framework; the completed
+	// ones aren't decoded.
+	Executors []Executor `json:"executors,omitempty"`
+}
+
+// Executor holds an executor of a framework as defined in the /state.json
+// Mesos HTTP endpoint.
+type Executor struct {
+	ID          string        `json:"executor_id"`
+	Name        string        `json:"name,omitempty"`
+	FrameworkID string        `json:"framework_id"`
+	SlaveID     string        `json:"slave_id"`
+	Discovery   DiscoveryInfo `json:"discovery"`
 }
 
 // HostPort returns the hostname and port where a framework's scheduler is
@@ -233,9 +438,34 @@ func (f Framework) HostPort() (string, string) {
 
 // Slave holds a slave as defined in the /state.json Mesos HTTP endpoint.
 type Slave struct {
-	ID       string `json:"id"`
-	Hostname string `json:"hostname"`
-	PID      PID    `json:"pid"`
+	ID         string     `json:"id"`
+	Hostname   string     `json:"hostname"`
+	PID        PID        `json:"pid"`
+	Attributes Attributes `json:"attributes,omitempty"`
+}
+
+// Attributes holds the attributes of a slave, e.g. rack:r1, by name, their
+// values as formatted in the /state.json Mesos HTTP endpoint: text, ranges
+// and sets as is, e.g. "[1-10]" or "{a,b}", and scalars in decimal.
+type Attributes map[string]string
+
+// UnmarshalJSON implements the json.Unmarshaler interface for Attributes,
+// whose scalar values are JSON numbers. Values of other types are skipped.
+func (a *Attributes) UnmarshalJSON(data []byte) error {
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*a = make(Attributes, len(values))
+	for name, v := range values {
+		switch v := v.(type) {
+		case string:
+			(*a)[name] = v
+		case float64:
+			(*a)[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return nil
 }
 
 // PID holds a Mesos PID and implements the json.Unmarshaler interface.
@@ -252,6 +482,66 @@ type State struct {
 	Frameworks []Framework `json:"frameworks"`
 	Slaves     []Slave     `json:"slaves"`
 	Leader     string      `json:"leader"`
+	// OrphanTasks are tasks whose framework hasn't re-registered with the
+	// master (yet), e.g. after a master failover.
+	OrphanTasks []Task `json:"orphan_tasks"`
+	// DecodeTime is the time the decoder of the state took to decode it, if
+	// told, rather than part of the master's response.
+	DecodeTime time.Duration `json:"-"`
+}
+
+// maxPort is the largest port number.
+const maxPort = 65535
+
+// Check sanity checks the decoded state for values no master would report,
+// which are taken as a sign of a corrupt state rather than skipped one by one:
+// discovery port numbers out of the valid port space and tasks with more
+// discovery ports than there are distinct TCP and UDP ports.
+func (s *State) Check() error {
+	check := func(t *Task) error {
+		ports := t.DiscoveryInfo.Ports.DiscoveryPorts
+		if len(ports) > 2*(maxPort+1) {
+			return fmt.Errorf("task %q has %d discovery ports", t.ID, len(ports))
+		}
+		for _, port := range ports {
+			if port.Number < 0 || port.Number > maxPort {
+				return fmt.Errorf("task %q has discovery port number %d", t.ID, port.Number)
+			}
+		}
+		return nil
+	}
+	for i := range s.Frameworks {
+		for j := range s.Frameworks[i].Tasks {
+			if err := check(&s.Frameworks[i].Tasks[j]); err != nil {
+				return fmt.Errorf("corrupt state: %v", err)
+			}
+		}
+	}
+	for i := range s.OrphanTasks {
+		if err := check(&s.OrphanTasks[i]); err != nil {
+			return fmt.Errorf("corrupt state: %v", err)
+		}
+	}
+	return nil
+}
+
+// DefaultHealthCheckInterval is the interval, in seconds, between the health
+// checks of a task which Mesos defaults to.
+const DefaultHealthCheckInterval = 10
+
+// HealthCheck holds the health check of a task defined in the /state.json Mesos HTTP endpoint.
+type HealthCheck struct {
+	// IntervalSeconds is the interval between checks, in seconds, if set.
+	IntervalSeconds float64 `json:"interval_seconds,omitempty"`
+}
+
+// Interval returns the interval between checks, in seconds, defaulting to
+// DefaultHealthCheckInterval.
+func (h *HealthCheck) Interval() float64 {
+	if h.IntervalSeconds > 0 {
+		return h.IntervalSeconds
+	}
+	return DefaultHealthCheckInterval
 }
 
 // DiscoveryInfo holds the discovery meta data for a task defined in the /state.json Mesos HTTP endpoint.
@@ -274,4 +564,7 @@ type DiscoveryPort struct {
 	Protocol string `json:"protocol"`
 	Number   int    `json:"number"`
 	Name     string `json:"name"`
+	Labels   struct {
+		Labels []Label `json:"labels"`
+	} `json:"labels"`
 }
@@ -1,20 +1,46 @@
 package state_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/mesosphere/mesos-dns/logging"
 	. "github.com/mesosphere/mesos-dns/records/state"
 	"github.com/mesosphere/mesos-dns/records/state/upid"
 )
 
+func init() {
+	logging.VerboseFlag = false
+	logging.SetupLogs()
+}
+
 func TestResources_Ports(t *testing.T) {
-	r := Resources{PortRanges: "[31111-31111, 31115-31117]"}
-	want := []string{"31111", "31115", "31116", "31117"}
-	if got := r.Ports(); !reflect.DeepEqual(got, want) {
-		t.Fatalf("got: %v, want: %v", got, want)
+	for i, tt := range []struct {
+		ranges string
+		want   []string
+	}{
+		{"", []string{}},
+		{"[]", []string{}},
+		{"[31111-31111, 31115-31117]", []string{"31111", "31115", "31116", "31117"}},
+		{"31111-31112", []string{}},
+		{"[31111]", []string{}},
+		{"[31111, 31115-31115]", []string{"31115"}},
+		{"[31111-31112-31113]", []string{}},
+		{"[0-2000000000]", []string{}},
+		{"[-5-3]", []string{}},
+	} {
+		r := Resources{PortRanges: tt.ranges}
+		if got := r.Ports(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: %q: got: %v, want: %v", i+1, tt.ranges, got, tt.want)
+		}
 	}
 }
 
@@ -42,6 +68,25 @@ func TestPID_UnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestHealthCheck_Interval(t *testing.T) {
+	for i, tt := range []struct {
+		data string
+		want float64
+	}{
+		{`{"health_check": {"interval_seconds": 2.5, "timeout_seconds": 20}}`, 2.5},
+		{`{"health_check": {"type": "HTTP", "delay_seconds": 15}}`, DefaultHealthCheckInterval},
+		{`{"health_check": {"interval_seconds": -1}}`, DefaultHealthCheckInterval},
+	} {
+		var task Task
+		if err := json.Unmarshal([]byte(tt.data), &task); err != nil {
+			t.Fatalf("test #%d: %v", i, err)
+		}
+		if got := task.HealthCheck.Interval(); got != tt.want {
+			t.Errorf("test #%d: got: %v, want: %v", i, got, tt.want)
+		}
+	}
+}
+
 func TestTask_IPs(t *testing.T) {
 	for i, tt := range []struct {
 		*Task
@@ -117,6 +162,59 @@ func TestTask_IPs(t *testing.T) {
 			srcs: []string{"docker", "netinfo"},
 			want: ips("2.4.6.8"),
 		},
+		{ // autoip: container IPs on an overlay
+			Task: task(
+				slaveIPs("2.3.4.5"),
+				statuses(status(state("TASK_RUNNING"), netinfos(named("dcos", netinfo("9.0.1.2", "fd01:b::1:8000:2"))))),
+			),
+			srcs: []string{"autoip"},
+			want: ips("9.0.1.2", "fd01:b::1:8000:2"),
+		},
+		{ // autoip: slave IPs in bridge mode
+			Task: task(
+				slaveIPs("2.3.4.5"),
+				statuses(status(state("TASK_RUNNING"), netinfos(mapped(named("mesos-bridge", netinfo("172.31.0.2")))))),
+			),
+			srcs: []string{"autoip"},
+			want: ips("2.3.4.5"),
+		},
+		{ // autoip: slave IPs on an unnamed network
+			Task: task(
+				slaveIPs("2.3.4.5"),
+				statuses(status(state("TASK_RUNNING"), netinfos(netinfo("172.17.0.2")))),
+			),
+			srcs: []string{"autoip"},
+			want: ips("2.3.4.5"),
+		},
+		{ // autoip: container IPs in the routable networks
+			Task: task(
+				slaveIPs("2.3.4.5"),
+				containerNets("172.17.0.0/16"),
+				statuses(status(state("TASK_RUNNING"), netinfos(netinfo("172.17.0.2"), mapped(named("bridge", netinfo("172.18.0.2")))))),
+			),
+			srcs: []string{"autoip"},
+			want: ips("172.17.0.2"),
+		},
+		{ // netinfo: every address of every interface
+			Task: task(
+				statuses(status(state("TASK_RUNNING"), netinfos(
+					named("dcos", netinfo("9.0.1.2", "fd01:b::1:8000:2")),
+					named("storage", netinfo("192.168.10.2")),
+				))),
+			),
+			srcs: []string{"netinfo"},
+			want: ips("9.0.1.2", "fd01:b::1:8000:2", "192.168.10.2"),
+		},
+		{ // netinfo: the addresses of the interfaces on a named network
+			Task: task(
+				statuses(status(state("TASK_RUNNING"), netinfos(
+					named("dcos", netinfo("9.0.1.2", "fd01:b::1:8000:2")),
+					named("storage", netinfo("192.168.10.2")),
+				))),
+			),
+			srcs: []string{"netinfo:storage", "netinfo:other"},
+			want: ips("192.168.10.2"),
+		},
 		{ // label ordering
 			Task: task(
 				statuses(
@@ -137,6 +235,40 @@ func TestTask_IPs(t *testing.T) {
 	}
 }
 
+func TestTask_RecordIPs(t *testing.T) {
+	for i, tt := range []struct {
+		*Task
+		srcs []string
+		want []net.IP
+	}{
+		{ // each family from the first source with any
+			Task: task(
+				slaveIPs("2.3.4.5", "fd00::5"),
+				statuses(status(state("TASK_RUNNING"), netinfos(
+					named("dcos", netinfo("9.0.1.2")),
+					named("storage", netinfo("192.168.10.2", "9.0.1.2")),
+				))),
+			),
+			srcs: []string{"netinfo", "host"},
+			want: ips("9.0.1.2", "192.168.10.2", "fd00::5"),
+		},
+		{ // unknown sources are ignored
+			Task: task(slaveIPs("2.3.4.5", "2.3.4.6")),
+			srcs: []string{"rkt", "host"},
+			want: ips("2.3.4.5", "2.3.4.6"),
+		},
+		{ // no address
+			Task: task(),
+			srcs: []string{"netinfo"},
+			want: ips(),
+		},
+	} {
+		if got := tt.RecordIPs(tt.srcs...); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("test #%d: got %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
 // test helpers
 
 type (
@@ -207,6 +339,27 @@ func netinfo(ips ...string) NetworkInfo {
 	return netinfo
 }
 
+// named returns the given NetworkInfo on the network of the given name.
+func named(name string, netinfo NetworkInfo) NetworkInfo {
+	netinfo.Name = name
+	return netinfo
+}
+
+// mapped returns the given NetworkInfo with a port mapping.
+func mapped(netinfo NetworkInfo) NetworkInfo {
+	netinfo.PortMappings = append(netinfo.PortMappings, PortMapping{HostPort: 31000, ContainerPort: 80})
+	return netinfo
+}
+
+func containerNets(cidrs ...string) taskOpt {
+	return func(t *Task) {
+		for _, cidr := range cidrs {
+			_, n, _ := net.ParseCIDR(cidr)
+			t.ContainerNets = append(t.ContainerNets, n)
+		}
+	}
+}
+
 // NetworkInfo using v0.25 syntax for storing a single IP.
 func oldnetinfo(ip string) NetworkInfo {
 	netinfo := NetworkInfo{}
@@ -225,3 +378,243 @@ func oldnewnetinfo(oldip string, newip string) NetworkInfo {
 func timestamp(t float64) statusOpt {
 	return func(s *Status) { s.Timestamp = t }
 }
+
+func TestState_Check(t *testing.T) {
+	task := func(numbers ...int) Task {
+		var t Task
+		t.ID = "web.1"
+		for _, n := range numbers {
+			t.DiscoveryInfo.Ports.DiscoveryPorts = append(t.DiscoveryInfo.Ports.DiscoveryPorts,
+				DiscoveryPort{Protocol: "tcp", Number: n})
+		}
+		return t
+	}
+	for i, tt := range []struct {
+		state State
+		err   string
+	}{
+		{State{}, ""},
+		{State{Frameworks: []Framework{{Tasks: []Task{task(0, 80, 65535)}}}}, ""},
+		{State{Frameworks: []Framework{{Tasks: []Task{task(80, -1)}}}},
+			`corrupt state: task "web.1" has discovery port number -1`},
+		{State{OrphanTasks: []Task{task(65536)}}, `corrupt state: task "web.1" has discovery port number 65536`},
+		{State{OrphanTasks: []Task{task(make([]int, 2*65536+1)...)}},
+			`corrupt state: task "web.1" has 131073 discovery ports`},
+	} {
+		err := tt.state.Check()
+		if got := fmt.Sprint(err); (err != nil || tt.err != "") && got != tt.err {
+			t.Errorf("test #%d: got error %q, want %q", i, got, tt.err)
+		}
+	}
+}
+
+func TestUnmarshalV1(t *testing.T) {
+	read := func(name string) []byte {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	var want State
+	if err := json.Unmarshal(read("testdata/state.json"), &want); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"testdata/v1_state.json", "testdata/state.json"} {
+		var got State
+		if err := UnmarshalV1(read(name), &got); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got state %+v, want %+v", name, got, want)
+		}
+	}
+
+	for i, tt := range []struct {
+		data string
+		err  string
+	}{
+		{`{"type": "GET_MASTER", "get_master": {}}`, `unexpected v1 response type "GET_MASTER"`},
+		{`{"type": "GET_STATE"}`, "GET_STATE response without state"},
+		{`{"type": "GET_STATE", "get_state": `, "unexpected end of JSON input"},
+	} {
+		var s State
+		if err := UnmarshalV1([]byte(tt.data), &s); fmt.Sprint(err) != tt.err {
+			t.Errorf("test #%d: got error %v, want %q", i, err, tt.err)
+		}
+	}
+}
+
+func TestUnmarshalEvent(t *testing.T) {
+	for i, tt := range []struct {
+		data string
+		want Event
+		err  string
+	}{
+		{`{"type": "HEARTBEAT"}`, Event{Type: EventHeartbeat}, ""},
+		{`{"type": "SUBSCRIBED", "subscribed": {"get_state": {"get_agents": {"agents": [{"agent_info": {"id": {"value": "s1"}}}]}}}}`,
+			Event{Type: EventSubscribed, State: &State{Slaves: []Slave{{ID: "s1"}}}}, ""},
+		{`{"type": "TASK_ADDED", "task_added": {"task": {"task_id": {"value": "web.1"}, "framework_id": {"value": "f1"}, "state": "TASK_STAGING"}}}`,
+			Event{Type: EventTaskAdded, Task: &Task{ID: "web.1", FrameworkID: "f1", State: "TASK_STAGING"}}, ""},
+		{`{"type": "TASK_ADDED", "task_added": {"task": {"task_id": {"value": "web.instance-1.nginx"}, "executor_id": {"value": "instance-web.1"}}}}`,
+			Event{Type: EventTaskAdded, Task: &Task{ID: "web.instance-1.nginx", ExecutorID: "instance-web.1"}}, ""},
+		{`{"type": "TASK_UPDATED", "task_updated": {"framework_id": {"value": "f1"}, "state": "TASK_RUNNING",
+			"status": {"task_id": {"value": "web.1"}, "state": "TASK_RUNNING", "timestamp": 2}}}`,
+			Event{Type: EventTaskUpdated, FrameworkID: "f1", TaskID: "web.1", TaskState: "TASK_RUNNING",
+				Status: &Status{State: "TASK_RUNNING", Timestamp: 2}}, ""},
+		{`{"type": "FRAMEWORK_UPDATED", "framework_updated": {"framework": {"framework_info": {"id": {"value": "f1"}, "name": "marathon"}}}}`,
+			Event{Type: EventFrameworkUpdated, Framework: &Framework{ID: "f1", Name: "marathon"}}, ""},
+		{`{"type": "AGENT_REMOVED", "agent_removed": {"agent_id": {"value": "s1"}}}`,
+			Event{Type: EventAgentRemoved, SlaveID: "s1"}, ""},
+		{`{"task_added": {}}`, Event{}, "event without type"},
+	} {
+		got, err := UnmarshalEvent([]byte(tt.data))
+		if (err != nil || tt.err != "") && fmt.Sprint(err) != tt.err {
+			t.Errorf("test #%d: got error %v, want %q", i, err, tt.err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got event %+v, want %+v", i, got, tt.want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	names, err := filepath.Glob("../testdata/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = append(names, "testdata/state.json")
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want, got State
+		if err := json.Unmarshal(b, &want); err != nil {
+			continue // not a state
+		}
+		if err := Decode(bytes.NewReader(b), &got); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got state %+v, want %+v", name, got, want)
+		}
+	}
+
+	for i, tt := range []struct {
+		data string
+		ok   bool
+	}{
+		{`null`, true},
+		{`{}`, true},
+		{`{"frameworks": null, "slaves": [], "orphan_tasks": null}`, true},
+		{`{"frameworks": [{"name": "a", "tasks": []}, {"tasks": null}, {}]}`, true},
+		{`{"frameworks": [{"tasks": [{"id": "a"}], "completed_tasks": [{"id": "b"}]}],
+		  "completed_frameworks": [{"tasks": [{"id": "c"}]}], "leader": "master@10.0.0.1:5050"}`, true},
+		{`{"frameworks": [{"executors": [{"executor_id": "e", "slave_id": "s"}],
+		  "completed_executors": [{"executor_id": "f"}]}]}`, true},
+		{`{"frameworks": [{"tasks": [{"id": 1}]}]}`, false},
+		{`{"frameworks": {}}`, false},
+		{`{"frameworks": [`, false},
+		{`{"slaves": []} {}`, false},
+		{`[]`, false},
+	} {
+		var want, got State
+		werr := json.Unmarshal([]byte(tt.data), &want)
+		err := Decode(strings.NewReader(tt.data), &got)
+		if (werr == nil) != tt.ok {
+			t.Fatalf("test #%d: json.Unmarshal error: %v", i, werr)
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("test #%d: got error %v, want one: %t", i, err, !tt.ok)
+		} else if tt.ok && !reflect.DeepEqual(got, want) {
+			t.Errorf("test #%d: got state %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+// BenchmarkDecode compares the decoding of a large, synthetic, state read
+// in full then unmarshaled, as before Decode, to its streamed decoding.
+func BenchmarkDecode(b *testing.B) {
+	data := largeState(b, 50<<20)
+	b.Logf("state of %d bytes", len(data))
+	for _, bb := range []struct {
+		name   string
+		decode func(io.Reader, *State) error
+	}{
+		{"Unmarshal", func(r io.Reader, s *State) error {
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(b, s)
+		}},
+		{"Decode", Decode},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				var s State
+				if err := bb.decode(bytes.NewReader(data), &s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// largeState returns a synthetic state of about the given size, of which
+// the running tasks take about a fifth, the completed tasks the rest, as on
+// long running clusters.
+func largeState(b *testing.B, size int) []byte {
+	task := func(i int, state string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":           fmt.Sprintf("app-%d.%08x-0000-0000-0000-000000000000", i, i),
+			"name":         fmt.Sprintf("app-%d", i),
+			"framework_id": "20160107-001256-134875658-5050-27524-0000",
+			"slave_id":     fmt.Sprintf("20160107-001256-134875658-5050-27524-S%d", i%100),
+			"state":        state,
+			"resources":    map[string]interface{}{"ports": "[31000-31001]", "cpus": 0.1, "mem": 128},
+			"statuses": []map[string]interface{}{{
+				"state":     state,
+				"timestamp": 1452150000.0 + float64(i),
+				"container_status": map[string]interface{}{
+					"network_infos": []map[string]interface{}{{
+						"ip_addresses": []map[string]string{{"ip_address": "10.0.0.1"}},
+					}},
+				},
+			}},
+			"labels": []map[string]string{{"key": "owner", "value": "team"}},
+		}
+	}
+	var frameworks []map[string]interface{}
+	for n, i := 0, 0; n < size; i++ {
+		f := map[string]interface{}{
+			"id":       fmt.Sprintf("20160107-001256-134875658-5050-27524-%04d", i),
+			"name":     fmt.Sprintf("framework-%d", i),
+			"hostname": "10.0.0.1",
+			"pid":      "scheduler@10.0.0.1:5051",
+		}
+		var tasks, completed []map[string]interface{}
+		for j := 0; j < 1000; j++ {
+			if j%5 == 0 {
+				tasks = append(tasks, task(j, "TASK_RUNNING"))
+			} else {
+				completed = append(completed, task(j, "TASK_FINISHED"))
+			}
+		}
+		f["tasks"], f["completed_tasks"] = tasks, completed
+		frameworks = append(frameworks, f)
+		fb, err := json.Marshal(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		n += len(fb)
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"leader":     "master@10.0.0.1:5050",
+		"frameworks": frameworks,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,339 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The v1 types hold the parts of the response to the GET_STATE call of the
+// Mesos v1 operator API which map to a State, as defined in
+// http://mesos.apache.org/documentation/latest/operator-http-api/.
+type (
+	v1Response struct {
+		Type     string   `json:"type"`
+		GetState *v1State `json:"get_state"`
+	}
+
+	v1State struct {
+		GetTasks struct {
+			PendingTasks []v1Task `json:"pending_tasks"`
+			Tasks        []v1Task `json:"tasks"`
+			OrphanTasks  []v1Task `json:"orphan_tasks"`
+		} `json:"get_tasks"`
+		GetFrameworks struct {
+			Frameworks []v1Framework `json:"frameworks"`
+		} `json:"get_frameworks"`
+		GetAgents struct {
+			Agents []v1Agent `json:"agents"`
+		} `json:"get_agents"`
+	}
+
+	v1ID struct {
+		Value string `json:"value"`
+	}
+
+	v1Labels struct {
+		Labels []Label `json:"labels"`
+	}
+
+	v1Task struct {
+		Name        string        `json:"name"`
+		TaskID      v1ID          `json:"task_id"`
+		FrameworkID v1ID          `json:"framework_id"`
+		AgentID     v1ID          `json:"agent_id"`
+		State       string        `json:"state"`
+		Statuses    []v1Status    `json:"statuses"`
+		Resources   []v1Resource  `json:"resources"`
+		Discovery   DiscoveryInfo `json:"discovery"`
+		Labels      v1Labels      `json:"labels"`
+		HealthCheck *HealthCheck  `json:"health_check"`
+		ExecutorID  v1ID          `json:"executor_id"`
+	}
+
+	v1Status struct {
+		TaskID          v1ID            `json:"task_id"`
+		Timestamp       float64         `json:"timestamp"`
+		State           string          `json:"state"`
+		Labels          v1Labels        `json:"labels"`
+		ContainerStatus ContainerStatus `json:"container_status"`
+	}
+
+	v1Resource struct {
+		Name   string `json:"name"`
+		Ranges struct {
+			Range []struct {
+				Begin uint64 `json:"begin"`
+				End   uint64 `json:"end"`
+			} `json:"range"`
+		} `json:"ranges"`
+	}
+
+	v1Framework struct {
+		FrameworkInfo struct {
+			ID       v1ID   `json:"id"`
+			Name     string `json:"name"`
+			Hostname string `json:"hostname"`
+		} `json:"framework_info"`
+		RegisteredTime struct {
+			Nanoseconds int64 `json:"nanoseconds"`
+		} `json:"registered_time"`
+	}
+
+	v1Agent struct {
+		AgentInfo struct {
+			ID         v1ID          `json:"id"`
+			Hostname   string        `json:"hostname"`
+			Attributes []v1Attribute `json:"attributes"`
+		} `json:"agent_info"`
+		PID PID `json:"pid"`
+	}
+
+	v1Attribute struct {
+		Name   string `json:"name"`
+		Type   string `json:"type"`
+		Scalar struct {
+			Value float64 `json:"value"`
+		} `json:"scalar"`
+		Text struct {
+			Value string `json:"value"`
+		} `json:"text"`
+	}
+
+	v1Event struct {
+		Type       string `json:"type"`
+		Subscribed *struct {
+			GetState *v1State `json:"get_state"`
+		} `json:"subscribed"`
+		TaskAdded *struct {
+			Task v1Task `json:"task"`
+		} `json:"task_added"`
+		TaskUpdated *struct {
+			FrameworkID v1ID     `json:"framework_id"`
+			Status      v1Status `json:"status"`
+			State       string   `json:"state"`
+		} `json:"task_updated"`
+		FrameworkAdded *struct {
+			Framework v1Framework `json:"framework"`
+		} `json:"framework_added"`
+		FrameworkUpdated *struct {
+			Framework v1Framework `json:"framework"`
+		} `json:"framework_updated"`
+		AgentAdded *struct {
+			Agent v1Agent `json:"agent"`
+		} `json:"agent_added"`
+		AgentRemoved *struct {
+			AgentID v1ID `json:"agent_id"`
+		} `json:"agent_removed"`
+	}
+)
+
+// Types of the events of the Mesos v1 operator API event stream, as told by
+// Event.Type. Events of other types are unmarshaled with their type only.
+const (
+	EventSubscribed       = "SUBSCRIBED"
+	EventTaskAdded        = "TASK_ADDED"
+	EventTaskUpdated      = "TASK_UPDATED"
+	EventFrameworkAdded   = "FRAMEWORK_ADDED"
+	EventFrameworkUpdated = "FRAMEWORK_UPDATED"
+	EventAgentAdded       = "AGENT_ADDED"
+	EventAgentRemoved     = "AGENT_REMOVED"
+	EventHeartbeat        = "HEARTBEAT"
+)
+
+// Event holds an event of the Mesos v1 operator API event stream.
+type Event struct {
+	Type string
+	// State is the state of the cluster once subscribed, without leader,
+	// of EventSubscribed events.
+	State *State
+	// Task is the task added, of EventTaskAdded events.
+	Task *Task
+	// FrameworkID and TaskID identify the task updated, and TaskState and
+	// Status tell its new state and status, of EventTaskUpdated events.
+	FrameworkID, TaskID, TaskState string
+	Status                         *Status
+	// Framework is the framework added or updated, without tasks, of
+	// EventFrameworkAdded and EventFrameworkUpdated events.
+	Framework *Framework
+	// Slave is the agent added, of EventAgentAdded events.
+	Slave *Slave
+	// SlaveID is the ID of the agent removed, of EventAgentRemoved events.
+	SlaveID string
+}
+
+// UnmarshalEvent unmarshals the given event of the Mesos v1 operator API
+// event stream, as framed by the stream.
+func UnmarshalEvent(data []byte) (Event, error) {
+	var v v1Event
+	if err := json.Unmarshal(data, &v); err != nil {
+		return Event{}, err
+	}
+	ev := Event{Type: v.Type}
+	switch {
+	case v.Type == "":
+		return ev, errors.New("event without type")
+	case v.Subscribed != nil && v.Subscribed.GetState != nil:
+		s := v.Subscribed.GetState.state()
+		ev.State = &s
+	case v.TaskAdded != nil:
+		t := v.TaskAdded.Task.task()
+		ev.Task = &t
+	case v.TaskUpdated != nil:
+		st := v.TaskUpdated.Status.status()
+		ev.FrameworkID = v.TaskUpdated.FrameworkID.Value
+		ev.TaskID = v.TaskUpdated.Status.TaskID.Value
+		ev.TaskState = v.TaskUpdated.State
+		ev.Status = &st
+	case v.FrameworkAdded != nil:
+		f := v.FrameworkAdded.Framework.framework()
+		ev.Framework = &f
+	case v.FrameworkUpdated != nil:
+		f := v.FrameworkUpdated.Framework.framework()
+		ev.Framework = &f
+	case v.AgentAdded != nil:
+		s := v.AgentAdded.Agent.slave()
+		ev.Slave = &s
+	case v.AgentRemoved != nil:
+		ev.SlaveID = v.AgentRemoved.AgentID.Value
+	}
+	return ev, nil
+}
+
+// UnmarshalV1 unmarshals the given response to a GET_STATE call of the Mesos
+// v1 operator API into the given State. Such responses don't tell the leader,
+// which is left unset. Tasks of frameworks the response has no framework of
+// are taken as orphan tasks, like those it lists as such. Anything but such a
+// response, e.g. the state.json of masters not supporting the v1 API, is
+// unmarshaled as is.
+func UnmarshalV1(data []byte, s *State) error {
+	var resp v1Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return err
+	}
+	switch {
+	case resp.Type == "":
+		return json.Unmarshal(data, s)
+	case resp.Type != "GET_STATE":
+		return fmt.Errorf("unexpected v1 response type %q", resp.Type)
+	case resp.GetState == nil:
+		return errors.New("GET_STATE response without state")
+	}
+	*s = resp.GetState.state()
+	return nil
+}
+
+// state maps the v1 state to a State.
+func (v *v1State) state() State {
+	var s State
+	frameworks := make(map[string]int, len(v.GetFrameworks.Frameworks))
+	for i := range v.GetFrameworks.Frameworks {
+		f := v.GetFrameworks.Frameworks[i].framework()
+		frameworks[f.ID] = len(s.Frameworks)
+		s.Frameworks = append(s.Frameworks, f)
+	}
+	for _, tasks := range [][]v1Task{v.GetTasks.PendingTasks, v.GetTasks.Tasks} {
+		for i := range tasks {
+			t := tasks[i].task()
+			if j, ok := frameworks[t.FrameworkID]; ok {
+				s.Frameworks[j].Tasks = append(s.Frameworks[j].Tasks, t)
+			} else {
+				s.OrphanTasks = append(s.OrphanTasks, t)
+			}
+		}
+	}
+	for i := range v.GetTasks.OrphanTasks {
+		s.OrphanTasks = append(s.OrphanTasks, v.GetTasks.OrphanTasks[i].task())
+	}
+	for i := range v.GetAgents.Agents {
+		s.Slaves = append(s.Slaves, v.GetAgents.Agents[i].slave())
+	}
+	return s
+}
+
+// framework maps the v1 framework to a Framework, without tasks.
+func (f *v1Framework) framework() Framework {
+	return Framework{
+		ID:             f.FrameworkInfo.ID.Value,
+		Name:           f.FrameworkInfo.Name,
+		Hostname:       f.FrameworkInfo.Hostname,
+		RegisteredTime: seconds(f.RegisteredTime.Nanoseconds),
+	}
+}
+
+// slave maps the v1 agent to a Slave.
+func (a *v1Agent) slave() Slave {
+	s := Slave{
+		ID:       a.AgentInfo.ID.Value,
+		Hostname: a.AgentInfo.Hostname,
+		PID:      a.PID,
+	}
+	for _, attr := range a.AgentInfo.Attributes {
+		if s.Attributes == nil {
+			s.Attributes = Attributes{}
+		}
+		switch attr.Type {
+		case "TEXT":
+			s.Attributes[attr.Name] = attr.Text.Value
+		case "SCALAR":
+			s.Attributes[attr.Name] = strconv.FormatFloat(attr.Scalar.Value, 'f', -1, 64)
+		}
+	}
+	return s
+}
+
+// seconds returns the given number of nanoseconds in seconds, converting
+// whole seconds apart so that their fractions keep as much precision as
+// float64 allows.
+func seconds(ns int64) float64 {
+	return float64(ns/1e9) + float64(ns%1e9)/1e9
+}
+
+// task maps the v1 task to a Task.
+func (t *v1Task) task() Task {
+	task := Task{
+		FrameworkID:   t.FrameworkID.Value,
+		ID:            t.TaskID.Value,
+		Name:          t.Name,
+		SlaveID:       t.AgentID.Value,
+		State:         t.State,
+		Resources:     Resources{PortRanges: portRanges(t.Resources)},
+		DiscoveryInfo: t.Discovery,
+		Labels:        t.Labels.Labels,
+		HealthCheck:   t.HealthCheck,
+		ExecutorID:    t.ExecutorID.Value,
+	}
+	for i := range t.Statuses {
+		task.Statuses = append(task.Statuses, t.Statuses[i].status())
+	}
+	return task
+}
+
+// status maps the v1 task status to a Status.
+func (st *v1Status) status() Status {
+	return Status{
+		Timestamp:       st.Timestamp,
+		State:           st.State,
+		Labels:          st.Labels.Labels,
+		ContainerStatus: st.ContainerStatus,
+	}
+}
+
+// portRanges returns the ranges of the ports resource among the given ones in
+// the format of the /state.json Mesos HTTP endpoint, e.g. "[31000-31001]", or
+// "" without one.
+func portRanges(resources []v1Resource) string {
+	for _, r := range resources {
+		if r.Name != "ports" {
+			continue
+		}
+		ranges := make([]string, len(r.Ranges.Range))
+		for i, rng := range r.Ranges.Range {
+			ranges[i] = fmt.Sprintf("%d-%d", rng.Begin, rng.End)
+		}
+		return "[" + strings.Join(ranges, ", ") + "]"
+	}
+	return ""
+}
@@ -0,0 +1,342 @@
+package records
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SkipReason describes why no records were generated for a task.
+type SkipReason string
+
+const (
+	// SkipNotRunning is used for tasks which aren't in the TASK_RUNNING state.
+	SkipNotRunning SkipReason = "not_running"
+	// SkipMissingSlaveIP is used for tasks whose slave is unknown.
+	SkipMissingSlaveIP SkipReason = "missing_slave_ip"
+	// SkipMalformed is used for tasks lacking an id or a slave id.
+	SkipMalformed SkipReason = "malformed"
+	// SkipFiltered is used for tasks excluded by configuration.
+	SkipFiltered SkipReason = "filtered"
+	// SkipInvalidIP is used for tasks without any usable IP address.
+	SkipInvalidIP SkipReason = "invalid_ip"
+	// SkipVisibility is used for tasks whose discovery info hides them.
+	SkipVisibility SkipReason = "visibility"
+	// SkipRecordCap is used for tasks whose records were cut off by the
+	// record cap.
+	SkipRecordCap SkipReason = "record_cap"
+)
+
+// MnameCheck is the outcome of the SOA mname consistency check.
+type MnameCheck string
+
+const (
+	// MnameResolves is used when the SOA mname has an address record.
+	MnameResolves MnameCheck = "resolves"
+	// MnameSynthesized is used when an address record was synthesized for
+	// the SOA mname.
+	MnameSynthesized MnameCheck = "synthesized"
+	// MnameMissing is used when the SOA mname has no address record.
+	MnameMissing MnameCheck = "missing"
+)
+
+// Event is a defensive behavior triggered during record generation.
+type Event string
+
+const (
+	// EventCollision is used for record names claimed by more than one
+	// framework.
+	EventCollision Event = "collision"
+	// EventTruncation is used for names cut short to fit in a label.
+	EventTruncation Event = "truncation"
+	// EventHashCollision is used for task IDs hashing to the canonical name
+	// of another task.
+	EventHashCollision Event = "hash_collision"
+	// EventInvalidIP is used for addresses that aren't valid IPs, either
+	// skipped or published as is.
+	EventInvalidIP Event = "invalid_ip"
+	// EventInvalidName is used for rejected, structurally invalid, record
+	// names.
+	EventInvalidName Event = "invalid_name"
+	// EventSanitationFallback is used when a fallback value replaces one
+	// that couldn't be determined, e.g. a slave hostname used in lieu of its
+	// IP or the loopback address in lieu of the local ones.
+	EventSanitationFallback Event = "sanitation_fallback"
+	// EventMalformed is used for slaves and tasks dropped for missing
+	// identifying fields.
+	EventMalformed Event = "malformed"
+	// EventUnroutable is used for slaves skipped for advertising only
+	// unspecified or loopback addresses.
+	EventUnroutable Event = "unroutable"
+	// EventDuplicatePort is used for tasks listing distinct discovery ports
+	// under the same name and protocol.
+	EventDuplicatePort Event = "duplicate_port"
+)
+
+// Classes of generation errors, as reported in GenerationError.Class. Failed
+// state fetches are classified by their client.FailureClass, if known.
+const (
+	// ErrorClassFetch is used for failed state fetches of unknown class.
+	ErrorClassFetch = "fetch"
+	// ErrorClassLeader is used for states lacking a leader.
+	ErrorClassLeader = "leader"
+	// ErrorClassGeneration is used for failures to generate records from
+	// a fetched state.
+	ErrorClassGeneration = "generation"
+)
+
+// GenerationError describes a failed record generation.
+type GenerationError struct {
+	// Time is when the generation failed
+	Time time.Time `json:"time"`
+	// Message is the error message
+	Message string `json:"message"`
+	// Class is the class of the error
+	Class string `json:"class"`
+	// Master is the address of the master whose state fetch failed, if any
+	Master string `json:"master,omitempty"`
+}
+
+// Generation passes, as reported in GenerationStats.Durations.
+const (
+	passFetch      = "fetch"
+	passDecode     = "decode"
+	passNormalize  = "normalize"
+	passFrameworks = "frameworks"
+	passSlaves     = "slaves"
+	passListener   = "listener"
+	passMasters    = "masters"
+	passTasks      = "tasks"
+	passSnapshot   = "snapshot"
+)
+
+// Record sources, as reported in GenerationStats.Sources.
+const (
+	// SourceFramework is used for framework scheduler records.
+	SourceFramework = "framework"
+	// SourceSlave is used for slave records.
+	SourceSlave = "slave"
+	// SourceMaster is used for leader and master records.
+	SourceMaster = "master"
+	// SourceListener is used for the records of Mesos-DNS itself.
+	SourceListener = "listener"
+	// SourceTask is used for task records.
+	SourceTask = "task"
+	// SourceStatic is used for statically configured records.
+	SourceStatic = "static"
+)
+
+// passSources maps each generation pass to the source of its records.
+var passSources = map[string]string{
+	passFrameworks: SourceFramework,
+	passSlaves:     SourceSlave,
+	passListener:   SourceListener,
+	passMasters:    SourceMaster,
+	passTasks:      SourceTask,
+}
+
+// GenerationStats summarizes a single record generation.
+type GenerationStats struct {
+	// Records is the number of records inserted per kind
+	Records map[string]int `json:"records"`
+	// Sources is the number of records inserted per source
+	Sources map[string]int `json:"sources"`
+	// Frameworks is the number of frameworks processed
+	Frameworks int `json:"frameworks"`
+	// FrameworksWithoutHost is the number of frameworks without a scheduler
+	// host, which got no records
+	FrameworksWithoutHost int `json:"frameworks_without_host"`
+	// FrameworksWithoutPort is the number of frameworks without a scheduler
+	// port, which got no SRV record
+	FrameworksWithoutPort int `json:"frameworks_without_port"`
+	// Tasks is the number of tasks processed, including skipped ones
+	Tasks int `json:"tasks"`
+	// Skipped is the number of tasks without records, per reason
+	Skipped map[SkipReason]int `json:"skipped"`
+	// ResolutionFailures is the number of hostnames that could not be
+	// translated into IP addresses
+	ResolutionFailures int `json:"resolution_failures"`
+	// Mname is the outcome of the SOA mname consistency check
+	Mname MnameCheck `json:"mname"`
+	// Capped is set if the record cap was reached, cutting off task records
+	Capped bool `json:"capped"`
+	// CutOff is the number of tasks whose records were cut off, entirely or
+	// partially, by the record cap, per framework name
+	CutOff map[string]int `json:"cut_off,omitempty"`
+	// Filtered is the number of tasks of the frameworks filtered out by the
+	// framework whitelist or blacklist, per framework name
+	Filtered map[string]int `json:"filtered,omitempty"`
+	// Events is the number of defensive behaviors triggered, per event and
+	// per source
+	Events map[Event]map[string]int `json:"events"`
+	// Durations holds the wall-clock duration of each generation pass,
+	// including fetching and decoding the state
+	Durations map[string]time.Duration `json:"durations"`
+	// Duration is the wall-clock duration of the whole generation
+	Duration time.Duration `json:"duration"`
+
+	// source is the source of the records being inserted
+	source string
+}
+
+// clock tells the time generation passes are measured with.
+type clock interface {
+	Now() time.Time
+}
+
+// wallClock is a clock telling the wall-clock time.
+type wallClock struct{}
+
+// Now implements the clock interface.
+func (wallClock) Now() time.Time { return time.Now() }
+
+func newGenerationStats() GenerationStats {
+	return GenerationStats{
+		Records: map[string]int{},
+		Sources: map[string]int{
+			SourceFramework: 0,
+			SourceSlave:     0,
+			SourceMaster:    0,
+			SourceListener:  0,
+			SourceTask:      0,
+			SourceStatic:    0,
+		},
+		Skipped:   map[SkipReason]int{},
+		Events:    map[Event]map[string]int{},
+		Durations: map[string]time.Duration{},
+	}
+}
+
+// skip accounts for a task skipped for the given reason.
+func (s *GenerationStats) skip(reason SkipReason) {
+	if s.Skipped == nil {
+		s.Skipped = map[SkipReason]int{}
+	}
+	s.Skipped[reason]++
+}
+
+// inserted accounts for a record of the given kind, attributed to the
+// current source.
+func (s *GenerationStats) inserted(kind rrsKind) {
+	if s.Records == nil {
+		s.Records = map[string]int{}
+	}
+	s.Records[string(kind)]++
+	if s.source != "" {
+		if s.Sources == nil {
+			s.Sources = map[string]int{}
+		}
+		s.Sources[s.source]++
+	}
+}
+
+// removed accounts for the removal of a record of the given kind, attributed
+// to the current source.
+func (s *GenerationStats) removed(kind rrsKind) {
+	s.Records[string(kind)]--
+	if s.source != "" {
+		s.Sources[s.source]--
+	}
+}
+
+// event accounts for the given event, attributed to the current source.
+func (s *GenerationStats) event(e Event) {
+	s.eventFrom(e, s.source)
+}
+
+// eventFrom accounts for the given event, attributed to the given source.
+func (s *GenerationStats) eventFrom(e Event, source string) {
+	if s.Events == nil {
+		s.Events = map[Event]map[string]int{}
+	}
+	if s.Events[e] == nil {
+		s.Events[e] = map[string]int{}
+	}
+	s.Events[e][source]++
+}
+
+// attributed runs f, attributing the records it inserts to the given source.
+func (s *GenerationStats) attributed(source string, f func()) {
+	prev := s.source
+	s.source = source
+	f()
+	s.source = prev
+}
+
+// cutOff accounts for a task of the named framework whose records were cut
+// off by the record cap.
+func (s *GenerationStats) cutOff(framework string) {
+	if s.CutOff == nil {
+		s.CutOff = map[string]int{}
+	}
+	s.CutOff[framework]++
+}
+
+// filtered accounts for the given number of tasks of the named framework,
+// filtered out by the framework whitelist or blacklist, along with the
+// framework itself.
+func (s *GenerationStats) filtered(framework string, tasks int) {
+	if s.Filtered == nil {
+		s.Filtered = map[string]int{}
+	}
+	s.Filtered[framework] += tasks
+}
+
+// observe accounts for the given duration of a generation pass.
+func (s *GenerationStats) observe(pass string, d time.Duration) {
+	if s.Durations == nil {
+		s.Durations = map[string]time.Duration{}
+	}
+	s.Durations[pass] += d
+}
+
+// Phases returns a one-line summary of the durations of the generation passes.
+func (s GenerationStats) Phases() string {
+	parts := make([]string, 0, len(s.Durations))
+	for pass, d := range s.Durations {
+		parts = append(parts, fmt.Sprintf("%s=%s", pass, d))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// TotalRecords returns the number of records inserted across all kinds.
+func (s GenerationStats) TotalRecords() (n int) {
+	for _, c := range s.Records {
+		n += c
+	}
+	return
+}
+
+// TotalSkipped returns the number of tasks skipped across all reasons.
+func (s GenerationStats) TotalSkipped() (n int) {
+	for _, c := range s.Skipped {
+		n += c
+	}
+	return
+}
+
+// String returns a one-line summary of the stats.
+func (s GenerationStats) String() string {
+	return fmt.Sprintf("records=%d (%s) (%s) frameworks=%d tasks=%d skipped=%d (%s) resolution_failures=%d duration=%s",
+		s.TotalRecords(), joinCounts(s.Records), joinCounts(s.Sources), s.Frameworks, s.Tasks,
+		s.TotalSkipped(), joinSkipped(s.Skipped), s.ResolutionFailures, s.Duration)
+}
+
+func joinCounts(m map[string]int) string {
+	parts := make([]string, 0, len(m))
+	for k, v := range m {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, v))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+func joinSkipped(m map[SkipReason]int) string {
+	counts := make(map[string]int, len(m))
+	for k, v := range m {
+		counts[string(k)] = v
+	}
+	return joinCounts(counts)
+}
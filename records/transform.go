@@ -0,0 +1,58 @@
+package records
+
+import (
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// Record is a record about to be inserted, as handed over to the record
+// transforms.
+type Record struct {
+	// Name is the fully qualified name of the record, lowercase and with its
+	// trailing dot.
+	Name string
+	// Type is the type of the record, A, AAAA, SRV, PTR or TXT, which
+	// transforms can't change.
+	Type string
+	// Host is the IP address of A and AAAA records, the target host and
+	// port, as host:port, of SRV records, the target name of PTR records and
+	// the character strings of TXT records, in zone file presentation
+	// format.
+	Host string
+}
+
+// RecordTransform rewrites a record before it's inserted, returning false to
+// drop it instead.
+type RecordTransform func(Record) (Record, bool)
+
+// WithRecordTransform returns an Option running every record generated
+// through the given transform before it's inserted, e.g. to rewrite a domain
+// suffix during a migration or drop a record family. Transforms are applied
+// in the order they were added in, each to the record returned by the
+// previous one, until one drops it. The records returned are normalized and
+// validated like generated ones, so that transforms can't insert invalid
+// names.
+func WithRecordTransform(transform RecordTransform) Option {
+	return func(rg *RecordGenerator) {
+		if transform != nil {
+			rg.transforms = append(rg.transforms, transform)
+		}
+	}
+}
+
+// transformRecord applies the record transforms to the given normalized
+// record, returning it normalized anew, or false if dropped.
+func (rg *RecordGenerator) transformRecord(name, host string, kind rrsKind) (string, string, rrsKind, bool) {
+	rec := Record{Name: name, Type: string(kind), Host: host}
+	for _, transform := range rg.transforms {
+		var ok bool
+		if rec, ok = transform(rec); !ok {
+			logging.CurLog.DroppedRecords.Inc()
+			return "", "", kind, false
+		}
+	}
+	if len(rg.transforms) == 0 {
+		return name, host, kind, true
+	}
+	name, host, kind = normalizeRecord(rec.Name, rec.Host, kind)
+	return name, host, kind, true
+}
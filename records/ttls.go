@@ -0,0 +1,323 @@
+package records
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// TTLOverride sets the TTL of the records whose names match a pattern, e.g.
+// a short one for leader.mesos so that master failovers propagate fast.
+type TTLOverride struct {
+	// Regexp is a regular expression, anchored at both ends, matching the
+	// fully qualified names of the records, lowercase and less their
+	// trailing dot.
+	Regexp string
+	// Glob is a pattern, as per path.Match, matching the names of the
+	// records instead, used if Regexp is empty.
+	Glob string
+	// TTL is the TTL of the records matched, in seconds.
+	TTL int32
+
+	re *regexp.Regexp
+}
+
+// pattern returns the pattern of the override, as configured.
+func (o *TTLOverride) pattern() string {
+	if o.Regexp != "" {
+		return o.Regexp
+	}
+	return o.Glob
+}
+
+// matches tells whether the given record name, less its trailing dot, is
+// matched.
+func (o *TTLOverride) matches(name string) bool {
+	switch {
+	case o.re != nil:
+		return o.re.MatchString(name)
+	case o.Regexp != "":
+		ok, err := regexp.MatchString(anchored(o.Regexp), name)
+		return ok && err == nil
+	default:
+		ok, err := path.Match(o.Glob, name)
+		return ok && err == nil
+	}
+}
+
+// anchored returns the given regular expression anchored at both ends.
+func anchored(re string) string {
+	return "^(?:" + re + ")$"
+}
+
+// initTTLOverrides compiles the regular expressions of TTLOverrides, which
+// Validate checked.
+func (c *Config) initTTLOverrides() {
+	for i := range c.TTLOverrides {
+		if o := &c.TTLOverrides[i]; o.Regexp != "" {
+			o.re, _ = regexp.Compile(anchored(o.Regexp))
+		}
+	}
+}
+
+// validateTTLOverrides checks that each TTL override has either a valid
+// regular expression or a valid glob, and a TTL that isn't negative.
+func validateTTLOverrides(overrides []TTLOverride) error {
+	for i, o := range overrides {
+		if (o.Regexp == "") == (o.Glob == "") {
+			return fmt.Errorf("#%d: specify either Regexp or Glob", i)
+		}
+		if o.Regexp != "" {
+			if _, err := regexp.Compile(anchored(o.Regexp)); err != nil {
+				return fmt.Errorf("#%d: %v", i, err)
+			}
+		} else if _, err := path.Match(o.Glob, ""); err != nil {
+			return fmt.Errorf("#%d: %v", i, err)
+		}
+		if err := validateAtLeast(int(o.TTL), 0); err != nil {
+			return fmt.Errorf("#%d: TTL %v", i, err)
+		}
+	}
+	return nil
+}
+
+// TTLLabel is the key of the DiscoveryInfo label of tasks setting the TTL of
+// their records, in seconds, e.g. a short one for canary deployments.
+const TTLLabel = "mesos-dns.ttl"
+
+// maxLabelTTL is the longest TTL TTLLabel may set.
+const maxLabelTTL = 86400
+
+// invalidTTLLog rate limits the logging of invalid TTL labels.
+var invalidTTLLog = logging.NewLimiter(10 * time.Minute)
+
+// labelTTL returns the TTL of the records of the given task set by its
+// TTLLabel, if any. Values which aren't integers between 0 and maxLabelTTL
+// are ignored with a warning, leaving the task with the usual TTLs.
+func labelTTL(task *state.Task) (uint32, bool) {
+	for _, l := range task.DiscoveryInfo.Labels.Labels {
+		if l.Key != TTLLabel {
+			continue
+		}
+		ttl, err := strconv.Atoi(strings.TrimSpace(l.Value))
+		if err != nil || ttl < 0 || ttl > maxLabelTTL {
+			if invalidTTLLog.Allow(task.ID) {
+				logging.Error.Printf("warning: ignoring label %s=%q of task %q: not a TTL between 0 and %d seconds",
+					l.Key, l.Value, task.ID, maxLabelTTL)
+			}
+			return 0, false
+		}
+		return uint32(ttl), true
+	}
+	return 0, false
+}
+
+// unmatchedTTLLog rate limits the logging of TTL overrides matching no
+// record.
+var unmatchedTTLLog = logging.NewLimiter(10 * time.Minute)
+
+// setNameTTLs sets the TTLs of the records, by name and kind, which don't get
+// the global TTL: that of the first TTL override matching their name, if any,
+// or else that of the TTL labels of the tasks generating them or of the
+// domain of the first framework domain they're under setting one, lowered as
+// per the health checks of the tasks generating them with health check TTLs.
+// TTL overrides matching no record are logged.
+func (rg *RecordGenerator) setNameTTLs() {
+	labeled := rg.labelTTLs()
+	if !rg.customTTLs() && len(labeled) == 0 {
+		return
+	}
+	intervals := rg.healthIntervals()
+	matched := make([]bool, len(rg.ttlOverrides))
+	for _, kind := range []rrsKind{A, AAAA, SRV, PTR, TXT} {
+		kind.rrs(rg).each(func(name string, set *rrset) {
+			ttl, i, ok := rg.nameTTL(claimKey{name, kind}, intervals, labeled)
+			set.ttl, set.hasTTL = ttl, ok
+			if ok && i >= 0 {
+				matched[i] = true
+			}
+		})
+	}
+	for i, ok := range matched {
+		if p := rg.ttlOverrides[i].pattern(); !ok && unmatchedTTLLog.Allow(p) {
+			logging.Error.Printf("warning: TTL override #%d %q matches no record", i, p)
+		}
+	}
+}
+
+// customTTLs tells whether any record name may not get the global TTL.
+func (rg *RecordGenerator) customTTLs() bool {
+	return len(rg.ttlOverrides) > 0 || rg.domainTTLs() || rg.healthCheckTTLs
+}
+
+// domainTTLs tells whether any framework domain sets a TTL.
+func (rg *RecordGenerator) domainTTLs() bool {
+	for _, fd := range rg.frameworkDomains {
+		if fd.TTL > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// nameTTL returns the TTL of the records of the given name and kind, if they
+// don't get the global one, along with the index of the TTL override setting
+// it, or -1 if set by the given TTL labels of the tasks generating them, see
+// labelTTLs, or by a framework domain, or lowered as per the given shortest
+// health check intervals of the tasks generating them, see healthIntervals.
+// TTL overrides and framework domains match names, whatever the kind.
+func (rg *RecordGenerator) nameTTL(key claimKey, intervals map[claimKey]float64, labeled map[claimKey]uint32) (ttl uint32, override int, ok bool) {
+	fqdn := strings.TrimSuffix(key.name, ".")
+	for i := range rg.ttlOverrides {
+		if rg.ttlOverrides[i].matches(fqdn) {
+			return uint32(rg.ttlOverrides[i].TTL), i, true
+		}
+	}
+	ttl = rg.defaultTTL
+	if labelTTL, labeled := labeled[key]; labeled {
+		ttl, ok = labelTTL, true
+	} else {
+		for _, fd := range rg.frameworkDomains {
+			if fd.TTL > 0 && (fqdn == fd.Domain || strings.HasSuffix(fqdn, "."+fd.Domain)) {
+				ttl, ok = uint32(fd.TTL), true
+				break
+			}
+		}
+	}
+	if interval, checked := intervals[key]; checked {
+		if lowered := rg.healthTTL(interval, ttl); lowered < ttl {
+			return lowered, -1, true
+		}
+	}
+	return ttl, -1, ok
+}
+
+const (
+	// defaultHealthTTLFactor and defaultHealthTTLFloor are the defaults of
+	// HealthCheckTTLFactor and HealthCheckTTLFloor.
+	defaultHealthTTLFactor = 1
+	defaultHealthTTLFloor  = 5
+)
+
+// healthIntervals returns the shortest interval between the health checks,
+// in seconds, of the tasks listing the A, AAAA and SRV records of each name,
+// by name and kind, of those with health checks, or nil without health check
+// TTLs.
+func (rg *RecordGenerator) healthIntervals() map[claimKey]float64 {
+	if !rg.healthCheckTTLs {
+		return nil
+	}
+	intervals := map[claimKey]float64{}
+	for _, f := range rg.EnumData.Frameworks {
+		for _, t := range f.Tasks {
+			t.noteInterval(intervals, "")
+		}
+	}
+	return intervals
+}
+
+// noteInterval notes in the given intervals the health check interval of t
+// as that of its A, AAAA and SRV records, of the given name only unless
+// empty, where shorter than the one noted.
+func (t *EnumerableTask) noteInterval(intervals map[claimKey]float64, name string) {
+	if t.healthInterval <= 0 {
+		return
+	}
+	for _, rec := range t.Records {
+		kind := rrsKind(rec.Rtype)
+		if (name != "" && rec.Name != name) || (kind != A && kind != AAAA && kind != SRV) {
+			continue
+		}
+		key := claimKey{rec.Name, kind}
+		if i, ok := intervals[key]; !ok || t.healthInterval < i {
+			intervals[key] = t.healthInterval
+		}
+	}
+}
+
+// labelTTLs returns the shortest TTL set by the TTL labels of the tasks
+// listing the records of each name, by name and kind, of those with one.
+func (rg *RecordGenerator) labelTTLs() map[claimKey]uint32 {
+	ttls := map[claimKey]uint32{}
+	for _, f := range rg.EnumData.Frameworks {
+		for _, t := range f.Tasks {
+			t.noteTTL(ttls, "")
+		}
+	}
+	return ttls
+}
+
+// noteTTL notes in the given TTLs the TTL label of t as that of its records,
+// of the given name only unless empty, where shorter than the one noted.
+func (t *EnumerableTask) noteTTL(ttls map[claimKey]uint32, name string) {
+	if !t.hasTTL {
+		return
+	}
+	for _, rec := range t.Records {
+		if name != "" && rec.Name != name {
+			continue
+		}
+		key := claimKey{rec.Name, rrsKind(rec.Rtype)}
+		if ttl, ok := ttls[key]; !ok || t.ttl < ttl {
+			ttls[key] = t.ttl
+		}
+	}
+}
+
+// healthTTL returns the given TTL of the records of a task health checked at
+// the given interval, in seconds, lowered to the interval times the health
+// check TTL factor, but no lower than the floor.
+func (rg *RecordGenerator) healthTTL(interval float64, ttl uint32) uint32 {
+	factor, floor := rg.healthTTLFactor, rg.healthTTLFloor
+	if factor == 0 {
+		factor = defaultHealthTTLFactor
+	}
+	if floor == 0 {
+		floor = defaultHealthTTLFloor
+	}
+	if lowered := math.Max(interval*factor, float64(floor)); lowered < float64(ttl) {
+		return uint32(lowered)
+	}
+	return ttl
+}
+
+// TTL returns the TTL of the given record, as returned by Lookup, if it
+// doesn't get the global one, as set by the TTL overrides, TTL labels,
+// framework domains and health checks when the records were generated, those
+// of the Snapshot of rg, if any, like Lookup. Records of the same name but of
+// different kinds may get different TTLs.
+func (rg *RecordGenerator) TTL(rec Record) (uint32, bool) {
+	if set := rrsKind(rec.Type).rrs(rg.view()).set(rec.Name); set != nil {
+		return set.ttl, set.hasTTL
+	}
+	return 0, false
+}
+
+// recordTTL returns the TTL of the records of the given name and kind: the
+// one set by the TTL overrides, TTL labels, framework domains and health
+// checks, if any, or else the global one.
+func (rg *RecordGenerator) recordTTL(name string, kind rrsKind) uint32 {
+	if set := kind.rrs(rg).set(name); set != nil && set.hasTTL {
+		return set.ttl
+	}
+	return rg.defaultTTL
+}
+
+// setRecordTTLs sets the TTLs of the enumerated records of the given
+// frameworks.
+func (rg *RecordGenerator) setRecordTTLs(frameworks []*EnumerableFramework) {
+	for _, f := range frameworks {
+		for _, t := range f.Tasks {
+			for i := range t.Records {
+				t.Records[i].TTL = rg.recordTTL(t.Records[i].Name, rrsKind(t.Records[i].Rtype))
+			}
+		}
+	}
+}
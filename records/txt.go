@@ -0,0 +1,69 @@
+package records
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// maxTXTString is the maximum length, in octets, of a character string of a
+// TXT record.
+const maxTXTString = 255
+
+// taskTXT returns the rdata of the TXT record of the given task of the given
+// framework: key=value character strings of its ID, slave ID, framework and
+// state, followed by those of its DiscoveryInfo labels, in zone file
+// presentation format, e.g. "task-id=web.1" "slave-id=s1".
+func taskTXT(task state.Task, f state.Framework) string {
+	strs := []string{
+		"task-id=" + task.ID,
+		"slave-id=" + task.SlaveID,
+		"framework=" + f.Name,
+		"state=" + task.State,
+	}
+	for _, l := range task.DiscoveryInfo.Labels.Labels {
+		strs = append(strs, l.Key+"="+l.Value)
+	}
+	quoted := make([]string, len(strs))
+	for i, s := range strs {
+		quoted[i] = quoteTXT(truncateTXT(s))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// truncateTXT truncates the given string to the length limit of the character
+// strings of TXT records, without splitting a UTF-8 encoded character.
+func truncateTXT(s string) string {
+	if len(s) <= maxTXTString {
+		return s
+	}
+	// cut before the character the first octet dropped belongs to
+	i := maxTXTString
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return s[:i]
+}
+
+// quoteTXT returns the given character string in zone file presentation
+// format: double quoted, with double quotes and backslashes escaped with a
+// backslash and other non printable octets as \DDD.
+func quoteTXT(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -3,23 +3,18 @@ package records
 import (
 	"fmt"
 	"net"
+	"net/url"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
 )
 
 var dnsValidationRegex = regexp.MustCompile(`^[a-zA-Z0-9-]+(\.[a-zA-Z0-9-]+)*$`)
 
-func validateEnabledServices(c *Config) error {
-	if !c.DNSOn && !c.HTTPOn {
-		return fmt.Errorf("Either DNS or HTTP server should be on")
-	}
-	if len(c.Masters) == 0 && c.Zk == "" {
-		return fmt.Errorf("Specify Mesos masters or Zookeeper in config.json")
-	}
-	return nil
-}
-
 // validateMasters checks that each master in the list is a properly formatted host:port or IP:port pair.
 // duplicate masters in the list are not allowed.
 // returns nil if the masters list is empty, or else all masters in the list are valid.
@@ -134,7 +129,8 @@ func validateUniqueStrings(strings []string, normalize func(string) (string, err
 	return nil
 }
 
-// validateIPSources checks validity of ip sources
+// validateIPSources checks validity of ip sources against the ones known by
+// the state package.
 func validateIPSources(srcs []string) error {
 	if len(srcs) == 0 {
 		return fmt.Errorf("empty ip sources")
@@ -142,14 +138,171 @@ func validateIPSources(srcs []string) error {
 	if len(srcs) != len(unique(srcs)) {
 		return fmt.Errorf("duplicate ip source specified")
 	}
+	known := state.IPSources()
 	for _, src := range srcs {
-		switch src {
-		case "host", "docker", "mesos", "netinfo":
+		if contains(known, src) || state.IsIPSource(src) {
+			continue
+		}
+		if strings.Contains(src, ",") {
+			return fmt.Errorf("invalid ip source %q: list each source as a separate string", src)
+		}
+		return fmt.Errorf("invalid ip source %q, want one of %s", src, strings.Join(known, ", "))
+	}
+	return nil
+}
+
+// validateCIDRs checks that the given networks are in CIDR notation.
+func validateCIDRs(cidrs []string) error {
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCIDRs returns the given networks, which validateCIDRs checked.
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHostName checks that each label of the given name is valid as per
+// the given label spec, suggesting the valid form of the first label which
+// isn't.
+func validateHostName(name string, spec labels.Func) error {
+	if err := validateRecordName(name + "."); err != nil {
+		return err
+	}
+	for _, label := range strings.Split(name, ".") {
+		switch valid := spec(label); {
+		case valid == strings.ToLower(label):
+		case valid == "":
+			return fmt.Errorf("invalid label %q", label)
 		default:
-			return fmt.Errorf("invalid ip source %q", src)
+			return fmt.Errorf("invalid label %q, %q would be valid", label, valid)
 		}
 	}
+	return nil
+}
+
+// validateListener checks that the given listener address is an IP address.
+func validateListener(addr string) error {
+	if net.ParseIP(addr) == nil {
+		return fmt.Errorf("%q is not an IP address", addr)
+	}
+	return nil
+}
+
+// validatePort checks that the given port is between 1 and 65535.
+func validatePort(port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("%d is not a port between 1 and 65535", port)
+	}
+	return nil
+}
 
+// validateAtLeast checks that the given setting is at least min.
+func validateAtLeast(v, min int) error {
+	if v < min {
+		return fmt.Errorf("%d is less than %d", v, min)
+	}
+	return nil
+}
+
+// validateFile checks that the given file exists and is a regular file.
+func validateFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	return nil
+}
+
+// validateSearchSuffixes checks that the given search suffixes are valid
+// domain names, as per RFC 1123 since they aren't of Mesos-DNS records.
+func validateSearchSuffixes(suffixes []string) error {
+	for _, suffix := range suffixes {
+		if err := validateHostName(strings.Trim(suffix, "."), labels.RFC1123); err != nil {
+			return fmt.Errorf("%q: %v", suffix, err)
+		}
+	}
+	return nil
+}
+
+// validatePortProtocols checks that the given default port protocols only
+// list tcp and/or udp, once each.
+func validatePortProtocols(protocols []string) error {
+	seen := map[string]bool{}
+	for _, protocol := range protocols {
+		if protocol != "tcp" && protocol != "udp" {
+			return fmt.Errorf("unknown protocol %q: list tcp and/or udp", protocol)
+		}
+		if seen[protocol] {
+			return fmt.Errorf("protocol %q listed twice", protocol)
+		}
+		seen[protocol] = true
+	}
+	return nil
+}
+
+// validateStateFetchStrategy checks that the given state fetch strategy is
+// known.
+func validateStateFetchStrategy(strategy string) error {
+	switch strategy {
+	case "", StateFetchSequential, StateFetchConcurrent:
+		return nil
+	default:
+		return fmt.Errorf("unknown strategy %q: use %q or %q", strategy, StateFetchSequential, StateFetchConcurrent)
+	}
+}
+
+// validateAnswerOrder checks that the given answer order is known.
+func validateAnswerOrder(order string) error {
+	switch order {
+	case "", AnswerOrderRandom, AnswerOrderRotate, AnswerOrderSorted:
+		return nil
+	default:
+		return fmt.Errorf("unknown order %q: use %q, %q or %q", order, AnswerOrderRandom, AnswerOrderRotate, AnswerOrderSorted)
+	}
+}
+
+// validateMesosAPIVersion checks that the given Mesos API version is known.
+func validateMesosAPIVersion(version string) error {
+	switch version {
+	case "", MesosAPIv0, MesosAPIv1:
+		return nil
+	default:
+		return fmt.Errorf("unknown version %q: use %q or %q", version, MesosAPIv0, MesosAPIv1)
+	}
+}
+
+// validateHTTPURL checks that the given URL is an absolute HTTP or HTTPS one.
+func validateHTTPURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute HTTP or HTTPS URL", rawurl)
+	}
 	return nil
 }
 
@@ -159,3 +312,76 @@ func validPortString(portString string) bool {
 	port, err := strconv.Atoi(portString)
 	return err == nil && port > 0 && port <= 65535
 }
+
+const (
+	// maxNameLen is the maximum length, in octets, of a record name sans the
+	// trailing dot. See https://tools.ietf.org/html/rfc1035#section-2.3.4
+	maxNameLen = 253
+	// maxLabelLen is the maximum length, in octets, of a single label.
+	maxLabelLen = 63
+)
+
+// validateRecordName checks the structure of a generated record name: it may
+// not contain empty labels, no label may exceed 63 octets and the name itself
+// may not exceed 253 octets. A single trailing dot is permitted.
+func validateRecordName(name string) error {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	if len(name) > maxNameLen {
+		return fmt.Errorf("name is %d octets long, exceeds %d", len(name), maxNameLen)
+	}
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i < len(name) && name[i] != '.' {
+			continue
+		}
+		switch n := i - start; {
+		case n == 0:
+			return fmt.Errorf("empty label at offset %d", start)
+		case n > maxLabelLen:
+			return fmt.Errorf("label %q is %d octets long, exceeds %d", name[start:i], n, maxLabelLen)
+		}
+		start = i + 1
+	}
+	return nil
+}
+
+// normalizeRecord canonicalizes a generated record so that formatting variants
+// of the same record are stored once: the name is lowercased and given a
+// trailing dot, IP rdata is formatted by net.IP.String (and the kind adjusted
+// to match, e.g. for IPv4-mapped IPv6 addresses), the target of SRV rdata is
+// canonicalized like the rdata of A records, if an IP, or like the name
+// otherwise, and the target of PTR rdata like the name.
+func normalizeRecord(name, host string, kind rrsKind) (string, string, rrsKind) {
+	name = normalizeName(name)
+	switch kind {
+	case A, AAAA:
+		if ip := net.ParseIP(host); ip != nil {
+			host, kind = ip.String(), rrsKindForIP(ip)
+		}
+	case SRV:
+		if d, err := ParseSRV(host); err == nil {
+			if ip := net.ParseIP(d.Target); ip != nil {
+				d.Target = ip.String()
+			} else {
+				d.Target = normalizeName(d.Target)
+			}
+			host = d.String()
+		}
+	case PTR:
+		host = normalizeName(host)
+	}
+	return name, host, kind
+}
+
+// normalizeName lowercases the given name and appends a trailing dot, unless
+// the name is empty or already has one.
+func normalizeName(name string) string {
+	name = strings.ToLower(name)
+	if name != "" && !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
@@ -1,6 +1,7 @@
 package records
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -135,3 +136,28 @@ func validateZone(t *testing.T, i int, tc zoneValidationTest,
 			" %v", i, len(tc.in), tc.in)
 	}
 }
+
+func TestValidateRecordName(t *testing.T) {
+	for i, tt := range []struct {
+		name string
+		ok   bool
+	}{
+		{"", false},
+		{".", false},
+		{"mesos", true},
+		{"mesos.", true},
+		{"a.marathon.mesos.", true},
+		{"_http._a._tcp.marathon.mesos.", true},
+		{"a..b.marathon.mesos.", false},
+		{".a.mesos.", false},
+		{"a.mesos..", false},
+		{strings.Repeat("a", 63) + ".mesos.", true},
+		{strings.Repeat("a", 64) + ".mesos.", false},
+		{strings.Repeat("abcdefgh.", 28) + "mesos.", false},
+		{strings.Repeat("abcdefgh.", 27) + "mesos.", true},
+	} {
+		if err := validateRecordName(tt.name); (err == nil) != tt.ok {
+			t.Errorf("test #%d: validateRecordName(%q) = %v, want ok=%v", i+1, tt.name, err, tt.ok)
+		}
+	}
+}
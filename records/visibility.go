@@ -0,0 +1,43 @@
+package records
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// Visibilities of the DiscoveryInfo of tasks, from the narrowest to the
+// widest, as set by Config.MinimumVisibility.
+const (
+	VisibilityFramework = "FRAMEWORK"
+	VisibilityCluster   = "CLUSTER"
+	VisibilityExternal  = "EXTERNAL"
+)
+
+// visibilityRanks ranks the visibilities, from the narrowest to the widest.
+var visibilityRanks = map[string]int{
+	VisibilityFramework: 1,
+	VisibilityCluster:   2,
+	VisibilityExternal:  3,
+}
+
+// validateVisibility checks that the given minimum visibility is known.
+func validateVisibility(visibility string) error {
+	if _, ok := visibilityRanks[visibility]; visibility == "" || ok {
+		return nil
+	}
+	return fmt.Errorf("unknown visibility %q: use %q, %q or %q",
+		visibility, VisibilityFramework, VisibilityCluster, VisibilityExternal)
+}
+
+// visible tells whether the given task is visible enough to get records: if
+// there is no minimum visibility, if the task has no DiscoveryInfo visibility,
+// or an unknown one, or if its visibility is at least the minimum one.
+func (rg *RecordGenerator) visible(task state.Task) bool {
+	if rg.minVisibility == "" {
+		return true
+	}
+	rank, ok := visibilityRanks[strings.ToUpper(task.DiscoveryInfo.Visibilty)]
+	return !ok || rank >= visibilityRanks[rg.minVisibility]
+}
@@ -0,0 +1,104 @@
+//go:build !windows
+// +build !windows
+
+package resolver
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/activation"
+	"github.com/miekg/dns"
+)
+
+// activationHelperEnv is set in the environment of the child processes
+// started by TestSocketActivation.
+const activationHelperEnv = "MESOS_DNS_ACTIVATION_HELPER"
+
+// TestSocketActivationHelper isn't a real test: it serves DNS on the sockets
+// passed by TestSocketActivation, as systemd would, until killed.
+func TestSocketActivationHelper(t *testing.T) {
+	if os.Getenv(activationHelperEnv) == "" {
+		t.Skip("only run as a child process of TestSocketActivation")
+	}
+	sockets, err := activation.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.UseSockets(sockets)
+	select {
+	case err := <-res.LaunchDNS():
+		t.Fatal(err)
+	case <-time.After(time.Minute):
+	}
+}
+
+func TestSocketActivation(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpAddr, tcpAddr := pc.LocalAddr().String(), ln.Addr().String()
+	udp, err := pc.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// LISTEN_PID must be the pid of the process the sockets are meant for,
+	// which is only known once it's started
+	cmd := exec.Command("/bin/sh", "-c", `export LISTEN_PID=$$; exec "$0" "$@"`,
+		os.Args[0], "-test.run=^TestSocketActivationHelper$")
+	cmd.Env = append(os.Environ(),
+		activationHelperEnv+"=1",
+		"LISTEN_FDS=2",
+		"LISTEN_FDNAMES="+activation.DNSUDP+":"+activation.DNSTCP,
+	)
+	cmd.ExtraFiles = []*os.File{udp, tcp}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	// only the child serves on the sockets from now on
+	for _, c := range []interface{ Close() error }{udp, tcp, pc, ln} {
+		_ = c.Close()
+	}
+
+	for _, tt := range []struct{ proto, addr string }{{"udp", udpAddr}, {"tcp", tcpAddr}} {
+		c := &dns.Client{Net: tt.proto, Timeout: 500 * time.Millisecond}
+		q := new(dns.Msg).SetQuestion("leader.mesos.", dns.TypeA)
+		var (
+			r   *dns.Msg
+			err error
+		)
+		for deadline := time.Now().Add(20 * time.Second); time.Now().Before(deadline); {
+			if r, _, err = c.Exchange(q, tt.addr); err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.proto, err)
+		} else if len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "1.2.3.4" {
+			t.Errorf("%s: got answer %v, want leader.mesos. A 1.2.3.4", tt.proto, r.Answer)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package resolver
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// dumpTimeFormat is the time format of dump file names.
+const dumpTimeFormat = "20060102T150405.000000000Z"
+
+// RequestDump asynchronously dumps the records being served to a new file in
+// the configured DumpDir, see dump. Requests made while a dump is in progress
+// are coalesced into a single further dump.
+func (res *Resolver) RequestDump() {
+	res.dumpOnce.Do(func() {
+		res.dumpReqs = make(chan struct{}, 1)
+		go res.dumpLoop(res.dumpReqs)
+	})
+	select {
+	case res.dumpReqs <- struct{}{}:
+	default:
+		logging.Verbose.Println("record dump already pending, coalescing request")
+	}
+}
+
+func (res *Resolver) dumpLoop(reqs <-chan struct{}) {
+	for range reqs {
+		path, err := res.dump()
+		if err != nil {
+			logging.Error.Printf("failed to dump records: %v", err)
+		} else {
+			logging.Verbose.Printf("dumped records to %s", path)
+		}
+		if res.afterDump != nil {
+			res.afterDump(path, err)
+		}
+	}
+}
+
+// dump writes the records being served, preceded by their generation
+// metadata, to a new timestamped file in the configured DumpDir and returns
+// its path. The file only appears under its final name once complete.
+func (res *Resolver) dump() (string, error) {
+	rs := res.records()
+	dir := res.conf().DumpDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	path := filepath.Join(dir, "mesos-dns-records-"+res.now().UTC().Format(dumpTimeFormat)+".txt")
+
+	f, err := ioutil.TempFile(dir, ".mesos-dns-records-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "; version: %s\n", res.version)
+	fmt.Fprintf(w, "; generated: %s\n", rs.Timestamp.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "; dumped: %s\n", res.now().UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "; stats: %s\n", rs.Stats)
+	if _, err = rs.WriteTo(w); err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, os.Rename(f.Name(), path)
+}
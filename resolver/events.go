@@ -0,0 +1,158 @@
+package resolver
+
+import (
+	"context"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// resubscribeInterval is how long the event stream is resubscribed to after
+// it ended.
+const resubscribeInterval = 5 * time.Second
+
+// terminalStates are the states of the tasks which are done for good.
+var terminalStates = map[string]bool{
+	"TASK_FINISHED":         true,
+	"TASK_FAILED":           true,
+	"TASK_KILLED":           true,
+	"TASK_LOST":             true,
+	"TASK_ERROR":            true,
+	"TASK_DROPPED":          true,
+	"TASK_GONE":             true,
+	"TASK_GONE_BY_OPERATOR": true,
+}
+
+// taskKey identifies a task within its framework.
+type taskKey struct{ framework, task string }
+
+// eventView is the view of the frameworks and tasks of the cluster built from
+// the events of a subscription, which task updates are applied with.
+type eventView struct {
+	frameworks map[string]state.Framework
+	tasks      map[taskKey]state.Task
+}
+
+// reset replaces the view with the given state, if any.
+func (v *eventView) reset(s *state.State) {
+	v.frameworks = map[string]state.Framework{}
+	v.tasks = map[taskKey]state.Task{}
+	if s == nil {
+		return
+	}
+	for _, f := range s.Frameworks {
+		for _, t := range f.Tasks {
+			v.tasks[taskKey{f.ID, t.ID}] = t
+		}
+		f.Tasks = nil
+		v.frameworks[f.ID] = f
+	}
+}
+
+// Resyncs returns the channel the full resync requests of FollowEvents are
+// sent on, which the caller of Reload should act upon.
+func (res *Resolver) Resyncs() <-chan struct{} {
+	return res.resyncs
+}
+
+// requestResync requests a full resync, unless one is pending already.
+func (res *Resolver) requestResync() {
+	select {
+	case res.resyncs <- struct{}{}:
+	default:
+	}
+}
+
+// FollowEvents follows the event stream of the leading master, see
+// records.RecordGenerator.Subscribe, applying the task changes it tells to
+// the records being served as incremental updates, see ApplyTaskUpdate: the
+// records of tasks entering the TASK_RUNNING state are added and those of
+// tasks leaving it removed. Subscriptions, which may have missed events, and
+// events incremental updates can't apply, such as agent changes, request a
+// full resync on Resyncs instead. Streams which ended are resubscribed to
+// after a while. It never returns.
+func (res *Resolver) FollowEvents() {
+	for {
+		res.rsLock.RLock()
+		rs, masters := res.rs, res.fetched
+		res.rsLock.RUnlock()
+
+		view := &eventView{}
+		view.reset(nil)
+		err := rs.Subscribe(context.Background(), masters, func(ev state.Event) error {
+			res.handleEvent(view, ev)
+			return nil
+		})
+		logging.Error.Printf("event stream ended: %v; resubscribing in %s", err, resubscribeInterval)
+		time.Sleep(resubscribeInterval)
+	}
+}
+
+// handleEvent updates the given view with the given event, applying the
+// task changes it tells to the records being served.
+func (res *Resolver) handleEvent(view *eventView, ev state.Event) {
+	switch ev.Type {
+	case state.EventSubscribed:
+		view.reset(ev.State)
+		res.requestResync()
+	case state.EventFrameworkAdded, state.EventFrameworkUpdated:
+		if ev.Framework != nil {
+			view.frameworks[ev.Framework.ID] = *ev.Framework
+		}
+	case state.EventTaskAdded:
+		if ev.Task != nil {
+			res.applyTask(view, *ev.Task)
+		}
+	case state.EventTaskUpdated:
+		t, ok := view.tasks[taskKey{ev.FrameworkID, ev.TaskID}]
+		if !ok {
+			logging.Error.Printf("update of unknown task %q of framework %q; resyncing", ev.TaskID, ev.FrameworkID)
+			res.requestResync()
+			return
+		}
+		t.State = ev.TaskState
+		if ev.Status != nil {
+			t.Statuses = append(t.Statuses[:len(t.Statuses):len(t.Statuses)], *ev.Status)
+		}
+		res.applyTask(view, t)
+	case state.EventAgentAdded, state.EventAgentRemoved:
+		// the records of agents and the IPs of their tasks are only
+		// generated from full states
+		res.requestResync()
+	}
+}
+
+// applyTask records the given task in the given view, forgetting it once
+// done, and applies its records to those being served if it's entering or
+// leaving the TASK_RUNNING state: they're added if it's running and removed
+// otherwise.
+func (res *Resolver) applyTask(view *eventView, t state.Task) {
+	key := taskKey{t.FrameworkID, t.ID}
+	prev, known := view.tasks[key]
+	if terminalStates[t.State] {
+		delete(view.tasks, key)
+	} else {
+		view.tasks[key] = t
+	}
+	running := t.State == "TASK_RUNNING"
+	if !running && !(known && prev.State == "TASK_RUNNING") {
+		return
+	}
+
+	f, ok := view.frameworks[t.FrameworkID]
+	if !ok {
+		logging.Error.Printf("task %q of unknown framework %q; resyncing", t.ID, t.FrameworkID)
+		res.requestResync()
+		return
+	}
+	op := records.TaskRemove
+	if running {
+		op = records.TaskAdd
+	}
+	if err := res.ApplyTaskUpdate(t, f, op); err != nil {
+		logging.Error.Printf("failed to apply the update of task %q: %v; resyncing", t.ID, err)
+		res.requestResync()
+	}
+}
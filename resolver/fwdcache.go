@@ -0,0 +1,267 @@
+package resolver
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/exchanger"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+const (
+	// prefetchQueueSize bounds the number of refreshes pending; popular
+	// entries found with a full queue aren't refreshed early.
+	prefetchQueueSize = 64
+	// prefetchWindowFraction is the fraction of the TTL of an entry before
+	// its expiry within which it's refreshed, if popular.
+	prefetchWindowFraction = 10
+	// prefetchMinWindow is the shortest window before expiry within which
+	// popular entries are refreshed.
+	prefetchMinWindow = time.Second
+	// prefetchMinBackoff and prefetchMaxBackoff bound the duration
+	// refreshes are suspended for after a failed one, doubling with each
+	// consecutive failure.
+	prefetchMinBackoff = time.Second
+	prefetchMaxBackoff = time.Minute
+)
+
+// fwdCache caches the answers to forwarded queries for the smallest TTL of
+// their records, evicting the least recently used ones beyond its size.
+// With a positive hits threshold, entries hit at least as many times since
+// they were stored are refreshed asynchronously shortly before they expire,
+// so that popular names keep being answered from the cache. Refreshes are
+// queued in a bounded queue and suspended for a while after each failure,
+// so that a dead resolver isn't hammered. A nil fwdCache caches nothing.
+// It's safe for concurrent use.
+type fwdCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	size    int
+	hits    int
+	entries map[cacheKey]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+	queue   chan *cacheEntry
+	// backoff is the duration refreshes were last suspended for, and
+	// retryAt when they resume.
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// cacheKey identifies the answers of a question.
+type cacheKey struct {
+	name          string
+	qtype, qclass uint16
+}
+
+// cacheEntry is a cached answer, along with what it takes to refresh it.
+type cacheEntry struct {
+	key     cacheKey
+	msg     *dns.Msg
+	query   *dns.Msg
+	fwd     exchanger.Forwarder
+	proto   string
+	stored  time.Time
+	ttl     time.Duration
+	hits    int
+	pending bool // a refresh is queued or in flight
+}
+
+func newFwdCache(size, hits int, now func() time.Time) *fwdCache {
+	return &fwdCache{
+		now:     now,
+		size:    size,
+		hits:    hits,
+		entries: make(map[cacheKey]*list.Element, size),
+		lru:     list.New(),
+		queue:   make(chan *cacheEntry, prefetchQueueSize),
+	}
+}
+
+// keyOf returns the cache key of the given query, if it has a question.
+func keyOf(r *dns.Msg) (cacheKey, bool) {
+	if len(r.Question) == 0 {
+		return cacheKey{}, false
+	}
+	q := r.Question[0]
+	return cacheKey{strings.ToLower(q.Name), q.Qtype, q.Qclass}, true
+}
+
+// get returns the cached answer to the given query, with its TTLs lowered by
+// the time it's been cached for, if any, queueing a refresh of the entry if
+// it's popular and about to expire.
+func (c *fwdCache) get(r *dns.Msg) (*dns.Msg, bool) {
+	if c == nil {
+		return nil, false
+	}
+	k, ok := keyOf(r)
+	if !ok {
+		return nil, false
+	}
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	age := now.Sub(e.stored)
+	if age >= e.ttl {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	e.hits++
+	c.schedule(e, e.ttl-age, now)
+
+	m := e.msg.Copy()
+	m.Id = r.Id
+	m.Question = append(m.Question[:0], r.Question...)
+	elapsed := uint32(age / time.Second)
+	for _, rrs := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range rrs {
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT {
+				h.Ttl -= elapsed
+			}
+		}
+	}
+	return m, true
+}
+
+// put caches the given answer to the given query, forwarded with the given
+// forwarder and protocol, if cacheable: successful or NXDOMAIN, complete and
+// with a positive TTL.
+func (c *fwdCache) put(r, m *dns.Msg, fwd exchanger.Forwarder, proto string) {
+	if c == nil || m == nil || m.Truncated ||
+		(m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError) {
+		return
+	}
+	k, ok := keyOf(r)
+	if !ok {
+		return
+	}
+	ttl, ok := minTTL(m)
+	if !ok || ttl == 0 {
+		return
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(r.Question[0].Name, r.Question[0].Qtype)
+	query.Question[0].Qclass = r.Question[0].Qclass
+	query.RecursionDesired = r.RecursionDesired
+	e := &cacheEntry{
+		key:    k,
+		msg:    m.Copy(),
+		query:  query,
+		fwd:    fwd,
+		proto:  proto,
+		stored: c.now(),
+		ttl:    time.Duration(ttl) * time.Second,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[k]; ok {
+		c.remove(el)
+	}
+	c.entries[k] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// minTTL returns the smallest TTL of the records of the given answer, if it
+// has any.
+func minTTL(m *dns.Msg) (ttl uint32, ok bool) {
+	for _, rrs := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range rrs {
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT && (!ok || h.Ttl < ttl) {
+				ttl, ok = h.Ttl, true
+			}
+		}
+	}
+	return ttl, ok
+}
+
+// remove evicts the entry of the given element; c.mu must be held.
+func (c *fwdCache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*cacheEntry).key)
+	c.lru.Remove(el)
+}
+
+// flush evicts every entry, e.g. after the resolvers changed.
+func (c *fwdCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[cacheKey]*list.Element, c.size)
+	c.lru.Init()
+}
+
+// schedule queues a refresh of the given entry, expiring in the given
+// duration, if it's popular, about to expire and neither already queued nor
+// refreshes suspended; c.mu must be held.
+func (c *fwdCache) schedule(e *cacheEntry, left time.Duration, now time.Time) {
+	window := e.ttl / prefetchWindowFraction
+	if window < prefetchMinWindow {
+		window = prefetchMinWindow
+	}
+	if c.hits <= 0 || e.hits < c.hits || e.pending || left > window || now.Before(c.retryAt) {
+		return
+	}
+	select {
+	case c.queue <- e:
+		e.pending = true
+	default:
+		logging.CurLog.PrefetchDrops.Inc()
+	}
+}
+
+// prefetch refreshes the queued entries, one at a time; it never returns.
+func (c *fwdCache) prefetch() {
+	for e := range c.queue {
+		c.refresh(e)
+	}
+}
+
+// prefetchQueued refreshes the entries queued so far; it's used in tests.
+func (c *fwdCache) prefetchQueued() {
+	for {
+		select {
+		case e := <-c.queue:
+			c.refresh(e)
+		default:
+			return
+		}
+	}
+}
+
+// refresh forwards the query of the given entry anew, caching the answer.
+// Failures suspend refreshes, for twice as long as the previous time if it
+// failed too.
+func (c *fwdCache) refresh(e *cacheEntry) {
+	logging.CurLog.PrefetchAttempts.Inc()
+	m, err := e.fwd(e.query, e.proto)
+	if err == nil && m != nil && (m.Rcode == dns.RcodeSuccess || m.Rcode == dns.RcodeNameError) {
+		c.mu.Lock()
+		c.backoff = 0
+		c.mu.Unlock()
+		c.put(e.query, m, e.fwd, e.proto)
+		logging.CurLog.PrefetchSuccesses.Inc()
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.pending = false
+	c.backoff *= 2
+	if c.backoff < prefetchMinBackoff {
+		c.backoff = prefetchMinBackoff
+	} else if c.backoff > prefetchMaxBackoff {
+		c.backoff = prefetchMaxBackoff
+	}
+	c.retryAt = c.now().Add(c.backoff)
+}
@@ -0,0 +1,220 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/mesosphere/mesos-dns/activation"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// UseSockets makes the DNS and HTTP servers serve on the given sockets,
+// passed by systemd and named as per the activation package, rather than
+// bind their configured addresses. Sockets of other names are closed. It
+// must be called before launching the servers.
+func (res *Resolver) UseSockets(s activation.Sockets) {
+	for name, l := range s.Listeners {
+		if name != activation.DNSTCP && name != activation.HTTP {
+			logging.Error.Printf("Warning: ignoring unknown stream socket %q", name)
+			_ = l.Close()
+			delete(s.Listeners, name)
+		}
+	}
+	for name, pc := range s.PacketConns {
+		if name != activation.DNSUDP {
+			logging.Error.Printf("Warning: ignoring unknown datagram socket %q", name)
+			_ = pc.Close()
+			delete(s.PacketConns, name)
+		}
+	}
+	res.listeners.mu.Lock()
+	defer res.listeners.mu.Unlock()
+	res.listeners.activated = s
+}
+
+// listeners tracks the DNS and HTTP servers launched so that they can be
+// rebound to other addresses. Errors of the servers which got replaced
+// aren't reported. Servers on sockets passed by systemd are never rebound
+// since their addresses are set by the socket units.
+type listeners struct {
+	mu        sync.Mutex
+	activated activation.Sockets
+	dns       map[string]*dns.Server // by protocol
+	dnsErrs   chan<- error
+	http      *http.Server
+	httpErrs  chan<- error
+}
+
+// launchDNS starts the TCP and UDP DNS servers on the sockets passed by
+// systemd, if any, or else on the given address, sending their errors to
+// errCh.
+func (l *listeners) launchDNS(res *Resolver, addr string, errCh chan<- error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dns, l.dnsErrs = map[string]*dns.Server{}, errCh
+	for _, proto := range []string{"tcp", "udp"} {
+		server, errs := l.activatedDNS(proto)
+		if server == nil {
+			server, _, errs = res.serve(proto, addr)
+		} else {
+			logging.Verbose.Printf("serving DNS over %s on the socket activated %s", proto, server.Addr)
+		}
+		l.trackDNS(proto, server, errs)
+	}
+}
+
+// activatedDNS starts a DNS server for the given protocol on the socket
+// passed by systemd, if any. It must be called with l.mu held.
+func (l *listeners) activatedDNS(proto string) (*dns.Server, <-chan error) {
+	server := &dns.Server{Net: proto}
+	switch proto {
+	case "tcp":
+		ln, ok := l.activated.Listeners[activation.DNSTCP]
+		if !ok {
+			return nil, nil
+		}
+		server.Listener, server.Addr = ln, ln.Addr().String()
+	case "udp":
+		pc, ok := l.activated.PacketConns[activation.DNSUDP]
+		if !ok {
+			return nil, nil
+		}
+		server.PacketConn, server.Addr = pc, pc.LocalAddr().String()
+	}
+	_, errs := start(server, server.ActivateAndServe)
+	return server, errs
+}
+
+// isActivated tells whether the DNS server of the given protocol serves on a
+// socket passed by systemd. It must be called with l.mu held.
+func (l *listeners) isActivated(proto string) bool {
+	switch proto {
+	case "tcp":
+		_, ok := l.activated.Listeners[activation.DNSTCP]
+		return ok
+	case "udp":
+		_, ok := l.activated.PacketConns[activation.DNSUDP]
+		return ok
+	}
+	return false
+}
+
+func (l *listeners) servingDNS() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dns != nil
+}
+
+// trackDNS makes server the DNS server of the given protocol, forwarding its
+// error unless it got replaced meanwhile. It must be called with l.mu held.
+func (l *listeners) trackDNS(proto string, server *dns.Server, errs <-chan error) {
+	l.dns[proto] = server
+	go func() {
+		err := <-errs
+		l.mu.Lock()
+		current := l.dns[proto] == server
+		l.mu.Unlock()
+		if current {
+			l.dnsErrs <- err
+		}
+	}()
+}
+
+// rebindDNS replaces the DNS servers not listening on the given address, if
+// any, by ones which do.
+func (l *listeners) rebindDNS(res *Resolver, addr string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for proto, old := range l.dns {
+		if old.Addr == addr {
+			continue
+		}
+		if l.isActivated(proto) {
+			logging.Error.Printf("Warning: not rebinding the socket activated %s DNS server to %s", proto, addr)
+			continue
+		}
+		if err := old.Shutdown(); err != nil {
+			logging.VeryVerbose.Printf("failed to shut down the %s DNS server: %v", proto, err)
+		}
+		server, started, errs := res.serve(proto, addr)
+		select {
+		case <-started:
+			l.trackDNS(proto, server, errs)
+		case err := <-errs:
+			// keep track of the failed server so that rebinding to the
+			// old address restarts it
+			l.dns[proto] = server
+			return err
+		}
+	}
+	return nil
+}
+
+// launchHTTP starts the HTTP server on the socket passed by systemd, if any,
+// or else on the given address, sending its errors to errCh.
+func (l *listeners) launchHTTP(addr string, errCh chan<- error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.httpErrs = errCh
+	if ln, ok := l.activated.Listeners[activation.HTTP]; ok {
+		logging.Verbose.Printf("serving HTTP on the socket activated %s", ln.Addr())
+		l.serveHTTP(ln, addr)
+		return
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		errCh <- fmt.Errorf("Failed to setup http server: %v", err)
+		return
+	}
+	l.serveHTTP(ln, addr)
+}
+
+// serveHTTP starts an HTTP server, known by the given address, on the given
+// listener, making it the current one. It must be called with l.mu held.
+func (l *listeners) serveHTTP(ln net.Listener, addr string) {
+	server := &http.Server{Addr: addr}
+	l.http = server
+	go func() {
+		err := server.Serve(ln)
+		l.mu.Lock()
+		current := l.http == server
+		l.mu.Unlock()
+		if !current {
+			return
+		}
+		if err != nil {
+			err = fmt.Errorf("Failed to setup http server: %v", err)
+		} else {
+			logging.Error.Println("Not serving http requests any more.")
+		}
+		l.httpErrs <- err
+	}()
+}
+
+// rebindHTTP replaces the HTTP server, if any and not listening on the given
+// address, by one which does. The current server keeps serving if the
+// address can't be bound.
+func (l *listeners) rebindHTTP(addr string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.http == nil || l.http.Addr == addr {
+		return nil
+	}
+	if _, ok := l.activated.Listeners[activation.HTTP]; ok {
+		logging.Error.Printf("Warning: not rebinding the socket activated HTTP server to %s", addr)
+		return nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Failed to setup http server: %v", err)
+	}
+	old := l.http
+	l.serveHTTP(ln, addr)
+	if err := old.Close(); err != nil {
+		logging.VeryVerbose.Printf("failed to close the HTTP server: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package resolver
+
+import (
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+// mastersFile tracks the Mesos masters listed in a masters file, re-reading
+// it when its modification time or size change.
+type mastersFile struct {
+	path    string
+	mtime   time.Time
+	size    int64
+	masters []string
+	// errs limits the logging of failures to read the file.
+	errs *logging.Limiter
+}
+
+// read returns the masters listed in the file, which are none if it's
+// missing, empty or can't be read.
+func (mf *mastersFile) read() []string {
+	fi, err := os.Stat(mf.path)
+	if err == nil && fi.ModTime().Equal(mf.mtime) && fi.Size() == mf.size {
+		return mf.masters
+	}
+	var masters []string
+	if err == nil {
+		masters, err = records.ReadMasters(mf.path)
+	}
+	if err != nil {
+		if mf.errs.Allow(mf.path) {
+			logging.Error.Printf("failed to read the masters of %s: %v", mf.path, err)
+		}
+		mf.mtime, mf.size, masters = time.Time{}, 0, nil
+	} else {
+		mf.mtime, mf.size = fi.ModTime(), fi.Size()
+	}
+	if !reflect.DeepEqual(masters, mf.masters) {
+		logging.Error.Printf("masters of %s changed from %v to %v", mf.path, mf.masters, masters)
+		mf.masters = masters
+	}
+	return masters
+}
+
+// listedMasters returns the masters to fetch the state from: those of the
+// configured masters file, if any are listed, or else the given ones, which
+// start with the leader, if known.
+func (res *Resolver) listedMasters(config *records.Config, masters []string) []string {
+	if config.MastersFile == "" {
+		res.mastersFile = nil
+		return masters
+	}
+	if res.mastersFile == nil || res.mastersFile.path != config.MastersFile {
+		res.mastersFile = &mastersFile{path: config.MastersFile, errs: logging.NewLimiter(time.Minute)}
+	}
+	if listed := res.mastersFile.read(); len(listed) > 0 {
+		return append([]string{""}, listed...)
+	}
+	return masters
+}
@@ -0,0 +1,85 @@
+package resolver
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// maxRotations caps the number of names rotation counters are kept for; they
+// are all reset once it's reached, e.g. as records come and go.
+const maxRotations = 1 << 16
+
+// rotationKey identifies the rotation counter of the answers of a name and
+// type.
+type rotationKey struct {
+	name  string
+	qtype uint16
+}
+
+// rotations counts the queries answered of each name and type, to rotate
+// their address answers with the rotate answer order.
+type rotations struct {
+	sync.Mutex
+	counts map[rotationKey]uint64
+}
+
+// advance returns the number of queries of the given name and type answered
+// before, counting one more.
+func (r *rotations) advance(name string, qtype uint16) uint64 {
+	r.Lock()
+	defer r.Unlock()
+	if r.counts == nil || len(r.counts) >= maxRotations {
+		r.counts = map[rotationKey]uint64{}
+	}
+	key := rotationKey{name, qtype}
+	n := r.counts[key]
+	r.counts[key] = n + 1
+	return n
+}
+
+// orderAddresses orders the given A or AAAA answers of the given name and
+// type as per the given answer order: rotated by one more each query, with
+// records.AnswerOrderRotate, or sorted by address, with
+// records.AnswerOrderSorted. Random orders are left to shuffleAnswers.
+func (res *Resolver) orderAddresses(order, name string, qtype uint16, answers []dns.RR) {
+	if len(answers) < 2 {
+		return
+	}
+	switch order {
+	case records.AnswerOrderRotate:
+		n := int(res.rotations.advance(name, qtype) % uint64(len(answers)))
+		rotated := append(append([]dns.RR{}, answers[n:]...), answers[:n]...)
+		copy(answers, rotated)
+	case records.AnswerOrderSorted:
+		sort.SliceStable(answers, func(i, j int) bool {
+			return bytes.Compare(addressOf(answers[i]), addressOf(answers[j])) < 0
+		})
+	}
+}
+
+// addressOf returns the address of the given A or AAAA record, nil for
+// others.
+func addressOf(rr dns.RR) net.IP {
+	switch rr := rr.(type) {
+	case *dns.A:
+		return rr.A.To16()
+	case *dns.AAAA:
+		return rr.AAAA.To16()
+	}
+	return nil
+}
+
+// shuffled tells whether the answers of queries of the given type are
+// shuffled with the given answer order: always but for those with address
+// answers, A, AAAA and ANY queries, unless randomly ordered.
+func shuffled(order string, qtype uint16) bool {
+	if order == "" || order == records.AnswerOrderRandom {
+		return true
+	}
+	return qtype != dns.TypeA && qtype != dns.TypeAAAA && qtype != dns.TypeANY
+}
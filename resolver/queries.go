@@ -0,0 +1,163 @@
+package resolver
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// querySource is where the answer to a DNS query came from.
+type querySource int
+
+const (
+	sourceLocal querySource = iota
+	sourceForward
+	sourceCache
+	numSources
+)
+
+// The dimensions queries are counted along. Each has a fixed set of labels,
+// the last of which catches everything else where applicable, so that
+// counting a query never allocates.
+var (
+	qtypeLabels  = [...]string{"A", "AAAA", "SRV", "SOA", "NS", "ANY", "other"}
+	rcodeLabels  = [...]string{"NOERROR", "NXDOMAIN", "SERVFAIL", "REFUSED", "other"}
+	zoneLabels   = [...]string{"authoritative", "external"}
+	sourceLabels = [numSources]string{"local", "forward", "cache"}
+)
+
+// latencyBounds are the inclusive upper bounds of the latency histogram
+// buckets; a last bucket catches the slower queries.
+var latencyBounds = [...]time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+type (
+	queryCounts    [len(qtypeLabels)][len(rcodeLabels)][len(zoneLabels)][numSources]uint64
+	queryHistogram [len(latencyBounds) + 1]uint64
+)
+
+// queryStats counts DNS queries by qtype, response rcode, zone and answer
+// source, along with a latency histogram split along the same dimensions.
+// It's safe for concurrent use.
+type queryStats struct {
+	counts  queryCounts
+	latency [len(qtypeLabels)][len(rcodeLabels)][len(zoneLabels)][numSources]queryHistogram
+}
+
+// observe accounts for a query of the given type, answered with the given
+// rcode from the given source in the given duration. authoritative tells
+// whether the queried name is inside the Mesos domain.
+func (s *queryStats) observe(qtype uint16, rcode int, authoritative bool, src querySource, d time.Duration) {
+	q, r, z := qtypeIndex(qtype), rcodeIndex(rcode), 1
+	if authoritative {
+		z = 0
+	}
+	atomic.AddUint64(&s.counts[q][r][z][src], 1)
+	atomic.AddUint64(&s.latency[q][r][z][src][latencyIndex(d)], 1)
+}
+
+func qtypeIndex(qtype uint16) int {
+	switch qtype {
+	case dns.TypeA:
+		return 0
+	case dns.TypeAAAA:
+		return 1
+	case dns.TypeSRV:
+		return 2
+	case dns.TypeSOA:
+		return 3
+	case dns.TypeNS:
+		return 4
+	case dns.TypeANY:
+		return 5
+	default:
+		return len(qtypeLabels) - 1
+	}
+}
+
+func rcodeIndex(rcode int) int {
+	switch rcode {
+	case dns.RcodeSuccess:
+		return 0
+	case dns.RcodeNameError:
+		return 1
+	case dns.RcodeServerFailure:
+		return 2
+	case dns.RcodeRefused:
+		return 3
+	default:
+		return len(rcodeLabels) - 1
+	}
+}
+
+func latencyIndex(d time.Duration) int {
+	for i, bound := range latencyBounds {
+		if d <= bound {
+			return i
+		}
+	}
+	return len(latencyBounds)
+}
+
+// queryBucket is the JSON representation of the queries counted along a
+// single combination of dimensions.
+type queryBucket struct {
+	Qtype  string `json:"qtype"`
+	Rcode  string `json:"rcode"`
+	Zone   string `json:"zone"`
+	Source string `json:"source"`
+	Count  uint64 `json:"count"`
+	// Latency holds the number of queries per latency bucket, keyed by the
+	// bucket's upper bound, or "+Inf" for the last one
+	Latency map[string]uint64 `json:"latency"`
+}
+
+// buckets returns the queries counted so far, omitting empty buckets.
+func (s *queryStats) buckets() []queryBucket {
+	bs := []queryBucket{}
+	for q := range s.counts {
+		for r := range s.counts[q] {
+			for z := range s.counts[q][r] {
+				for src := range s.counts[q][r][z] {
+					n := atomic.LoadUint64(&s.counts[q][r][z][src])
+					if n == 0 {
+						continue
+					}
+					b := queryBucket{
+						Qtype:   qtypeLabels[q],
+						Rcode:   rcodeLabels[r],
+						Zone:    zoneLabels[z],
+						Source:  sourceLabels[src],
+						Count:   n,
+						Latency: map[string]uint64{},
+					}
+					h := &s.latency[q][r][z][src]
+					for i := range h {
+						if c := atomic.LoadUint64(&h[i]); c > 0 {
+							b.Latency[latencyLabel(i)] = c
+						}
+					}
+					bs = append(bs, b)
+				}
+			}
+		}
+	}
+	return bs
+}
+
+func latencyLabel(i int) string {
+	if i == len(latencyBounds) {
+		return "+Inf"
+	}
+	return latencyBounds[i].String()
+}
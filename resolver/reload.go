@@ -0,0 +1,168 @@
+package resolver
+
+import (
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// configReload describes the outcome of a configuration reload.
+type configReload struct {
+	Time   time.Time `json:"time"`
+	OK     bool      `json:"ok"`
+	Errors []string  `json:"errors,omitempty"`
+}
+
+func newConfigReload(t time.Time, err error) *configReload {
+	r := &configReload{Time: t, OK: err == nil}
+	switch err := err.(type) {
+	case nil:
+	case records.ConfigError:
+		r.Errors = err
+	default:
+		r.Errors = []string{err.Error()}
+	}
+	return r
+}
+
+// ReloadConfig re-reads the configuration file and, if it's valid, applies
+// it as a whole: the next generation uses its options, label spec and IP
+// sources, queries get answered and forwarded as per its settings, and the
+// listeners whose address changed get rebound. An invalid configuration, or
+// one whose listeners can't be bound, is rejected as a whole, keeping the
+// current one. Changes to the ZooKeeper, statsd, enabled servers and
+// endpoints settings only take effect upon restart.
+// It's safe for concurrent use.
+func (res *Resolver) ReloadConfig() error {
+	res.reloadLock.Lock()
+	defer res.reloadLock.Unlock()
+
+	old := res.conf()
+	config, err := records.LoadConfig(old.File)
+	if err == nil {
+		err = res.applyConfig(old, &config)
+	}
+	res.lastReload = newConfigReload(res.now(), err)
+	if err != nil {
+		logging.Error.Printf("rejected configuration reload, keeping the current one: %v", err)
+		return err
+	}
+	logging.Verbose.Printf("configuration reloaded from %q", config.File)
+	return nil
+}
+
+// restartSettings lists the configuration fields whose changes only take
+// effect upon restart.
+var restartSettings = []string{
+	"Zk", "ZkDetectionTimeout", "ExhibitorURL", "ExhibitorZkPath", "DNSOn", "HTTPOn", "EnumerationOn",
+	"TopTalkersOn", "WatchResolvConf", "StatsdAddress", "StatsdPrefix", "StatsdFlushSeconds",
+	"StatsdSampleRate", "ForwardCacheSize", "PrefetchHits",
+}
+
+// applyConfig switches from the old configuration to the given one, which
+// must be valid. It must be called with res.reloadLock held.
+func (res *Resolver) applyConfig(old, config *records.Config) error {
+	oldv, newv := reflect.ValueOf(old).Elem(), reflect.ValueOf(config).Elem()
+	for _, name := range restartSettings {
+		if !reflect.DeepEqual(oldv.FieldByName(name).Interface(), newv.FieldByName(name).Interface()) {
+			logging.Error.Printf("Warning: changing %s requires a restart", name)
+		}
+	}
+
+	if err := res.rebind(old, config); err != nil {
+		return err
+	}
+
+	fwds := newForwarders(config, res.recursors)
+	res.rsLock.Lock()
+	res.generatorOptions = append([]records.Option{records.WithConfig(*config)}, res.options...)
+	if config.Zk == "" && !reflect.DeepEqual(old.Masters, config.Masters) {
+		res.masters = append([]string{""}, config.Masters...)
+	}
+	res.rsLock.Unlock()
+	res.setForwarders(fwds)
+	res.config.Store(config)
+	res.rehandle(old, config, fwds)
+	return nil
+}
+
+// rebind rebinds the DNS and HTTP servers whose address changed, restoring
+// the old DNS address if any of them can't be bound; the HTTP server is only
+// replaced once the new one is bound.
+func (res *Resolver) rebind(old, config *records.Config) error {
+	oldDNS := net.JoinHostPort(old.Listener, strconv.Itoa(old.Port))
+	newDNS := net.JoinHostPort(config.Listener, strconv.Itoa(config.Port))
+	if err := res.listeners.rebindDNS(res, newDNS); err != nil {
+		if rerr := res.listeners.rebindDNS(res, oldDNS); rerr != nil {
+			logging.Error.Printf("failed to restore the DNS servers on %s: %v", oldDNS, rerr)
+		}
+		return err
+	}
+
+	newHTTP := net.JoinHostPort(config.HTTPListener, strconv.Itoa(config.HTTPPort))
+	if err := res.listeners.rebindHTTP(newHTTP); err != nil {
+		if rerr := res.listeners.rebindDNS(res, oldDNS); rerr != nil {
+			logging.Error.Printf("failed to restore the DNS servers on %s: %v", oldDNS, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// rehandle registers the DNS handlers of the Mesos domains and the forwarded
+// zones of the new configuration in place of the old ones, if DNS is served.
+func (res *Resolver) rehandle(old, config *records.Config, fwds *forwarders) {
+	if !res.listeners.servingDNS() {
+		return
+	}
+	domains := map[string]bool{}
+	for _, domain := range config.Domains() {
+		domains[domain] = true
+	}
+	for _, domain := range old.Domains() {
+		if !domains[domain] {
+			dns.HandleRemove(domain + ".")
+		}
+	}
+	for zone := range old.ZoneResolvers {
+		if _, ok := fwds.zones[zone]; !ok {
+			dns.HandleRemove(zone + ".")
+		}
+	}
+	for domain := range domains {
+		dns.HandleFunc(domain+".", panicRecover(res.HandleMesos))
+	}
+	for zone := range fwds.zones {
+		dns.HandleFunc(zone+".", panicRecover(res.HandleNonMesos(res.forwarder(zone))))
+	}
+}
+
+// RestReload handles HTTP requests to reload the configuration, responding
+// with 400 Bad Request and the reasons if it got rejected.
+func (res *Resolver) RestReload(req *restful.Request, resp *restful.Response) {
+	if err := res.ReloadConfig(); err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+	}
+	res.RestLastReload(req, resp)
+}
+
+// RestLastReload handles HTTP requests of the outcome of the last
+// configuration reload.
+func (res *Resolver) RestLastReload(req *restful.Request, resp *restful.Response) {
+	res.reloadLock.Lock()
+	last := res.lastReload
+	res.reloadLock.Unlock()
+	if last == nil {
+		last = &configReload{}
+	}
+	if err := resp.WriteAsJson(last); err != nil {
+		logging.Error.Println(err)
+	}
+}
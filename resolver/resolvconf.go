@@ -0,0 +1,110 @@
+package resolver
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+const (
+	// resolvConfPath is the path of the resolv.conf file watched if
+	// WatchResolvConf is set.
+	resolvConfPath = "/etc/resolv.conf"
+	// resolvConfPollInterval is the interval at which it's polled.
+	resolvConfPollInterval = 5 * time.Second
+)
+
+// resolvConf tracks the changes of a resolv.conf file, as told by its
+// modification time and size.
+type resolvConf struct {
+	path  string
+	mtime time.Time
+	size  int64
+	// errs limits the logging of failures to read the file.
+	errs *logging.Limiter
+}
+
+// changed returns the non-local nameservers of the file if it changed since
+// the last call.
+func (rc *resolvConf) changed() (servers []string, changed bool, err error) {
+	fi, err := os.Stat(rc.path)
+	if err != nil {
+		return nil, false, err
+	}
+	if fi.ModTime().Equal(rc.mtime) && fi.Size() == rc.size {
+		return nil, false, nil
+	}
+	if servers, err = records.LocalDNS(rc.path); err != nil {
+		return nil, false, err
+	}
+	rc.mtime, rc.size = fi.ModTime(), fi.Size()
+	return servers, true, nil
+}
+
+// watchResolvConf polls the given resolv.conf file every interval, until done
+// is closed, to forward to its nameservers as they change.
+func (res *Resolver) watchResolvConf(rc *resolvConf, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			res.pollResolvConf(rc)
+		case <-done:
+			return
+		}
+	}
+}
+
+// pollResolvConf forwards to the nameservers of the given resolv.conf file,
+// after the configured resolvers, if they changed. The queries being
+// forwarded keep their targets.
+func (res *Resolver) pollResolvConf(rc *resolvConf) {
+	servers, changed, err := rc.changed()
+	if err != nil {
+		if rc.errs.Allow(rc.path) {
+			logging.Error.Printf("failed to read the nameservers of %s: %v", rc.path, err)
+		}
+		return
+	} else if !changed {
+		return
+	}
+
+	res.reloadLock.Lock()
+	defer res.reloadLock.Unlock()
+	if len(servers) == len(res.recursors) && (len(servers) == 0 || reflect.DeepEqual(servers, res.recursors)) {
+		return
+	}
+	logging.Error.Printf("nameservers of %s changed from %v to %v", rc.path, res.recursors, servers)
+	res.recursors = servers
+	res.setForwarders(newForwarders(res.conf(), servers))
+	logging.CurLog.RecursorChanges.Inc()
+}
+
+// mergeResolvers returns the given resolvers followed by the given
+// recursors which aren't among them, so that the resolvers are tried first.
+func mergeResolvers(resolvers, recursors []string) []string {
+	merged := make([]string, 0, len(resolvers)+len(recursors))
+	seen := make(map[string]bool, len(resolvers)+len(recursors))
+	for _, addrs := range [][]string{resolvers, recursors} {
+		for _, addr := range addrs {
+			if key := resolverKey(addr); !seen[key] {
+				seen[key] = true
+				merged = append(merged, addr)
+			}
+		}
+	}
+	return merged
+}
+
+// resolverKey normalizes the address of a resolver, which defaults to port 53.
+func resolverKey(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, "53")
+}
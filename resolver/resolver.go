@@ -20,6 +20,7 @@ import (
 	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/mesosphere/mesos-dns/models"
 	"github.com/mesosphere/mesos-dns/records"
+	"github.com/mesosphere/mesos-dns/records/state"
 	"github.com/mesosphere/mesos-dns/util"
 	"github.com/miekg/dns"
 )
@@ -28,52 +29,169 @@ import (
 type Resolver struct {
 	masters          []string
 	version          string
-	config           records.Config
+	config           atomic.Value // *records.Config, see conf
 	ready            chan struct{}
 	rs               *records.RecordGenerator
 	rsLock           sync.RWMutex
 	rng              *rand.Rand
+	rotations        rotations
 	generatorOptions []records.Option
-	zoneFwds         map[string]exchanger.Forwarder // map of zone -> forwarder
-	defaultFwd       exchanger.Forwarder
+	fwds             atomic.Value // *forwarders
+	soaSerial        uint32
+	queries          *queryStats
+	talkers          *topTalkers
+	fwdCache         *fwdCache
+	// now tells the time staleness is measured with; it's overridden in
+	// tests.
+	now func() time.Time
+	// lastErr describes the last failed record generation, guarded by
+	// rsLock.
+	lastErr *records.GenerationError
+	// lastDelta holds the records changed by the last reload, guarded by
+	// rsLock.
+	lastDelta *records.RecordDelta
+	// dumpReqs queues record dump requests, see RequestDump.
+	dumpReqs chan struct{}
+	dumpOnce sync.Once
+	// afterDump is called after each record dump; it's set in tests.
+	afterDump func(path string, err error)
+	// updateLock serializes incremental task updates, see ApplyTaskUpdate.
+	updateLock sync.Mutex
+	// resyncs queues full resync requests of the event stream follower,
+	// see Resyncs.
+	resyncs chan struct{}
+	// fetched are the masters the records being served were fetched from,
+	// which the event stream is subscribed to; guarded by rsLock.
+	fetched []string
+	// reloadLock serializes configuration reloads and recursor changes, and
+	// guards lastReload and recursors.
+	reloadLock sync.Mutex
+	lastReload *configReload
+	// recursors are the nameservers of the watched resolv.conf file, if any.
+	recursors []string
+	// listeners are the DNS and HTTP servers serving, see ReloadConfig.
+	listeners listeners
+	// mastersFile tracks the configured masters file, if any; it's only used
+	// by Reload.
+	mastersFile *mastersFile
+	// options are the record generator options given to New, applied after
+	// the configuration, e.g. records.WithNamingLinks.
+	options []records.Option
 }
 
-// New returns a Resolver with the given version and configuration.
-func New(version string, config records.Config) *Resolver {
-	generatorOptions := []records.Option{
+// New returns a Resolver with the given version and configuration. Records are
+// generated with the given options applied after the configuration.
+func New(version string, config records.Config, options ...records.Option) *Resolver {
+	return NewWithSource(version, config, rand.NewSource(time.Now().UnixNano()), options...)
+}
+
+// NewWithSource is like New, but answers are shuffled with numbers drawn from
+// the given source rather than one seeded with the current time, e.g. so that
+// tests can assert exact answer orderings.
+func NewWithSource(version string, config records.Config, src rand.Source, options ...records.Option) *Resolver {
+	generatorOptions := append([]records.Option{
 		records.WithConfig(config),
-	}
+	}, options...)
 	recordGenerator := records.NewRecordGenerator(generatorOptions...)
 	r := &Resolver{
 		version: version,
-		config:  config,
 		ready:   make(chan struct{}),
 		rs:      recordGenerator,
 		// rand.Sources aren't safe for concurrent use, except the global one.
 		// See: https://github.com/golang/go/issues/3611
-		rng:              rand.New(&lockedSource{src: rand.NewSource(time.Now().UnixNano())}),
+		rng:              rand.New(&lockedSource{src: src}),
 		masters:          append([]string{""}, config.Masters...),
 		generatorOptions: generatorOptions,
+		options:          options,
+		soaSerial:        config.SOASerial,
+		queries:          &queryStats{},
+		now:              time.Now,
+		resyncs:          make(chan struct{}, 1),
+	}
+	r.config.Store(&config)
+	r.setForwarders(newForwarders(&config, nil))
+	logging.CurLog.StalenessSeconds.SetFunc(r.staleness)
+	if config.TopTalkersOn {
+		r.talkers = newTopTalkers(topTalkersCapacity, time.Now)
+	}
+	if config.ForwardCacheSize > 0 {
+		r.fwdCache = newFwdCache(config.ForwardCacheSize, config.PrefetchHits, time.Now)
+		go r.fwdCache.prefetch()
+	}
+	if config.WatchResolvConf {
+		rc := &resolvConf{path: resolvConfPath, errs: logging.NewLimiter(time.Minute)}
+		r.pollResolvConf(rc)
+		go r.watchResolvConf(rc, resolvConfPollInterval, nil)
 	}
 
+	return r
+}
+
+// conf returns the current (read-only) configuration, or a zero one if none
+// was set. attempts to write to the returned object will likely result in a
+// data race.
+func (res *Resolver) conf() *records.Config {
+	if config, ok := res.config.Load().(*records.Config); ok {
+		return config
+	}
+	return &records.Config{}
+}
+
+// forwarders holds the forwarders of non-Mesos queries, and of the Mesos
+// queries without local records in agent-local mode.
+type forwarders struct {
+	zones    map[string]exchanger.Forwarder // map of zone -> forwarder
+	dflt     exchanger.Forwarder
+	targets  []string            // the resolvers of dflt
+	upstream exchanger.Forwarder // nil unless in agent-local mode
+}
+
+// newForwarders returns the forwarders of the given configuration, the
+// default one forwarding to its resolvers followed by the given recursors.
+func newForwarders(config *records.Config, recursors []string) *forwarders {
 	timeout := 5 * time.Second
 	if config.Timeout != 0 {
 		timeout = time.Duration(config.Timeout) * time.Second
 	}
 
-	r.zoneFwds = make(map[string]exchanger.Forwarder)
+	fwds := &forwarders{zones: make(map[string]exchanger.Forwarder)}
 	if config.ExternalOn {
 		for zone, resolvers := range config.ZoneResolvers {
-			r.zoneFwds[zone] = exchanger.NewForwarder(resolvers, exchangers(timeout, "udp", "tcp"))
+			fwds.zones[zone] = exchanger.NewForwarder(resolvers, exchangers(timeout, "udp", "tcp"))
 		}
-		r.defaultFwd = exchanger.NewForwarder(
-			config.Resolvers, exchangers(timeout, "udp", "tcp"))
+		fwds.targets = mergeResolvers(config.Resolvers, recursors)
+		fwds.dflt = exchanger.NewForwarder(
+			fwds.targets, exchangers(timeout, "udp", "tcp"))
 	} else {
-		r.defaultFwd = exchanger.NewForwarder(
+		fwds.dflt = exchanger.NewForwarder(
 			make([]string, 0), exchangers(timeout, "udp", "tcp"))
 	}
+	if config.LocalAgent != "" && len(config.LocalAgentUpstreams) > 0 {
+		fwds.upstream = exchanger.NewForwarder(
+			config.LocalAgentUpstreams, exchangers(timeout, "udp", "tcp"))
+	}
+	return fwds
+}
 
-	return r
+// setForwarders makes the given forwarders forward the queries received from
+// now on, while the ones being forwarded keep their forwarders.
+func (res *Resolver) setForwarders(fwds *forwarders) {
+	res.fwds.Store(fwds)
+	res.fwdCache.flush()
+	logging.CurLog.Recursors.Set(int64(len(fwds.targets)))
+}
+
+// forwarder returns a Forwarder to the resolvers currently configured for the
+// given zone, or to the default ones if there are none.
+func (res *Resolver) forwarder(zone string) exchanger.Forwarder {
+	return func(m *dns.Msg, proto string) (*dns.Msg, error) {
+		fwds := res.fwds.Load().(*forwarders)
+		fwd, ok := fwds.zones[zone]
+		if !ok {
+			fwd = fwds.dflt
+		}
+		return fwd(m, proto)
+	}
 }
 
 func exchangers(timeout time.Duration, protos ...string) map[string]exchanger.Exchanger {
@@ -104,8 +222,9 @@ func (res *Resolver) Ready() <-chan struct{} {
 	return res.ready
 }
 
-// return the current (read-only) record set. attempts to write to the returned
-// object will likely result in a data race.
+// return the current (read-only) record set, a snapshot of the records last
+// generated, see records.RecordGenerator.Snapshot, but before the first reload.
+// attempts to write to the returned object will likely result in a data race.
 func (res *Resolver) records() *records.RecordGenerator {
 	res.rsLock.RLock()
 	defer res.rsLock.RUnlock()
@@ -115,23 +234,23 @@ func (res *Resolver) records() *records.RecordGenerator {
 // LaunchDNS starts a (TCP and UDP) DNS server for the Resolver,
 // returning a error channel to which errors are asynchronously sent.
 func (res *Resolver) LaunchDNS() <-chan error {
+	config := res.conf()
 	// Handers for Mesos requests
-	dns.HandleFunc(res.config.Domain+".", panicRecover(res.HandleMesos))
+	for _, domain := range config.Domains() {
+		dns.HandleFunc(domain+".", panicRecover(res.HandleMesos))
+	}
 	// Handlers for nonMesos requests
-	for zone, fwd := range res.zoneFwds {
+	for zone := range res.fwds.Load().(*forwarders).zones {
 		dns.HandleFunc(
 			zone+".",
-			panicRecover(res.HandleNonMesos(fwd)))
+			panicRecover(res.HandleNonMesos(res.forwarder(zone))))
 	}
 	dns.HandleFunc(
 		".",
-		panicRecover(res.HandleNonMesos(res.defaultFwd)))
+		panicRecover(res.HandleNonMesos(res.forwarder(""))))
 
 	errCh := make(chan error, 2)
-	_, e1 := res.Serve("tcp")
-	go func() { errCh <- <-e1 }()
-	_, e2 := res.Serve("udp")
-	go func() { errCh <- <-e2 }()
+	res.listeners.launchDNS(res, net.JoinHostPort(config.Listener, strconv.Itoa(config.Port)), errCh)
 	return errCh
 }
 
@@ -139,22 +258,37 @@ func (res *Resolver) LaunchDNS() <-chan error {
 // the returned signal chan is closed upon the server successfully entering the listening phase.
 // if the server aborts then an error is sent on the error chan.
 func (res *Resolver) Serve(proto string) (<-chan struct{}, <-chan error) {
-	defer util.HandleCrash()
+	config := res.conf()
+	_, ch, errCh := res.serve(proto, net.JoinHostPort(config.Listener, strconv.Itoa(config.Port)))
+	return ch, errCh
+}
 
-	ch := make(chan struct{})
+// serve starts a DNS server for net protocol (tcp/udp) listening on the given
+// address, as described by Serve, and returns it.
+func (res *Resolver) serve(proto, addr string) (*dns.Server, <-chan struct{}, <-chan error) {
 	server := &dns.Server{
-		Addr:              net.JoinHostPort(res.config.Listener, strconv.Itoa(res.config.Port)),
-		Net:               proto,
-		TsigSecret:        nil,
-		NotifyStartedFunc: func() { close(ch) },
+		Addr:       addr,
+		Net:        proto,
+		TsigSecret: nil,
 	}
+	ch, errCh := start(server, server.ListenAndServe)
+	return server, ch, errCh
+}
+
+// start runs the given DNS server with the given method, either
+// ListenAndServe or ActivateAndServe, as described by Serve.
+func start(server *dns.Server, serve func() error) (<-chan struct{}, <-chan error) {
+	defer util.HandleCrash()
+
+	ch := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(ch) }
 
 	errCh := make(chan error, 1)
 	go func() {
 		defer close(errCh)
-		err := server.ListenAndServe()
+		err := serve()
 		if err != nil {
-			errCh <- fmt.Errorf("Failed to setup %q server: %v", proto, err)
+			errCh <- fmt.Errorf("Failed to setup %q server: %v", server.Net, err)
 		} else {
 			logging.Error.Printf("Not listening/serving any more requests.")
 		}
@@ -163,24 +297,70 @@ func (res *Resolver) Serve(proto string) (<-chan struct{}, <-chan error) {
 }
 
 // SetMasters sets the given masters.
-// This method is not goroutine-safe.
 func (res *Resolver) SetMasters(masters []string) {
+	res.rsLock.Lock()
+	defer res.rsLock.Unlock()
 	res.masters = masters
 }
 
+// RefreshInterval returns the configured interval between state loads: the
+// resync interval when following the event stream.
+func (res *Resolver) RefreshInterval() time.Duration {
+	config := res.conf()
+	if !config.EventStream {
+		return time.Second * time.Duration(config.RefreshSeconds)
+	}
+	if config.ResyncSeconds > 0 {
+		return time.Second * time.Duration(config.ResyncSeconds)
+	}
+	return time.Second * records.DefaultResyncSeconds
+}
+
 // Reload triggers a new state load from the configured mesos masters.
 // This method is not goroutine-safe.
 func (res *Resolver) Reload() {
-	t := records.NewRecordGenerator(res.generatorOptions...)
-	err := t.ParseState(res.config, res.masters...)
+	res.rsLock.RLock()
+	options, masters := res.generatorOptions, res.masters
+	res.rsLock.RUnlock()
+
+	config := res.conf()
+	masters = res.listedMasters(config, masters)
+	t := records.NewRecordGenerator(options...)
+	err := t.ParseState(*config, masters...)
 
 	if err == nil {
-		timestamp := uint32(time.Now().Unix())
+		next := t.Snapshot()
+		// compared before locking, as it takes a while with many records
+		delta := records.Diff(res.records(), next)
+		logRecordDelta(delta)
 		// may need to refactor for fairness
 		res.rsLock.Lock()
 		defer res.rsLock.Unlock()
-		atomic.StoreUint32(&res.config.SOASerial, timestamp)
-		res.rs = t
+		atomic.StoreUint32(&res.soaSerial, res.nextSerial())
+		res.rs = next
+		res.lastDelta = &delta
+		res.fetched = masters
+		logging.CurLog.RecordSwaps.Add("full", 1)
+		logging.Verbose.Printf("generated records: %s", next.Stats)
+		logging.Verbose.Printf("generation phases: %s", next.Stats.Phases())
+		for pass, d := range next.Stats.Durations {
+			logging.CurLog.GenerationPhaseMillis.Observe(pass, float64(d)/float64(time.Millisecond))
+		}
+		logging.CurLog.GeneratedRecords.Set(int64(next.Stats.TotalRecords()))
+		setRecordGauges(next.Stats)
+		for event, sources := range next.Stats.Events {
+			for source, n := range sources {
+				logging.CurLog.GenerationEvents.Add(string(event)+"/"+source, uint64(n))
+			}
+		}
+		logging.CurLog.SkippedTasks.Set(int64(next.Stats.TotalSkipped()))
+		if next.Stats.Capped {
+			logging.CurLog.RecordsCapped.Set(1)
+		} else {
+			logging.CurLog.RecordsCapped.Set(0)
+		}
+		logging.CurLog.GenerationMillis.Set(int64(next.Stats.Duration / time.Millisecond))
+		logging.CurLog.RecordsStale.Set(0)
 		select {
 		case <-res.ready:
 			// noop because channel is already closed
@@ -189,23 +369,116 @@ func (res *Resolver) Reload() {
 		}
 	} else {
 		logging.Error.Printf("Warning: Error generating records: %v; keeping old DNS state", err)
+		failure := t.Failure
+		if failure == nil {
+			failure = &records.GenerationError{
+				Time:    res.now(),
+				Message: err.Error(),
+				Class:   records.ErrorClassGeneration,
+			}
+		}
+		res.rsLock.Lock()
+		res.lastErr = failure
+		res.rsLock.Unlock()
+		if res.stale() {
+			logging.Error.Printf("warning: serving stale records, last updated %s ago",
+				res.now().Sub(res.LastUpdated()).Truncate(time.Second))
+			logging.CurLog.RecordsStale.Set(1)
+		}
 	}
 
 	logging.PrintCurLog()
 }
 
-// formatSRV returns the SRV resource record for target
-func (res *Resolver) formatSRV(name string, target string) (*dns.SRV, error) {
-	ttl := uint32(res.config.TTL)
+// logRecordDelta logs a summary of the records changed by a reload, and the
+// records themselves in very verbose mode.
+func logRecordDelta(delta records.RecordDelta) {
+	logging.Verbose.Printf("record changes: %s", delta)
+	for _, rc := range delta.Added {
+		logging.VeryVerbose.Printf("added [%s]\t%s: %s", rc.Rtype, rc.Name, rc.Host)
+	}
+	for _, rc := range delta.Removed {
+		logging.VeryVerbose.Printf("removed [%s]\t%s: %s", rc.Rtype, rc.Name, rc.Host)
+	}
+}
 
-	h, port, err := net.SplitHostPort(target)
-	if err != nil {
-		return nil, errors.New("invalid target")
+// ApplyTaskUpdate applies the given incremental update of the records of a
+// task, see records.RecordGenerator.ApplyTaskUpdate, to the records being
+// served, swapping the updated ones in and bumping the SOA serial. Updates
+// racing a Reload are applied to the records it generated; they're
+// superseded by the next ones otherwise.
+func (res *Resolver) ApplyTaskUpdate(task state.Task, f state.Framework, op records.TaskOp) error {
+	res.updateLock.Lock()
+	defer res.updateLock.Unlock()
+	for {
+		rs := res.records()
+		next, err := rs.ApplyTaskUpdate(task, f, op)
+		if err != nil {
+			return err
+		}
+
+		res.rsLock.Lock()
+		if res.rs != rs {
+			// reloaded meanwhile
+			res.rsLock.Unlock()
+			continue
+		}
+		atomic.StoreUint32(&res.soaSerial, res.nextSerial())
+		res.rs = next.Snapshot()
+		res.rsLock.Unlock()
+
+		logging.CurLog.RecordSwaps.Add("incremental", 1)
+		logging.CurLog.GeneratedRecords.Set(int64(next.Stats.TotalRecords()))
+		setRecordGauges(next.Stats)
+		return nil
 	}
-	p, err := strconv.Atoi(port)
-	if err != nil {
-		return nil, errors.New("invalid target port")
+}
+
+// nextSerial returns the SOA serial of the next records swapped in: the
+// current time, in seconds, unless the serial is already there, e.g. bumped
+// by several updates within a second, in which case it's incremented, so that
+// it never goes backwards. It must be called with rsLock held.
+func (res *Resolver) nextSerial() uint32 {
+	serial := uint32(res.now().Unix())
+	if prev := atomic.LoadUint32(&res.soaSerial); serial <= prev {
+		serial = prev + 1
+	}
+	return serial
+}
+
+// LastUpdated returns when the records being served were generated from a
+// state, the zero time if none were yet. Failed reloads keep serving them.
+func (res *Resolver) LastUpdated() time.Time {
+	return res.records().LastUpdated()
+}
+
+// stale tells whether the records being served are older than the
+// StaleSeconds threshold or, if unset, the refresh interval.
+func (res *Resolver) stale() bool {
+	ts := res.LastUpdated()
+	if ts.IsZero() {
+		return false
+	}
+	threshold := time.Duration(res.conf().StaleSeconds) * time.Second
+	if threshold == 0 {
+		threshold = res.RefreshInterval()
+	}
+	return res.now().Sub(ts) > threshold
+}
+
+// staleness returns the number of seconds since the records being served
+// were generated, or -1 if none were yet.
+func (res *Resolver) staleness() int64 {
+	ts := res.LastUpdated()
+	if ts.IsZero() {
+		return -1
 	}
+	return int64(res.now().Sub(ts) / time.Second)
+}
+
+// formatSRV returns the SRV resource record for the given SRV data
+func (res *Resolver) formatSRV(name string, d records.SRVData) *dns.SRV {
+	ttl := uint32(res.conf().TTL)
 
 	return &dns.SRV{
 		Hdr: dns.RR_Header{
@@ -214,17 +487,17 @@ func (res *Resolver) formatSRV(name string, target string) (*dns.SRV, error) {
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Priority: 0,
-		Weight:   0,
-		Port:     uint16(p),
-		Target:   h,
-	}, nil
+		Priority: d.Priority,
+		Weight:   d.Weight,
+		Port:     d.Port,
+		Target:   d.Target,
+	}
 }
 
 // returns the A resource record for target
 // assumes target is a well formed IPv4 address
 func (res *Resolver) formatA(dom string, target string) (*dns.A, error) {
-	ttl := uint32(res.config.TTL)
+	ttl := uint32(res.conf().TTL)
 
 	a := net.ParseIP(target)
 	if a == nil {
@@ -244,7 +517,7 @@ func (res *Resolver) formatA(dom string, target string) (*dns.A, error) {
 // returns the AAAA resource record for target
 // assumes target is a well formed IPv6 address
 func (res *Resolver) formatAAAA(dom string, target string) (*dns.AAAA, error) {
-	ttl := uint32(res.config.TTL)
+	ttl := uint32(res.conf().TTL)
 
 	aaaa := net.ParseIP(target)
 	if aaaa == nil {
@@ -261,9 +534,37 @@ func (res *Resolver) formatAAAA(dom string, target string) (*dns.AAAA, error) {
 	}, nil
 }
 
+// formatPTR returns the PTR resource record of the given reverse name
+// targeting the given name.
+func (res *Resolver) formatPTR(dom string, target string) *dns.PTR {
+	return &dns.PTR{
+		Hdr: dns.RR_Header{
+			Name:   dom,
+			Rrtype: dns.TypePTR,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(res.conf().TTL)},
+		Ptr: target,
+	}
+}
+
+// formatTXT returns the TXT resource record of the given name with the given
+// character strings, in zone file presentation format.
+func (res *Resolver) formatTXT(dom string, strs string) (*dns.TXT, error) {
+	rr, err := dns.NewRR(dom + " " + strconv.Itoa(int(res.conf().TTL)) + " IN TXT " + strs)
+	if err != nil {
+		return nil, err
+	}
+	txt, ok := rr.(*dns.TXT)
+	if !ok {
+		return nil, errors.New("invalid TXT strings")
+	}
+	return txt, nil
+}
+
 // formatSOA returns the SOA resource record for the mesos domain
 func (res *Resolver) formatSOA(dom string) *dns.SOA {
-	ttl := uint32(res.config.TTL)
+	config := res.conf()
+	ttl := uint32(config.TTL)
 
 	return &dns.SOA{
 		Hdr: dns.RR_Header{
@@ -272,19 +573,20 @@ func (res *Resolver) formatSOA(dom string) *dns.SOA {
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Ns:      res.config.SOAMname,
-		Mbox:    res.config.SOARname,
-		Serial:  atomic.LoadUint32(&res.config.SOASerial),
-		Refresh: res.config.SOARefresh,
-		Retry:   res.config.SOARetry,
-		Expire:  res.config.SOAExpire,
+		Ns:      config.SOAMname,
+		Mbox:    config.SOARname,
+		Serial:  atomic.LoadUint32(&res.soaSerial),
+		Refresh: config.SOARefresh,
+		Retry:   config.SOARetry,
+		Expire:  config.SOAExpire,
 		Minttl:  ttl,
 	}
 }
 
 // formatNS returns the NS  record for the mesos domain
 func (res *Resolver) formatNS(dom string) *dns.NS {
-	ttl := uint32(res.config.TTL)
+	config := res.conf()
+	ttl := uint32(config.TTL)
 
 	return &dns.NS{
 		Hdr: dns.RR_Header{
@@ -293,7 +595,7 @@ func (res *Resolver) formatNS(dom string) *dns.NS {
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Ns: res.config.SOAMname,
+		Ns: config.SOAMname,
 	}
 }
 
@@ -309,18 +611,35 @@ func shuffleAnswers(rng *rand.Rand, answers []dns.RR) []dns.RR {
 }
 
 // HandleNonMesos handles non-mesos queries by forwarding to configured
-// external DNS servers.
+// external DNS servers, answering from the forward cache if enabled.
 func (res *Resolver) HandleNonMesos(fwd exchanger.Forwarder) func(
 	dns.ResponseWriter, *dns.Msg) {
 	return func(w dns.ResponseWriter, r *dns.Msg) {
+		start := time.Now()
 		logging.CurLog.NonMesosRequests.Inc()
-		m, err := fwd(r, w.RemoteAddr().Network())
+		src := sourceCache
+		m, ok := res.fwdCache.get(r)
+		var err error
+		if !ok {
+			src = sourceForward
+			proto := w.RemoteAddr().Network()
+			if m, err = fwd(r, proto); err == nil {
+				res.fwdCache.put(r, m, fwd, proto)
+			}
+		}
 		if err != nil {
 			m = new(dns.Msg).SetRcode(r, rcode(err))
 		} else if len(m.Answer) == 0 {
 			logging.CurLog.NonMesosNXDomain.Inc()
 		}
-		reply(w, m, res.config.SetTruncateBit)
+		reply(w, m, res.conf().SetTruncateBit)
+
+		var qtype uint16
+		if len(r.Question) > 0 {
+			qtype = r.Question[0].Qtype
+			res.talkers.observe(w.RemoteAddr(), strings.ToLower(r.Question[0].Name))
+		}
+		res.queries.observe(qtype, m.Rcode, false, src, time.Since(start))
 	}
 }
 
@@ -337,17 +656,20 @@ func rcode(err error) int {
 // question with resource answer(s)
 // it can handle {A, AAAA, SRV, ANY}
 func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	config := res.conf()
 	logging.CurLog.MesosRequests.Inc()
 
 	m := &dns.Msg{MsgHdr: dns.MsgHdr{
 		Authoritative:      true,
-		RecursionAvailable: res.config.RecurseOn,
+		RecursionAvailable: config.RecurseOn,
 	}}
 	m.SetReply(r)
 
 	var errs multiError
 	rs := res.records()
 	name := strings.ToLower(cleanWild(r.Question[0].Name))
+	res.talkers.observe(w.RemoteAddr(), name)
 	switch r.Question[0].Qtype {
 	case dns.TypeSRV:
 		errs.Add(res.handleSRV(rs, name, m, r))
@@ -355,6 +677,10 @@ func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 		errs.Add(res.handleA(rs, name, m))
 	case dns.TypeAAAA:
 		errs.Add(res.handleAAAA(rs, name, m))
+	case dns.TypePTR:
+		errs.Add(res.handlePTR(rs, name, m))
+	case dns.TypeTXT:
+		errs.Add(res.handleTXT(rs, name, m))
 	case dns.TypeSOA:
 		errs.Add(res.handleSOA(m, r))
 	case dns.TypeNS:
@@ -364,15 +690,22 @@ func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 			res.handleSRV(rs, name, m, r),
 			res.handleA(rs, name, m),
 			res.handleAAAA(rs, name, m),
+			res.handlePTR(rs, name, m),
+			res.handleTXT(rs, name, m),
 			res.handleSOA(m, r),
 			res.handleNS(m, r),
 		)
 	}
 
+	if len(m.Answer) == 0 && res.forwardUpstream(w, r, rs, name, start) {
+		return
+	}
 	if len(m.Answer) == 0 {
 		errs.Add(res.handleEmpty(rs, name, m, r))
 	} else {
-		shuffleAnswers(res.rng, m.Answer)
+		if shuffled(config.AnswerOrder, r.Question[0].Qtype) {
+			shuffleAnswers(res.rng, m.Answer)
+		}
 		logging.CurLog.MesosSuccess.Inc()
 	}
 
@@ -381,43 +714,65 @@ func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 		logging.CurLog.MesosFailed.Inc()
 	}
 
-	reply(w, m, res.config.SetTruncateBit)
+	reply(w, m, config.SetTruncateBit)
+	res.queries.observe(r.Question[0].Qtype, m.Rcode, true, sourceLocal, time.Since(start))
+}
+
+// forwardUpstream forwards a query of a name without local records to the
+// upstream Mesos-DNS servers in agent-local mode, since they're records of
+// other agents, replying with their answer. It tells whether it did.
+func (res *Resolver) forwardUpstream(w dns.ResponseWriter, r *dns.Msg, rs *records.RecordGenerator, name string, start time.Time) bool {
+	fwd := res.fwds.Load().(*forwarders).upstream
+	qtype := r.Question[0].Qtype
+	if fwd == nil || qtype == dns.TypeSOA || qtype == dns.TypeNS || rs.Exists(name) {
+		return false
+	}
+	m, err := fwd(r, w.RemoteAddr().Network())
+	if err != nil {
+		m = new(dns.Msg).SetRcode(r, rcode(err))
+	}
+	reply(w, m, res.conf().SetTruncateBit)
+	res.queries.observe(qtype, m.Rcode, true, sourceForward, time.Since(start))
+	return true
 }
 
 func (res *Resolver) handleSRV(rs *records.RecordGenerator, name string, m, r *dns.Msg) error {
 	var errs multiError
 	aAdded := map[string]struct{}{}    // track the A RR's we've already added, avoid dups
 	aaaaAdded := map[string]struct{}{} // track the AAAA RR's we've already added, avoid dups
-	for srv := range rs.SRVs[name] {
-		srvRR, err := res.formatSRV(r.Question[0].Name, srv)
+	for _, srv := range rs.Lookup(name, records.SRV) {
+		d, err := rs.SRVData(srv)
 		if err != nil {
 			errs.Add(err)
 			continue
 		}
+		srvRR := res.formatSRV(r.Question[0].Name, d)
+		setTTL(rs, srv, srvRR)
 
 		m.Answer = append(m.Answer, srvRR)
-		host, _, err := net.SplitHostPort(srv)
-		if err != nil {
-			logging.Error.Println(err)
-		}
-		if len(rs.As[host]) == 0 && len(rs.AAAAs[host]) == 0 {
+		host := d.Target
+		if !rs.Exists(host) {
 			continue
 		}
+		// the glue lists every address of the target, which is shared by
+		// the instances of a task when SRV records target short names
 		if _, aFound := aAdded[host]; !aFound {
-			if a, ok := rs.As.First(host); ok {
-				if aRR, err := res.formatA(host, a); err == nil {
+			aAdded[host] = struct{}{}
+			for _, a := range rs.Lookup(host, records.A) {
+				if aRR, err := res.formatA(host, a.Host); err == nil {
+					setTTL(rs, a, aRR)
 					m.Extra = append(m.Extra, aRR)
-					aAdded[host] = struct{}{}
 				} else {
 					errs.Add(err)
 				}
 			}
 		}
 		if _, aaaaFound := aaaaAdded[host]; !aaaaFound {
-			if aaaa, ok := rs.AAAAs.First(host); ok {
-				if aaaaRR, err := res.formatAAAA(host, aaaa); err == nil {
+			aaaaAdded[host] = struct{}{}
+			for _, aaaa := range rs.Lookup(host, records.AAAA) {
+				if aaaaRR, err := res.formatAAAA(host, aaaa.Host); err == nil {
+					setTTL(rs, aaaa, aaaaRR)
 					m.Extra = append(m.Extra, aaaaRR)
-					aaaaAdded[host] = struct{}{}
 				} else {
 					errs.Add(err)
 				}
@@ -429,12 +784,39 @@ func (res *Resolver) handleSRV(rs *records.RecordGenerator, name string, m, r *d
 
 func (res *Resolver) handleA(rs *records.RecordGenerator, name string, m *dns.Msg) error {
 	var errs multiError
-	for a := range rs.As[name] {
-		rr, err := res.formatA(name, a)
+	var answers []dns.RR
+	for _, a := range rs.Lookup(name, records.A) {
+		rr, err := res.formatA(name, a.Host)
 		if err != nil {
 			errs.Add(err)
 			continue
 		}
+		setTTL(rs, a, rr)
+		answers = append(answers, rr)
+	}
+	res.orderAddresses(res.conf().AnswerOrder, name, dns.TypeA, answers)
+	m.Answer = append(m.Answer, answers...)
+	return errs
+}
+
+func (res *Resolver) handlePTR(rs *records.RecordGenerator, name string, m *dns.Msg) error {
+	for _, ptr := range rs.Lookup(name, records.PTR) {
+		rr := res.formatPTR(name, ptr.Host)
+		setTTL(rs, ptr, rr)
+		m.Answer = append(m.Answer, rr)
+	}
+	return nil
+}
+
+func (res *Resolver) handleTXT(rs *records.RecordGenerator, name string, m *dns.Msg) error {
+	var errs multiError
+	for _, txt := range rs.Lookup(name, records.TXT) {
+		rr, err := res.formatTXT(name, txt.Host)
+		if err != nil {
+			errs.Add(err)
+			continue
+		}
+		setTTL(rs, txt, rr)
 		m.Answer = append(m.Answer, rr)
 	}
 	return errs
@@ -442,17 +824,30 @@ func (res *Resolver) handleA(rs *records.RecordGenerator, name string, m *dns.Ms
 
 func (res *Resolver) handleAAAA(rs *records.RecordGenerator, name string, m *dns.Msg) error {
 	var errs multiError
-	for aaaa := range rs.AAAAs[name] {
-		rr, err := res.formatAAAA(name, aaaa)
+	var answers []dns.RR
+	for _, aaaa := range rs.Lookup(name, records.AAAA) {
+		rr, err := res.formatAAAA(name, aaaa.Host)
 		if err != nil {
 			errs.Add(err)
 			continue
 		}
-		m.Answer = append(m.Answer, rr)
+		setTTL(rs, aaaa, rr)
+		answers = append(answers, rr)
 	}
+	res.orderAddresses(res.conf().AnswerOrder, name, dns.TypeAAAA, answers)
+	m.Answer = append(m.Answer, answers...)
 	return errs
 }
 
+// setTTL sets the TTL of the given answer of the given record to the one the
+// record got when the records were generated, as per the TTL overrides,
+// framework domains, TTL labels and health checks, if not the global one.
+func setTTL(rs *records.RecordGenerator, rec records.Record, rr dns.RR) {
+	if ttl, ok := rs.TTL(rec); ok {
+		rr.Header().Ttl = ttl
+	}
+}
+
 func (res *Resolver) handleSOA(m, r *dns.Msg) error {
 	m.Ns = append(m.Ns, res.formatSOA(r.Question[0].Name))
 	return nil
@@ -476,13 +871,13 @@ func (res *Resolver) handleEmpty(rs *records.RecordGenerator, name string, m, r
 	// The second component is just a matter of returning NODATA if we have
 	// SRV or A records for the given name, but no neccessarily the given query
 
-	if len(rs.SRVs[name])+len(rs.As[name])+len(rs.AAAAs[name]) > 0 {
+	if rs.Exists(name) {
 		m.Rcode = dns.RcodeSuccess
 	}
 
 	logging.CurLog.MesosNXDomain.Inc()
-	logging.VeryVerbose.Println("total A rrs:\t" + strconv.Itoa(len(rs.As)))
-	logging.VeryVerbose.Println("total AAAA rrs:\t" + strconv.Itoa(len(rs.AAAAs)))
+	logging.VeryVerbose.Println("total A rrs:\t" + strconv.Itoa(rs.As.Len()))
+	logging.VeryVerbose.Println("total AAAA rrs:\t" + strconv.Itoa(rs.AAAAs.Len()))
 	logging.VeryVerbose.Println("failed looking for " + r.Question[0].String())
 
 	m.Ns = append(m.Ns, res.formatSOA(r.Question[0].Name))
@@ -582,48 +977,164 @@ func truncateAnswers(m *dns.Msg, max uint16) {
 }
 
 func (res *Resolver) configureHTTP() {
+	config := res.conf()
+
 	// webserver + available routes
 	ws := new(restful.WebService)
 	ws.Consumes(restful.MIME_JSON)
 	ws.Produces(restful.MIME_JSON)
+	ws.Filter(timeHTTP)
 
 	ws.Route(ws.GET("/v1/version").To(res.RestVersion))
+	ws.Route(ws.GET("/v1/ready").To(res.RestReady))
+	ws.Route(ws.GET("/v1/checksum").To(res.RestChecksum))
 	ws.Route(ws.GET("/v1/config").To(res.RestConfig))
+	ws.Route(ws.GET("/v1/reload").To(res.RestLastReload))
+	ws.Route(ws.POST("/v1/reload").Consumes("*/*").To(res.RestReload))
+	ws.Route(ws.GET("/v1/stats").To(res.RestStats))
+	ws.Route(ws.GET("/v1/masters").To(res.RestMasters))
+	ws.Route(ws.GET("/v1/queries").To(res.RestQueries))
+	if config.TopTalkersOn {
+		ws.Route(ws.GET("/v1/debug/toptalkers").To(res.RestTopTalkers))
+	}
 	ws.Route(ws.GET("/v1/hosts/{host}").To(res.RestHost))
 	ws.Route(ws.GET("/v1/hosts/{host}/ports").To(res.RestPorts))
 	ws.Route(ws.GET("/v1/services/{service}").To(res.RestService))
-	if res.config.EnumerationOn {
+	if config.EnumerationOn {
 		ws.Route(ws.GET("/v1/enumerate").To(res.RestEnumerate))
 		ws.Route(ws.GET("/v1/axfr").To(res.RestAXFR))
+		ws.Route(ws.GET("/v1/collisions").To(res.RestCollisions))
+		ws.Route(ws.GET("/v1/records/diff/latest").To(res.RestRecordDelta))
 	}
 	restful.Add(ws)
 }
 
+// timeHTTP is a filter accounting for the duration of HTTP requests, per
+// route.
+func timeHTTP(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	start := time.Now()
+	chain.ProcessFilter(req, resp)
+	elapsed := time.Since(start)
+	logging.CurLog.HTTPRequestMillis.Observe(req.SelectedRoutePath(), float64(elapsed)/float64(time.Millisecond))
+}
+
 // LaunchHTTP starts an HTTP server for the Resolver, returning a error channel
 // to which errors are asynchronously sent.
 func (res *Resolver) LaunchHTTP() <-chan error {
 	defer util.HandleCrash()
 
 	res.configureHTTP()
-	listenAddress := net.JoinHostPort(res.config.HTTPListener, strconv.Itoa(res.config.HTTPPort))
+	config := res.conf()
+	listenAddress := net.JoinHostPort(config.HTTPListener, strconv.Itoa(config.HTTPPort))
 
 	errCh := make(chan error, 1)
-	go func() {
-		var err error
-		defer func() { errCh <- err }()
-
-		if err = http.ListenAndServe(listenAddress, nil); err != nil {
-			err = fmt.Errorf("Failed to setup http server: %v", err)
-		} else {
-			logging.Error.Println("Not serving http requests any more.")
-		}
-	}()
+	res.listeners.launchHTTP(listenAddress, errCh)
 	return errCh
 }
 
 // RestConfig handles HTTP requests of Resolver configuration.
 func (res *Resolver) RestConfig(req *restful.Request, resp *restful.Response) {
-	if err := resp.WriteAsJson(res.config); err != nil {
+	config := *res.conf()
+	config.SOASerial = atomic.LoadUint32(&res.soaSerial)
+	if err := resp.WriteAsJson(config); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
+// readiness is the body of the readiness endpoint.
+type readiness struct {
+	Ready            bool                     `json:"ready"`
+	LastSuccess      *time.Time               `json:"last_success"`
+	StalenessSeconds int64                    `json:"staleness_seconds"`
+	Stale            bool                     `json:"stale"`
+	LastError        *records.GenerationError `json:"last_error"`
+}
+
+// RestReady handles HTTP requests of the Resolver readiness: it responds
+// with 503 Service Unavailable until records were generated, along with the
+// staleness of the records being served and the last generation error.
+func (res *Resolver) RestReady(req *restful.Request, resp *restful.Response) {
+	res.rsLock.RLock()
+	ts, lastErr := res.rs.Timestamp, res.lastErr
+	res.rsLock.RUnlock()
+
+	body := readiness{
+		StalenessSeconds: res.staleness(),
+		Stale:            res.stale(),
+		LastError:        lastErr,
+	}
+	select {
+	case <-res.ready:
+		body.Ready = true
+	default:
+	}
+	if !ts.IsZero() {
+		body.LastSuccess = &ts
+	}
+
+	if !body.Ready {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := resp.WriteAsJson(body); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
+// zoneChecksum is the body of the checksum endpoint.
+type zoneChecksum struct {
+	Checksum  string     `json:"checksum"`
+	Serial    uint32     `json:"serial"`
+	Generated *time.Time `json:"generated"`
+	Leader    string     `json:"leader"`
+}
+
+// RestChecksum handles HTTP requests of the checksum of the records being
+// served, along with the SOA serial, the time they were generated and the
+// leader of the state they were generated from, for replicas to be checked
+// for consistency.
+func (res *Resolver) RestChecksum(req *restful.Request, resp *restful.Response) {
+	rs := res.records()
+	body := zoneChecksum{
+		Checksum: rs.Checksum(),
+		Serial:   atomic.LoadUint32(&res.soaSerial),
+		Leader:   rs.Leader,
+	}
+	if ts := rs.Timestamp; !ts.IsZero() {
+		body.Generated = &ts
+	}
+	if err := resp.WriteAsJson(body); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
+// RestStats handles HTTP requests of the statistics of the last record
+// generation.
+func (res *Resolver) RestStats(req *restful.Request, resp *restful.Response) {
+	if err := resp.WriteAsJson(res.records().Stats); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
+// RestMasters handles HTTP requests of the state fetch outcomes per Mesos
+// master.
+func (res *Resolver) RestMasters(req *restful.Request, resp *restful.Response) {
+	if err := resp.WriteAsJson(res.records().Masters()); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
+// RestQueries handles HTTP requests of the DNS queries served so far, counted
+// by qtype, rcode, zone and answer source.
+func (res *Resolver) RestQueries(req *restful.Request, resp *restful.Response) {
+	if err := resp.WriteAsJson(res.queries.buckets()); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
+// RestTopTalkers handles HTTP requests of the clients and query names seen
+// most frequently over the last minute and the last five minutes.
+func (res *Resolver) RestTopTalkers(req *restful.Request, resp *restful.Response) {
+	if err := resp.WriteAsJson(res.talkers.top(topTalkersListed)); err != nil {
 		logging.Error.Println(err)
 	}
 }
@@ -637,23 +1148,57 @@ func (res *Resolver) RestEnumerate(req *restful.Request, resp *restful.Response)
 	}
 }
 
+// RestCollisions handles HTTP requests of the record names that were generated
+// by more than one framework during the last generation.
+func (res *Resolver) RestCollisions(req *restful.Request, resp *restful.Response) {
+	collisions := res.records().EnumData.Collisions
+	if collisions == nil {
+		collisions = []records.Collision{}
+	}
+	if err := resp.WriteAsJson(collisions); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
+// RestRecordDelta handles HTTP requests of the records added and removed by
+// the last reload, empty before the first one. Incremental updates since
+// aren't accounted for.
+func (res *Resolver) RestRecordDelta(req *restful.Request, resp *restful.Response) {
+	res.rsLock.RLock()
+	delta := res.lastDelta
+	res.rsLock.RUnlock()
+	if delta == nil {
+		delta = &records.RecordDelta{
+			Added:   []records.RecordChange{},
+			Removed: []records.RecordChange{},
+			Summary: map[string]records.ChangeCount{},
+		}
+	}
+	if err := resp.WriteAsJson(delta); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
 // RestAXFR handles HTTP requests to turn the zone into a transferable format
 func (res *Resolver) RestAXFR(req *restful.Request, resp *restful.Response) {
 	records := res.records()
+	config := res.conf()
 
 	AXFRRecords := models.AXFRRecords{
 		SRVs:  records.SRVs.ToAXFRResourceRecordSet(),
 		As:    records.As.ToAXFRResourceRecordSet(),
 		AAAAs: records.AAAAs.ToAXFRResourceRecordSet(),
+		PTRs:  records.PTRs.ToAXFRResourceRecordSet(),
+		TXTs:  records.TXTs.ToAXFRResourceRecordSet(),
 	}
 	AXFR := models.AXFR{
 		Records:        AXFRRecords,
-		Serial:         atomic.LoadUint32(&res.config.SOASerial),
-		Mname:          res.config.SOAMname,
-		Rname:          res.config.SOARname,
-		TTL:            res.config.TTL,
-		RefreshSeconds: res.config.RefreshSeconds,
-		Domain:         res.config.Domain,
+		Serial:         atomic.LoadUint32(&res.soaSerial),
+		Mname:          config.SOAMname,
+		Rname:          config.SOARname,
+		TTL:            config.TTL,
+		RefreshSeconds: config.RefreshSeconds,
+		Domain:         config.Domain,
 	}
 
 	if err := resp.WriteAsJson(AXFR); err != nil {
@@ -661,12 +1206,33 @@ func (res *Resolver) RestAXFR(req *restful.Request, resp *restful.Response) {
 	}
 }
 
+// setRecordGauges exports the record counts per kind and per source of the
+// given generation.
+func setRecordGauges(st records.GenerationStats) {
+	for gauge, n := range map[logging.Gauge]int{
+		logging.CurLog.ARecords:         st.Records[string(records.A)],
+		logging.CurLog.AAAARecords:      st.Records[string(records.AAAA)],
+		logging.CurLog.SRVRecords:       st.Records[records.SRV],
+		logging.CurLog.FrameworkRecords: st.Sources[records.SourceFramework],
+		logging.CurLog.SlaveRecords:     st.Sources[records.SourceSlave],
+		logging.CurLog.MasterRecords:    st.Sources[records.SourceMaster],
+		logging.CurLog.ListenerRecords:  st.Sources[records.SourceListener],
+		logging.CurLog.TaskRecords:      st.Sources[records.SourceTask],
+		logging.CurLog.StaticRecords:    st.Sources[records.SourceStatic],
+	} {
+		gauge.Set(int64(n))
+	}
+}
+
 // RestVersion handles HTTP requests of Mesos-DNS version.
 func (res *Resolver) RestVersion(req *restful.Request, resp *restful.Response) {
-	err := resp.WriteAsJson(map[string]string{
-		"Service": "Mesos-DNS",
-		"Version": res.version,
-		"URL":     "https://github.com/mesosphere/mesos-dns",
+	stats := res.records().Stats
+	err := resp.WriteAsJson(map[string]interface{}{
+		"Service":       "Mesos-DNS",
+		"Version":       res.version,
+		"URL":           "https://github.com/mesosphere/mesos-dns",
+		"Records":       stats.Records,
+		"RecordSources": stats.Sources,
 	})
 	if err != nil {
 		logging.Error.Println(err)
@@ -688,14 +1254,11 @@ func (res *Resolver) RestHost(req *restful.Request, resp *restful.Response) {
 		IP   string `json:"ip"`
 	}
 
-	aRRs := rs.As[dom]
-	aaaaRRs := rs.AAAAs[dom]
+	aRRs := rs.Lookup(dom, records.A)
+	aaaaRRs := rs.Lookup(dom, records.AAAA)
 	records := make([]record, 0, len(aRRs)+len(aaaaRRs))
-	for ip := range aRRs {
-		records = append(records, record{dom, ip})
-	}
-	for ip := range aaaaRRs {
-		records = append(records, record{dom, ip})
+	for _, rr := range append(aRRs, aaaaRRs...) {
+		records = append(records, record{dom, rr.Host})
 	}
 
 	if len(records) == 0 {
@@ -706,21 +1269,25 @@ func (res *Resolver) RestHost(req *restful.Request, resp *restful.Response) {
 		logging.Error.Println(err)
 	}
 
-	stats(dom, res.config.Domain+".", len(aRRs) > 0)
+	stats(dom, res.conf().Domains(), len(aRRs) > 0)
 }
 
-func stats(domain, zone string, success bool) {
-	if strings.HasSuffix(domain, zone) {
-		logging.CurLog.MesosRequests.Inc()
-		if success {
-			logging.CurLog.MesosSuccess.Inc()
-		} else {
-			logging.CurLog.MesosNXDomain.Inc()
+// stats counts an HTTP request of the given name as a Mesos request if it's
+// within any of the given domains, or as a failed non-Mesos one otherwise.
+func stats(name string, domains []string, success bool) {
+	for _, domain := range domains {
+		if strings.HasSuffix(name, domain+".") {
+			logging.CurLog.MesosRequests.Inc()
+			if success {
+				logging.CurLog.MesosSuccess.Inc()
+			} else {
+				logging.CurLog.MesosNXDomain.Inc()
+			}
+			return
 		}
-	} else {
-		logging.CurLog.NonMesosRequests.Inc()
-		logging.CurLog.NonMesosFailed.Inc()
 	}
+	logging.CurLog.NonMesosRequests.Inc()
+	logging.CurLog.NonMesosFailed.Inc()
 }
 
 // RestPorts is an HTTP handler which is currently not implemented.
@@ -748,31 +1315,32 @@ func (res *Resolver) RestService(req *restful.Request, resp *restful.Response) {
 		Port    string `json:"port"`
 	}
 
-	srvRRs := rs.SRVs[dom]
-	records := make([]record, 0, len(srvRRs))
-	for s := range srvRRs {
-		host, port, err := net.SplitHostPort(s)
+	srvRRs := rs.Lookup(dom, records.SRV)
+	recs := make([]record, 0, len(srvRRs))
+	for _, s := range srvRRs {
+		d, err := records.ParseSRV(s.Host)
 		if err != nil {
 			logging.Error.Println(err)
 			continue
 		}
-		if aR, aOk := rs.As.First(host); aOk {
-			records = append(records, record{service, host, aR, port})
+		host, port := d.Target, strconv.Itoa(int(d.Port))
+		for _, aR := range rs.Lookup(host, records.A) {
+			recs = append(recs, record{service, host, aR.Host, port})
 		}
-		if aaaaR, aaaaOk := rs.AAAAs.First(host); aaaaOk {
-			records = append(records, record{service, host, aaaaR, port})
+		for _, aaaaR := range rs.Lookup(host, records.AAAA) {
+			recs = append(recs, record{service, host, aaaaR.Host, port})
 		}
 	}
 
-	if len(records) == 0 {
-		records = append(records, record{})
+	if len(recs) == 0 {
+		recs = append(recs, record{})
 	}
 
-	if err := resp.WriteAsJson(records); err != nil {
+	if err := resp.WriteAsJson(recs); err != nil {
 		logging.Error.Println(err)
 	}
 
-	stats(dom, res.config.Domain+".", len(srvRRs) > 0)
+	stats(dom, res.conf().Domains(), len(srvRRs) > 0)
 }
 
 // panicRecover catches any panics from the resolvers and sets an error
@@ -1,22 +1,32 @@
 package resolver
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/emicklei/go-restful"
 	"github.com/kylelemons/godebug/pretty"
 	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/exchanger"
 	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/mesostest"
 	"github.com/mesosphere/mesos-dns/records"
 	"github.com/mesosphere/mesos-dns/records/labels"
 	"github.com/mesosphere/mesos-dns/records/state"
@@ -75,6 +85,54 @@ func TestShuffleAnswers(t *testing.T) {
 	}
 }
 
+func TestShuffleAnswers_Seeded(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same source yields the same orderings, query after query
+	for i, want := range [][]string{
+		{"1.2.3.10", "1.2.3.11", "1.2.3.12"},
+		{"1.2.3.11", "1.2.3.12", "1.2.3.10"},
+		{"1.2.3.11", "1.2.3.12", "1.2.3.10"},
+	} {
+		if got := answerIPs(res, "slave.mesos."); !reflect.DeepEqual(got, want) {
+			t.Errorf("query %d: got answers %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestShuffleAnswers_EverySeed(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := map[string]int{}
+	for seed := int64(0); seed < 100; seed++ {
+		res.rng.Seed(seed)
+		first[answerIPs(res, "slave.mesos.")[0]]++
+	}
+	for _, ip := range res.rs.As.Hosts("slave.mesos.") {
+		if first[ip] == 0 {
+			t.Errorf("%s never answered first over 100 seeds: %v", ip, first)
+		}
+	}
+}
+
+// answerIPs returns the addresses the given resolver answers a query of the
+// A records of the given name with, in order.
+func answerIPs(res *Resolver, name string) []string {
+	var rw ResponseRecorder
+	res.HandleMesos(&rw, Message(Question(name, dns.TypeA)))
+	ips := make([]string, 0, len(rw.Msg.Answer))
+	for _, rr := range rw.Msg.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			ips = append(ips, a.A.String())
+		}
+	}
+	return ips
+}
+
 func TestHandlers(t *testing.T) {
 	if err := runHandlers(); err != nil {
 		t.Error(err)
@@ -89,160 +147,1267 @@ func BenchmarkHandlers(b *testing.B) {
 	}
 }
 
-func runHandlers() error {
-	res, err := fakeDNS()
+func runHandlers() error {
+	res, err := fakeDNS()
+	if err != nil {
+		return err
+	}
+	fwd := func(m *dns.Msg, net string) (*dns.Msg, error) {
+		rr1, err := res.formatA("google.com.", "1.1.1.1")
+		if err != nil {
+			return nil, err
+		}
+		rr2, err := res.formatA("google.com.", "2.2.2.2")
+		if err != nil {
+			return nil, err
+		}
+		msg := &dns.Msg{Answer: []dns.RR{rr1, rr2}}
+		msg.SetReply(m)
+		return msg, nil
+	}
+
+	for i, tt := range []struct {
+		dns.HandlerFunc
+		*dns.Msg
+	}{
+		{
+			res.HandleMesos,
+			Message(
+				Question("chronos.marathon.mesos.", dns.TypeA),
+				Header(true, dns.RcodeSuccess),
+				Answers(
+					A(RRHeader("chronos.marathon.mesos.", dns.TypeA, 60),
+						net.ParseIP("1.2.3.11")))),
+		},
+		{ // case insensitive
+			res.HandleMesos,
+			Message(
+				Question("cHrOnOs.MARATHON.mesoS.", dns.TypeA),
+				Header(true, dns.RcodeSuccess),
+				Answers(
+					A(RRHeader("chronos.marathon.mesos.", dns.TypeA, 60),
+						net.ParseIP("1.2.3.11")))),
+		},
+		{ // ipv6
+			res.HandleMesos,
+			Message(
+				Question("toy-store.ipv6-framework.mesos.", dns.TypeAAAA),
+				Header(true, dns.RcodeSuccess),
+				Answers(
+					AAAA(RRHeader("toy-store.ipv6-framework.mesos.", dns.TypeAAAA, 60),
+						net.ParseIP("fd01:b::1:8000:2")))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV),
+				Header(true, dns.RcodeSuccess),
+				Answers(
+					SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 60),
+						"liquor-store-4dfjd-0.marathon.mesos.", 443, 0, 0),
+					SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 60),
+						"liquor-store-zasmd-1.marathon.mesos.", 80, 0, 0),
+					SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 60),
+						"liquor-store-zasmd-1.marathon.mesos.", 443, 0, 0),
+					SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 60),
+						"liquor-store-4dfjd-0.marathon.mesos.", 80, 0, 0)),
+				Extras(
+					A(RRHeader("liquor-store-4dfjd-0.marathon.mesos.", dns.TypeA, 60),
+						net.ParseIP("10.3.0.1")),
+					A(RRHeader("liquor-store-zasmd-1.marathon.mesos.", dns.TypeA, 60),
+						net.ParseIP("10.3.0.2")))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("_car-store._udp.marathon.mesos.", dns.TypeSRV),
+				Header(true, dns.RcodeSuccess),
+				Answers(
+					SRV(RRHeader("_car-store._udp.marathon.mesos.", dns.TypeSRV, 60),
+						"car-store-zinaz-0.marathon.slave.mesos.", 31365, 0, 0),
+					SRV(RRHeader("_car-store._udp.marathon.mesos.", dns.TypeSRV, 60),
+						"car-store-zinaz-0.marathon.slave.mesos.", 31364, 0, 0)),
+				Extras(
+					A(RRHeader("car-store-zinaz-0.marathon.slave.mesos.", dns.TypeA, 60),
+						net.ParseIP("1.2.3.11")))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("_car-store._udp.marathon.mesos.", dns.TypeA),
+				Header(true, dns.RcodeSuccess),
+				NSs(
+					SOA(RRHeader("_car-store._udp.marathon.mesos.", dns.TypeSOA, 60),
+						"ns1.mesos", "root.ns1.mesos", 60))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("non-existing.mesos.", dns.TypeSOA),
+				Header(true, dns.RcodeSuccess),
+				NSs(
+					SOA(RRHeader("non-existing.mesos.", dns.TypeSOA, 60),
+						"ns1.mesos", "root.ns1.mesos", 60))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("non-existing.mesos.", dns.TypeNS),
+				Header(true, dns.RcodeSuccess),
+				NSs(
+					NS(RRHeader("non-existing.mesos.", dns.TypeNS, 60), "ns1.mesos"))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("missing.mesos.", dns.TypeA),
+				Header(true, dns.RcodeNameError),
+				NSs(
+					SOA(RRHeader("missing.mesos.", dns.TypeSOA, 60),
+						"ns1.mesos", "root.ns1.mesos", 60))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("chronos.marathon.mesos.", dns.TypeAAAA),
+				Header(true, dns.RcodeSuccess),
+				NSs(
+					SOA(RRHeader("chronos.marathon.mesos.", dns.TypeSOA, 60),
+						"ns1.mesos", "root.ns1.mesos", 60))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("missing.mesos.", dns.TypeAAAA),
+				Header(true, dns.RcodeNameError),
+				NSs(
+					SOA(RRHeader("missing.mesos.", dns.TypeSOA, 60),
+						"ns1.mesos", "root.ns1.mesos", 60))),
+		},
+		{
+			res.HandleNonMesos(fwd),
+			Message(
+				Question("google.com.", dns.TypeA),
+				Header(false, dns.RcodeSuccess),
+				Answers(
+					A(RRHeader("google.com.", dns.TypeA, 60), net.ParseIP("1.1.1.1")),
+					A(RRHeader("google.com.", dns.TypeA, 60), net.ParseIP("2.2.2.2")))),
+		},
+	} {
+		var rw ResponseRecorder
+		tt.HandlerFunc(&rw, tt.Msg)
+		if got, want := rw.Msg, tt.Msg; !(Msg{got}).equivalent(Msg{want}) {
+			return fmt.Errorf("test #%d\n%v\n%s", i, pretty.Sprint(tt.Msg.Question), pretty.Compare(got, want))
+		}
+	}
+	return nil
+}
+
+func TestQueryStats(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := func(m *dns.Msg, net string) (*dns.Msg, error) {
+		rr, err := res.formatA("google.com.", "1.1.1.1")
+		if err != nil {
+			return nil, err
+		}
+		msg := &dns.Msg{Answer: []dns.RR{rr}}
+		msg.SetReply(m)
+		return msg, nil
+	}
+	refuse := func(*dns.Msg, string) (*dns.Msg, error) {
+		return nil, &exchanger.ForwardError{Proto: "udp"}
+	}
+	fail := func(*dns.Msg, string) (*dns.Msg, error) {
+		return nil, errors.New("boom")
+	}
+
+	for _, tt := range []struct {
+		handler dns.HandlerFunc
+		name    string
+		qtype   uint16
+		want    string
+	}{
+		{res.HandleMesos, "chronos.marathon.mesos.", dns.TypeA, "A NOERROR authoritative local"},
+		{res.HandleMesos, "missing.mesos.", dns.TypeA, "A NXDOMAIN authoritative local"},
+		{res.HandleMesos, "toy-store.ipv6-framework.mesos.", dns.TypeAAAA, "AAAA NOERROR authoritative local"},
+		{res.HandleMesos, "missing.mesos.", dns.TypeAAAA, "AAAA NXDOMAIN authoritative local"},
+		{res.HandleMesos, "_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, "SRV NOERROR authoritative local"},
+		{res.HandleMesos, "mesos.", dns.TypeSOA, "SOA NOERROR authoritative local"},
+		{res.HandleMesos, "mesos.", dns.TypeNS, "NS NOERROR authoritative local"},
+		{res.HandleMesos, "chronos.marathon.mesos.", dns.TypeANY, "ANY NOERROR authoritative local"},
+		{res.HandleMesos, "missing.mesos.", dns.TypeMX, "other NXDOMAIN authoritative local"},
+		{res.HandleNonMesos(answer), "google.com.", dns.TypeA, "A NOERROR external forward"},
+		{res.HandleNonMesos(answer), "google.com.", dns.TypeTXT, "other NOERROR external forward"},
+		{res.HandleNonMesos(refuse), "google.com.", dns.TypeA, "A REFUSED external forward"},
+		{res.HandleNonMesos(fail), "google.com.", dns.TypeSRV, "SRV SERVFAIL external forward"},
+	} {
+		var rw ResponseRecorder
+		tt.handler(&rw, new(dns.Msg).SetQuestion(tt.name, tt.qtype))
+	}
+
+	want := map[string]bool{
+		"A NOERROR authoritative local": true, "A NXDOMAIN authoritative local": true,
+		"AAAA NOERROR authoritative local": true, "AAAA NXDOMAIN authoritative local": true,
+		"SRV NOERROR authoritative local": true, "SOA NOERROR authoritative local": true,
+		"NS NOERROR authoritative local": true, "ANY NOERROR authoritative local": true,
+		"other NXDOMAIN authoritative local": true, "A NOERROR external forward": true,
+		"other NOERROR external forward": true, "A REFUSED external forward": true,
+		"SRV SERVFAIL external forward": true,
+	}
+	buckets := res.queries.buckets()
+	for _, b := range buckets {
+		key := strings.Join([]string{b.Qtype, b.Rcode, b.Zone, b.Source}, " ")
+		if !want[key] {
+			t.Errorf("unexpected bucket %q", key)
+			continue
+		}
+		delete(want, key)
+		var latencies uint64
+		for _, n := range b.Latency {
+			latencies += n
+		}
+		if b.Count != 1 || latencies != 1 {
+			t.Errorf("bucket %q: got count %d and %d latencies, want 1", key, b.Count, latencies)
+		}
+	}
+	for key := range want {
+		t.Errorf("missing bucket %q", key)
+	}
+
+	var s queryStats
+	if allocs := testing.AllocsPerRun(100, func() {
+		s.observe(dns.TypeSRV, dns.RcodeSuccess, true, sourceLocal, time.Millisecond)
+	}); allocs != 0 {
+		t.Errorf("observe allocated %v times per query", allocs)
+	}
+}
+
+func TestForwardCache(t *testing.T) {
+	now := time.Unix(1e9, 0)
+	c := newFwdCache(2, 0, func() time.Time { return now })
+	answer := func(name string, rcode int, ttl uint32) *dns.Msg {
+		m := new(dns.Msg).SetRcode(new(dns.Msg).SetQuestion(name, dns.TypeA), rcode)
+		if rcode == dns.RcodeSuccess {
+			m.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: net.ParseIP("10.1.1.1")}}
+		}
+		m.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 30}}}
+		return m
+	}
+	truncated := answer("tc.example.com.", dns.RcodeSuccess, 60)
+	truncated.Truncated = true
+	for _, m := range []*dns.Msg{
+		answer("a.example.com.", dns.RcodeSuccess, 60),
+		answer("missing.example.com.", dns.RcodeNameError, 0),
+		answer("fail.example.com.", dns.RcodeServerFailure, 0),
+		answer("zero.example.com.", dns.RcodeSuccess, 0),
+		truncated,
+	} {
+		c.put(m, m, nil, "udp")
+	}
+	now = now.Add(10 * time.Second)
+
+	for i, tt := range []struct {
+		name string
+		ttl  uint32 // of the first record, 0 if not cached
+	}{
+		{"A.example.com.", 50},       // less the 10s it was cached for
+		{"missing.example.com.", 20}, // NXDOMAIN
+		{"fail.example.com.", 0},
+		{"zero.example.com.", 0},
+		{"tc.example.com.", 0},
+	} {
+		q := new(dns.Msg).SetQuestion(tt.name, dns.TypeA)
+		m, ok := c.get(q)
+		if got := ok && len(m.Answer)+len(m.Ns) > 0; got != (tt.ttl > 0) {
+			t.Errorf("test #%d: got cached %t, want %t", i, got, tt.ttl > 0)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		rrs := append(m.Answer, m.Ns...)
+		if m.Id != q.Id || m.Question[0].Name != tt.name || rrs[0].Header().Ttl != tt.ttl {
+			t.Errorf("test #%d: got id %d, question %v and TTL %d, want %d, %s and %d",
+				i, m.Id, m.Question[0].Name, rrs[0].Header().Ttl, q.Id, tt.name, tt.ttl)
+		}
+	}
+
+	// the least recently used entry is evicted
+	c.get(new(dns.Msg).SetQuestion("a.example.com.", dns.TypeA))
+	b := answer("b.example.com.", dns.RcodeSuccess, 60)
+	c.put(b, b, nil, "udp")
+	for name, want := range map[string]bool{"a.example.com.": true, "missing.example.com.": false, "b.example.com.": true} {
+		if _, ok := c.get(new(dns.Msg).SetQuestion(name, dns.TypeA)); ok != want {
+			t.Errorf("got cached %t for %s, want %t", ok, name, want)
+		}
+	}
+
+	// entries expire with their smallest TTL, that of the SOA
+	now = now.Add(20 * time.Second)
+	if _, ok := c.get(new(dns.Msg).SetQuestion("a.example.com.", dns.TypeA)); ok {
+		t.Error("got an expired entry")
+	}
+}
+
+func TestForwardCache_Prefetch(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1e9, 0)
+	res.fwdCache = newFwdCache(16, 3, func() time.Time { return now })
+	var (
+		forwarded int
+		down      bool
+	)
+	upstream := func(r *dns.Msg, proto string) (*dns.Msg, error) {
+		forwarded++
+		if down {
+			return nil, errors.New("recursor down")
+		}
+		m := new(dns.Msg).SetReply(r)
+		m.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 100},
+			A:   net.ParseIP("10.1.1.1"),
+		}}
+		return m, nil
+	}
+	handler := res.HandleNonMesos(upstream)
+	query := func() *dns.Msg {
+		var rw ResponseRecorder
+		handler(&rw, new(dns.Msg).SetQuestion("store.example.com.", dns.TypeA))
+		res.fwdCache.prefetchQueued()
+		return rw.Msg
+	}
+	attempts := logging.CurLog.PrefetchAttempts.(*logging.LogCounter)
+	successes := logging.CurLog.PrefetchSuccesses.(*logging.LogCounter)
+	attemptsBefore, successesBefore := attempts.String(), successes.String()
+
+	// a hot name queried every 5 seconds for 10 minutes is only forwarded
+	// once, and refreshed before it expires
+	for i := 0; i < 120; i++ {
+		m := query()
+		if len(m.Answer) != 1 || m.Answer[0].Header().Ttl == 0 || m.Answer[0].Header().Ttl > 100 {
+			t.Fatalf("query #%d: got answers %v", i, m.Answer)
+		}
+		now = now.Add(5 * time.Second)
+	}
+	counts := map[string]uint64{}
+	for _, b := range res.queries.buckets() {
+		counts[b.Source] += b.Count
+	}
+	if want := map[string]uint64{"forward": 1, "cache": 119}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("got queries by source %v, want %v", counts, want)
+	}
+	if forwarded < 7 {
+		t.Errorf("got %d forwarded queries, want at least 7", forwarded)
+	}
+	if attempts.String() == attemptsBefore || successes.String() == successesBefore {
+		t.Error("expected prefetches to be counted")
+	}
+
+	// failed refreshes back off, within the last seconds of the entry
+	down, forwarded = true, 0
+	for _, el := range res.fwdCache.entries {
+		e := el.Value.(*cacheEntry)
+		now = e.stored.Add(e.ttl - 5*time.Second)
+	}
+	for i, want := range []int{1, 2, 2, 3, 3} {
+		query()
+		if forwarded != want {
+			t.Errorf("step #%d: got %d refreshes, want %d", i, forwarded, want)
+		}
+		now = now.Add(time.Second)
+	}
+}
+
+func TestTopTalkers(t *testing.T) {
+	now := time.Unix(1456913640, 0)
+	const capacity = 16
+	tt := newTopTalkers(capacity, func() time.Time { return now })
+
+	// a skewed stream: three heavy hitters drowned in one-off noise
+	heavy := []struct {
+		client, name string
+		n            int
+	}{
+		{"10.0.0.1", "web.marathon.mesos.", 300},
+		{"10.0.0.2", "db.marathon.mesos.", 200},
+		{"10.0.0.3", "cache.marathon.mesos.", 100},
+	}
+	for i := 0; i < 300; i++ {
+		for _, h := range heavy {
+			if i < h.n {
+				tt.observe(&net.UDPAddr{IP: net.ParseIP(h.client)}, h.name)
+			}
+		}
+		tt.observe(&net.UDPAddr{IP: net.IPv4(10, 1, byte(i/256), byte(i))}, "noise-"+strconv.Itoa(i)+".mesos.")
+	}
+
+	for i := range tt.slots {
+		if s := tt.slots[i].clients; s != nil && (len(s.index) > capacity || len(tt.slots[i].names.index) > capacity) {
+			t.Fatalf("slot %d tracks more than %d keys", i, capacity)
+		}
+	}
+
+	assertTop := func(window string, want ...talkerCount) {
+		top := tt.top(len(heavy))[window]
+		for i, w := range want {
+			if i >= len(top.Clients) || top.Clients[i].Key != heavy[i].client || top.Clients[i].Count < w.Count {
+				t.Errorf("window %s: got clients %v, want %s first", window, top.Clients, heavy[i].client)
+			}
+			if i >= len(top.Names) || top.Names[i].Key != w.Key || top.Names[i].Count < w.Count {
+				t.Errorf("window %s: got names %v, want %v", window, top.Names, want)
+			}
+		}
+		if len(want) == 0 && (len(top.Clients) > 0 || len(top.Names) > 0) {
+			t.Errorf("window %s: got %v, want no talkers", window, top)
+		}
+	}
+	var want []talkerCount
+	for _, h := range heavy {
+		want = append(want, talkerCount{h.name, uint64(h.n)})
+	}
+
+	assertTop("1m", want...)
+	assertTop("5m", want...)
+
+	now = now.Add(2 * time.Minute)
+	assertTop("1m")
+	assertTop("5m", want...)
+
+	now = now.Add(5 * time.Minute)
+	assertTop("5m")
+
+	var disabled *topTalkers
+	disabled.observe(&net.UDPAddr{IP: net.ParseIP("10.0.0.1")}, "web.marathon.mesos.")
+}
+
+func TestStaleness(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated := time.Unix(1456913640, 0)
+	now := generated.Add(30 * time.Second)
+	res.now = func() time.Time { return now }
+	res.rs.Timestamp = generated
+	close(res.ready)
+
+	if got := res.staleness(); got != 30 {
+		t.Errorf("got staleness %d, want 30", got)
+	}
+
+	// a failed poll, here of a state lacking a leader, keeps the records
+	res.generatorOptions = nil
+	res.Reload()
+	now = now.Add(time.Minute)
+
+	if got, want := fmt.Sprint(logging.CurLog.StalenessSeconds), "90"; got != want {
+		t.Errorf("got staleness gauge %s, want %s", got, want)
+	}
+	var rw ResponseRecorder
+	res.HandleMesos(&rw, new(dns.Msg).SetQuestion("chronos.marathon.mesos.", dns.TypeA))
+	if rw.Msg == nil || len(rw.Msg.Answer) != 1 {
+		t.Errorf("records not served after a failed poll: %v", rw.Msg)
+	}
+
+	rec := httptest.NewRecorder()
+	res.RestReady(restful.NewRequest(httptest.NewRequest("GET", "/v1/ready", nil)), restful.NewResponse(rec))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var body readiness
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if !body.Ready || body.StalenessSeconds != 90 || body.LastSuccess == nil || !body.LastSuccess.Equal(generated) {
+		t.Errorf("unexpected readiness %+v", body)
+	}
+	if body.LastError == nil || body.LastError.Class != records.ErrorClassLeader || body.LastError.Message != "empty master" {
+		t.Errorf("unexpected last error %+v", body.LastError)
+	}
+}
+
+func TestReload_RecordDelta(t *testing.T) {
+	var masters []*mesostest.Master
+	for _, fixture := range []string{"orphans_reregistered.json", "dnssd.json"} {
+		b, err := ioutil.ReadFile("../records/testdata/" + fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := mesostest.NewMaster(mesostest.State(b))
+		defer m.Close()
+		masters = append(masters, m)
+	}
+	config := records.NewConfig()
+	config.Masters = []string{masters[0].Addr()}
+	config.IPSources = []string{"host"}
+	res := New("", config)
+
+	delta := func() records.RecordDelta {
+		rec := httptest.NewRecorder()
+		res.RestRecordDelta(restful.NewRequest(httptest.NewRequest("GET", "/v1/records/diff/latest", nil)), restful.NewResponse(rec))
+		var delta records.RecordDelta
+		if err := json.Unmarshal(rec.Body.Bytes(), &delta); err != nil {
+			t.Fatal(err)
+		}
+		return delta
+	}
+	if d := delta(); len(d.Added) != 0 || len(d.Removed) != 0 || !d.To.IsZero() {
+		t.Errorf("got delta %+v before any reload, want an empty one", d)
+	}
+
+	res.Reload()
+	prev := res.records()
+	if d := delta(); d.Summary["A"].Added != prev.Stats.Records["A"] || !d.From.IsZero() || !d.To.Equal(prev.Timestamp) {
+		t.Errorf("got delta %+v after the first reload, want every record added", d)
+	}
+
+	// the leader, fetched from first, goes away
+	masters[0].Close()
+	res.masters = []string{"", masters[1].Addr()}
+	res.Reload()
+	d := delta()
+	if !d.From.Equal(prev.Timestamp) || !d.To.Equal(res.records().Timestamp) {
+		t.Errorf("got delta from %s to %s, want one from the previous records", d.From, d.To)
+	}
+	var web []records.RecordChange
+	for _, rc := range d.Added {
+		if rc.Name == "web.marathon.mesos." {
+			web = append(web, rc)
+		}
+	}
+	if want := []records.RecordChange{{Name: "web.marathon.mesos.", Host: "10.0.1.2", Rtype: "A"}}; !reflect.DeepEqual(web, want) {
+		t.Errorf("got web records added %+v, want %+v", web, want)
+	}
+}
+
+func TestReload_Serial(t *testing.T) {
+	b, err := ioutil.ReadFile("../records/testdata/orphans_reregistered.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mesostest.NewMaster(mesostest.State(b))
+	defer m.Close()
+	config := records.NewConfig()
+	config.Masters = []string{m.Addr()}
+	config.IPSources = []string{"host"}
+	res := New("", config)
+	now := time.Unix(1000, 0)
+	res.now = func() time.Time { return now }
+
+	// pushed ahead of the clock by incremental updates
+	res.soaSerial = 1005
+	res.Reload()
+	if res.soaSerial != 1006 {
+		t.Errorf("got serial %d, want 1006", res.soaSerial)
+	}
+	now = time.Unix(2000, 0)
+	res.Reload()
+	if res.soaSerial != 2000 {
+		t.Errorf("got serial %d, want 2000", res.soaSerial)
+	}
+}
+
+func TestReload_Stale(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := *res.conf()
+	config.StaleSeconds = 150
+	res.config.Store(&config)
+	generated := res.LastUpdated()
+	now := generated
+	res.now = func() time.Time { return now }
+	close(res.ready)
+	logging.CurLog.RecordsStale.Set(0)
+
+	answers := func() []string {
+		var answers []string
+		for _, q := range []struct {
+			name  string
+			qtype uint16
+		}{
+			{"chronos.marathon.mesos.", dns.TypeA},
+			{"_liquor-store._tcp.marathon.mesos.", dns.TypeSRV},
+			{"leader.mesos.", dns.TypeA},
+		} {
+			var rw ResponseRecorder
+			res.HandleMesos(&rw, new(dns.Msg).SetQuestion(q.name, q.qtype))
+			if rw.Msg == nil || len(rw.Msg.Answer) == 0 {
+				t.Fatalf("no answer to %s", q.name)
+			}
+			for _, rr := range rw.Msg.Answer {
+				answers = append(answers, rr.String())
+			}
+		}
+		sort.Strings(answers) // answers are shuffled
+		return answers
+	}
+	want := answers()
+
+	// failed polls, here of a state lacking a leader, keep the records
+	res.generatorOptions = nil
+	for i, stale := range []bool{false, false, true} {
+		now = now.Add(time.Minute)
+		res.Reload()
+		if got := answers(); !reflect.DeepEqual(got, want) {
+			t.Errorf("poll #%d: got answers %q, want %q", i, got, want)
+		}
+		if got := res.LastUpdated(); !got.Equal(generated) {
+			t.Errorf("poll #%d: got records last updated %s, want %s", i, got, generated)
+		}
+		if got := res.stale(); got != stale {
+			t.Errorf("poll #%d: got stale %t, want %t", i, got, stale)
+		}
+		gauge := "0"
+		if stale {
+			gauge = "1"
+		}
+		if got := fmt.Sprint(logging.CurLog.RecordsStale); got != gauge {
+			t.Errorf("poll #%d: got stale gauge %s, want %s", i, got, gauge)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	res.RestReady(restful.NewRequest(httptest.NewRequest("GET", "/v1/ready", nil)), restful.NewResponse(rec))
+	var body readiness
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if !body.Ready || !body.Stale || body.StalenessSeconds != 180 {
+		t.Errorf("unexpected readiness %+v", body)
+	}
+}
+
+func TestRestReady_NotReady(t *testing.T) {
+	res := New("", records.NewConfig())
+	rec := httptest.NewRecorder()
+	res.RestReady(restful.NewRequest(httptest.NewRequest("GET", "/v1/ready", nil)), restful.NewResponse(rec))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if got := res.staleness(); got != -1 {
+		t.Errorf("got staleness %d before any generation, want -1", got)
+	}
+}
+
+func TestRestChecksum(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.soaSerial = 42
+	rec := httptest.NewRecorder()
+	res.RestChecksum(restful.NewRequest(httptest.NewRequest("GET", "/v1/checksum", nil)), restful.NewResponse(rec))
+	var body zoneChecksum
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Checksum == "" || body.Checksum != res.rs.Checksum() {
+		t.Errorf("got checksum %q, want %q", body.Checksum, res.rs.Checksum())
+	}
+	if body.Serial != 42 || body.Generated == nil || !body.Generated.Equal(res.rs.Timestamp) {
+		t.Errorf("unexpected checksum body %+v", body)
+	}
+}
+
+func TestApplyTaskUpdate(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.soaSerial = math.MaxUint32 - 1
+	served := res.records()
+	b, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sj state.State
+	if err = json.Unmarshal(b, &sj); err != nil {
+		t.Fatal(err)
+	}
+	marathon := sj.Frameworks[3]
+	task := marathon.Tasks[len(marathon.Tasks)-1] // reviewbot
+	const name = "reviewbot.marathon.mesos."
+	if len(served.As.Hosts(name)) == 0 {
+		t.Fatalf("no %s record to start with", name)
+	}
+	if err = res.ApplyTaskUpdate(task, marathon, records.TaskRemove); err != nil {
+		t.Fatal(err)
+	}
+	if rs := res.records(); rs == served || len(rs.As.Hosts(name)) > 0 || rs.Checksum() == served.Checksum() {
+		t.Errorf("records of removed task %q still served", task.ID)
+	}
+	if len(served.As.Hosts(name)) == 0 {
+		t.Error("records updated in place")
+	}
+	if res.soaSerial != math.MaxUint32 {
+		t.Errorf("got serial %d, want it bumped", res.soaSerial)
+	}
+	if err = res.ApplyTaskUpdate(task, marathon, records.TaskAdd); err != nil {
+		t.Fatal(err)
+	}
+	if rs := res.records(); rs.Checksum() != served.Checksum() {
+		t.Errorf("got checksum %s once the task is back, want %s", rs.Checksum(), served.Checksum())
+	}
+}
+
+func TestDump(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mesos-dns-dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	res.conf().DumpDir = dir
+	res.version = "0.1.1"
+	now := time.Date(2016, 3, 2, 10, 14, 52, 0, time.UTC)
+	res.now = func() time.Time { return now }
+
+	path, err := res.dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "mesos-dns-records-20160302T101452.000000000Z.txt"); path != want {
+		t.Errorf("got dump path %q, want %q", path, want)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var meta, recs []string
+	for _, line := range strings.SplitAfter(string(b), "\n") {
+		if strings.HasPrefix(line, ";") {
+			meta = append(meta, line)
+		} else {
+			recs = append(recs, line)
+		}
+	}
+	if len(meta) != 4 || meta[0] != "; version: 0.1.1\n" || meta[2] != "; dumped: 2016-03-02T10:14:52Z\n" {
+		t.Errorf("unexpected dump metadata %q", meta)
+	}
+	var want bytes.Buffer
+	if _, err := res.records().WriteTo(&want); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(recs, ""); got != want.String() {
+		t.Errorf("dumped records differ from the served ones:\n%s", pretty.Compare(got, want.String()))
+	}
+}
+
+func TestRequestDump_Coalesced(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mesos-dns-dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	res.conf().DumpDir = dir
+	dumped := make(chan error, 10)
+	res.afterDump = func(_ string, err error) { dumped <- err }
+
+	// dumps block on reading the records while they're being swapped
+	res.rsLock.Lock()
+	for i := 0; i < 5; i++ {
+		res.RequestDump()
+	}
+	res.rsLock.Unlock()
+
+	if err := <-dumped; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-dumped: // a dump may have been pending behind the first one
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+	select {
+	case <-dumped:
+		t.Error("requests made during a dump weren't coalesced")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// writeConfig writes a configuration file of the given contents to dir and
+// returns its path.
+func writeConfig(t *testing.T, dir, contents string) string {
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReloadConfig(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mesos-dns-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	res.conf().File = writeConfig(t, dir, `{
+		"masters": ["144.76.157.37:5050", "144.76.157.38:5050"],
+		"resolvers": ["8.8.8.8"],
+		"refreshSeconds": 30,
+		"IPSources": ["host"]
+	}`)
+
+	rec := httptest.NewRecorder()
+	res.RestReload(restful.NewRequest(httptest.NewRequest("POST", "/v1/reload", nil)), restful.NewResponse(rec))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var reload configReload
+	if err := json.NewDecoder(rec.Body).Decode(&reload); err != nil {
+		t.Fatal(err)
+	} else if !reload.OK || len(reload.Errors) > 0 {
+		t.Errorf("got reload %+v, want a successful one", reload)
+	}
+
+	// the next generation uses the new options, masters and IP sources
+	if got, want := res.conf().IPSources, []string{"host"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got IPSources %q, want %q", got, want)
+	}
+	if got, want := res.RefreshInterval(), 30*time.Second; got != want {
+		t.Errorf("got refresh interval %s, want %s", got, want)
+	}
+	if got, want := res.masters, []string{"", "144.76.157.37:5050", "144.76.157.38:5050"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got masters %q, want %q", got, want)
+	}
+	if len(res.generatorOptions) != 1 {
+		t.Errorf("got %d generator options, want 1", len(res.generatorOptions))
+	}
+}
+
+func TestReloadConfig_Rejected(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mesos-dns-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := res.conf()
+	old.File = writeConfig(t, dir, `{
+		"masters": ["144.76.157.37:5050"],
+		"resolvers": ["8.8.8.8"],
+		"domain": "bad domain!",
+		"IPSources": ["host"]
+	}`)
+
+	err = res.ReloadConfig()
+	if _, ok := err.(records.ConfigError); !ok {
+		t.Fatalf("got error %v, want a ConfigError", err)
+	}
+	if res.conf() != old {
+		t.Error("the configuration was changed by a rejected reload")
+	}
+
+	rec := httptest.NewRecorder()
+	res.RestReload(restful.NewRequest(httptest.NewRequest("POST", "/v1/reload", nil)), restful.NewResponse(rec))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	var reload configReload
+	if err := json.NewDecoder(rec.Body).Decode(&reload); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`Domain: invalid label "bad domain!", "baddomain" would be valid`}
+	if reload.OK || !reflect.DeepEqual(reload.Errors, want) {
+		t.Errorf("got reload %+v, want errors %q", reload, want)
+	}
+}
+
+func TestFrameworkDomains(t *testing.T) {
+	config := records.NewConfig()
+	config.Masters = []string{"144.76.157.37:5050"}
+	config.FrameworkDomains = []records.FrameworkDomain{{Framework: "marathon", Domain: "apps.example.internal"}}
+	res := New("", config)
+
+	b, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sj state.State
+	if err = json.Unmarshal(b, &sj); err != nil {
+		t.Fatal(err)
+	}
+	rg := records.NewRecordGenerator(records.WithConfig(config))
+	if err = rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, config.IPSources, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+	res.rs = rg
+
+	// register the handlers of the domains as if serving DNS
+	res.listeners.dns = map[string]*dns.Server{}
+	old := *res.conf()
+	old.FrameworkDomains = nil
+	res.rehandle(&old, res.conf(), res.fwds.Load().(*forwarders))
+	defer func() {
+		for _, domain := range res.conf().Domains() {
+			dns.HandleRemove(domain + ".")
+		}
+	}()
+
+	for i, tt := range []struct {
+		name  string
+		qtype uint16
+		rcode int
+		ans   int
+		ns    int
+	}{
+		{"apps.example.internal.", dns.TypeSOA, dns.RcodeSuccess, 0, 1},
+		{"apps.example.internal.", dns.TypeNS, dns.RcodeSuccess, 0, 1},
+		{"marathon.apps.example.internal.", dns.TypeA, dns.RcodeSuccess, 1, 0},
+		{"liquor-store.marathon.apps.example.internal.", dns.TypeA, dns.RcodeSuccess, 2, 0},
+		{"liquor-store.marathon.mesos.", dns.TypeA, dns.RcodeNameError, 0, 1},
+		{"some-box.chronoswithaspaceandmixedcase-2.0.1.mesos.", dns.TypeA, dns.RcodeSuccess, 1, 0},
+		{"some-box.chronoswithaspaceandmixedcase-2.0.1.apps.example.internal.", dns.TypeA, dns.RcodeNameError, 0, 1},
+	} {
+		var rw ResponseRecorder
+		dns.DefaultServeMux.ServeDNS(&rw, new(dns.Msg).SetQuestion(tt.name, tt.qtype))
+		m := rw.Msg
+		if !m.Authoritative || m.Rcode != tt.rcode || len(m.Answer) != tt.ans || len(m.Ns) != tt.ns {
+			t.Errorf("test #%d: got authoritative=%t rcode=%d %d answers %d authority records, want %d %d %d\n%v",
+				i, m.Authoritative, m.Rcode, len(m.Answer), len(m.Ns), tt.rcode, tt.ans, tt.ns, m)
+		}
+	}
+}
+
+func TestReverseZones(t *testing.T) {
+	config := records.NewConfig()
+	config.Masters = []string{"144.76.157.37:5050"}
+	config.ReverseZones = []string{"10.3.0.0/20", "10.0.3.0/24"}
+	res := New("", config)
+
+	// register the handlers of the zones as if serving DNS, forwarding
+	// every other query to corporate DNS
+	res.listeners.dns = map[string]*dns.Server{}
+	old := *res.conf()
+	old.ReverseZones = nil
+	res.rehandle(&old, res.conf(), res.fwds.Load().(*forwarders))
+	corporate := func(r *dns.Msg, net string) (*dns.Msg, error) {
+		m := new(dns.Msg).SetReply(r)
+		m.Answer = append(m.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 60},
+			Ptr: "host.corp.example.",
+		})
+		return m, nil
+	}
+	dns.HandleFunc(".", res.HandleNonMesos(corporate))
+	defer func() {
+		for _, domain := range res.conf().Domains() {
+			dns.HandleRemove(domain + ".")
+		}
+		dns.HandleRemove(".")
+	}()
+
+	for i, tt := range []struct {
+		name  string
+		qtype uint16
+		auth  bool
+		rcode int
+		ans   int
+		ns    int
+	}{
+		// in-zone hit: the zone apex
+		{"3.0.10.in-addr.arpa.", dns.TypeSOA, true, dns.RcodeSuccess, 0, 1},
+		// in-zone misses, in each of the /24 zones of the /20
+		{"17.3.0.10.in-addr.arpa.", dns.TypePTR, true, dns.RcodeNameError, 0, 1},
+		{"1.0.3.10.in-addr.arpa.", dns.TypePTR, true, dns.RcodeNameError, 0, 1},
+		{"254.15.3.10.in-addr.arpa.", dns.TypePTR, true, dns.RcodeNameError, 0, 1},
+		// out-of-zone: past the /20, in its parent zone and elsewhere
+		{"1.16.3.10.in-addr.arpa.", dns.TypePTR, false, dns.RcodeSuccess, 1, 0},
+		{"3.10.in-addr.arpa.", dns.TypeSOA, false, dns.RcodeSuccess, 1, 0},
+		{"1.4.0.10.in-addr.arpa.", dns.TypePTR, false, dns.RcodeSuccess, 1, 0},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.", dns.TypePTR, false, dns.RcodeSuccess, 1, 0},
+	} {
+		var rw ResponseRecorder
+		dns.DefaultServeMux.ServeDNS(&rw, new(dns.Msg).SetQuestion(tt.name, tt.qtype))
+		m := rw.Msg
+		if m.Authoritative != tt.auth || m.Rcode != tt.rcode || len(m.Answer) != tt.ans || len(m.Ns) != tt.ns {
+			t.Errorf("test #%d: got authoritative=%t rcode=%d %d answers %d authority records, want %t %d %d %d\n%v",
+				i, m.Authoritative, m.Rcode, len(m.Answer), len(m.Ns), tt.auth, tt.rcode, tt.ans, tt.ns, m)
+		}
+	}
+}
+
+func TestShortSRVTargets(t *testing.T) {
+	config := records.NewConfig()
+	config.Masters = []string{"144.76.157.37:5050"}
+	config.RecurseOn = false
+	config.ShortSRVTargets = true
+	res := New("", config)
+
+	b, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sj state.State
+	if err = json.Unmarshal(b, &sj); err != nil {
+		t.Fatal(err)
+	}
+	rg := records.NewRecordGenerator(records.WithConfig(config))
+	if err = rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"netinfo", "docker", "mesos", "host"}, labels.RFC952); err != nil {
+		t.Fatal(err)
+	}
+	res.rs = rg
+
+	// the glue lists every instance behind the short target
+	want := Message(
+		Question("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV),
+		Header(true, dns.RcodeSuccess),
+		Answers(
+			SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 60),
+				"liquor-store.marathon.mesos.", 80, 0, 0),
+			SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 60),
+				"liquor-store.marathon.mesos.", 443, 0, 0)),
+		Extras(
+			A(RRHeader("liquor-store.marathon.mesos.", dns.TypeA, 60),
+				net.ParseIP("10.3.0.1")),
+			A(RRHeader("liquor-store.marathon.mesos.", dns.TypeA, 60),
+				net.ParseIP("10.3.0.2"))))
+	var rw ResponseRecorder
+	res.HandleMesos(&rw, want)
+	if got := rw.Msg; !(Msg{got}).equivalent(Msg{want}) {
+		t.Errorf("unexpected response\n%s", pretty.Compare(got, want))
+	}
+}
+
+func TestTTLOverrides(t *testing.T) {
+	config := records.NewConfig()
+	config.Masters = []string{"144.76.157.37:5050"}
+	config.RecurseOn = false
+	config.ShortSRVTargets = true
+	config.TTLOverrides = []records.TTLOverride{
+		{Regexp: `_liquor-store\._tcp\..*`, TTL: 15},
+		{Glob: "liquor-store.marathon.mesos", TTL: 5},
+	}
+	res := New("", config)
+
+	b, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sj state.State
+	if err = json.Unmarshal(b, &sj); err != nil {
+		t.Fatal(err)
+	}
+	rg := records.NewRecordGenerator(records.WithConfig(config))
+	if err = rg.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, []string{"netinfo", "docker", "mesos", "host"}, labels.RFC952); err != nil {
+		t.Fatal(err)
+	}
+	res.rs = rg
+
+	// answers and glue get the TTLs of their own names
+	want := Message(
+		Question("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV),
+		Header(true, dns.RcodeSuccess),
+		Answers(
+			SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 15),
+				"liquor-store.marathon.mesos.", 80, 0, 0),
+			SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 15),
+				"liquor-store.marathon.mesos.", 443, 0, 0)),
+		Extras(
+			A(RRHeader("liquor-store.marathon.mesos.", dns.TypeA, 5),
+				net.ParseIP("10.3.0.1")),
+			A(RRHeader("liquor-store.marathon.mesos.", dns.TypeA, 5),
+				net.ParseIP("10.3.0.2"))))
+	var rw ResponseRecorder
+	res.HandleMesos(&rw, want)
+	if got := rw.Msg; !(Msg{got}).equivalent(Msg{want}) {
+		t.Errorf("unexpected response\n%s", pretty.Compare(got, want))
+	}
+
+	// names matched by no override get the global TTL
+	want = Message(
+		Question("marathon.mesos.", dns.TypeA),
+		Header(true, dns.RcodeSuccess),
+		Answers(A(RRHeader("marathon.mesos.", dns.TypeA, 60), net.ParseIP("1.2.3.11"))))
+	res.HandleMesos(&rw, want)
+	if got := rw.Msg; !(Msg{got}).equivalent(Msg{want}) {
+		t.Errorf("unexpected response\n%s", pretty.Compare(got, want))
+	}
+}
+
+// udpRecorder is a ResponseRecorder of queries received over UDP.
+type udpRecorder struct{ *ResponseRecorder }
+
+func (udpRecorder) RemoteAddr() net.Addr { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+func TestLocalAgent(t *testing.T) {
+	// an upstream Mesos-DNS server answering every A query
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg).SetReply(r)
+		m.Answer = append(m.Answer, A(RRHeader(r.Question[0].Name, dns.TypeA, 60), net.ParseIP("192.0.2.1")))
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = upstream.ActivateAndServe() }()
+	defer func() { _ = upstream.Shutdown() }()
+
+	config := records.NewConfig()
+	config.Masters = []string{"144.76.157.37:5050"}
+	config.LocalAgent = "1.2.3.12"
+	config.LocalAgentUpstreams = []string{pc.LocalAddr().String()}
+	res := New("", config)
+	b, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sj state.State
+	if err = json.Unmarshal(b, &sj); err != nil {
+		t.Fatal(err)
+	}
+	if err = res.rs.InsertState(sj, "mesos", "ns1.mesos.", "127.0.0.1", nil, config.IPSources, labels.RFC1123); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		name          string
+		qtype         uint16
+		authoritative bool
+		rcode         int
+		answer        string
+	}{
+		// local task
+		{"reviewbot.marathon.mesos.", dns.TypeA, true, dns.RcodeSuccess, "1.2.3.12"},
+		// remote task
+		{"car-store.marathon.mesos.", dns.TypeA, false, dns.RcodeSuccess, "192.0.2.1"},
+		{"mesos.", dns.TypeSOA, true, dns.RcodeSuccess, ""},
+	} {
+		rw := udpRecorder{&ResponseRecorder{}}
+		res.HandleMesos(rw, new(dns.Msg).SetQuestion(tt.name, tt.qtype))
+		m := rw.Msg
+		var answer string
+		if len(m.Answer) > 0 {
+			answer = m.Answer[0].(*dns.A).A.String()
+		}
+		if m.Authoritative != tt.authoritative || m.Rcode != tt.rcode || answer != tt.answer {
+			t.Errorf("test #%d: got authoritative=%t rcode=%d answer %q, want %t %d %q",
+				i, m.Authoritative, m.Rcode, answer, tt.authoritative, tt.rcode, tt.answer)
+		}
+	}
+}
+
+func TestMastersFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-masters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "masters")
+	write := func(contents string) {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := records.NewConfig()
+	config.Masters = []string{"10.0.0.9:5050"}
+	config.MastersFile = path
+	config.AllowUnknownLeader = true
+	res := New("", config)
+	res.generatorOptions = nil // generate from an empty state, whose leader is unknown
+	masterN := func() []string {
+		res.Reload()
+		var hosts []string
+		for i := 0; i < 3; i++ {
+			hosts = append(hosts, res.records().As.Hosts(fmt.Sprintf("master%d.mesos.", i))...)
+		}
+		return hosts
+	}
+
+	for i, tt := range []struct {
+		contents string // the file is removed if empty
+		want     []string
+	}{
+		{"", []string{"10.0.0.9"}},
+		{"# masters\n10.0.0.1:5050\nbogus\n10.0.0.2:5050\n", []string{"10.0.0.1", "10.0.0.2"}},
+		{"10.0.0.3:5050\n10.0.0.2:5050\n10.0.0.1:5050\n", []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"}},
+		{"# no masters\n", []string{"10.0.0.9"}},
+	} {
+		if tt.contents == "" {
+			_ = os.Remove(path)
+		} else {
+			write(tt.contents)
+		}
+		if got := masterN(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got masterN records %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestWatchResolvConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-resolvconf")
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
-	fwd := func(m *dns.Msg, net string) (*dns.Msg, error) {
-		rr1, err := res.formatA("google.com.", "1.1.1.1")
-		if err != nil {
-			return nil, err
-		}
-		rr2, err := res.formatA("google.com.", "2.2.2.2")
-		if err != nil {
-			return nil, err
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resolv.conf")
+	write := func(contents string) {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
 		}
-		msg := &dns.Msg{Answer: []dns.RR{rr1, rr2}}
-		msg.SetReply(m)
-		return msg, nil
 	}
+	write("nameserver 192.0.2.1\nnameserver 198.51.100.1\n")
 
-	for i, tt := range []struct {
-		dns.HandlerFunc
-		*dns.Msg
-	}{
-		{
-			res.HandleMesos,
-			Message(
-				Question("chronos.marathon.mesos.", dns.TypeA),
-				Header(true, dns.RcodeSuccess),
-				Answers(
-					A(RRHeader("chronos.marathon.mesos.", dns.TypeA, 60),
-						net.ParseIP("1.2.3.11")))),
-		},
-		{ // case insensitive
-			res.HandleMesos,
-			Message(
-				Question("cHrOnOs.MARATHON.mesoS.", dns.TypeA),
-				Header(true, dns.RcodeSuccess),
-				Answers(
-					A(RRHeader("chronos.marathon.mesos.", dns.TypeA, 60),
-						net.ParseIP("1.2.3.11")))),
-		},
-		{ // ipv6
-			res.HandleMesos,
-			Message(
-				Question("toy-store.ipv6-framework.mesos.", dns.TypeAAAA),
-				Header(true, dns.RcodeSuccess),
-				Answers(
-					AAAA(RRHeader("toy-store.ipv6-framework.mesos.", dns.TypeAAAA, 60),
-						net.ParseIP("fd01:b::1:8000:2")))),
-		},
-		{
-			res.HandleMesos,
-			Message(
-				Question("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV),
-				Header(true, dns.RcodeSuccess),
-				Answers(
-					SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 60),
-						"liquor-store-4dfjd-0.marathon.mesos.", 443, 0, 0),
-					SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 60),
-						"liquor-store-zasmd-1.marathon.mesos.", 80, 0, 0),
-					SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 60),
-						"liquor-store-zasmd-1.marathon.mesos.", 443, 0, 0),
-					SRV(RRHeader("_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, 60),
-						"liquor-store-4dfjd-0.marathon.mesos.", 80, 0, 0)),
-				Extras(
-					A(RRHeader("liquor-store-4dfjd-0.marathon.mesos.", dns.TypeA, 60),
-						net.ParseIP("10.3.0.1")),
-					A(RRHeader("liquor-store-zasmd-1.marathon.mesos.", dns.TypeA, 60),
-						net.ParseIP("10.3.0.2")))),
-		},
-		{
-			res.HandleMesos,
-			Message(
-				Question("_car-store._udp.marathon.mesos.", dns.TypeSRV),
-				Header(true, dns.RcodeSuccess),
-				Answers(
-					SRV(RRHeader("_car-store._udp.marathon.mesos.", dns.TypeSRV, 60),
-						"car-store-zinaz-0.marathon.slave.mesos.", 31365, 0, 0),
-					SRV(RRHeader("_car-store._udp.marathon.mesos.", dns.TypeSRV, 60),
-						"car-store-zinaz-0.marathon.slave.mesos.", 31364, 0, 0)),
-				Extras(
-					A(RRHeader("car-store-zinaz-0.marathon.slave.mesos.", dns.TypeA, 60),
-						net.ParseIP("1.2.3.11")))),
-		},
-		{
-			res.HandleMesos,
-			Message(
-				Question("_car-store._udp.marathon.mesos.", dns.TypeA),
-				Header(true, dns.RcodeSuccess),
-				NSs(
-					SOA(RRHeader("_car-store._udp.marathon.mesos.", dns.TypeSOA, 60),
-						"ns1.mesos", "root.ns1.mesos", 60))),
-		},
-		{
-			res.HandleMesos,
-			Message(
-				Question("non-existing.mesos.", dns.TypeSOA),
-				Header(true, dns.RcodeSuccess),
-				NSs(
-					SOA(RRHeader("non-existing.mesos.", dns.TypeSOA, 60),
-						"ns1.mesos", "root.ns1.mesos", 60))),
-		},
-		{
-			res.HandleMesos,
-			Message(
-				Question("non-existing.mesos.", dns.TypeNS),
-				Header(true, dns.RcodeSuccess),
-				NSs(
-					NS(RRHeader("non-existing.mesos.", dns.TypeNS, 60), "ns1.mesos"))),
-		},
-		{
-			res.HandleMesos,
-			Message(
-				Question("missing.mesos.", dns.TypeA),
-				Header(true, dns.RcodeNameError),
-				NSs(
-					SOA(RRHeader("missing.mesos.", dns.TypeSOA, 60),
-						"ns1.mesos", "root.ns1.mesos", 60))),
-		},
-		{
-			res.HandleMesos,
-			Message(
-				Question("chronos.marathon.mesos.", dns.TypeAAAA),
-				Header(true, dns.RcodeSuccess),
-				NSs(
-					SOA(RRHeader("chronos.marathon.mesos.", dns.TypeSOA, 60),
-						"ns1.mesos", "root.ns1.mesos", 60))),
-		},
-		{
-			res.HandleMesos,
-			Message(
-				Question("missing.mesos.", dns.TypeAAAA),
-				Header(true, dns.RcodeNameError),
-				NSs(
-					SOA(RRHeader("missing.mesos.", dns.TypeSOA, 60),
-						"ns1.mesos", "root.ns1.mesos", 60))),
-		},
-		{
-			res.HandleNonMesos(fwd),
-			Message(
-				Question("google.com.", dns.TypeA),
-				Header(false, dns.RcodeSuccess),
-				Answers(
-					A(RRHeader("google.com.", dns.TypeA, 60), net.ParseIP("1.1.1.1")),
-					A(RRHeader("google.com.", dns.TypeA, 60), net.ParseIP("2.2.2.2")))),
-		},
-	} {
-		var rw ResponseRecorder
-		tt.HandlerFunc(&rw, tt.Msg)
-		if got, want := rw.Msg, tt.Msg; !(Msg{got}).equivalent(Msg{want}) {
-			return fmt.Errorf("test #%d\n%v\n%s", i, pretty.Sprint(tt.Msg.Question), pretty.Compare(got, want))
+	config := records.NewConfig()
+	config.Resolvers = []string{"198.51.100.1:53"}
+	res := New("", config)
+	rc := &resolvConf{path: path, errs: logging.NewLimiter(time.Minute)}
+	res.pollResolvConf(rc)
+
+	// explicitly configured resolvers come first
+	targets := func() []string { return res.fwds.Load().(*forwarders).targets }
+	if got, want := targets(), []string{"198.51.100.1:53", "192.0.2.1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got targets %q, want %q", got, want)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go res.watchResolvConf(rc, 10*time.Millisecond, done)
+	before := fmt.Sprint(logging.CurLog.RecursorChanges)
+	write("nameserver 203.0.113.1\n")
+
+	want := []string{"198.51.100.1:53", "203.0.113.1"}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if reflect.DeepEqual(targets(), want) {
+			break
 		}
 	}
-	return nil
+	if got := targets(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got targets %q after rewriting resolv.conf, want %q", got, want)
+	}
+	if got := fmt.Sprint(logging.CurLog.Recursors); got != "2" {
+		t.Errorf("got %s recursors, want 2", got)
+	}
+	if got := fmt.Sprint(logging.CurLog.RecursorChanges); got == before {
+		t.Error("recursor change not counted")
+	}
 }
 
 type Msg struct{ *dns.Msg }
@@ -320,9 +1485,16 @@ func TestHTTP(t *testing.T) {
 				"Service": "Mesos-DNS",
 				"URL":     "https://github.com/mesosphere/mesos-dns",
 				"Version": "0.1.1",
+				"Records": map[string]interface{}{
+					"A": 58.0, "AAAA": 6.0, "SRV": 82.0,
+				},
+				"RecordSources": map[string]interface{}{
+					"framework": 7.0, "slave": 5.0, "master": 7.0,
+					"listener": 1.0, "task": 126.0, "static": 0.0,
+				},
 			},
 		},
-		{"/v1/config", http.StatusOK, &records.Config{}, &res.config},
+		{"/v1/config", http.StatusOK, &records.Config{}, res.conf()},
 		{"/v1/services/_leader._tcp.mesos.", http.StatusOK, []interface{}{},
 			[]interface{}{map[string]interface{}{
 				"service": "_leader._tcp.mesos.",
@@ -339,6 +1511,16 @@ func TestHTTP(t *testing.T) {
 				"port":    "",
 			}},
 		},
+		{"/v1/collisions", http.StatusOK, []interface{}{}, []interface{}{}},
+		{"/v1/records/diff/latest", http.StatusOK, map[string]interface{}{},
+			map[string]interface{}{
+				"from":    "0001-01-01T00:00:00Z",
+				"to":      "0001-01-01T00:00:00Z",
+				"added":   []interface{}{},
+				"removed": []interface{}{},
+				"summary": map[string]interface{}{},
+			},
+		},
 		{"/v1/hosts/leader.mesos", http.StatusOK, []interface{}{},
 			[]interface{}{map[string]interface{}{
 				"host": "leader.mesos.",
@@ -391,14 +1573,173 @@ func TestHTTPAcceptApplicationJson(t *testing.T) {
 	}
 }
 
+func TestHandleMesos_Wildcard(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.rs.As.Add("*.marathon.mesos.", "10.9.0.1")
+
+	// wildcards answer names without records of their own, under the
+	// name queried
+	want := Message(
+		Question("unknown.marathon.mesos.", dns.TypeA),
+		Header(true, dns.RcodeSuccess),
+		Answers(A(RRHeader("unknown.marathon.mesos.", dns.TypeA, 60), net.ParseIP("10.9.0.1"))))
+	var rw ResponseRecorder
+	res.HandleMesos(&rw, want)
+	if got := rw.Msg; !(Msg{got}).equivalent(Msg{want}) {
+		t.Errorf("unexpected response\n%s", pretty.Compare(got, want))
+	}
+	// but not the names with records of other kinds
+	if got := answerIPs(res, "_liquor-store._tcp.marathon.mesos."); len(got) != 0 {
+		t.Errorf("got A answers %v for an SRV name, want none", got)
+	}
+}
+
+func TestHandleMesos_PTR(t *testing.T) {
+	config := records.NewConfig()
+	config.Masters = []string{"144.76.157.37:5050"}
+	config.RecurseOn = false
+	config.ReverseZones = []string{"1.2.3.0/24"}
+	res := New("", config)
+	b, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sj state.State
+	if err = json.Unmarshal(b, &sj); err != nil {
+		t.Fatal(err)
+	}
+	if err = res.rs.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", config.Masters, []string{"host"}, labels.RFC952); err != nil {
+		t.Fatal(err)
+	}
+
+	var rw ResponseRecorder
+	res.HandleMesos(&rw, Message(Question("12.3.2.1.in-addr.arpa.", dns.TypePTR)))
+	targets := map[string]bool{}
+	for _, rr := range rw.Msg.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok && ptr.Hdr.Name == "12.3.2.1.in-addr.arpa." {
+			targets[ptr.Ptr] = true
+		}
+	}
+	// the slave and each of its tasks
+	want := map[string]bool{
+		"slave.mesos.":                         true,
+		"liquor-store-zasmd-1.marathon.mesos.": true,
+		"reviewbot-8sq89-1.marathon.mesos.":    true,
+	}
+	if rw.Msg.Rcode != dns.RcodeSuccess || !reflect.DeepEqual(targets, want) {
+		t.Errorf("got rcode %d and PTR targets %v, want %v", rw.Msg.Rcode, targets, want)
+	}
+
+	rw = ResponseRecorder{}
+	res.HandleMesos(&rw, Message(Question("99.3.2.1.in-addr.arpa.", dns.TypePTR)))
+	if rw.Msg.Rcode != dns.RcodeNameError {
+		t.Errorf("got rcode %d for an unknown address, want NXDOMAIN", rw.Msg.Rcode)
+	}
+}
+
+func TestHandleMesos_TXT(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.rs.TXTs.Add("web.marathon.mesos.", `"task-id=web.1" "team=say \"hi\"" "a\009b"`)
+
+	var rw ResponseRecorder
+	res.HandleMesos(&rw, Message(Question("web.marathon.mesos.", dns.TypeTXT)))
+	if len(rw.Msg.Answer) != 1 {
+		t.Fatalf("got answers %v, want a TXT record", rw.Msg.Answer)
+	}
+	// the strings are unescaped on the wire
+	b, err := rw.Msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"task-id=web.1", `team=say "hi"`, "a\tb"} {
+		if !bytes.Contains(b, append([]byte{byte(len(want))}, want...)) {
+			t.Errorf("TXT string %q missing from answer %v", want, rw.Msg.Answer[0])
+		}
+	}
+}
+
+func TestHandleEvent(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := res.records()
+	b, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sj state.State
+	if err = json.Unmarshal(b, &sj); err != nil {
+		t.Fatal(err)
+	}
+	marathon := sj.Frameworks[3]
+	task := marathon.Tasks[len(marathon.Tasks)-1] // reviewbot
+	const name = "reviewbot.marathon.mesos."
+	resynced := func() bool {
+		select {
+		case <-res.Resyncs():
+			return true
+		default:
+			return false
+		}
+	}
+
+	view := &eventView{}
+	res.handleEvent(view, state.Event{Type: state.EventSubscribed, State: &sj})
+	if !resynced() {
+		t.Error("no resync requested once subscribed")
+	}
+
+	res.handleEvent(view, state.Event{Type: state.EventTaskUpdated, FrameworkID: marathon.ID, TaskID: task.ID,
+		TaskState: "TASK_KILLED", Status: &state.Status{State: "TASK_KILLED"}})
+	if rs := res.records(); len(rs.As.Hosts(name)) > 0 {
+		t.Errorf("records of killed task %q still served", task.ID)
+	}
+	if _, ok := view.tasks[taskKey{marathon.ID, task.ID}]; ok {
+		t.Errorf("killed task %q still tracked", task.ID)
+	}
+
+	staging := task
+	staging.ID, staging.State = "reviewbot.2", "TASK_STAGING"
+	rs := res.records()
+	res.handleEvent(view, state.Event{Type: state.EventTaskAdded, Task: &staging})
+	if res.records() != rs {
+		t.Error("records updated for a task which isn't running")
+	}
+
+	res.handleEvent(view, state.Event{Type: state.EventTaskAdded, Task: &task})
+	if rs := res.records(); rs.Checksum() != served.Checksum() {
+		t.Errorf("got checksum %s once the task is back, want %s", rs.Checksum(), served.Checksum())
+	}
+	if resynced() {
+		t.Error("resync requested for task changes")
+	}
+
+	for _, ev := range []state.Event{
+		{Type: state.EventTaskUpdated, FrameworkID: marathon.ID, TaskID: "unknown", TaskState: "TASK_RUNNING"},
+		{Type: state.EventAgentAdded, Slave: &state.Slave{ID: "s9"}},
+		{Type: state.EventAgentRemoved, SlaveID: "s9"},
+	} {
+		res.handleEvent(view, ev)
+		if !resynced() {
+			t.Errorf("no resync requested for %+v", ev)
+		}
+	}
+}
+
 func fakeDNS() (*Resolver, error) {
 	config := records.NewConfig()
 	config.Masters = []string{"144.76.157.37:5050"}
 	config.RecurseOn = false
 	config.IPSources = []string{"netinfo", "docker", "mesos", "host"}
 
-	res := New("", config)
-	res.rng.Seed(0) // for deterministic tests
+	res := NewWithSource("", config, rand.NewSource(0)) // for deterministic tests
 
 	b, err := ioutil.ReadFile("../factories/fake.json")
 	if err != nil {
@@ -412,7 +1753,7 @@ func fakeDNS() (*Resolver, error) {
 	}
 
 	spec := labels.RFC952
-	err = res.rs.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", res.config.Masters, res.config.IPSources, spec)
+	err = res.rs.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", res.conf().Masters, res.conf().IPSources, spec)
 	if err != nil {
 		return nil, err
 	}
@@ -652,3 +1993,44 @@ func genA(n int) []dns.RR {
 	}
 	return records
 }
+
+func TestAnswerOrder(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []string{"10.9.0.1", "10.9.0.10", "10.9.0.2", "10.9.0.3"}
+	for _, host := range hosts {
+		res.rs.As.Add("ordered.marathon.mesos.", host)
+	}
+	setOrder := func(order string) {
+		config := *res.conf()
+		config.AnswerOrder = order
+		res.config.Store(&config)
+	}
+
+	setOrder(records.AnswerOrderRotate)
+	const queries = 100
+	firsts := map[string]int{}
+	for i := 0; i < queries; i++ {
+		ips := answerIPs(res, "ordered.marathon.mesos.")
+		sorted := append([]string(nil), ips...)
+		sort.Strings(sorted)
+		if want := []string{"10.9.0.1", "10.9.0.10", "10.9.0.2", "10.9.0.3"}; !reflect.DeepEqual(sorted, want) {
+			t.Fatalf("query #%d: got answers %v, want every host", i, ips)
+		}
+		firsts[ips[0]]++
+	}
+	for _, host := range hosts {
+		if got, want := firsts[host], queries/len(hosts); got != want {
+			t.Errorf("got %s first %d times out of %d, want %d", host, got, queries, want)
+		}
+	}
+
+	setOrder(records.AnswerOrderSorted)
+	for i := 0; i < 3; i++ {
+		if got, want := answerIPs(res, "ordered.marathon.mesos."), []string{"10.9.0.1", "10.9.0.2", "10.9.0.3", "10.9.0.10"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got sorted answers %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package resolver
+
+import (
+	"container/heap"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// topTalkersCapacity bounds the number of keys tracked per slot and
+	// dimension.
+	topTalkersCapacity = 256
+	// topTalkersSlot is the duration covered by a single slot.
+	topTalkersSlot = time.Minute
+	// topTalkersSlots is the number of slots kept, i.e. the longest window.
+	topTalkersSlots = 5
+	// topTalkersListed is the number of top clients and names listed per
+	// window.
+	topTalkersListed = 10
+)
+
+// topTalkersWindows are the windows top talkers are listed for.
+var topTalkersWindows = []struct {
+	label string
+	slots int
+}{
+	{"1m", 1},
+	{"5m", 5},
+}
+
+// topTalkers tracks the clients and query names seen most frequently over the
+// last few minutes. Memory is bounded regardless of their cardinality: each
+// of the per-minute slots keeps a fixed number of approximate counters.
+// A nil topTalkers tracks nothing. It's safe for concurrent use.
+type topTalkers struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	capacity int
+	slots    [topTalkersSlots]talkerSlot
+}
+
+// talkerSlot holds the counters of a single slot.
+type talkerSlot struct {
+	start   time.Time
+	clients *spaceSaving
+	names   *spaceSaving
+}
+
+func newTopTalkers(capacity int, now func() time.Time) *topTalkers {
+	return &topTalkers{now: now, capacity: capacity}
+}
+
+// observe accounts for a query of the given name from the client of the
+// given address.
+func (t *topTalkers) observe(client net.Addr, name string) {
+	if t == nil {
+		return
+	}
+	host := clientHost(client)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.slot(t.now())
+	s.clients.add(host)
+	s.names.add(name)
+}
+
+// clientHost returns the host part of the given client address.
+func clientHost(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	case nil:
+		return ""
+	default:
+		return addr.String()
+	}
+}
+
+// slot returns the slot covering the given time, recycling it if it covered
+// an earlier time. It must be called with t.mu held.
+func (t *topTalkers) slot(now time.Time) *talkerSlot {
+	start := now.Truncate(topTalkersSlot)
+	s := &t.slots[int(start.Unix()/int64(topTalkersSlot/time.Second))%topTalkersSlots]
+	if !s.start.Equal(start) || s.clients == nil {
+		*s = talkerSlot{
+			start:   start,
+			clients: newSpaceSaving(t.capacity),
+			names:   newSpaceSaving(t.capacity),
+		}
+	}
+	return s
+}
+
+// talkerCount is the approximate number of queries of a client or name.
+type talkerCount struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// talkerWindow lists the top clients and names of a window.
+type talkerWindow struct {
+	Clients []talkerCount `json:"clients"`
+	Names   []talkerCount `json:"names"`
+}
+
+// top returns the n top clients and names of each window, keyed by the
+// window's label.
+func (t *topTalkers) top(n int) map[string]talkerWindow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now().Truncate(topTalkersSlot)
+	windows := make(map[string]talkerWindow, len(topTalkersWindows))
+	for _, w := range topTalkersWindows {
+		oldest := now.Add(-time.Duration(w.slots-1) * topTalkersSlot)
+		clients, names := map[string]uint64{}, map[string]uint64{}
+		for i := range t.slots {
+			s := &t.slots[i]
+			if s.clients == nil || s.start.Before(oldest) || s.start.After(now) {
+				continue
+			}
+			s.clients.each(func(k string, c uint64) { clients[k] += c })
+			s.names.each(func(k string, c uint64) { names[k] += c })
+		}
+		windows[w.label] = talkerWindow{Clients: topCounts(clients, n), Names: topCounts(names, n)}
+	}
+	return windows
+}
+
+// topCounts returns the n keys with the highest counts, ordered by
+// decreasing count and then by key.
+func topCounts(counts map[string]uint64, n int) []talkerCount {
+	tcs := make([]talkerCount, 0, len(counts))
+	for k, c := range counts {
+		tcs = append(tcs, talkerCount{k, c})
+	}
+	sort.Sort(byCount(tcs))
+	if len(tcs) > n {
+		tcs = tcs[:n]
+	}
+	return tcs
+}
+
+type byCount []talkerCount
+
+func (s byCount) Len() int      { return len(s) }
+func (s byCount) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCount) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	return s[i].Key < s[j].Key
+}
+
+// spaceSaving approximates the most frequent keys of a stream with a fixed
+// number of counters, as per the Space-Saving algorithm: once all counters
+// are in use, the least counted key is replaced by the new one, which
+// inherits its count. Counts are thus overestimated, but any key occurring
+// more than 1/capacity of the time is guaranteed to be tracked.
+type spaceSaving struct {
+	capacity int
+	index    map[string]*talker
+	heap     talkerHeap
+}
+
+type talker struct {
+	key   string
+	count uint64
+	i     int // index in the heap
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{
+		capacity: capacity,
+		index:    make(map[string]*talker, capacity),
+		heap:     make(talkerHeap, 0, capacity),
+	}
+}
+
+// add accounts for an occurrence of the given key.
+func (s *spaceSaving) add(key string) {
+	if t, ok := s.index[key]; ok {
+		t.count++
+		heap.Fix(&s.heap, t.i)
+		return
+	}
+	if len(s.heap) < s.capacity {
+		t := &talker{key: key, count: 1}
+		s.index[key] = t
+		heap.Push(&s.heap, t)
+		return
+	}
+	t := s.heap[0]
+	delete(s.index, t.key)
+	t.key = key
+	t.count++
+	s.index[key] = t
+	heap.Fix(&s.heap, 0)
+}
+
+// each calls f with every tracked key and its count.
+func (s *spaceSaving) each(f func(key string, count uint64)) {
+	for _, t := range s.heap {
+		f(t.key, t.count)
+	}
+}
+
+// talkerHeap is a min-heap of talkers ordered by count.
+type talkerHeap []*talker
+
+func (h talkerHeap) Len() int           { return len(h) }
+func (h talkerHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h talkerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].i, h[j].i = i, j
+}
+
+func (h *talkerHeap) Push(x interface{}) {
+	t := x.(*talker)
+	t.i = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *talkerHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
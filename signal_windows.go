@@ -0,0 +1,11 @@
+package main
+
+import "os"
+
+// notifyDump is a no-op: there's no signal requesting a record dump on
+// Windows.
+func notifyDump(c chan<- os.Signal) {}
+
+// notifyReload is a no-op: there's no signal requesting a configuration
+// reload on Windows.
+func notifyReload(c chan<- os.Signal) {}
@@ -37,10 +37,18 @@ func Path(path string) Option { return func(b *Builder) { b.Path = path } }
 //     zk://host1:port1,host2:port2,.../path
 //     zk://username:password@host1:port1,host2:port2,.../path
 //     file:///path/to/file (where file contains one of the above)
+//
+// IPv6 addresses may be bracketed, e.g. [2001:db8::1]:5050, or not, in which
+// case the port follows their last colon, e.g. 2001:db8::1:5050.
 func SplitHostPort(pair string) (string, string, error) {
 	if host, port, err := net.SplitHostPort(pair); err == nil {
 		return host, port, nil
 	}
+	if i := strings.LastIndex(pair, ":"); i > 0 && !strings.Contains(pair, "[") {
+		if ip := net.ParseIP(pair[:i]); ip != nil && ip.To4() == nil {
+			return pair[:i], pair[i+1:], nil
+		}
+	}
 
 	h := strings.SplitN(pair, ":", 2)
 	if len(h) != 2 {